@@ -0,0 +1,259 @@
+/* Copyright 2020 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// RuleBuilder builds a Rule with chainable methods. Errors in the arguments of a method are
+// recorded, and returned by Build.
+//
+//	r, err := NewRuleBuilder().Alert().Proto("http").From("$HOME_NET", "any").To("$EXTERNAL_NET", "any").
+//		Msg("foo").Flow("established", "to_server").Buffer("http.uri").Content([]byte("/index.php")).
+//		SID(1000001).Build()
+type RuleBuilder struct {
+	r      *Rule
+	buffer DataPos
+	errs   []error
+}
+
+// NewRuleBuilder returns a RuleBuilder for an empty rule. Source and destination default to any,
+// and revision to 1.
+func NewRuleBuilder() *RuleBuilder {
+	return &RuleBuilder{
+		r: &Rule{
+			Source:      Network{Nets: []string{"any"}, Ports: []string{"any"}},
+			Destination: Network{Nets: []string{"any"}, Ports: []string{"any"}},
+			Revision:    1,
+		},
+	}
+}
+
+// errorf records an error returned by Build.
+func (b *RuleBuilder) errorf(format string, args ...interface{}) {
+	b.errs = append(b.errs, fmt.Errorf(format, args...))
+}
+
+// Action sets the action of the rule (e.g. alert, drop).
+func (b *RuleBuilder) Action(a string) *RuleBuilder {
+	b.r.Action = a
+	return b
+}
+
+// Alert sets the action of the rule to alert.
+func (b *RuleBuilder) Alert() *RuleBuilder {
+	return b.Action("alert")
+}
+
+// Drop sets the action of the rule to drop.
+func (b *RuleBuilder) Drop() *RuleBuilder {
+	return b.Action("drop")
+}
+
+// Pass sets the action of the rule to pass.
+func (b *RuleBuilder) Pass() *RuleBuilder {
+	return b.Action("pass")
+}
+
+// Proto sets the protocol of the rule.
+func (b *RuleBuilder) Proto(p string) *RuleBuilder {
+	b.r.Protocol = p
+	return b
+}
+
+// network returns a Network from addresses and ports, each a single entry or a list (e.g.
+// "[80,443]").
+func (b *RuleBuilder) network(nets, ports string) Network {
+	var n Network
+	var err error
+	if n.Nets, err = splitNetList(nets); err != nil {
+		b.errorf("invalid addresses %q: %v", nets, err)
+	}
+	if n.Ports, err = splitNetList(ports); err != nil {
+		b.errorf("invalid ports %q: %v", ports, err)
+	}
+	return n
+}
+
+// From sets the source addresses and ports of the rule (e.g. "$HOME_NET", "any").
+func (b *RuleBuilder) From(nets, ports string) *RuleBuilder {
+	b.r.Source = b.network(nets, ports)
+	return b
+}
+
+// To sets the destination addresses and ports of the rule (e.g. "$EXTERNAL_NET", "[80,443]").
+func (b *RuleBuilder) To(nets, ports string) *RuleBuilder {
+	b.r.Destination = b.network(nets, ports)
+	return b
+}
+
+// Bidirectional makes the rule match traffic in both directions (<>).
+func (b *RuleBuilder) Bidirectional() *RuleBuilder {
+	b.r.Bidirectional = true
+	return b
+}
+
+// Msg sets the msg of the rule.
+func (b *RuleBuilder) Msg(msg string) *RuleBuilder {
+	b.r.Description = msg
+	return b
+}
+
+// Flow sets the flow options of the rule (e.g. "established", "to_server").
+func (b *RuleBuilder) Flow(opts ...string) *RuleBuilder {
+	return b.Tag("flow", strings.Join(opts, ","))
+}
+
+// Classtype sets the classtype of the rule.
+func (b *RuleBuilder) Classtype(c string) *RuleBuilder {
+	b.r.Classtype = c
+	return b
+}
+
+// Priority sets the priority of the rule.
+func (b *RuleBuilder) Priority(p int) *RuleBuilder {
+	b.r.Priority = p
+	return b
+}
+
+// Tag sets a keyword stored in Tags (e.g. threshold). Keywords with a typed field, like classtype
+// and priority, have their own methods.
+func (b *RuleBuilder) Tag(key, value string) *RuleBuilder {
+	if b.r.Tags == nil {
+		b.r.Tags = make(map[string]string)
+	}
+	b.r.Tags[key] = value
+	return b
+}
+
+// Buffer sets the sticky buffer (e.g. http.uri) used by the following contents, pcres and
+// transforms.
+func (b *RuleBuilder) Buffer(name string) *RuleBuilder {
+	d, err := StickyBuffer(name)
+	if err != nil {
+		b.errorf("invalid buffer: %v", err)
+		return b
+	}
+	b.buffer = d
+	return b
+}
+
+// Transform adds a transform (e.g. to_lowercase) to the current sticky buffer.
+func (b *RuleBuilder) Transform(name string) *RuleBuilder {
+	if !isTransform(name) || transformNames[name] {
+		b.errorf("unsupported transform %q", name)
+		return b
+	}
+	b.r.Matchers = append(b.r.Matchers, &Transform{DataPosition: b.buffer, Name: name})
+	return b
+}
+
+// Content adds a content matching the raw bytes of pattern on the current sticky buffer, with the
+// given options (e.g. nocase, distance).
+func (b *RuleBuilder) Content(pattern []byte, opts ...ContentOption) *RuleBuilder {
+	c := NewContent(pattern, opts...)
+	c.DataPosition = b.buffer
+	b.r.Matchers = append(b.r.Matchers, c)
+	return b
+}
+
+// NotContent adds a negated content, like Content.
+func (b *RuleBuilder) NotContent(pattern []byte, opts ...ContentOption) *RuleBuilder {
+	c := NewContent(pattern, opts...)
+	c.DataPosition = b.buffer
+	c.Negate = true
+	b.r.Matchers = append(b.r.Matchers, c)
+	return b
+}
+
+// PCRE adds a pcre, written as in a rule without quotes (e.g. "/foo/Ri", or "!/foo/").
+func (b *RuleBuilder) PCRE(s string) *RuleBuilder {
+	negate := strings.HasPrefix(s, "!")
+	p, err := parsePCRE(strings.TrimPrefix(s, "!"))
+	if err != nil {
+		b.errorf("invalid pcre %q: %v", s, err)
+		return b
+	}
+	p.Negate = negate
+	p.DataPosition = b.buffer
+	b.r.Matchers = append(b.r.Matchers, p)
+	return b
+}
+
+// Reference adds a reference to the rule (e.g. "cve", "2020-1234").
+func (b *RuleBuilder) Reference(t, v string) *RuleBuilder {
+	b.r.AddReference(t, v)
+	return b
+}
+
+// Metadata adds a metadata key and value to the rule.
+func (b *RuleBuilder) Metadata(key, value string) *RuleBuilder {
+	b.r.AddMetadata(key, value)
+	return b
+}
+
+// SID sets the sid of the rule.
+func (b *RuleBuilder) SID(n int) *RuleBuilder {
+	b.r.SID = n
+	return b
+}
+
+// Rev sets the revision of the rule.
+func (b *RuleBuilder) Rev(n int) *RuleBuilder {
+	b.r.Revision = n
+	return b
+}
+
+// Build returns the rule, or an error if one of the methods received an invalid argument, a
+// required field (action, protocol, msg, sid) is missing, the rule does not validate, or it cannot
+// be parsed back once written. All of the problems found are returned as ValidationErrors. The
+// builder can be reused, the rule returned is a copy.
+func (b *RuleBuilder) Build() (*Rule, error) {
+	errs := append(ValidationErrors(nil), b.errs...)
+	r := b.r.Clone()
+	if r.Action == "" {
+		errs = append(errs, errors.New("rule has no action"))
+	}
+	if r.Protocol == "" {
+		errs = append(errs, errors.New("rule has no protocol"))
+	}
+	if r.Description == "" {
+		errs = append(errs, errors.New("rule has no msg"))
+	}
+	if r.SID < 1 {
+		errs = append(errs, fmt.Errorf("invalid sid %d", r.SID))
+	}
+	if err := r.Validate(); err != nil {
+		if verrs, ok := err.(ValidationErrors); ok {
+			errs = append(errs, verrs...)
+		} else {
+			errs = append(errs, err)
+		}
+	}
+	// A rule that is not valid is not expected to parse back.
+	if len(errs) == 0 {
+		if _, err := ParseRule(r.String()); err != nil {
+			errs = append(errs, fmt.Errorf("rule cannot be parsed once written: %v", err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return r, nil
+}
@@ -0,0 +1,124 @@
+/* Copyright 2020 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"testing"
+)
+
+func TestRuleBuilder(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		builder *RuleBuilder
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "minimal",
+			builder: NewRuleBuilder().Alert().Proto("tcp").Msg("foo").SID(1),
+			want:    `alert tcp any any -> any any (msg:"foo"; sid:1; rev:1;)`,
+		},
+		{
+			name: "full",
+			builder: NewRuleBuilder().Alert().Proto("http").From("$HOME_NET", "any").To("[1.2.3.4,5.6.7.8]", "[80,443]").
+				Msg("foo").Flow("established", "to_server").
+				Content([]byte("GET ")).
+				Buffer("http.uri").Transform("to_lowercase").Content([]byte("/index.php"), ContentOption{Name: "nocase"}).
+				NotContent([]byte("bar|baz"), ContentOption{Name: "distance", Value: "0"}).
+				PCRE("/foo\\d+/R").
+				Classtype("trojan-activity").Priority(2).Reference("cve", "2020-1234").Metadata("created_at", "2020_01_01").
+				SID(1000001).Rev(2),
+			want: `alert http $HOME_NET any -> [1.2.3.4,5.6.7.8] [80,443] (msg:"foo"; flow:established,to_server; content:"GET "; http.uri; to_lowercase; content:"/index.php"; nocase; content:!"bar|7C|baz"; distance:0; pcre:"/foo\d+/R"; metadata:created_at 2020_01_01; classtype:trojan-activity; priority:2; reference:cve,2020-1234; sid:1000001; rev:2;)`,
+		},
+		{
+			name:    "missing sid",
+			builder: NewRuleBuilder().Alert().Proto("tcp").Msg("foo"),
+			wantErr: true,
+		},
+		{
+			name:    "missing protocol",
+			builder: NewRuleBuilder().Alert().Msg("foo").SID(1),
+			wantErr: true,
+		},
+		{
+			name:    "invalid buffer",
+			builder: NewRuleBuilder().Alert().Proto("tcp").Msg("foo").Buffer("foo").Content([]byte("bar")).SID(1),
+			wantErr: true,
+		},
+		{
+			name:    "invalid pcre",
+			builder: NewRuleBuilder().Alert().Proto("tcp").Msg("foo").PCRE("foo").SID(1),
+			wantErr: true,
+		},
+		{
+			name:    "invalid network",
+			builder: NewRuleBuilder().Alert().Proto("tcp").From("1.2.3.256", "any").Msg("foo").SID(1),
+			wantErr: true,
+		},
+		{
+			name:    "does not validate",
+			builder: NewRuleBuilder().Alert().Proto("udp").Msg("foo").Buffer("http.uri").Content([]byte("bar")).SID(1),
+			wantErr: true,
+		},
+	} {
+		r, err := tt.builder.Build()
+		if tt.wantErr != (err != nil) {
+			t.Fatalf("%s: got err %v; expected err %v", tt.name, err, tt.wantErr)
+		}
+		if tt.wantErr {
+			continue
+		}
+		if got := r.String(); got != tt.want {
+			t.Fatalf("%s: got %v; expected %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRuleBuilderErrors(t *testing.T) {
+	_, err := NewRuleBuilder().Proto("udp").PCRE("foo").Buffer("http.uri").Content([]byte("bar")).Build()
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatalf("got %T %v; expected ValidationErrors", err, err)
+	}
+	// The invalid pcre, the missing action, msg and sid, and the http buffer on udp.
+	if len(errs) != 5 {
+		t.Fatalf("got %d errors %v; expected 5", len(errs), errs)
+	}
+
+	// A rule that cannot be parsed back is also reported as ValidationErrors.
+	_, err = NewRuleBuilder().Alert().Proto("tcp").Msg(`a";b`).SID(1).Build()
+	if errs, ok := err.(ValidationErrors); !ok || len(errs) != 1 {
+		t.Fatalf("got %T %v; expected a single ValidationErrors", err, err)
+	}
+}
+
+func TestRuleBuilderReuse(t *testing.T) {
+	b := NewRuleBuilder().Alert().Proto("tcp").Msg("foo").Content([]byte("a")).SID(1)
+	first, err := b.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	second, err := b.Content([]byte("b")).SID(2).Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if want := `alert tcp any any -> any any (msg:"foo"; content:"a"; sid:1; rev:1;)`; first.String() != want {
+		t.Fatalf("got %v; expected %v", first, want)
+	}
+	if want := `alert tcp any any -> any any (msg:"foo"; content:"a"; content:"b"; sid:2; rev:1;)`; second.String() != want {
+		t.Fatalf("got %v; expected %v", second, want)
+	}
+}
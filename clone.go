@@ -0,0 +1,251 @@
+/* Copyright 2020 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+// cloneBytes returns a copy of b, nil if b is nil.
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+// cloneStrings returns a copy of ss, nil if ss is nil.
+func cloneStrings(ss []string) []string {
+	if ss == nil {
+		return nil
+	}
+	return append([]string{}, ss...)
+}
+
+// cloneNetwork returns a copy of a Network.
+func cloneNetwork(n Network) Network {
+	return Network{Nets: cloneStrings(n.Nets), Ports: cloneStrings(n.Ports)}
+}
+
+// Clone returns a deep copy of a Content. Mutating the copy, including its Pattern and Options,
+// never affects the original.
+func (c *Content) Clone() *Content {
+	if c == nil {
+		return nil
+	}
+	v := *c
+	v.Pattern = cloneBytes(c.Pattern)
+	if c.Options != nil {
+		v.Options = make([]*ContentOption, len(c.Options))
+		for i, o := range c.Options {
+			co := *o
+			v.Options[i] = &co
+		}
+	}
+	v.written = cloneStrings(c.written)
+	for _, name := range positionOptions {
+		if p := c.positionOption(name); p != nil {
+			pv := *p
+			v.setPositionOption(name, &pv)
+		}
+	}
+	return &v
+}
+
+// Clone returns a deep copy of a PCRE. Mutating the copy never affects the original.
+func (p *PCRE) Clone() *PCRE {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	v.Pattern = cloneBytes(p.Pattern)
+	v.Options = cloneBytes(p.Options)
+	v.Vars = cloneStrings(p.Vars)
+	return &v
+}
+
+// Clone returns a deep copy of a ByteMatch. Mutating the copy never affects the original.
+func (b *ByteMatch) Clone() *ByteMatch {
+	if b == nil {
+		return nil
+	}
+	v := *b
+	v.Options = cloneStrings(b.Options)
+	return &v
+}
+
+// Clone returns a deep copy of a LenMatch. Mutating the copy never affects the original.
+func (l *LenMatch) Clone() *LenMatch {
+	if l == nil {
+		return nil
+	}
+	v := *l
+	v.Options = cloneStrings(l.Options)
+	return &v
+}
+
+// Clone returns a copy of a Transform.
+func (t *Transform) Clone() *Transform {
+	if t == nil {
+		return nil
+	}
+	v := *t
+	return &v
+}
+
+// cloneMatcher returns a copy of an orderedMatcher.
+func cloneMatcher(m orderedMatcher) orderedMatcher {
+	switch v := m.(type) {
+	case *Content:
+		return v.Clone()
+	case *PCRE:
+		return v.Clone()
+	case *ByteMatch:
+		return v.Clone()
+	case *LenMatch:
+		return v.Clone()
+	case *Transform:
+		return v.Clone()
+	case *UnknownOption:
+		c := *v
+		return &c
+	}
+	return m
+}
+
+// Clone returns a deep copy of a rule. The copy shares no slices, maps or pointers with the rule,
+// and Matchers hold clones of the original matchers, so mutating the copy never affects the rule.
+func (r *Rule) Clone() *Rule {
+	if r == nil {
+		return nil
+	}
+	c := *r
+	c.Source = cloneNetwork(r.Source)
+	c.Destination = cloneNetwork(r.Destination)
+	c.Statements = cloneStrings(r.Statements)
+	if r.Origin != nil {
+		o := *r.Origin
+		c.Origin = &o
+	}
+
+	if r.References != nil {
+		c.References = make([]*Reference, len(r.References))
+		for i, ref := range r.References {
+			v := *ref
+			c.References[i] = &v
+		}
+	}
+	if r.Tags != nil {
+		c.Tags = make(map[string]string, len(r.Tags))
+		for k, v := range r.Tags {
+			c.Tags[k] = v
+		}
+	}
+	if r.MultiTags != nil {
+		c.MultiTags = make(map[string][]string, len(r.MultiTags))
+		for k, v := range r.MultiTags {
+			c.MultiTags[k] = cloneStrings(v)
+		}
+	}
+	if r.TLSTags != nil {
+		c.TLSTags = make([]*TLSTag, len(r.TLSTags))
+		for i, t := range r.TLSTags {
+			v := *t
+			c.TLSTags[i] = &v
+		}
+	}
+	if r.StreamMatch != nil {
+		v := *r.StreamMatch
+		c.StreamMatch = &v
+	}
+	if r.Metas != nil {
+		c.Metas = make(Metadatas, len(r.Metas))
+		for i, m := range r.Metas {
+			v := *m
+			c.Metas[i] = &v
+		}
+	}
+	if r.Flowbits != nil {
+		c.Flowbits = make([]*Flowbit, len(r.Flowbits))
+		for i, fb := range r.Flowbits {
+			v := *fb
+			c.Flowbits[i] = &v
+		}
+	}
+	if r.Xbits != nil {
+		c.Xbits = make([]*Xbit, len(r.Xbits))
+		for i, xb := range r.Xbits {
+			v := *xb
+			c.Xbits[i] = &v
+		}
+	}
+	if r.Flowints != nil {
+		c.Flowints = make([]*Flowint, len(r.Flowints))
+		for i, fi := range r.Flowints {
+			v := *fi
+			c.Flowints[i] = &v
+		}
+	}
+	if r.Lua != nil {
+		c.Lua = make([]*Lua, len(r.Lua))
+		for i, l := range r.Lua {
+			v := *l
+			c.Lua[i] = &v
+		}
+	}
+	if r.IPProto != nil {
+		c.IPProto = make([]*IPProto, len(r.IPProto))
+		for i, p := range r.IPProto {
+			v := *p
+			c.IPProto[i] = &v
+		}
+	}
+	if r.GeoIP != nil {
+		c.GeoIP = &GeoIP{Direction: r.GeoIP.Direction, Countries: cloneStrings(r.GeoIP.Countries)}
+	}
+	if r.IPRep != nil {
+		c.IPRep = make([]*IPRep, len(r.IPRep))
+		for i, p := range r.IPRep {
+			v := *p
+			c.IPRep[i] = &v
+		}
+	}
+	if r.DCEIface != nil {
+		v := *r.DCEIface
+		c.DCEIface = &v
+	}
+	if r.DCEOpnums != nil {
+		c.DCEOpnums = make([]*OpnumRange, len(r.DCEOpnums))
+		for i, o := range r.DCEOpnums {
+			v := *o
+			c.DCEOpnums[i] = &v
+		}
+	}
+	if r.FileMatches != nil {
+		c.FileMatches = make([]*FileMatch, len(r.FileMatches))
+		for i, f := range r.FileMatches {
+			v := *f
+			c.FileMatches[i] = &v
+		}
+	}
+	if r.Filestore != nil {
+		v := *r.Filestore
+		c.Filestore = &v
+	}
+	if r.Matchers != nil {
+		c.Matchers = make([]orderedMatcher, len(r.Matchers))
+		for i, m := range r.Matchers {
+			c.Matchers[i] = cloneMatcher(m)
+		}
+	}
+	return &c
+}
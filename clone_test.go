@@ -0,0 +1,112 @@
+/* Copyright 2020 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestRuleClone(t *testing.T) {
+	const rule = `alert http [1.2.3.4,5.6.7.8] any -> $HOME_NET [80,443] (msg:"foo"; flow:established,to_server; content:"abc"; http.uri; to_lowercase; content:"def"; nocase; distance:0; pcre:"/(x)/R, flow:x"; byte_test:4,>,10,0,relative; urilen:>10; stream_size:server,>,10; tls.version:1.2; flowbits:set,a; xbits:set,b,track ip_src; flowint:c,+,1; sameip; ip_proto:!6; iprep:src,CnC,>,30; ftpbounce; reference:cve,2020-1234; metadata:foo bar; sid:1; rev:1;)`
+	r, err := ParseRule(rule)
+	if err != nil {
+		t.Fatalf("parse rule failed: %v", err)
+	}
+	r.Origin = &Origin{File: "local.rules", Line: 1}
+	want := r.String()
+	c := r.Clone()
+	if diff := pretty.Compare(c, r); diff != "" {
+		t.Fatal(fmt.Sprintf("diff (-got +want):\n%s", diff))
+	}
+
+	// Mutating the clone must not affect the original rule.
+	c.Source.Nets[0] = "9.9.9.9"
+	c.Destination.Ports[0] = "8080"
+	c.Tags["flow"] = "to_client"
+	c.Statements[0] = "foo"
+	c.IPProto[0].Proto = "17"
+	c.IPRep[0].Value = 10
+	c.Origin.Line = 2
+	c.References[0].Value = "2021-0000"
+	c.Metas[0].Value = "baz"
+	c.TLSTags[0].Value = "1.3"
+	c.StreamMatch.Number = 20
+	c.Flowbits[0].Value = "z"
+	c.Xbits[0].Name = "z"
+	c.Flowints[0].Value = "2"
+	c.Contents()[0].Pattern[0] = 'z'
+	c.Contents()[1].Distance.Int = 5
+	c.PCREs()[0].Pattern[1] = 'y'
+	c.PCREs()[0].Vars[0] = "pkt:y"
+	c.ByteMatchers()[0].Value = "20"
+	c.LenMatchers()[0].Num = 20
+	c.Matchers = c.Matchers[:1]
+
+	if got := r.String(); got != want {
+		t.Fatalf("got %v; expected %v", got, want)
+	}
+	if r.Origin.Line != 1 {
+		t.Fatalf("origin of the original rule changed: %v", r.Origin)
+	}
+	if c.String() == want {
+		t.Fatal("clone was not modified")
+	}
+	if (*Rule)(nil).Clone() != nil {
+		t.Fatal("clone of nil rule is not nil")
+	}
+}
+
+func TestMatcherClone(t *testing.T) {
+	c := &Content{Pattern: []byte("abc"), Depth: &IntOrVar{Int: 3}, FastPattern: FastPattern{Enabled: true}}
+	cc := c.Clone()
+	if diff := pretty.Compare(cc, c); diff != "" {
+		t.Fatal(fmt.Sprintf("content: diff (-got +want):\n%s", diff))
+	}
+	cc.Pattern[0] = 'z'
+	cc.Depth.Int = 4
+	cc.Options = append(cc.Options, &ContentOption{Name: "nocase"})
+	if got, want := c.String(), `content:"abc"; depth:3; fast_pattern;`; got != want {
+		t.Fatalf("content: got %v; expected %v", got, want)
+	}
+
+	p := &PCRE{Pattern: []byte("(a)"), Options: []byte("R"), Vars: []string{"flow:a"}}
+	pc := p.Clone()
+	if diff := pretty.Compare(pc, p); diff != "" {
+		t.Fatal(fmt.Sprintf("pcre: diff (-got +want):\n%s", diff))
+	}
+	pc.Pattern[1], pc.Options[0], pc.Vars[0] = 'b', 'i', "pkt:b"
+	if got, want := p.String(), `pcre:"/(a)/R, flow:a";`; got != want {
+		t.Fatalf("pcre: got %v; expected %v", got, want)
+	}
+
+	b := &ByteMatch{Kind: bJump, NumBytes: "4", Offset: 0, Options: []string{"foo"}}
+	bc := b.Clone()
+	if diff := pretty.Compare(bc, b); diff != "" {
+		t.Fatal(fmt.Sprintf("byte_match: diff (-got +want):\n%s", diff))
+	}
+	bc.Options[0] = "bar"
+	if b.Options[0] != "foo" {
+		t.Fatalf("byte_match: got %v; expected foo", b.Options[0])
+	}
+
+	var nc *Content
+	if nc.Clone() != nil {
+		t.Fatal("clone of nil content is not nil")
+	}
+}
@@ -0,0 +1,234 @@
+/* Copyright 2020 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// EqualOptions controls which fields are ignored when comparing rules.
+type EqualOptions struct {
+	// IgnoreSID ignores the sid of the rules.
+	IgnoreSID bool
+	// IgnoreRevision ignores the rev of the rules.
+	IgnoreRevision bool
+	// IgnoreDescription ignores the msg of the rules.
+	IgnoreDescription bool
+	// IgnoreMetadata ignores the metadata of the rules.
+	IgnoreMetadata bool
+	// IgnoreReferences ignores the references of the rules.
+	IgnoreReferences bool
+}
+
+// Equals returns true if two rules are functionally equal. The revision is ignored, as is the
+// ordering of keywords where it has no effect on detection (tags, metadata, references, flowbits,
+// content options, etc.). The order of matchers is significant.
+func (r *Rule) Equals(other *Rule) bool {
+	return r.EqualsWithOptions(other, EqualOptions{IgnoreRevision: true})
+}
+
+// EqualsWithOptions returns true if two rules are functionally equal, ignoring the fields
+// specified in opts.
+func (r *Rule) EqualsWithOptions(other *Rule, opts EqualOptions) bool {
+	if r == nil || other == nil {
+		return r == other
+	}
+	return r.canonical(opts) == other.canonical(opts)
+}
+
+// Hash returns a hex encoded SHA-256 fingerprint of a rule, for deduplication and change tracking.
+//
+// The fingerprint covers Disabled, Action, Protocol, Source, Bidirectional, Destination, GID, SID,
+// Description, Matchers, StreamMatch, TLSTags, Classtype, Priority, Target, Tags, PrefilterTag,
+// Statements, SameIP, IPProto, GeoIP, IPRep, DCEIface, DCEOpnums, NoAlert, Flowbits, Flowints,
+// Xbits, Lua, FileMatches, Filestore and References. Revision and Metas are not included, and
+// ordering is normalized the same way as in Equals, so a revision bump or reordered tags do not
+// change the hash.
+func (r *Rule) Hash() string {
+	sum := sha256.Sum256([]byte(r.canonical(EqualOptions{IgnoreRevision: true, IgnoreMetadata: true})))
+	return hex.EncodeToString(sum[:])
+}
+
+// sortedStrings returns a sorted copy of ss.
+func sortedStrings(ss []string) []string {
+	s := make([]string, len(ss))
+	copy(s, ss)
+	sort.Strings(s)
+	return s
+}
+
+// canonicalString returns a string for a Content, where the ordering of options is ignored.
+// Modifiers selecting a buffer (e.g. http_uri) are left out, as the buffer is written with the
+// content by canonical.
+func (c Content) canonicalString() string {
+	opts := c.positionStrings()
+	for _, o := range c.Options {
+		if _, ok := cOptToStickyBuffer[o.Name]; ok {
+			continue
+		}
+		opts = append(opts, o.String())
+	}
+	return fmt.Sprintf("content:%v,%q,%v,%v,%s,%v", c.Negate, c.Pattern, c.Nocase, sortedStrings(opts), c.FastPattern,
+		c.Prefilter)
+}
+
+// canonical returns a normalized representation of a rule that should be identical for
+// functionally equal rules.
+func (r *Rule) canonical(opts EqualOptions) string {
+	var s strings.Builder
+	w := func(k string, v interface{}) {
+		s.WriteString(fmt.Sprintf("%s=%v\n", k, v))
+	}
+
+	w("disabled", r.Disabled)
+	w("action", r.Action)
+	w("protocol", r.Protocol)
+	w("src", sortedStrings(r.Source.Nets))
+	w("sport", sortedStrings(r.Source.Ports))
+	w("bidirectional", r.Bidirectional)
+	w("dst", sortedStrings(r.Destination.Nets))
+	w("dport", sortedStrings(r.Destination.Ports))
+	w("gid", r.effectiveGID())
+	if !opts.IgnoreSID {
+		w("sid", r.SID)
+	}
+	if !opts.IgnoreRevision {
+		w("rev", r.Revision)
+	}
+	if !opts.IgnoreDescription {
+		w("msg", r.Description)
+	}
+
+	for _, m := range r.Matchers {
+		d, _ := matcherDataPos(m)
+		if c, ok := m.(*Content); ok {
+			w(c.buffer().String(), c.canonicalString())
+			continue
+		}
+		w(d.String(), m)
+	}
+
+	if r.StreamMatch != nil {
+		w("stream_size", r.StreamMatch)
+	}
+
+	var ss []string
+	for _, t := range r.TLSTags {
+		ss = append(ss, t.String())
+	}
+	w("tls", sortedStrings(ss))
+
+	if !opts.IgnoreMetadata {
+		ss = ss[:0]
+		for _, m := range r.Metas {
+			ss = append(ss, m.Key+" "+m.Value)
+		}
+		w("metadata", sortedStrings(ss))
+	}
+
+	ss = ss[:0]
+	for _, t := range r.typedTags() {
+		ss = append(ss, t[0]+":"+t[1])
+	}
+	for k, v := range r.Tags {
+		// Order of flow options is not significant.
+		if k == "flow" {
+			var fs []string
+			for _, f := range strings.Split(v, ",") {
+				fs = append(fs, strings.TrimSpace(f))
+			}
+			v = strings.Join(sortedStrings(fs), ",")
+		}
+		ss = append(ss, k+":"+v)
+	}
+	for k, vs := range r.MultiTags {
+		for _, v := range vs {
+			ss = append(ss, k+":"+v)
+		}
+	}
+	w("tags", sortedStrings(ss))
+	if r.PrefilterTag != "" {
+		w("prefilter", r.PrefilterTag)
+	}
+	w("statements", sortedStrings(r.Statements))
+	w("sameip", r.SameIP)
+	if r.DCEIface != nil {
+		w("dce_iface", strings.ToLower(r.DCEIface.String()))
+	}
+	if len(r.DCEOpnums) > 0 {
+		w("dce_opnum", dceOpnumString(r.DCEOpnums))
+	}
+	if r.GeoIP != nil {
+		w("geoip", r.GeoIP.Direction+","+strings.Join(sortedStrings(r.GeoIP.Countries), ","))
+	}
+	ss = ss[:0]
+	for _, p := range r.IPProto {
+		ss = append(ss, p.String())
+	}
+	w("ip_proto", sortedStrings(ss))
+	ss = ss[:0]
+	for _, i := range r.IPRep {
+		ss = append(ss, i.String())
+	}
+	w("iprep", sortedStrings(ss))
+	w("noalert", r.NoAlert)
+
+	ss = ss[:0]
+	for _, fb := range r.Flowbits {
+		ss = append(ss, fb.String())
+	}
+	w("flowbits", sortedStrings(ss))
+
+	ss = ss[:0]
+	for _, fi := range r.Flowints {
+		ss = append(ss, fi.String())
+	}
+	w("flowints", sortedStrings(ss))
+
+	ss = ss[:0]
+	for _, xb := range r.Xbits {
+		ss = append(ss, xb.String())
+	}
+	w("xbits", sortedStrings(ss))
+
+	ss = ss[:0]
+	for _, l := range r.Lua {
+		ss = append(ss, l.String())
+	}
+	w("lua", sortedStrings(ss))
+
+	ss = ss[:0]
+	for _, f := range r.FileMatches {
+		ss = append(ss, f.String())
+	}
+	w("file", sortedStrings(ss))
+	if r.Filestore != nil {
+		w("filestore", r.Filestore)
+	}
+
+	if !opts.IgnoreReferences {
+		ss = ss[:0]
+		for _, ref := range r.References {
+			ss = append(ss, ref.String())
+		}
+		w("references", sortedStrings(ss))
+	}
+	return s.String()
+}
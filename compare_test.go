@@ -0,0 +1,186 @@
+/* Copyright 2020 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"testing"
+)
+
+func TestEquals(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		a    string
+		b    string
+		opts *EqualOptions
+		want bool
+	}{
+		{
+			name: "identical",
+			a:    `alert tcp $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; content:"bar"; sid:1; rev:1;)`,
+			b:    `alert tcp $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; content:"bar"; sid:1; rev:1;)`,
+			want: true,
+		},
+		{
+			name: "revision and whitespace",
+			a:    `alert tcp $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; content:"bar"; sid:1; rev:1;)`,
+			b:    `alert tcp   $HOME_NET any ->  $EXTERNAL_NET 80 (msg:"foo";content:"bar";  sid:1; rev:7;)`,
+			want: true,
+		},
+		{
+			name: "tag, metadata and option ordering",
+			a:    `alert tcp $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; flow:established,to_server; content:"bar"; nocase; depth:10; classtype:trojan-activity; metadata:foo bar, baz qux; reference:cve,2020-1; reference:url,example.com; sid:1; rev:1;)`,
+			b:    `alert tcp $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; classtype:trojan-activity; flow:to_server, established; content:"bar"; depth:10; nocase; metadata:baz qux; metadata:foo bar; reference:url,example.com; reference:cve,2020-1; sid:1; rev:2;)`,
+			want: true,
+		},
+		{
+			name: "content modifier and sticky buffer",
+			a:    `alert http $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; content:"/x"; http_uri; sid:1; rev:1;)`,
+			b:    `alert http $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; http.uri; content:"/x"; sid:1; rev:1;)`,
+			want: true,
+		},
+		{
+			name: "content modifier and other buffer",
+			a:    `alert http $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; content:"/x"; http_uri; sid:1; rev:1;)`,
+			b:    `alert http $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; http.header; content:"/x"; sid:1; rev:1;)`,
+			want: false,
+		},
+		{
+			name: "different content",
+			a:    `alert tcp $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; content:"bar"; sid:1; rev:1;)`,
+			b:    `alert tcp $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; content:"baz"; sid:1; rev:1;)`,
+			want: false,
+		},
+		{
+			name: "different matcher order",
+			a:    `alert tcp $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; content:"bar"; content:"baz"; distance:0; sid:1; rev:1;)`,
+			b:    `alert tcp $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; content:"baz"; content:"bar"; distance:0; sid:1; rev:1;)`,
+			want: false,
+		},
+		{
+			name: "different buffer",
+			a:    `alert http $HOME_NET any -> $EXTERNAL_NET any (msg:"foo"; http.uri; content:"bar"; sid:1; rev:1;)`,
+			b:    `alert http $HOME_NET any -> $EXTERNAL_NET any (msg:"foo"; http.host; content:"bar"; sid:1; rev:1;)`,
+			want: false,
+		},
+		{
+			name: "different network",
+			a:    `alert tcp $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; content:"bar"; sid:1; rev:1;)`,
+			b:    `alert tcp $HOME_NET any -> $EXTERNAL_NET 443 (msg:"foo"; content:"bar"; sid:1; rev:1;)`,
+			want: false,
+		},
+		{
+			name: "different sid",
+			a:    `alert tcp $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; content:"bar"; sid:1; rev:1;)`,
+			b:    `alert tcp $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; content:"bar"; sid:2; rev:1;)`,
+			want: false,
+		},
+		{
+			name: "ignore sid",
+			a:    `alert tcp $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; content:"bar"; sid:1; rev:1;)`,
+			b:    `alert tcp $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; content:"bar"; sid:2; rev:1;)`,
+			opts: &EqualOptions{IgnoreSID: true},
+			want: true,
+		},
+		{
+			name: "revision not ignored",
+			a:    `alert tcp $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; content:"bar"; sid:1; rev:1;)`,
+			b:    `alert tcp $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; content:"bar"; sid:1; rev:2;)`,
+			opts: &EqualOptions{},
+			want: false,
+		},
+		{
+			name: "ignore metadata and references",
+			a:    `alert tcp $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; content:"bar"; metadata:foo bar; sid:1; rev:1;)`,
+			b:    `alert tcp $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; content:"bar"; reference:cve,2020-1; sid:1; rev:1;)`,
+			opts: &EqualOptions{IgnoreMetadata: true, IgnoreReferences: true},
+			want: true,
+		},
+	} {
+		a, err := ParseRule(tt.a)
+		if err != nil {
+			t.Fatalf("%s: parse rule failed: %v", tt.name, err)
+		}
+		b, err := ParseRule(tt.b)
+		if err != nil {
+			t.Fatalf("%s: parse rule failed: %v", tt.name, err)
+		}
+		var got bool
+		if tt.opts == nil {
+			got = a.Equals(b)
+		} else {
+			got = a.EqualsWithOptions(b, *tt.opts)
+		}
+		if got != tt.want {
+			t.Fatalf("%s: got %v; expected %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		a    string
+		b    string
+		want bool
+	}{
+		{
+			name: "revision bump",
+			a:    `alert tcp $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; content:"bar"; sid:1; rev:1;)`,
+			b:    `alert tcp $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; content:"bar"; sid:1; rev:2;)`,
+			want: true,
+		},
+		{
+			name: "metadata and tag ordering",
+			a:    `alert tcp $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; flow:established,to_server; content:"bar"; classtype:misc-activity; metadata:created_at 2020_01_01; sid:1; rev:1;)`,
+			b:    `alert tcp $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; classtype:misc-activity; flow:established,to_server; content:"bar"; metadata:updated_at 2020_02_02; sid:1; rev:2;)`,
+			want: true,
+		},
+		{
+			name: "content modifier and sticky buffer",
+			a:    `alert http $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; content:"/x"; http_uri; sid:1; rev:1;)`,
+			b:    `alert http $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; http.uri; content:"/x"; sid:1; rev:2;)`,
+			want: true,
+		},
+		{
+			name: "matcher change",
+			a:    `alert tcp $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; content:"bar"; sid:1; rev:1;)`,
+			b:    `alert tcp $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; content:"bar"; nocase; sid:1; rev:1;)`,
+			want: false,
+		},
+		{
+			name: "pcre change",
+			a:    `alert tcp $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; content:"bar"; pcre:"/bar$/"; sid:1; rev:1;)`,
+			b:    `alert tcp $HOME_NET any -> $EXTERNAL_NET 80 (msg:"foo"; content:"bar"; pcre:"/bar$/i"; sid:1; rev:1;)`,
+			want: false,
+		},
+	} {
+		a, err := ParseRule(tt.a)
+		if err != nil {
+			t.Fatalf("%s: parse rule failed: %v", tt.name, err)
+		}
+		b, err := ParseRule(tt.b)
+		if err != nil {
+			t.Fatalf("%s: parse rule failed: %v", tt.name, err)
+		}
+		if got := a.Hash() == b.Hash(); got != tt.want {
+			t.Fatalf("%s: got equal hashes %v; expected %v", tt.name, got, tt.want)
+		}
+		// Hashes must be stable.
+		if a.Hash() != a.Hash() {
+			t.Fatalf("%s: hash is not stable", tt.name)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+/* Copyright 2020 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"fmt"
+)
+
+// Dialect is the rule language of an IDS engine.
+type Dialect int
+
+const (
+	// Snort is the Snort 2.x rule language.
+	Snort Dialect = iota
+	// Suricata is the Suricata 5.x rule language.
+	Suricata
+)
+
+var dialectVals = map[Dialect]string{
+	Snort:    "snort",
+	Suricata: "suricata",
+}
+
+// String returns the name of a Dialect.
+func (d Dialect) String() string {
+	return dialectVals[d]
+}
+
+// Suricata sticky buffers mapped to Snort content modifiers.
+var stickyBufferToSnortCOpt = map[DataPos]string{
+	httpClientBody: "http_client_body",
+	httpCookie:     "http_cookie",
+	httpHeader:     "http_header",
+	httpHeaderRaw:  "http_raw_header",
+	httpMethod:     "http_method",
+	httpStatCode:   "http_stat_code",
+	httpStatMsg:    "http_stat_msg",
+	httpURI:        "http_uri",
+	httpURIRaw:     "http_raw_uri",
+}
+
+// Sticky buffers supported by Snort.
+var snortStickyBuffers = map[DataPos]bool{
+	pktData:    true,
+	fileData:   true,
+	base64Data: true,
+}
+
+// Content options only supported by Snort.
+var snortOnlyCOpts = []string{"http_raw_cookie"}
+
+// Content options only supported by Suricata.
+var suricataOnlyCOpts = []string{"startswith", "endswith"}
+
+// Suricata application layer protocols mapped to the transport protocol used in Snort.
+var appProtoToSnortProto = map[string]string{
+	"http": "tcp",
+	"ftp":  "tcp",
+	"smtp": "tcp",
+	"ssh":  "tcp",
+	"tls":  "tcp",
+}
+
+// Protocols supported by Snort.
+var snortProtocols = []string{"ip", "tcp", "udp", "icmp"}
+
+// ConvertDialect rewrites a rule to the target dialect. Known incompatibilities are rewritten, for
+// example uricontent or http_* content modifiers are moved to Suricata sticky buffers, and sticky
+// buffers are moved back to content modifiers for Snort. An error is returned, and the rule is
+// left unchanged, if the rule uses something that cannot be expressed in the target dialect.
+func (r *Rule) ConvertDialect(target Dialect) error {
+	switch target {
+	case Snort:
+		return r.convertToSnort()
+	case Suricata:
+		return r.convertToSuricata()
+	}
+	return fmt.Errorf("unsupported dialect %d", int(target))
+}
+
+// convertToSuricata rewrites a Snort rule for Suricata.
+func (r *Rule) convertToSuricata() error {
+	for _, c := range r.Contents() {
+		for _, o := range c.Options {
+			if inSlice(o.Name, snortOnlyCOpts) {
+				return fmt.Errorf("content option %s is not supported by suricata", o.Name)
+			}
+		}
+	}
+	r.SnortHTTPHeaderFix()
+	r.SnortURILenFix()
+	r.UpgradeToSuri5()
+	return nil
+}
+
+// convertToSnort rewrites a Suricata rule for Snort.
+func (r *Rule) convertToSnort() error {
+	protocol := r.Protocol
+	if p, ok := appProtoToSnortProto[protocol]; ok {
+		protocol = p
+	}
+	if !inSlice(protocol, snortProtocols) {
+		return fmt.Errorf("protocol %s is not supported by snort", r.Protocol)
+	}
+
+	for _, m := range r.Matchers {
+		d, _ := matcherDataPos(m)
+		if snortStickyBuffers[suricata4Buffer(d)] {
+			continue
+		}
+		if _, ok := stickyBufferToSnortCOpt[d]; ok {
+			if _, isContent := m.(*Content); isContent {
+				continue
+			}
+		}
+		return fmt.Errorf("buffer %s is not supported by snort", d)
+	}
+	for _, c := range r.Contents() {
+		for _, o := range c.Options {
+			if inSlice(o.Name, suricataOnlyCOpts) {
+				return fmt.Errorf("content option %s is not supported by snort", o.Name)
+			}
+		}
+	}
+	for _, l := range r.LenMatchers() {
+		if l.Kind == bSize {
+			return fmt.Errorf("%s is not supported by snort", l.Kind)
+		}
+	}
+
+	var modified bool
+	if protocol != r.Protocol {
+		r.Protocol = protocol
+		modified = true
+	}
+	for _, m := range r.Matchers {
+		switch v := m.(type) {
+		case *Content:
+			if opt, ok := stickyBufferToSnortCOpt[v.DataPosition]; ok {
+				v.DataPosition = pktData
+				v.Options = append(v.Options, &ContentOption{Name: opt})
+				modified = true
+			} else if d := suricata4Buffer(v.DataPosition); d != v.DataPosition {
+				v.DataPosition = d
+				modified = true
+			}
+		case *ByteMatch:
+			if d := suricata4Buffer(v.DataPosition); d != v.DataPosition {
+				v.DataPosition = d
+				modified = true
+			}
+		case *LenMatch:
+			if d := suricata4Buffer(v.DataPosition); d != v.DataPosition {
+				v.DataPosition = d
+				modified = true
+			}
+		}
+	}
+
+	if modified {
+		r.Metas = append(r.Metas, MetadataModifier("convert_to_snort"))
+	}
+	return nil
+}
+
+// suricata4Buffer returns the Suricata 4.x name of a Suricata 5.0 sticky buffer, if there is one.
+func suricata4Buffer(d DataPos) DataPos {
+	for k, v := range suri4StickyTo5Sticky {
+		if v == d {
+			return k
+		}
+	}
+	return d
+}
@@ -0,0 +1,109 @@
+/* Copyright 2020 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"testing"
+)
+
+func TestConvertDialect(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		input   string
+		target  Dialect
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "uricontent to suricata",
+			input:  `alert tcp $HOME_NET any -> $EXTERNAL_NET $HTTP_PORTS (msg:"foo"; uricontent:"/index.php"; nocase; content:"bar"; http_header; sid:1; rev:1;)`,
+			target: Suricata,
+			want:   `alert tcp $HOME_NET any -> $EXTERNAL_NET $HTTP_PORTS (msg:"foo"; http.uri; content:"/index.php"; nocase; http.header; content:"bar"; metadata:gonids upgrade_to_suri5; sid:1; rev:1;)`,
+		},
+		{
+			name:   "suricata 4 sticky buffer to suricata",
+			input:  `alert dns $HOME_NET any -> any any (msg:"foo"; dns_query; content:"foo"; sid:1; rev:1;)`,
+			target: Suricata,
+			want:   `alert dns $HOME_NET any -> any any (msg:"foo"; dns.query; content:"foo"; metadata:gonids upgrade_to_suri5; sid:1; rev:1;)`,
+		},
+		{
+			name:   "nothing to convert to suricata",
+			input:  `alert tcp $HOME_NET any -> any any (msg:"foo"; content:"foo"; sid:1; rev:1;)`,
+			target: Suricata,
+			want:   `alert tcp $HOME_NET any -> any any (msg:"foo"; content:"foo"; sid:1; rev:1;)`,
+		},
+		{
+			name:    "snort only option to suricata",
+			input:   `alert tcp $HOME_NET any -> any any (msg:"foo"; content:"foo"; http_raw_cookie; sid:1; rev:1;)`,
+			target:  Suricata,
+			wantErr: true,
+		},
+		{
+			name:   "sticky buffers to snort",
+			input:  `alert http $HOME_NET any -> $EXTERNAL_NET any (msg:"foo"; http.uri; content:"/index.php"; nocase; http.method; content:"POST"; file.data; content:"bar"; sid:1; rev:1;)`,
+			target: Snort,
+			want:   `alert tcp $HOME_NET any -> $EXTERNAL_NET any (msg:"foo"; content:"/index.php"; http_uri; nocase; content:"POST"; http_method; file_data; content:"bar"; metadata:gonids convert_to_snort; sid:1; rev:1;)`,
+		},
+		{
+			name:   "nothing to convert to snort",
+			input:  `alert tcp $HOME_NET any -> any any (msg:"foo"; content:"foo"; sid:1; rev:1;)`,
+			target: Snort,
+			want:   `alert tcp $HOME_NET any -> any any (msg:"foo"; content:"foo"; sid:1; rev:1;)`,
+		},
+		{
+			name:    "unsupported buffer to snort",
+			input:   `alert dns $HOME_NET any -> any any (msg:"foo"; dns.query; content:"foo"; sid:1; rev:1;)`,
+			target:  Snort,
+			wantErr: true,
+		},
+		{
+			name:    "unsupported protocol to snort",
+			input:   `alert smb $HOME_NET any -> any any (msg:"foo"; content:"foo"; sid:1; rev:1;)`,
+			target:  Snort,
+			wantErr: true,
+		},
+		{
+			name:    "bsize to snort",
+			input:   `alert http $HOME_NET any -> any any (msg:"foo"; http.uri; content:"foo"; bsize:10; sid:1; rev:1;)`,
+			target:  Snort,
+			wantErr: true,
+		},
+		{
+			name:    "unsupported option to snort",
+			input:   `alert tcp $HOME_NET any -> any any (msg:"foo"; content:"foo"; startswith; sid:1; rev:1;)`,
+			target:  Snort,
+			wantErr: true,
+		},
+	} {
+		r, err := ParseRule(tt.input)
+		if err != nil {
+			t.Fatalf("%s: parse rule failed: %v", tt.name, err)
+		}
+		err = r.ConvertDialect(tt.target)
+		if tt.wantErr != (err != nil) {
+			t.Fatalf("%s: gotErr=%v; wantErr=%v", tt.name, err, tt.wantErr)
+		}
+		if err != nil {
+			if r.String() != tt.input {
+				t.Fatalf("%s: rule changed on error, got %s", tt.name, r)
+			}
+			continue
+		}
+		if r.String() != tt.want {
+			t.Fatalf("%s: got %s -- expected %s", tt.name, r, tt.want)
+		}
+	}
+}
@@ -36,3 +36,22 @@ func TestFuzz(t *testing.T) {
 		FuzzParseRule([]byte(tt.rule))
 	}
 }
+
+// fuzzSeeds are the seed corpus of FuzzRule, in addition to testdata/fuzz/FuzzRule.
+var fuzzSeeds = []string{
+	`alert tcp $HOME_NET any -> $EXTERNAL_NET any (msg:"foo"; content:"bar"; sid:1; rev:1;)`,
+	`alert http $HOME_NET any -> $EXTERNAL_NET any (msg:"foo"; flow:established,to_server; http.uri; to_lowercase; content:"/index.php"; fast_pattern; content:"|00 01|"; distance:0; within:10; pcre:"/foo/Ri"; byte_extract:2,0,len,relative; byte_test:2,>,len,0,relative; urilen:>10; metadata:foo bar; reference:cve,2020-1234; sid:1; rev:1;)`,
+	`#alert tcp [1.1.1.1,![2.2.2.2,3.3.3.3]] !$HTTP_PORTS <> any [80,1024:] (msg:"disabled"; base64_decode:bytes 10,relative; base64_data; content:"foo"; gid:3; sid:2; rev:2;)`,
+	`alert tcp any any -> any any (msg:"unterminated"; content:"|4`,
+	`alert tcp any any -> any any (msg:"foo"; content:"a"; distance:; byte_math:bytes 1, offset 0, oper +, rvalue x, result y; sid:a;)`,
+}
+
+func FuzzRule(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add([]byte(s))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Any panic fails the fuzz target, parse errors are expected.
+		FuzzParseRule(data)
+	})
+}
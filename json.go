@@ -0,0 +1,266 @@
+/* Copyright 2020 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MarshalText returns the sticky buffer name of a DataPos.
+func (d DataPos) MarshalText() ([]byte, error) {
+	s, ok := stickyBuffers[d]
+	if !ok {
+		return nil, fmt.Errorf("unknown data position %d", int(d))
+	}
+	return []byte(s), nil
+}
+
+// UnmarshalText sets a DataPos from a sticky buffer name.
+func (d *DataPos) UnmarshalText(b []byte) error {
+	v, err := StickyBuffer(string(b))
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+// MarshalText returns the keyword of a byteMatchType.
+func (b byteMatchType) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+// UnmarshalText sets a byteMatchType from a keyword, an empty keyword is bUnknown.
+func (b *byteMatchType) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*b = bUnknown
+		return nil
+	}
+	v, err := byteMatcher(string(text))
+	if err != nil {
+		return err
+	}
+	*b = v
+	return nil
+}
+
+// MarshalText returns the keyword of a lenMatchType.
+func (i lenMatchType) MarshalText() ([]byte, error) {
+	return []byte(i.String()), nil
+}
+
+// UnmarshalText sets a lenMatchType from a keyword, an empty keyword is lUnknown.
+func (i *lenMatchType) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*i = lUnknown
+		return nil
+	}
+	v, err := lenMatcher(string(text))
+	if err != nil {
+		return err
+	}
+	*i = v
+	return nil
+}
+
+// jsonMatcherTypes maps the JSON type name of each orderedMatcher to a constructor.
+var jsonMatcherTypes = map[string]func() orderedMatcher{
+	"content":    func() orderedMatcher { return &Content{} },
+	"pcre":       func() orderedMatcher { return &PCRE{} },
+	"byte_match": func() orderedMatcher { return &ByteMatch{} },
+	"len_match":  func() orderedMatcher { return &LenMatch{} },
+	"transform":  func() orderedMatcher { return &Transform{} },
+	"unknown":    func() orderedMatcher { return &UnknownOption{} },
+}
+
+// jsonMatcherType returns the JSON type name of an orderedMatcher.
+func jsonMatcherType(m orderedMatcher) (string, error) {
+	switch m.(type) {
+	case *Content:
+		return "content", nil
+	case *PCRE:
+		return "pcre", nil
+	case *ByteMatch:
+		return "byte_match", nil
+	case *LenMatch:
+		return "len_match", nil
+	case *Transform:
+		return "transform", nil
+	case *UnknownOption:
+		return "unknown", nil
+	}
+	return "", fmt.Errorf("unsupported matcher type %T", m)
+}
+
+// jsonMatcher is the JSON representation of an orderedMatcher, tagged with its type.
+type jsonMatcher struct {
+	Type  string
+	Value json.RawMessage
+}
+
+// ruleAlias has the fields of a Rule, without its JSON methods.
+type ruleAlias Rule
+
+// jsonRule is the JSON representation of a Rule. Matchers shadows the field of the embedded Rule.
+type jsonRule struct {
+	*ruleAlias
+	Matchers []jsonMatcher
+}
+
+// MarshalJSON returns the JSON encoding of a Rule. The order of Matchers is preserved.
+func (r Rule) MarshalJSON() ([]byte, error) {
+	jr := jsonRule{ruleAlias: (*ruleAlias)(&r)}
+	for _, m := range r.Matchers {
+		t, err := jsonMatcherType(m)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		jr.Matchers = append(jr.Matchers, jsonMatcher{Type: t, Value: v})
+	}
+	return json.Marshal(jr)
+}
+
+// UnmarshalJSON sets a Rule from its JSON encoding.
+func (r *Rule) UnmarshalJSON(b []byte) error {
+	jr := jsonRule{ruleAlias: (*ruleAlias)(r)}
+	if err := json.Unmarshal(b, &jr); err != nil {
+		return err
+	}
+	r.Matchers = nil
+	for _, jm := range jr.Matchers {
+		newMatcher, ok := jsonMatcherTypes[jm.Type]
+		if !ok {
+			return fmt.Errorf("unsupported matcher type %q", jm.Type)
+		}
+		m := newMatcher()
+		if err := json.Unmarshal(jm.Value, m); err != nil {
+			return err
+		}
+		r.Matchers = append(r.Matchers, m)
+	}
+	return nil
+}
+
+// suricataNetwork is the JSON representation of a Network in ToSuricataJSON.
+type suricataNetwork struct {
+	Addresses []string `json:"addresses"`
+	Ports     []string `json:"ports"`
+}
+
+// suricataKeyword is the JSON representation of a keyword in ToSuricataJSON.
+type suricataKeyword struct {
+	Name    string      `json:"name"`
+	Negated bool        `json:"negated,omitempty"`
+	Value   interface{} `json:"value,omitempty"`
+}
+
+// suricataRule is the JSON representation of a Rule in ToSuricataJSON.
+type suricataRule struct {
+	Disabled    bool              `json:"disabled,omitempty"`
+	Action      string            `json:"action"`
+	Protocol    string            `json:"protocol"`
+	Source      suricataNetwork   `json:"source"`
+	Direction   string            `json:"direction"`
+	Destination suricataNetwork   `json:"destination"`
+	Keywords    []suricataKeyword `json:"keywords"`
+}
+
+// suricataValue returns the typed value of a keyword: quoted values are strings, integers are
+// numbers, and comma separated values are lists of strings and numbers.
+func suricataValue(i item) interface{} {
+	if i.typ == itemOptionValueString {
+		return i.value
+	}
+	scalar := func(s string) interface{} {
+		s = strings.TrimSpace(s)
+		if n, err := strconv.Atoi(s); err == nil {
+			return n
+		}
+		return s
+	}
+	if !strings.Contains(i.value, ",") {
+		return scalar(i.value)
+	}
+	var vs []interface{}
+	for _, v := range strings.Split(i.value, ",") {
+		vs = append(vs, scalar(v))
+	}
+	return vs
+}
+
+// ToSuricataJSON returns a JSON encoding of a rule close to the way Suricata describes a parsed
+// rule: the header, and an array of keywords in the order they are written by String, with their
+// Suricata names and typed values. Content options (e.g. nocase, depth) are keywords following the
+// content they apply to, as in the rule.
+func (r *Rule) ToSuricataJSON() ([]byte, error) {
+	sr := suricataRule{
+		Disabled:    r.Disabled,
+		Action:      r.Action,
+		Protocol:    r.Protocol,
+		Source:      suricataNetwork{Addresses: r.Source.Nets, Ports: r.Source.Ports},
+		Direction:   "->",
+		Destination: suricataNetwork{Addresses: r.Destination.Nets, Ports: r.Destination.Ports},
+		Keywords:    []suricataKeyword{},
+	}
+	if r.Bidirectional {
+		sr.Direction = "<>"
+	}
+
+	opts := DefaultRenderOptions()
+	opts.IncludeDisabledComment = false
+	l, err := lex(r.StringOpts(opts))
+	if err != nil {
+		return nil, err
+	}
+	defer l.close()
+	var negated bool
+	for {
+		i := l.nextItem()
+		switch i.typ {
+		case itemError:
+			return nil, fmt.Errorf("cannot lex rule: %s", i.value)
+		case itemEOR, itemEOF:
+			// Operators (e.g. "->", "<>") are not escaped, unlike with json.Marshal.
+			var b bytes.Buffer
+			enc := json.NewEncoder(&b)
+			enc.SetEscapeHTML(false)
+			if err := enc.Encode(sr); err != nil {
+				return nil, err
+			}
+			return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+		case itemOptionKey:
+			sr.Keywords = append(sr.Keywords, suricataKeyword{Name: i.value})
+			negated = false
+		case itemNot:
+			negated = true
+		case itemOptionValue, itemOptionValueString:
+			if len(sr.Keywords) == 0 {
+				return nil, fmt.Errorf("value %q has no keyword", i.value)
+			}
+			k := &sr.Keywords[len(sr.Keywords)-1]
+			k.Negated, k.Value = negated, suricataValue(i)
+			negated = false
+		}
+	}
+}
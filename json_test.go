@@ -0,0 +1,136 @@
+/* Copyright 2020 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		input string
+	}{
+		{
+			name:  "simple rule",
+			input: `alert udp $HOME_NET any -> $EXTERNAL_NET any (msg:"foo"; content:"|28|foo"; content:".AA"; within:40; sid:1337; rev:1;)`,
+		},
+		{
+			name:  "binary content",
+			input: `alert tcp $HOME_NET any -> $EXTERNAL_NET any (msg:"foo"; content:"|00 01 FF|abc|3B|"; sid:1337; rev:1;)`,
+		},
+		{
+			name:  "all matcher types",
+			input: `alert http $HOME_NET any -> $EXTERNAL_NET any (msg:"foo"; flow:established,to_server; http.uri; to_lowercase; content:"/index.php"; fast_pattern; urilen:>10; content:"bar"; byte_extract:2,0,len,relative; byte_test:2,>,len,0,relative; pcre:"/foo/Ri"; file_data; isdataat:!1,relative; tls.version:1.2; stream_size:client,<,10; metadata:foo bar; classtype:trojan-activity; priority:1; sameip; flowbits:set,foo; flowint:cnt,+,1; xbits:set,foo,track ip_src; reference:cve,2020-1; sid:1337; rev:1;)`,
+		},
+		{
+			name:  "disabled bidirectional rule",
+			input: `#alert tcp [1.1.1.1,2.2.2.2] any <> $EXTERNAL_NET [80,443] (msg:"foo"; content:"bar"; sid:1337; rev:1;)`,
+		},
+	} {
+		r, err := ParseRule(tt.input)
+		if err != nil {
+			t.Fatalf("%s: parse rule failed: %v", tt.name, err)
+		}
+		b, err := json.Marshal(r)
+		if err != nil {
+			t.Fatalf("%s: marshal failed: %v", tt.name, err)
+		}
+		got := new(Rule)
+		if err := json.Unmarshal(b, got); err != nil {
+			t.Fatalf("%s: unmarshal failed: %v", tt.name, err)
+		}
+		diff := pretty.Compare(got, r)
+		if diff != "" {
+			t.Fatal(fmt.Sprintf("%s: diff (-got +want):\n%s", tt.name, diff))
+		}
+		if got.String() != r.String() {
+			t.Fatalf("%s: got %v; expected %v", tt.name, got, r)
+		}
+	}
+}
+
+func TestJSONFields(t *testing.T) {
+	r, err := ParseRule(`alert http $HOME_NET any -> $EXTERNAL_NET any (msg:"foo"; http.uri; content:"bar"; urilen:>10; sid:1337; rev:1;)`)
+	if err != nil {
+		t.Fatalf("parse rule failed: %v", err)
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	for _, want := range []string{`"DataPosition":"http.uri"`, `"Kind":"urilen"`, `"Type":"content"`, `"Type":"len_match"`, `"Nets":["$HOME_NET"]`} {
+		if !strings.Contains(string(b), want) {
+			t.Fatalf("%s does not contain %s", b, want)
+		}
+	}
+}
+
+func TestJSONUnmarshalErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		input string
+	}{
+		{
+			name:  "unknown matcher type",
+			input: `{"Matchers":[{"Type":"foo","Value":{}}]}`,
+		},
+		{
+			name:  "unknown sticky buffer",
+			input: `{"Matchers":[{"Type":"content","Value":{"DataPosition":"foo"}}]}`,
+		},
+	} {
+		if err := json.Unmarshal([]byte(tt.input), new(Rule)); err == nil {
+			t.Fatalf("%s: got nil error; expected error", tt.name)
+		}
+	}
+}
+
+func TestToSuricataJSON(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "keywords",
+			input: `alert http $HOME_NET any -> [1.2.3.4,5.6.7.8] 80 (msg:"foo bar"; flow:established,to_server; http.uri; content:!"/a|3B|b"; nocase; distance:0; pcre:"/x/R"; sid:1337; rev:2;)`,
+			want:  `{"action":"alert","protocol":"http","source":{"addresses":["$HOME_NET"],"ports":["any"]},"direction":"->","destination":{"addresses":["1.2.3.4","5.6.7.8"],"ports":["80"]},"keywords":[{"name":"msg","value":"foo bar"},{"name":"flow","value":["established","to_server"]},{"name":"http.uri"},{"name":"content","negated":true,"value":"/a|3B|b"},{"name":"distance","value":0},{"name":"nocase"},{"name":"pcre","value":"/x/R"},{"name":"sid","value":1337},{"name":"rev","value":2}]}`,
+		},
+		{
+			name:  "disabled bidirectional",
+			input: `#alert tcp any any <> any any (msg:"foo"; dsize:!10; sid:1; rev:1;)`,
+			want:  `{"disabled":true,"action":"alert","protocol":"tcp","source":{"addresses":["any"],"ports":["any"]},"direction":"<>","destination":{"addresses":["any"],"ports":["any"]},"keywords":[{"name":"msg","value":"foo"},{"name":"dsize","negated":true,"value":10},{"name":"sid","value":1},{"name":"rev","value":1}]}`,
+		},
+	} {
+		r, err := ParseRule(tt.input)
+		if err != nil {
+			t.Fatalf("%s: parse rule failed: %v", tt.name, err)
+		}
+		got, err := r.ToSuricataJSON()
+		if err != nil {
+			t.Fatalf("%s: ToSuricataJSON failed: %v", tt.name, err)
+		}
+		if string(got) != tt.want {
+			t.Fatalf("%s: got %s; expected %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -129,6 +129,10 @@ func (l *lexer) emit(t itemType, trimSpaces bool) {
 	if trimSpaces {
 		input = strings.TrimSpace(input)
 	}
+	// Whitespace (e.g. a line break) between a closing quote and `;` is not part of a string.
+	if t == itemOptionValueString {
+		input = strings.TrimRightFunc(input, unicode.IsSpace)
+	}
 
 	// This is a bit of a hack. We lex until `;` now so we end up with extra `"`.
 	input = strings.TrimSuffix(input, `"`)
@@ -201,10 +205,16 @@ func lex(input string) (*lexer, error) {
 // TODO: handle error and corner case in all states.
 // run runs the state machine for the lexer.
 func (l *lexer) run() {
+	defer close(l.items)
+	// A panic in the lexer goroutine cannot be recovered by the parser, so it is emitted as an error.
+	defer func() {
+		if r := recover(); r != nil {
+			l.items <- item{itemError, fmt.Sprintf("recovered from panic: %v", r)}
+		}
+	}()
 	for l.state = lexRule; l.state != nil; {
 		l.state = l.state(l)
 	}
-	close(l.items)
 }
 
 func (l *lexer) close() {
@@ -404,38 +414,55 @@ func lexOptionValueBegin(l *lexer) stateFn {
 
 // lexOptionValueString consumes the inner content of a string value from the rule options.
 func lexOptionValueString(l *lexer) stateFn {
+	return lexOptionValueQuoted(l, itemOptionValueString, true)
+}
+
+// lexOptionValue scans a value from the rule options.
+func lexOptionValue(l *lexer) stateFn {
+	return lexOptionValueQuoted(l, itemOptionValue, false)
+}
+
+// lexOptionValueQuoted scans a value up to the `;` ending it, and emits it as an item of type t.
+// A `;` between quotes is part of the value, unless the quotes are never closed, in which case the
+// value ends at the first `;`. open is true if the value starts within quotes.
+func lexOptionValueQuoted(l *lexer, t itemType, open bool) stateFn {
 	escaped := false
+	// split is the position of the first `;` within quotes.
+	split := -1
 	for {
 		switch l.next() {
 		case ';':
+			if open {
+				if split < 0 {
+					split = l.pos - l.width
+				}
+				escaped = false
+				continue
+			}
 			l.backup()
-			l.emit(itemOptionValueString, false)
+			l.emit(t, t == itemOptionValue)
 			l.skipNext()
 			return lexOptionKey
+		case '"':
+			if !escaped {
+				open = !open
+			}
+			escaped = false
 		case '\\':
 			escaped = !escaped
 			if l.next() != ';' || !escaped {
 				l.backup()
 			}
 		case eof:
-			return l.unexpectedEOF()
-		default:
-			escaped = false
-		}
-	}
-}
-
-// lexOptionValue scans a value from the rule options.
-func lexOptionValue(l *lexer) stateFn {
-	for {
-		switch l.next() {
-		case ';':
-			l.backup()
-			l.emit(itemOptionValue, true)
+			if split < 0 {
+				return l.unexpectedEOF()
+			}
+			l.pos = split
+			l.emit(t, t == itemOptionValue)
 			l.skipNext()
 			return lexOptionKey
-		case eof:
-			return l.unexpectedEOF()
+		default:
+			escaped = false
 		}
 	}
 }
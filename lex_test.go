@@ -238,6 +238,26 @@ func TestLexer(t *testing.T) {
 			input:   "alert udp $HOME_NET any -> $EXTERNAL_NET incomplet",
 			wantErr: true,
 		},
+		{
+			name:  "semicolon in quotes",
+			input: `alert udp $HOME_NET any -> $EXTERNAL_NET any (key1:"a;b"; key2:a, "b;c"; key3:"d;)`,
+			items: []item{
+				{itemAction, "alert"},
+				{itemProtocol, "udp"},
+				{itemSourceAddress, "$HOME_NET"},
+				{itemSourcePort, "any"},
+				{itemDirection, "->"},
+				{itemDestinationAddress, "$EXTERNAL_NET"},
+				{itemDestinationPort, "any"},
+				{itemOptionKey, "key1"},
+				{itemOptionValueString, "a;b"},
+				{itemOptionKey, "key2"},
+				{itemOptionValue, `a, "b;c`},
+				{itemOptionKey, "key3"},
+				{itemOptionValueString, "d"},
+				{itemEOR, ""},
+			},
+		},
 		{
 			name:    "option key EOF",
 			input:   "alert udp $HOME_NET any -> $EXTERNAL_NET any (incomplet",
@@ -28,7 +28,7 @@ func (r *Rule) ShouldBeHTTP() bool {
 	}
 	// If we look at http buffers or sticky buffers, we should use the HTTP protocol.
 	for _, c := range r.Contents() {
-		if strings.HasPrefix(c.DataPosition.String(), "http_") {
+		if d := c.DataPosition.String(); strings.HasPrefix(d, "http_") || strings.HasPrefix(d, "http.") {
 			return true
 		}
 		for _, co := range c.Options {
@@ -157,3 +157,89 @@ func (r *Rule) OnlyShortContents() bool {
 	}
 	return true
 }
+
+// Minimum length of the content of a rule to be considered an indicator of compromise (e.g. a domain
+// or a URL path), shorter contents are too generic.
+const iocMinContentLen = 8
+
+// hashTransforms are the transforms replacing a buffer by its hash.
+var hashTransforms = []string{"to_md5", "to_sha1", "to_sha256"}
+
+// IsIOCRule returns true if a rule looks for a single indicator of compromise: it has exactly one
+// non-negated content, which is either at least iocMinContentLen bytes long, or a match on a hash
+// (a JA3/JA3S/JA4 hash buffer, or a buffer hashed with to_md5, to_sha1 or to_sha256). Behavioral
+// rules (see IsBehavioral) are never IOC rules. Negated contents, length matches and transforms are
+// allowed.
+func (r *Rule) IsIOCRule() bool {
+	if r.IsBehavioral() {
+		return false
+	}
+	var ioc *Content
+	for _, c := range r.Contents() {
+		if c.Negate {
+			continue
+		}
+		if ioc != nil {
+			return false
+		}
+		ioc = c
+	}
+	if ioc == nil {
+		return false
+	}
+	if len(ioc.Pattern) >= iocMinContentLen {
+		return true
+	}
+	if _, ok := fingerprintBuffers[ioc.buffer()]; ok {
+		return true
+	}
+	for _, t := range r.ContentTransforms(ioc) {
+		if inSlice(t, hashTransforms) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsBehavioral returns true if a rule describes a behavior rather than a static indicator: it
+// tracks state across packets (flowbits, xbits, flowint), or uses at least one pcre, byte_* or
+// isdataat match, or a Lua script. A base64_decode alone does not make a rule behavioral.
+func (r *Rule) IsBehavioral() bool {
+	if len(r.Flowbits) > 0 || len(r.Xbits) > 0 || len(r.Flowints) > 0 || len(r.Lua) > 0 {
+		return true
+	}
+	if len(r.PCREs()) > 0 {
+		return true
+	}
+	for _, b := range r.ByteMatchers() {
+		if b.Kind != b64Decode {
+			return true
+		}
+	}
+	return false
+}
+
+// UnusedVariables returns the names of the variables defined by the byte_extract and byte_math of
+// a rule that are never used by a matcher, in order. Suricata accepts unused variables, but they
+// usually are a mistake.
+func (r *Rule) UnusedVariables() []string {
+	var names []string
+	used := make(map[string]bool)
+	for _, m := range r.Matchers {
+		for _, v := range variableRefs(m) {
+			used[v] = true
+		}
+		if b, ok := m.(*ByteMatch); ok && b.Variable != "" && (b.Kind == bExtract || b.Kind == bMath) {
+			if !inSlice(b.Variable, names) {
+				names = append(names, b.Variable)
+			}
+		}
+	}
+	var unused []string
+	for _, n := range names {
+		if !used[n] {
+			unused = append(unused, n)
+		}
+	}
+	return unused
+}
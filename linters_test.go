@@ -16,7 +16,10 @@ limitations under the License.
 package gonids
 
 import (
+	"fmt"
 	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
 )
 
 func TestShouldBeHTTP(t *testing.T) {
@@ -76,6 +79,27 @@ func TestShouldBeHTTP(t *testing.T) {
 			},
 			want: true,
 		},
+		{
+			name: "suricata 5 sticky buffer change",
+			input: &Rule{
+				Protocol: "tcp",
+				Source: Network{
+					Nets:  []string{"$HOME_NET"},
+					Ports: []string{"any"},
+				},
+				Destination: Network{
+					Nets:  []string{"$EXTERNAL_NET"},
+					Ports: []string{"$HTTP_PORTS"},
+				},
+				Matchers: []orderedMatcher{
+					&Content{
+						DataPosition: httpURI,
+						Pattern:      []byte("AA"),
+					},
+				},
+			},
+			want: true,
+		},
 	} {
 		got := tt.input.ShouldBeHTTP()
 		if got != tt.want {
@@ -496,3 +520,95 @@ func TestOnlyShortContents(t *testing.T) {
 		}
 	}
 }
+
+func TestRuleClassification(t *testing.T) {
+	for _, tt := range []struct {
+		name           string
+		input          string
+		wantIOC        bool
+		wantBehavioral bool
+	}{
+		{
+			name:    "domain",
+			input:   `alert dns any any -> any any (msg:"foo"; dns.query; content:"c2.evil.com"; nocase; sid:1; rev:1;)`,
+			wantIOC: true,
+		},
+		{
+			name:    "ja3 hash",
+			input:   `alert tls any any -> any any (msg:"foo"; ja3.hash; content:"e7d705a3286e19ea42f587b344ee6865"; sid:1; rev:1;)`,
+			wantIOC: true,
+		},
+		{
+			name:    "short hashed content",
+			input:   `alert http any any -> any any (msg:"foo"; http.uri; to_md5; content:"|12 34|"; content:!"x"; sid:1; rev:1;)`,
+			wantIOC: true,
+		},
+		{
+			name:  "short content",
+			input: `alert tcp any any -> any any (msg:"foo"; content:"evil"; sid:1; rev:1;)`,
+		},
+		{
+			name:  "several contents",
+			input: `alert http any any -> any any (msg:"foo"; http.uri; content:"/evil/gate.php"; http.host; content:"evil.example.com"; sid:1; rev:1;)`,
+		},
+		{
+			name:           "pcre",
+			input:          `alert http any any -> any any (msg:"foo"; http.uri; content:"/evil/gate.php"; pcre:"/id=\d+/U"; sid:1; rev:1;)`,
+			wantBehavioral: true,
+		},
+		{
+			name:           "flowbits",
+			input:          `alert tcp any any -> any any (msg:"foo"; content:"evil.example.com"; flowbits:set,evil; sid:1; rev:1;)`,
+			wantBehavioral: true,
+		},
+		{
+			name:           "byte_test",
+			input:          `alert tcp any any -> any any (msg:"foo"; content:"|00 01|"; byte_test:2,>,10,0,relative; sid:1; rev:1;)`,
+			wantBehavioral: true,
+		},
+		{
+			name:  "base64_decode",
+			input: `alert tcp any any -> any any (msg:"foo"; content:"foo"; base64_decode; base64_data; content:"bar"; sid:1; rev:1;)`,
+		},
+	} {
+		r, err := ParseRule(tt.input)
+		if err != nil {
+			t.Fatalf("%s: parse failed: %v", tt.name, err)
+		}
+		if got := r.IsIOCRule(); got != tt.wantIOC {
+			t.Fatalf("%s: IsIOCRule() got %v; want %v", tt.name, got, tt.wantIOC)
+		}
+		if got := r.IsBehavioral(); got != tt.wantBehavioral {
+			t.Fatalf("%s: IsBehavioral() got %v; want %v", tt.name, got, tt.wantBehavioral)
+		}
+	}
+}
+
+func TestUnusedVariables(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		rule string
+		want []string
+	}{
+		{
+			name: "all used",
+			rule: `alert tcp any any -> any any (msg:"foo"; byte_extract:2,0,len; content:"a"; depth:len; sid:1; rev:1;)`,
+		},
+		{
+			name: "unused",
+			rule: `alert tcp any any -> any any (msg:"foo"; byte_extract:2,0,len; byte_extract:1,2,off; byte_math:bytes 1,offset 0,oper +,rvalue len,result sum; content:"a"; sid:1; rev:1;)`,
+			want: []string{"off", "sum"},
+		},
+	} {
+		r, err := ParseRule(tt.rule)
+		if err != nil {
+			t.Fatalf("%s: parse rule failed: %v", tt.name, err)
+		}
+		if err := r.Validate(); err != nil {
+			t.Fatalf("%s: got unexpected error %v", tt.name, err)
+		}
+		if diff := pretty.Compare(r.UnusedVariables(), tt.want); diff != "" {
+			t.Fatal(fmt.Sprintf("%s: diff (-got +want):\n%s", tt.name, diff))
+		}
+	}
+}
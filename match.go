@@ -0,0 +1,407 @@
+/* Copyright 2020 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"unicode/utf8"
+)
+
+// MatchBuffer evaluates the contents, pcres and byte matchers (byte_test, byte_jump, byte_extract
+// and isdataat) of a rule that apply to the buffer pos against buf, and returns true if they all
+// match. It is intended for unit testing rules against sample payloads.
+//
+// This is a single buffer approximation, not a detection engine: network addresses, ports, flow
+// state, flowbits, length matches and matchers on other buffers are ignored. Contents honor
+// offset, depth, distance, within, startswith, endswith and nocase, and relative matches are
+// retried on later occurrences of the previous content, as Suricata does.
+//
+// A negated content matches if its pattern is absent from its window: the whole buffer (within
+// offset and depth), or the bytes after the previous match for relative contents (within distance
+// and within). A negated relative content found after an occurrence of the previous content fails
+// that occurrence only, and the next occurrence is tried. Negated contents and pcres do not move
+// the position following relative matches are anchored to.
+//
+// An error is returned if no matcher applies to pos, or for matchers that cannot be evaluated
+// (e.g. transforms, base64_decode, byte_math, pcres not supported by Go regexps, see
+// PCRE.ToGoRegexp).
+func (r *Rule) MatchBuffer(buf []byte, pos DataPos) (bool, error) {
+	var ms []orderedMatcher
+	cur := pktData
+	for _, m := range r.Matchers {
+		d, _ := matcherDataPos(m)
+		switch v := m.(type) {
+		case *Content:
+			cur = v.DataPosition
+			d = v.buffer()
+		case *PCRE:
+			d = cur
+			if t, ok := v.TargetBuffer(); ok {
+				d = t
+			}
+		case *LenMatch, *UnknownOption:
+			// Length matches and unknown options are not evaluated.
+			continue
+		default:
+			cur = d
+		}
+		if d != pos {
+			continue
+		}
+		switch v := m.(type) {
+		case *Transform:
+			return false, fmt.Errorf("transform %s is not supported", v.Name)
+		case *ByteMatch:
+			if v.Kind == b64Decode || v.Kind == bMath || v.DCE {
+				return false, fmt.Errorf("%s is not supported", v)
+			}
+		}
+		ms = append(ms, m)
+	}
+	if len(ms) == 0 {
+		return false, fmt.Errorf("rule has no matcher for buffer %s", pos)
+	}
+	e := &bufferEval{buf: buf, matchers: ms}
+	return e.match(0, 0, nil)
+}
+
+// bufferEval evaluates matchers against a single buffer.
+type bufferEval struct {
+	buf      []byte
+	matchers []orderedMatcher
+}
+
+// match returns true if the matchers from i match, cursor being the end of the previous match, and
+// vars the values extracted by the previous byte_extract.
+func (e *bufferEval) match(i, cursor int, vars map[string]int) (bool, error) {
+	if i == len(e.matchers) {
+		return true, nil
+	}
+	switch v := e.matchers[i].(type) {
+	case *Content:
+		start, end, err := e.window(v, cursor, vars)
+		if err != nil {
+			return false, err
+		}
+		idxs := e.occurrences(v, start, end)
+		if v.Negate {
+			if len(idxs) > 0 {
+				return false, nil
+			}
+			return e.match(i+1, cursor, vars)
+		}
+		for _, idx := range idxs {
+			if ok, err := e.match(i+1, idx+len(v.Pattern), vars); ok || err != nil {
+				return ok, err
+			}
+		}
+		return false, nil
+	case *PCRE:
+		re, anyRune, err := v.goRegexp()
+		if err != nil {
+			return false, err
+		}
+		if anyRune && hasMultiByteRunes(e.buf) {
+			return false, fmt.Errorf("pcre %s cannot be matched on a buffer with UTF-8 sequences", v)
+		}
+		start := 0
+		if v.IsRelative() {
+			start = cursor
+		}
+		loc := re.FindIndex(e.buf[start:])
+		if v.Negate {
+			if loc != nil {
+				return false, nil
+			}
+			return e.match(i+1, cursor, vars)
+		}
+		if loc == nil {
+			return false, nil
+		}
+		return e.match(i+1, start+loc[1], vars)
+	case *ByteMatch:
+		return e.matchByte(i, v, cursor, vars)
+	}
+	return e.match(i+1, cursor, vars)
+}
+
+// resolve returns the value of an integer or a variable set by byte_extract.
+func resolve(v IntOrVar, vars map[string]int) (int, error) {
+	if v.Var == "" {
+		return v.Int, nil
+	}
+	n, ok := vars[v.Var]
+	if !ok {
+		return 0, fmt.Errorf("variable %s is not extracted", v.Var)
+	}
+	return n, nil
+}
+
+// contentOption returns the resolved value of a position option of a content.
+func contentOption(c *Content, name string, vars map[string]int) (int, bool, error) {
+	v := c.positionOption(name)
+	if v == nil {
+		return 0, false, nil
+	}
+	n, err := resolve(*v, vars)
+	return n, true, err
+}
+
+// window returns the range of the buffer a content must be found in. Relative contents start
+// distance bytes after the end of the previous match, and end within bytes after that, as in
+// Suricata.
+func (e *bufferEval) window(c *Content, cursor int, vars map[string]int) (int, int, error) {
+	start, end := 0, len(e.buf)
+	first, second := "offset", "depth"
+	if c.relative() {
+		start, first, second = cursor, "distance", "within"
+	}
+	n, ok, err := contentOption(c, first, vars)
+	if err != nil {
+		return 0, 0, err
+	}
+	if ok {
+		start += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	n, ok, err = contentOption(c, second, vars)
+	if err != nil {
+		return 0, 0, err
+	}
+	if ok && start+n < end {
+		end = start + n
+	}
+	if c.hasOption("startswith") && len(c.Pattern) < end {
+		end = len(c.Pattern)
+	}
+	return start, end, nil
+}
+
+// occurrences returns the indexes the pattern of a content is found at in the buffer between
+// start and end.
+func (e *bufferEval) occurrences(c *Content, start, end int) []int {
+	var idxs []int
+	n := len(c.Pattern)
+	if c.hasOption("endswith") {
+		if i := len(e.buf) - n; i >= start && i+n <= end && c.equalAt(e.buf, i) {
+			idxs = append(idxs, i)
+		}
+		return idxs
+	}
+	for i := start; i+n <= end; i++ {
+		if c.equalAt(e.buf, i) {
+			idxs = append(idxs, i)
+		}
+	}
+	return idxs
+}
+
+// equalAt returns true if the pattern of a content is found in buf at i.
+func (c *Content) equalAt(buf []byte, i int) bool {
+	b := buf[i : i+len(c.Pattern)]
+	if !c.Nocase {
+		return bytes.Equal(b, c.Pattern)
+	}
+	for j, p := range c.Pattern {
+		if asciiLower(b[j]) != asciiLower(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// asciiLower returns the lowercase of an ASCII letter, or b.
+func asciiLower(b byte) byte {
+	if b >= 'A' && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
+
+// hasMultiByteRunes returns true if buf has a valid UTF-8 sequence of more than one byte, which
+// Go regexps match as a single rune.
+func hasMultiByteRunes(buf []byte) bool {
+	for i := 0; i < len(buf); {
+		_, size := utf8.DecodeRune(buf[i:])
+		if size > 1 {
+			return true
+		}
+		i += size
+	}
+	return false
+}
+
+// matchByte evaluates a byte_test, byte_jump, byte_extract or isdataat, then the following
+// matchers.
+func (e *bufferEval) matchByte(i int, b *ByteMatch, cursor int, vars map[string]int) (bool, error) {
+	nv, err := ParseIntOrVar(b.NumBytes)
+	if err != nil {
+		return false, fmt.Errorf("%s: %v", b, err)
+	}
+	n, err := resolve(nv, vars)
+	if err != nil {
+		return false, err
+	}
+	at := b.Offset
+	if b.Kind == isDataAt {
+		// The value of isdataat is the position checked, not a number of bytes.
+		at = n
+	}
+	if isRelative(b) {
+		at += cursor
+	}
+
+	if b.Kind == isDataAt {
+		// A relative isdataat checks that the number of bytes follows the previous match, an absolute
+		// isdataat that there is a byte at the position.
+		ok := at < len(e.buf)
+		if isRelative(b) {
+			ok = at <= len(e.buf)
+		}
+		if ok == b.Negate {
+			return false, nil
+		}
+		return e.match(i+1, cursor, vars)
+	}
+
+	v, ok, err := b.read(e.buf, at, n)
+	if err != nil || !ok {
+		return false, err
+	}
+	switch b.Kind {
+	case bTest:
+		ok, err := b.compare(v, vars)
+		if err != nil || !ok {
+			return false, err
+		}
+		return e.match(i+1, cursor, vars)
+	case bExtract:
+		if b.Multiplier != 0 {
+			v *= uint64(b.Multiplier)
+		}
+		extracted := make(map[string]int, len(vars)+1)
+		for k, x := range vars {
+			extracted[k] = x
+		}
+		extracted[b.Variable] = int(v)
+		return e.match(i+1, at+n, extracted)
+	case bJump:
+		if b.Multiplier != 0 {
+			v *= uint64(b.Multiplier)
+		}
+		if b.Align && v%4 != 0 {
+			v += 4 - v%4
+		}
+		next := at + n
+		switch {
+		case b.FromBeginning:
+			next = 0
+		case b.FromEnd:
+			next = len(e.buf)
+		}
+		next += int(v) + b.PostOffset
+		if next < 0 || next > len(e.buf) {
+			return false, nil
+		}
+		return e.match(i+1, next, vars)
+	}
+	return false, fmt.Errorf("%s is not supported", b)
+}
+
+// read returns the value of the n bytes at i, and false if they are outside of the buffer or
+// cannot be converted.
+func (b *ByteMatch) read(buf []byte, i, n int) (uint64, bool, error) {
+	if i < 0 || n < 1 || i+n > len(buf) {
+		return 0, false, nil
+	}
+	data := buf[i : i+n]
+	var v uint64
+	if b.StringMode {
+		base := 10
+		switch b.Base {
+		case "hex":
+			base = 16
+		case "oct":
+			base = 8
+		}
+		var err error
+		if v, err = strconv.ParseUint(string(data), base, 64); err != nil {
+			return 0, false, nil
+		}
+	} else {
+		if n > 8 {
+			return 0, false, fmt.Errorf("%s: cannot convert %d bytes", b, n)
+		}
+		var padded [8]byte
+		if b.Endianness == "little" {
+			copy(padded[:], data)
+			v = binary.LittleEndian.Uint64(padded[:])
+		} else {
+			copy(padded[8-n:], data)
+			v = binary.BigEndian.Uint64(padded[:])
+		}
+	}
+	if b.Bitmask != "" {
+		mask, err := strconv.ParseUint(b.Bitmask, 0, 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("%s: invalid bitmask: %v", b, err)
+		}
+		v = (v & mask) >> uint(bits.TrailingZeros64(mask))
+	}
+	return v, true, nil
+}
+
+// compare returns the result of the comparison of a byte_test with the value read.
+func (b *ByteMatch) compare(v uint64, vars map[string]int) (bool, error) {
+	var want uint64
+	if x, err := strconv.ParseInt(b.Value, 0, 64); err == nil {
+		want = uint64(x)
+	} else {
+		x, err := resolve(IntOrVar{Var: b.Value}, vars)
+		if err != nil {
+			return false, err
+		}
+		want = uint64(x)
+	}
+	var ok bool
+	switch b.Operator {
+	case "<":
+		ok = v < want
+	case ">":
+		ok = v > want
+	case "=":
+		ok = v == want
+	case "<=":
+		ok = v <= want
+	case ">=":
+		ok = v >= want
+	case "&":
+		ok = v&want != 0
+	case "^":
+		ok = v^want != 0
+	case "!=":
+		ok = v != want
+	default:
+		return false, fmt.Errorf("%s: unsupported operator %q", b, b.Operator)
+	}
+	return ok != b.Negate, nil
+}
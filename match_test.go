@@ -0,0 +1,296 @@
+/* Copyright 2020 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMatchBuffer(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		opts    string
+		buf     string
+		pos     DataPos
+		want    bool
+		wantErr bool
+	}{
+		{
+			name: "content",
+			opts: `content:"foo";`,
+			buf:  "xxfooxx",
+			want: true,
+		},
+		{
+			name: "content missing",
+			opts: `content:"foo"; content:"bar";`,
+			buf:  "xxfooxx",
+		},
+		{
+			name: "nocase",
+			opts: `content:"FoO"; nocase;`,
+			buf:  "xxfOoxx",
+			want: true,
+		},
+		{
+			name: "case sensitive",
+			opts: `content:"FoO";`,
+			buf:  "xxfOoxx",
+		},
+		{
+			name: "offset and depth",
+			opts: `content:"foo"; offset:2; depth:3;`,
+			buf:  "xxfooxx",
+			want: true,
+		},
+		{
+			name: "depth too short",
+			opts: `content:"foo"; offset:2; depth:2;`,
+			buf:  "xxfooxx",
+		},
+		{
+			name: "distance and within",
+			opts: `content:"foo"; content:"bar"; distance:2; within:3;`,
+			buf:  "foo..bar",
+			want: true,
+		},
+		{
+			name: "within too short",
+			opts: `content:"foo"; content:"bar"; distance:2; within:2;`,
+			buf:  "foo..bar",
+		},
+		{
+			name: "relative retried on a later match",
+			opts: `content:"foo"; content:"bar"; within:3;`,
+			buf:  "foo....foobar",
+			want: true,
+		},
+		{
+			name: "startswith and endswith",
+			opts: `content:"GET"; startswith; content:"1.1"; endswith;`,
+			buf:  "GET / HTTP/1.1",
+			want: true,
+		},
+		{
+			name: "startswith not at start",
+			opts: `content:"GET"; startswith;`,
+			buf:  " GET /",
+		},
+		{
+			name: "hex content",
+			opts: `content:"|00 01|A";`,
+			buf:  "\x00\x01A",
+			want: true,
+		},
+		{
+			name: "relative pcre",
+			opts: `content:"id="; pcre:"/^\d{3}&/R";`,
+			buf:  "x=1&id=123&",
+			want: true,
+		},
+		{
+			name: "relative pcre anchored at previous match",
+			opts: `content:"id="; pcre:"/^\d{3}&/R";`,
+			buf:  "x=1&id=a123&",
+		},
+		{
+			name: "pcre dot on invalid utf-8",
+			opts: `pcre:"/^.\x01/";`,
+			buf:  "\xff\x01",
+			want: true,
+		},
+		{
+			name:    "pcre dot on utf-8 sequence",
+			opts:    `pcre:"/^.\x01/";`,
+			buf:     "\xc3\xa9\x01",
+			wantErr: true,
+		},
+		{
+			name: "byte_test",
+			opts: `content:"|FF|"; byte_test:2,>,255,0,relative;`,
+			buf:  "\xff\x01\x00",
+			want: true,
+		},
+		{
+			name: "byte_test string",
+			opts: `byte_test:3,=,123,1,string,dec;`,
+			buf:  "x123",
+			want: true,
+		},
+		{
+			name: "byte_jump",
+			opts: `content:"|01|"; byte_jump:1,0,relative; content:"end"; within:3;`,
+			buf:  "\x01\x02..end",
+			want: true,
+		},
+		{
+			name: "byte_extract in position option",
+			opts: `byte_extract:1,0,len; content:"foo"; offset:1; depth:len;`,
+			buf:  "\x04.foo",
+			want: true,
+		},
+		{
+			name: "byte_extract too short",
+			opts: `byte_extract:1,0,len; content:"foo"; offset:1; depth:len;`,
+			buf:  "\x02.foo",
+		},
+		{
+			name: "isdataat",
+			opts: `content:"foo"; isdataat:2,relative; isdataat:!4,relative;`,
+			buf:  "foo123",
+			want: true,
+		},
+		{
+			name: "relative isdataat at end of buffer",
+			opts: `content:"ab"; isdataat:1,relative;`,
+			buf:  "abc",
+			want: true,
+		},
+		{
+			name: "negated relative isdataat at end of buffer",
+			opts: `content:"ab"; isdataat:!1,relative;`,
+			buf:  "abc",
+		},
+		{
+			name: "relative isdataat past end of buffer",
+			opts: `content:"ab"; isdataat:2,relative;`,
+			buf:  "abc",
+		},
+		{
+			name: "absolute isdataat at end of buffer",
+			opts: `isdataat:3;`,
+			buf:  "abc",
+		},
+		{
+			name: "absolute isdataat on last byte",
+			opts: `isdataat:2;`,
+			buf:  "abc",
+			want: true,
+		},
+		{
+			name: "other buffers are ignored",
+			opts: `content:"foo"; http.uri; content:"bar";`,
+			buf:  "bar",
+			pos:  httpURI,
+			want: true,
+		},
+		{
+			name: "content modifier",
+			opts: `content:"bar"; http_uri;`,
+			buf:  "bar",
+			pos:  httpURI,
+			want: true,
+		},
+		{
+			name: "negated content absent",
+			opts: `content:"foo"; content:!"bar";`,
+			buf:  "xxfooxx",
+			want: true,
+		},
+		{
+			name: "negated content present",
+			opts: `content:"foo"; content:!"bar";`,
+			buf:  "barxxfoo",
+		},
+		{
+			name: "negated content with depth",
+			opts: `content:!"bar"; depth:3; content:"foo";`,
+			buf:  "xxxbarfoo",
+			want: true,
+		},
+		{
+			name: "negated content outside of within",
+			opts: `content:"foo"; content:!"bar"; within:5;`,
+			buf:  "foo...bar",
+			want: true,
+		},
+		{
+			name: "negated content inside of within",
+			opts: `content:"foo"; content:!"bar"; within:6;`,
+			buf:  "foo...bar",
+		},
+		{
+			name: "negated content before distance",
+			opts: `content:"foo"; content:!"bar"; distance:1;`,
+			buf:  "foobar",
+			want: true,
+		},
+		{
+			name: "negated content after distance",
+			opts: `content:"foo"; content:!"bar"; distance:1; within:4;`,
+			buf:  "foo.bar",
+		},
+		{
+			name: "negated content anchored to a later match",
+			opts: `content:"foo"; content:!"bar"; within:3;`,
+			buf:  "foobar foo...",
+			want: true,
+		},
+		{
+			name: "negated content after every match",
+			opts: `content:"foo"; content:!"bar"; within:3;`,
+			buf:  "foobar foobar",
+		},
+		{
+			name: "negated content does not move the anchor",
+			opts: `content:"foo"; content:!"bar"; distance:0; content:"baz"; distance:0; within:3;`,
+			buf:  "foobaz",
+			want: true,
+		},
+		{
+			name: "negated relative pcre",
+			opts: `content:"id="; pcre:!"/^\d/R";`,
+			buf:  "id=1 id=x",
+			want: true,
+		},
+		{
+			name: "negated pcre present",
+			opts: `content:"id="; pcre:!"/\d/";`,
+			buf:  "id=x 1",
+		},
+		{
+			name:    "no matcher for buffer",
+			opts:    `content:"foo";`,
+			pos:     httpURI,
+			wantErr: true,
+		},
+		{
+			name:    "transform",
+			opts:    `http.uri; to_lowercase; content:"foo";`,
+			pos:     httpURI,
+			wantErr: true,
+		},
+		{
+			name:    "unsupported pcre",
+			opts:    `pcre:"/foo(?!bar)/";`,
+			buf:     "foo",
+			wantErr: true,
+		},
+	} {
+		r, err := ParseRule(fmt.Sprintf(`alert tcp any any -> any any (msg:"foo"; %s sid:1;)`, tt.opts))
+		if err != nil {
+			t.Fatalf("%s: parse rule failed: %v", tt.name, err)
+		}
+		got, err := r.MatchBuffer([]byte(tt.buf), tt.pos)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%s: got err %v; expected err %v", tt.name, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Fatalf("%s: got %v; expected %v", tt.name, got, tt.want)
+		}
+	}
+}
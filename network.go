@@ -0,0 +1,450 @@
+/* Copyright 2020 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// splitNetList splits a list of addresses or ports on its top level commas. Brackets around the
+// whole list are removed, nested groups are returned as a single item.
+func splitNetList(s string) ([]string, error) {
+	s = strings.TrimSpace(s)
+	if isNetGroup(s) {
+		s = s[1 : len(s)-1]
+	}
+	var items []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced brackets in %q", s)
+			}
+		case ',':
+			if depth == 0 {
+				items = append(items, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced brackets in %q", s)
+	}
+	return append(items, strings.TrimSpace(s[start:])), nil
+}
+
+// isNetGroup returns true if s is a single group, enclosed in matching brackets.
+func isNetGroup(s string) bool {
+	if !strings.HasPrefix(s, "[") || !strings.HasSuffix(s, "]") {
+		return false
+	}
+	depth := 0
+	for i, c := range s {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return i == len(s)-1
+			}
+		}
+	}
+	return false
+}
+
+// NetEntry is an entry of a list of addresses or ports. It is either a single value, or a group
+// of entries. Entries of a Network can be nested groups (e.g. ![10.0.0.0/8,[1.2.3.4,5.6.7.8]]).
+type NetEntry struct {
+	// Negate is true if the entry is negated (!).
+	Negate bool
+	// Value is an address, port, range, variable or any. It is empty for a group.
+	Value string
+	// Group holds the entries of a group ([...]).
+	Group []*NetEntry
+}
+
+// ParseNetEntry parses a single entry of a list of addresses or ports.
+func ParseNetEntry(s string) (*NetEntry, error) {
+	s = strings.TrimSpace(s)
+	e := &NetEntry{}
+	if strings.HasPrefix(s, "!") {
+		e.Negate = true
+		s = s[1:]
+	}
+	if s == "" {
+		return nil, errors.New("empty network entry")
+	}
+	if !strings.HasPrefix(s, "[") {
+		if strings.ContainsAny(s, "[],!") {
+			return nil, fmt.Errorf("invalid network entry %q", s)
+		}
+		e.Value = s
+		return e, nil
+	}
+	if !isNetGroup(s) {
+		return nil, fmt.Errorf("invalid group %q", s)
+	}
+	items, err := splitNetList(s)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		g, err := ParseNetEntry(item)
+		if err != nil {
+			return nil, err
+		}
+		e.Group = append(e.Group, g)
+	}
+	return e, nil
+}
+
+// String returns a string for a NetEntry.
+func (e NetEntry) String() string {
+	var s strings.Builder
+	if e.Negate {
+		s.WriteString("!")
+	}
+	if e.Group == nil {
+		s.WriteString(e.Value)
+		return s.String()
+	}
+	s.WriteString("[")
+	for i, g := range e.Group {
+		if i > 0 {
+			s.WriteString(",")
+		}
+		s.WriteString(g.String())
+	}
+	s.WriteString("]")
+	return s.String()
+}
+
+// netEntries parses all items of a list of addresses or ports.
+func netEntries(items []string) ([]*NetEntry, error) {
+	var entries []*NetEntry
+	for _, item := range items {
+		e, err := ParseNetEntry(item)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// NetEntries returns the structured form of Nets, with negations and nested groups.
+func (n Network) NetEntries() ([]*NetEntry, error) {
+	return netEntries(n.Nets)
+}
+
+// PortEntries returns the structured form of Ports, with negations and nested groups.
+func (n Network) PortEntries() ([]*NetEntry, error) {
+	return netEntries(n.Ports)
+}
+
+// expandNetList substitutes the variables of a list of addresses or ports. seen holds the names
+// of the variables being expanded, to detect cyclic definitions.
+func expandNetList(items []string, vars map[string]string, seen []string) ([]string, error) {
+	var expanded []string
+	for _, item := range items {
+		v := strings.TrimPrefix(item, "!")
+		negate := len(v) != len(item)
+		switch {
+		case strings.HasPrefix(v, "$"):
+			name := v[1:]
+			if inSlice(name, seen) {
+				return nil, fmt.Errorf("cyclic definition of variable %q: %s", name, strings.Join(append(seen, name), " -> "))
+			}
+			def, ok := vars[name]
+			if !ok {
+				return nil, fmt.Errorf("undefined variable %q", name)
+			}
+			defItems, err := splitNetList(def)
+			if err != nil {
+				return nil, fmt.Errorf("invalid definition of variable %q: %v", name, err)
+			}
+			exp, err := expandNetList(defItems, vars, append(seen[:len(seen):len(seen)], name))
+			if err != nil {
+				return nil, err
+			}
+			// A negated variable is negated as a whole, negating a negated definition cancels out.
+			if negate {
+				if len(exp) == 1 && strings.HasPrefix(exp[0], "!") {
+					v := exp[0][1:]
+					if isNetGroup(v) {
+						if exp, err = splitNetList(v); err != nil {
+							return nil, err
+						}
+					} else {
+						exp = []string{v}
+					}
+					expanded = append(expanded, exp...)
+					continue
+				}
+				expanded = append(expanded, "!"+netString(exp))
+				continue
+			}
+			expanded = append(expanded, exp...)
+		case isNetGroup(v):
+			groupItems, err := splitNetList(v)
+			if err != nil {
+				return nil, err
+			}
+			exp, err := expandNetList(groupItems, vars, seen)
+			if err != nil {
+				return nil, err
+			}
+			prefix := ""
+			if negate {
+				prefix = "!"
+			}
+			expanded = append(expanded, prefix+"["+strings.Join(exp, ",")+"]")
+		default:
+			expanded = append(expanded, item)
+		}
+	}
+	return expanded, nil
+}
+
+// Expand returns a copy of the Network with variables (e.g. $HOME_NET) substituted by their
+// definition in vars, keyed by name without the leading "$". Definitions can reference other
+// variables, and can be lists (e.g. "[192.168.0.0/16,10.0.0.0/8]"). Literals are left intact.
+// An error is returned for undefined or cyclic variables.
+func (n Network) Expand(vars map[string]string) (Network, error) {
+	nets, err := expandNetList(n.Nets, vars, nil)
+	if err != nil {
+		return Network{}, err
+	}
+	ports, err := expandNetList(n.Ports, vars, nil)
+	if err != nil {
+		return Network{}, err
+	}
+	return Network{Nets: nets, Ports: ports}, nil
+}
+
+// netVarRE matches a valid variable name, including the leading "$".
+var netVarRE = regexp.MustCompile(`^\$[A-Za-z0-9_]+$`)
+
+// validateNetList validates each item of a list of addresses or ports with validate. Variables,
+// negations and nested groups are handled here.
+func validateNetList(items []string, validate func(string) error) error {
+	for _, item := range items {
+		v := strings.TrimPrefix(item, "!")
+		switch {
+		case v == "":
+			return fmt.Errorf("empty entry %q", item)
+		case strings.HasPrefix(v, "$"):
+			if !netVarRE.MatchString(v) {
+				return fmt.Errorf("invalid variable %q", item)
+			}
+		case strings.HasPrefix(v, "["):
+			if !isNetGroup(v) {
+				return fmt.Errorf("invalid group %q", item)
+			}
+			groupItems, err := splitNetList(v)
+			if err != nil {
+				return err
+			}
+			if err := validateNetList(groupItems, validate); err != nil {
+				return err
+			}
+		default:
+			if err := validate(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateAddress returns an error if s is not any, an IP, a CIDR or a range of IPs (a-b).
+func validateAddress(s string) error {
+	if s == "any" || net.ParseIP(s) != nil {
+		return nil
+	}
+	if _, _, err := net.ParseCIDR(s); err == nil {
+		return nil
+	}
+	if r := strings.Split(s, "-"); len(r) == 2 && net.ParseIP(r[0]) != nil && net.ParseIP(r[1]) != nil {
+		return nil
+	}
+	return fmt.Errorf("invalid address %q", s)
+}
+
+// parsePort returns the value of a port number.
+func parsePort(s string) (int, error) {
+	p, err := strconv.Atoi(s)
+	if err != nil || p < 0 || p > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return p, nil
+}
+
+// validatePort returns an error if s is not any, a port or a range of ports (80:443, 1024:, :80).
+func validatePort(s string) error {
+	if s == "any" {
+		return nil
+	}
+	r := strings.Split(s, ":")
+	switch len(r) {
+	case 1:
+		_, err := parsePort(s)
+		return err
+	case 2:
+		if r[0] == "" && r[1] == "" {
+			return fmt.Errorf("invalid port range %q", s)
+		}
+		low, high := 0, 65535
+		var err error
+		if r[0] != "" {
+			if low, err = parsePort(r[0]); err != nil {
+				return err
+			}
+		}
+		if r[1] != "" {
+			if high, err = parsePort(r[1]); err != nil {
+				return err
+			}
+		}
+		if low > high {
+			return fmt.Errorf("invalid port range %q", s)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid port %q", s)
+}
+
+// Validate returns an error describing the first invalid entry of the Network. Addresses can be
+// any, IPs, CIDRs or ranges, and ports can be any, numbers or ranges. Both can be negated (!),
+// grouped ([...]) or variables ($HOME_NET).
+func (n Network) Validate() error {
+	if err := validateNetList(n.Nets, validateAddress); err != nil {
+		return err
+	}
+	return validateNetList(n.Ports, validatePort)
+}
+
+// matchNetEntries returns true if a list of entries matches, using match for single values. A
+// list matches if any entry matches and no negated entry matches, a list of only negated entries
+// matches anything they do not.
+func matchNetEntries(entries []*NetEntry, match func(string) bool) bool {
+	var matched, positive bool
+	for _, e := range entries {
+		var m bool
+		if e.Group != nil {
+			m = matchNetEntries(e.Group, match)
+		} else {
+			m = match(e.Value)
+		}
+		if e.Negate {
+			if m {
+				return false
+			}
+			continue
+		}
+		positive = true
+		matched = matched || m
+	}
+	return matched || !positive
+}
+
+// portMatches returns true if the port value s (any, a port or a range) contains p.
+func portMatches(s string, p int) bool {
+	if s == "any" {
+		return true
+	}
+	r := strings.Split(s, ":")
+	if len(r) == 1 {
+		v, err := parsePort(s)
+		return err == nil && v == p
+	}
+	low, high := 0, 65535
+	var err error
+	if r[0] != "" {
+		if low, err = parsePort(r[0]); err != nil {
+			return false
+		}
+	}
+	if r[1] != "" {
+		if high, err = parsePort(r[1]); err != nil {
+			return false
+		}
+	}
+	return p >= low && p <= high
+}
+
+// addressMatches returns true if the address value s (any, an IP, a CIDR or a range) contains ip.
+func addressMatches(s string, ip net.IP) bool {
+	if s == "any" {
+		return true
+	}
+	if v := net.ParseIP(s); v != nil {
+		return v.Equal(ip)
+	}
+	if _, n, err := net.ParseCIDR(s); err == nil {
+		return n.Contains(ip)
+	}
+	if r := strings.Split(s, "-"); len(r) == 2 {
+		low, high := net.ParseIP(r[0]), net.ParseIP(r[1])
+		if low == nil || high == nil || ip.To16() == nil {
+			return false
+		}
+		return bytes.Compare(ip.To16(), low.To16()) >= 0 && bytes.Compare(ip.To16(), high.To16()) <= 0
+	}
+	return false
+}
+
+// PortInRange returns true if the port p is matched by Ports. Variables (e.g. $HTTP_PORTS) are
+// substituted by their definition in vars, as in Expand. An error is returned if a variable is
+// undefined or a port is malformed, rather than reporting a non-match.
+func (n Network) PortInRange(p int, vars map[string]string) (bool, error) {
+	return matchNetList(n.Ports, vars, validatePort, func(s string) bool { return portMatches(s, p) })
+}
+
+// CIDRContains returns true if ip is matched by Nets. Variables (e.g. $HOME_NET) are substituted
+// by their definition in vars, as in Expand. An error is returned if a variable is undefined or
+// an address is malformed, rather than reporting a non-match.
+func (n Network) CIDRContains(ip net.IP, vars map[string]string) (bool, error) {
+	return matchNetList(n.Nets, vars, validateAddress, func(s string) bool { return addressMatches(s, ip) })
+}
+
+// matchNetList expands the variables of a list of addresses or ports, validates its entries with
+// validate and matches them with match.
+func matchNetList(items []string, vars map[string]string, validate func(string) error, match func(string) bool) (bool, error) {
+	exp, err := expandNetList(items, vars, nil)
+	if err != nil {
+		return false, err
+	}
+	if err := validateNetList(exp, validate); err != nil {
+		return false, err
+	}
+	entries, err := netEntries(exp)
+	if err != nil {
+		return false, err
+	}
+	return matchNetEntries(entries, match), nil
+}
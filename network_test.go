@@ -0,0 +1,382 @@
+/* Copyright 2020 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestNetworkExpand(t *testing.T) {
+	vars := map[string]string{
+		"HOME_NET":     "[192.168.0.0/16,10.0.0.0/8]",
+		"EXTERNAL_NET": "!$HOME_NET",
+		"DNS_SERVERS":  "$HOME_NET",
+		"HTTP_PORTS":   "[80,8080]",
+		"ALL_PORTS":    "[$HTTP_PORTS,443]",
+		"NOT_SSH":      "!22",
+		"CYCLE_A":      "$CYCLE_B",
+		"CYCLE_B":      "[1.1.1.1,$CYCLE_A]",
+	}
+	for _, tt := range []struct {
+		name    string
+		input   Network
+		want    Network
+		wantErr bool
+	}{
+		{
+			name:  "literals",
+			input: Network{Nets: []string{"1.2.3.4", "!5.6.7.8"}, Ports: []string{"any"}},
+			want:  Network{Nets: []string{"1.2.3.4", "!5.6.7.8"}, Ports: []string{"any"}},
+		},
+		{
+			name:  "variables",
+			input: Network{Nets: []string{"$HOME_NET"}, Ports: []string{"$ALL_PORTS", "22"}},
+			want:  Network{Nets: []string{"192.168.0.0/16", "10.0.0.0/8"}, Ports: []string{"80", "8080", "443", "22"}},
+		},
+		{
+			name:  "nested negated variable",
+			input: Network{Nets: []string{"$EXTERNAL_NET"}, Ports: []string{"!$HTTP_PORTS"}},
+			want:  Network{Nets: []string{"![192.168.0.0/16,10.0.0.0/8]"}, Ports: []string{"![80,8080]"}},
+		},
+		{
+			name:  "double negation",
+			input: Network{Nets: []string{"!$EXTERNAL_NET"}, Ports: []string{"!$NOT_SSH"}},
+			want:  Network{Nets: []string{"192.168.0.0/16", "10.0.0.0/8"}, Ports: []string{"22"}},
+		},
+		{
+			name:  "variable in group",
+			input: Network{Nets: []string{"![$DNS_SERVERS,1.2.3.4]"}, Ports: []string{"any"}},
+			want:  Network{Nets: []string{"![192.168.0.0/16,10.0.0.0/8,1.2.3.4]"}, Ports: []string{"any"}},
+		},
+		{
+			name:    "undefined variable",
+			input:   Network{Nets: []string{"$FOO_NET"}, Ports: []string{"any"}},
+			wantErr: true,
+		},
+		{
+			name:    "cyclic variable",
+			input:   Network{Nets: []string{"$CYCLE_A"}, Ports: []string{"any"}},
+			wantErr: true,
+		},
+	} {
+		got, err := tt.input.Expand(vars)
+		if tt.wantErr != (err != nil) {
+			t.Fatalf("%s: got err %v; expected err %v", tt.name, err, tt.wantErr)
+		}
+		if diff := pretty.Compare(got, tt.want); diff != "" && !tt.wantErr {
+			t.Fatal(fmt.Sprintf("%s: diff (-got +want):\n%s", tt.name, diff))
+		}
+	}
+}
+
+func TestNetworkValidate(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		input   Network
+		wantErr bool
+	}{
+		{
+			name:  "any",
+			input: Network{Nets: []string{"any"}, Ports: []string{"any"}},
+		},
+		{
+			name:  "addresses",
+			input: Network{Nets: []string{"1.2.3.4", "!10.0.0.0/8", "2001:db8::/32", "::1", "1.1.1.1-1.1.1.9"}, Ports: []string{"any"}},
+		},
+		{
+			name:  "ports",
+			input: Network{Nets: []string{"any"}, Ports: []string{"80", "1024:", ":80", "80:443", "!22", "0", "65535"}},
+		},
+		{
+			name:  "variables and groups",
+			input: Network{Nets: []string{"$HOME_NET", "![$DNS_SERVERS,[1.2.3.4,5.6.7.8]]"}, Ports: []string{"![$HTTP_PORTS,8080]"}},
+		},
+		{
+			name:    "invalid address",
+			input:   Network{Nets: []string{"1.2.3.256"}, Ports: []string{"any"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid cidr",
+			input:   Network{Nets: []string{"1.2.3.0/33"}, Ports: []string{"any"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid address in group",
+			input:   Network{Nets: []string{"![1.2.3.4,foo]"}, Ports: []string{"any"}},
+			wantErr: true,
+		},
+		{
+			name:    "unbalanced group",
+			input:   Network{Nets: []string{"[1.2.3.4"}, Ports: []string{"any"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid variable",
+			input:   Network{Nets: []string{"$"}, Ports: []string{"any"}},
+			wantErr: true,
+		},
+		{
+			name:    "port out of range",
+			input:   Network{Nets: []string{"any"}, Ports: []string{"65536"}},
+			wantErr: true,
+		},
+		{
+			name:    "empty port range",
+			input:   Network{Nets: []string{"any"}, Ports: []string{":"}},
+			wantErr: true,
+		},
+		{
+			name:    "reversed port range",
+			input:   Network{Nets: []string{"any"}, Ports: []string{"443:80"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid port",
+			input:   Network{Nets: []string{"any"}, Ports: []string{"http"}},
+			wantErr: true,
+		},
+	} {
+		err := tt.input.Validate()
+		if tt.wantErr != (err != nil) {
+			t.Fatalf("%s: got err %v; expected err %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseNetEntry(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		input   string
+		want    *NetEntry
+		wantErr bool
+	}{
+		{
+			name:  "single value",
+			input: "1.2.3.4",
+			want:  &NetEntry{Value: "1.2.3.4"},
+		},
+		{
+			name:  "negated variable",
+			input: "!$HOME_NET",
+			want:  &NetEntry{Negate: true, Value: "$HOME_NET"},
+		},
+		{
+			name:  "negated nested group",
+			input: "![192.168.0.0/16,[10.0.0.0/8,!10.1.0.0/16]]",
+			want: &NetEntry{
+				Negate: true,
+				Group: []*NetEntry{
+					{Value: "192.168.0.0/16"},
+					{
+						Group: []*NetEntry{
+							{Value: "10.0.0.0/8"},
+							{Negate: true, Value: "10.1.0.0/16"},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:    "unbalanced group",
+			input:   "![1.2.3.4,[5.6.7.8]",
+			wantErr: true,
+		},
+		{
+			name:    "list",
+			input:   "1.2.3.4,5.6.7.8",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			input:   "!",
+			wantErr: true,
+		},
+	} {
+		got, err := ParseNetEntry(tt.input)
+		if tt.wantErr != (err != nil) {
+			t.Fatalf("%s: got err %v; expected err %v", tt.name, err, tt.wantErr)
+		}
+		if tt.wantErr {
+			continue
+		}
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Fatal(fmt.Sprintf("%s: diff (-got +want):\n%s", tt.name, diff))
+		}
+		if s := got.String(); s != tt.input {
+			t.Fatalf("%s: got %v; expected %v", tt.name, s, tt.input)
+		}
+	}
+}
+
+func TestNetworkRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		input string
+		want  Network
+	}{
+		{
+			name:  "list",
+			input: "[1.2.3.4,5.6.7.8] [80,443]",
+			want:  Network{Nets: []string{"1.2.3.4", "5.6.7.8"}, Ports: []string{"80", "443"}},
+		},
+		{
+			name:  "negated group",
+			input: "![192.168.0.0/16,10.0.0.0/8] !$HTTP_PORTS",
+			want:  Network{Nets: []string{"![192.168.0.0/16,10.0.0.0/8]"}, Ports: []string{"!$HTTP_PORTS"}},
+		},
+		{
+			name:  "nested groups",
+			input: "[$HOME_NET,![10.0.0.0/8,[1.2.3.4,!5.6.7.8]]] [1024:,![1100:1200,!1150]]",
+			want: Network{
+				Nets:  []string{"$HOME_NET", "![10.0.0.0/8,[1.2.3.4,!5.6.7.8]]"},
+				Ports: []string{"1024:", "![1100:1200,!1150]"},
+			},
+		},
+	} {
+		r, err := ParseRule(fmt.Sprintf(`alert tcp %s -> any any (msg:"foo"; sid:1; rev:1;)`, tt.input))
+		if err != nil {
+			t.Fatalf("%s: parse rule failed: %v", tt.name, err)
+		}
+		if diff := pretty.Compare(r.Source, tt.want); diff != "" {
+			t.Fatal(fmt.Sprintf("%s: diff (-got +want):\n%s", tt.name, diff))
+		}
+		if got := r.Source.String(); got != tt.input {
+			t.Fatalf("%s: got %v; expected %v", tt.name, got, tt.input)
+		}
+	}
+}
+
+func TestNetworkMatches(t *testing.T) {
+	vars := map[string]string{
+		"HOME_NET":   "[192.168.0.0/16,10.0.0.0/8]",
+		"SMB_PORTS":  "[139,445]",
+		"HTTP_PORTS": "[80,8000:8100]",
+	}
+	for _, tt := range []struct {
+		name   string
+		input  Network
+		ip     string
+		port   int
+		wantIP bool
+		wantP  bool
+	}{
+		{
+			name:   "any",
+			input:  Network{Nets: []string{"any"}, Ports: []string{"any"}},
+			ip:     "1.2.3.4",
+			port:   445,
+			wantIP: true,
+			wantP:  true,
+		},
+		{
+			name:   "variables",
+			input:  Network{Nets: []string{"$HOME_NET"}, Ports: []string{"$SMB_PORTS"}},
+			ip:     "10.1.2.3",
+			port:   445,
+			wantIP: true,
+			wantP:  true,
+		},
+		{
+			name:  "negated variables",
+			input: Network{Nets: []string{"!$HOME_NET"}, Ports: []string{"!$SMB_PORTS"}},
+			ip:    "192.168.1.1",
+			port:  139,
+		},
+		{
+			name:   "negation in list",
+			input:  Network{Nets: []string{"10.0.0.0/8", "!10.1.0.0/16"}, Ports: []string{"$HTTP_PORTS", "!8080"}},
+			ip:     "10.2.0.1",
+			port:   8050,
+			wantIP: true,
+			wantP:  true,
+		},
+		{
+			name:  "excluded by negation in list",
+			input: Network{Nets: []string{"10.0.0.0/8", "!10.1.0.0/16"}, Ports: []string{"$HTTP_PORTS", "!8080"}},
+			ip:    "10.1.0.1",
+			port:  8080,
+		},
+		{
+			name:   "ranges",
+			input:  Network{Nets: []string{"1.1.1.1-1.1.1.9"}, Ports: []string{"1024:"}},
+			ip:     "1.1.1.5",
+			port:   4444,
+			wantIP: true,
+			wantP:  true,
+		},
+		{
+			name:  "outside ranges",
+			input: Network{Nets: []string{"1.1.1.1-1.1.1.9"}, Ports: []string{":1023"}},
+			ip:    "1.1.1.10",
+			port:  4444,
+		},
+		{
+			name:   "nested negated group",
+			input:  Network{Nets: []string{"![10.0.0.0/8,[1.2.3.4,5.6.7.8]]"}, Ports: []string{"any"}},
+			ip:     "1.2.3.5",
+			port:   80,
+			wantIP: true,
+			wantP:  true,
+		},
+	} {
+		got, err := tt.input.CIDRContains(net.ParseIP(tt.ip), vars)
+		if err != nil {
+			t.Fatalf("%s: CIDRContains(%s) failed: %v", tt.name, tt.ip, err)
+		}
+		if got != tt.wantIP {
+			t.Fatalf("%s: CIDRContains(%s) got %v; expected %v", tt.name, tt.ip, got, tt.wantIP)
+		}
+		got, err = tt.input.PortInRange(tt.port, vars)
+		if err != nil {
+			t.Fatalf("%s: PortInRange(%d) failed: %v", tt.name, tt.port, err)
+		}
+		if got != tt.wantP {
+			t.Fatalf("%s: PortInRange(%d) got %v; expected %v", tt.name, tt.port, got, tt.wantP)
+		}
+	}
+
+	// Unresolved variables are an error, not a non-match, including when negated or grouped.
+	for _, n := range []Network{
+		{Nets: []string{"$EXTERNAL_NET"}, Ports: []string{"$SSH_PORTS"}},
+		{Nets: []string{"!$EXTERNAL_NET"}, Ports: []string{"!$SSH_PORTS"}},
+		{Nets: []string{"[1.2.3.4,!$EXTERNAL_NET]"}, Ports: []string{"[80,$SSH_PORTS]"}},
+	} {
+		if _, err := n.CIDRContains(net.ParseIP("1.2.3.4"), vars); err == nil {
+			t.Fatalf("%v: CIDRContains expected an error for an undefined variable", n.Nets)
+		}
+		if _, err := n.PortInRange(22, vars); err == nil {
+			t.Fatalf("%v: PortInRange expected an error for an undefined variable", n.Ports)
+		}
+	}
+	if _, err := (Network{Ports: []string{"$HTTP_PORTS"}}).PortInRange(80, nil); err == nil {
+		t.Fatal("PortInRange without variables expected an error")
+	}
+	// Malformed entries are an error, not a non-match.
+	if _, err := (Network{Ports: []string{"abc"}}).PortInRange(80, nil); err == nil {
+		t.Fatal("PortInRange expected an error for a malformed port")
+	}
+	if _, err := (Network{Nets: []string{"[1.2.3.4,!1.2.3.999]"}}).CIDRContains(net.ParseIP("1.2.3.4"), nil); err == nil {
+		t.Fatal("CIDRContains expected an error for a malformed address")
+	}
+	if _, err := (Network{Nets: []string{"$BAD_NET"}}).CIDRContains(net.ParseIP("1.2.3.4"), map[string]string{"BAD_NET": "foo"}); err == nil {
+		t.Fatal("CIDRContains expected an error for a malformed variable definition")
+	}
+}
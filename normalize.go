@@ -0,0 +1,103 @@
+/* Copyright 2020 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"sort"
+	"strings"
+)
+
+// sortNetEntries sorts a list of entries and the entries of nested groups, and removes
+// duplicates.
+func sortNetEntries(entries []*NetEntry) []*NetEntry {
+	for _, e := range entries {
+		if e.Group != nil {
+			e.Group = sortNetEntries(e.Group)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].String() < entries[j].String() })
+	var out []*NetEntry
+	for i, e := range entries {
+		if i == 0 || e.String() != entries[i-1].String() {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// normalizeNetList returns a sorted list of addresses or ports. Lists that cannot be parsed are
+// returned unchanged.
+func normalizeNetList(items []string) []string {
+	entries, err := netEntries(items)
+	if err != nil {
+		return items
+	}
+	var out []string
+	for _, e := range sortNetEntries(entries) {
+		out = append(out, e.String())
+	}
+	return out
+}
+
+// normalizeFlow returns the options of a flow tag trimmed and sorted.
+func normalizeFlow(v string) string {
+	var fs []string
+	for _, f := range strings.Split(v, ",") {
+		fs = append(fs, strings.TrimSpace(f))
+	}
+	return strings.Join(sortedStrings(fs), ",")
+}
+
+// Normalize rewrites a rule in place to a canonical form, so that rules that are functionally equal
+// (see Equals) are written identically by String. Normalize:
+//   - trims whitespace around the msg, tag values and statements, and sorts the options of flow,
+//   - sorts the addresses and ports of Source and Destination, including nested groups, and
+//     removes duplicates,
+//   - collapses whitespace in metadata and sorts it (see NormalizeMetadata),
+//   - sorts Statements, Flowbits, Xbits and References.
+//
+// Matchers, Flowints and TLSTags are not reordered, as their order can be significant.
+func (r *Rule) Normalize() {
+	r.Description = strings.TrimSpace(r.Description)
+	r.Classtype = strings.TrimSpace(r.Classtype)
+	for k, v := range r.Tags {
+		v = strings.TrimSpace(v)
+		if k == "flow" {
+			v = normalizeFlow(v)
+		}
+		r.Tags[k] = v
+	}
+	for _, vs := range r.MultiTags {
+		for i, v := range vs {
+			vs[i] = strings.TrimSpace(v)
+		}
+	}
+	for i, s := range r.Statements {
+		r.Statements[i] = strings.TrimSpace(s)
+	}
+	sort.Strings(r.Statements)
+
+	r.Source.Nets = normalizeNetList(r.Source.Nets)
+	r.Source.Ports = normalizeNetList(r.Source.Ports)
+	r.Destination.Nets = normalizeNetList(r.Destination.Nets)
+	r.Destination.Ports = normalizeNetList(r.Destination.Ports)
+
+	r.NormalizeMetadata(true)
+
+	sort.SliceStable(r.Flowbits, func(i, j int) bool { return r.Flowbits[i].String() < r.Flowbits[j].String() })
+	sort.SliceStable(r.Xbits, func(i, j int) bool { return r.Xbits[i].String() < r.Xbits[j].String() })
+	sort.SliceStable(r.References, func(i, j int) bool { return r.References[i].String() < r.References[j].String() })
+}
@@ -0,0 +1,59 @@
+/* Copyright 2020 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		a    string
+		b    string
+		want string
+	}{
+		{
+			name: "equivalent rules",
+			a:    `alert tcp [5.6.7.8,1.2.3.4,![10.0.0.0/8,9.0.0.0/8]] any -> $HOME_NET [443,80] (msg:"foo "; flow:to_server, established; content:"abc"; depth:4; offset:1; nocase; flowbits:set,b; flowbits:isset,a; metadata:tag  b, created_at 2020_01_01; reference:url,b.com; reference:cve,2020-1234; sid:1; rev:1;)`,
+			b:    `alert tcp [![9.0.0.0/8,10.0.0.0/8],1.2.3.4,5.6.7.8,1.2.3.4] any -> $HOME_NET [80,443] (msg:"foo"; flow:established,to_server; content:"abc"; offset:1; nocase; depth:4; flowbits:isset,a; flowbits:set,b; metadata:created_at 2020_01_01, tag b; reference:cve,2020-1234; reference:url,b.com; sid:1; rev:1;)`,
+			want: `alert tcp [![10.0.0.0/8,9.0.0.0/8],1.2.3.4,5.6.7.8] any -> $HOME_NET [443,80] (msg:"foo"; flow:established,to_server; content:"abc"; offset:1; depth:4; nocase; metadata:created_at 2020_01_01, tag b; flowbits:isset,a; flowbits:set,b; reference:cve,2020-1234; reference:url,b.com; sid:1; rev:1;)`,
+		},
+		{
+			name: "relative matches keep their order",
+			a:    `alert tcp any any -> any any (msg:"foo"; content:"b"; content:"a"; within:5; distance:1; sid:1; rev:1;)`,
+			b:    `alert tcp any any -> any any (msg:"foo"; content:"b"; content:"a"; distance:1; within:5; sid:1; rev:1;)`,
+			want: `alert tcp any any -> any any (msg:"foo"; content:"b"; content:"a"; distance:1; within:5; sid:1; rev:1;)`,
+		},
+	} {
+		a, err := ParseRule(tt.a)
+		if err != nil {
+			t.Fatalf("%s: parse rule failed: %v", tt.name, err)
+		}
+		b, err := ParseRule(tt.b)
+		if err != nil {
+			t.Fatalf("%s: parse rule failed: %v", tt.name, err)
+		}
+		a.Normalize()
+		b.Normalize()
+		if got := a.String(); got != tt.want {
+			t.Fatalf("%s: got %v; expected %v", tt.name, got, tt.want)
+		}
+		if got := b.String(); got != tt.want {
+			t.Fatalf("%s: got %v; expected %v", tt.name, got, tt.want)
+		}
+	}
+}
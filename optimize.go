@@ -17,6 +17,9 @@ package gonids
 
 import (
 	"bytes"
+	"encoding/hex"
+	"math"
+	"strings"
 )
 
 // Suricata 4.x content options mapped to Suricata 5.0 sticky buffers.
@@ -154,21 +157,17 @@ func (r *Rule) SnortHTTPHeaderFix() bool {
 func (r *Rule) UpgradeToSuri5() bool {
 	var modified bool
 	for _, c := range r.Contents() {
-		for i, opt := range c.Options {
+		// Remove the old modifiers, keeping the other options in place.
+		opts := c.Options[:0]
+		for _, opt := range c.Options {
 			if sticky, ok := cOptToStickyBuffer[opt.Name]; ok {
-				// Remove the old modifier.
-				// TODO(duane): Find a better way to handle this. If I break this into another function I need
-				// to iterate again across everything.
-				if i < len(c.Options)-1 {
-					copy(c.Options[i:], c.Options[i+1:])
-				}
-				c.Options[len(c.Options)-1] = nil // or the zero value of T
-				c.Options = c.Options[:len(c.Options)-1]
-
 				c.DataPosition = sticky
 				modified = true
+				continue
 			}
+			opts = append(opts, opt)
 		}
+		c.Options = opts
 		// old sticky buffer to new sticky buffer
 		if sticky, ok := suri4StickyTo5Sticky[c.DataPosition]; ok {
 			c.DataPosition = sticky
@@ -182,6 +181,195 @@ func (r *Rule) UpgradeToSuri5() bool {
 	return modified
 }
 
+// Contents shorter than this are never selected by OptimizeFastPattern, and are reported as too
+// short for a fast_pattern by Validate.
+const minFastPatternLen = 3
+
+// entropy returns the Shannon entropy of b in bits per byte.
+func entropy(b []byte) float64 {
+	if len(b) == 0 {
+		return 0
+	}
+	var counts [256]int
+	for _, c := range b {
+		counts[c]++
+	}
+	var e float64
+	for _, n := range counts {
+		if n == 0 {
+			continue
+		}
+		p := float64(n) / float64(len(b))
+		e -= p * math.Log2(p)
+	}
+	return e
+}
+
+// fastPatternScore returns how good a candidate a content is for fast_pattern, favoring long
+// patterns with a varied set of bytes.
+func fastPatternScore(c *Content) float64 {
+	return float64(len(c.Pattern)) * (1 + entropy(c.Pattern))
+}
+
+// fastPatternCandidate returns the best fast_pattern candidate in the primary buffer, the buffer
+// holding the most non-negated content bytes, or nil if no content of that buffer qualifies. Only
+// non-negated contents of at least minLen bytes are considered.
+func (r *Rule) fastPatternCandidate(minLen int) *Content {
+	var order []DataPos
+	sizes := make(map[DataPos]int)
+	for _, c := range r.Contents() {
+		if c.Negate {
+			continue
+		}
+		d := c.buffer()
+		if _, ok := sizes[d]; !ok {
+			order = append(order, d)
+		}
+		sizes[d] += len(c.Pattern)
+	}
+	if len(order) == 0 {
+		return nil
+	}
+	primary := order[0]
+	for _, d := range order[1:] {
+		if sizes[d] > sizes[primary] {
+			primary = d
+		}
+	}
+
+	var best *Content
+	for _, c := range r.ContentsForBuffer(primary) {
+		if c.Negate || len(c.Pattern) < minLen {
+			continue
+		}
+		if best == nil || fastPatternScore(c) > fastPatternScore(best) {
+			best = c
+		}
+	}
+	return best
+}
+
+// OptimizeFastPattern sets fast_pattern on the most specific content of a rule, and clears it
+// from all others.
+func (r *Rule) OptimizeFastPattern() bool {
+	best := r.fastPatternCandidate(minFastPatternLen)
+	if best == nil {
+		return false
+	}
+	var modified bool
+	for _, c := range r.Contents() {
+		want := FastPattern{}
+		if c == best {
+			want.Enabled = true
+			// Keep any existing settings if this content is already the fast_pattern.
+			if c.isFastPattern() {
+				continue
+			}
+		}
+		if c.FastPattern != want || c.Prefilter {
+			c.FastPattern = want
+			c.Prefilter = false
+			modified = true
+		}
+	}
+	if m := MetadataModifier("fast_pattern"); modified && !r.hasMetadata(m) {
+		r.Metas = append(r.Metas, m)
+	}
+	return modified
+}
+
+// pcreMetaChars are the characters with a special meaning in a pcre, outside of a class.
+const pcreMetaChars = `\^$.|?*+()[]{}`
+
+// pcreLiteral returns the bytes matched by a pcre pattern made only of literal characters, and
+// escaped punctuation or hex escapes (e.g. \. or \x2f). It returns false for any other construct.
+func pcreLiteral(pattern []byte) ([]byte, bool) {
+	var lit []byte
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		if c != '\\' {
+			if strings.IndexByte(pcreMetaChars, c) >= 0 {
+				return nil, false
+			}
+			lit = append(lit, c)
+			continue
+		}
+		if i+1 >= len(pattern) {
+			return nil, false
+		}
+		i++
+		switch e := pattern[i]; {
+		case e == 'x':
+			if i+2 >= len(pattern) {
+				return nil, false
+			}
+			b, err := hex.DecodeString(string(pattern[i+1 : i+3]))
+			if err != nil {
+				return nil, false
+			}
+			lit = append(lit, b[0])
+			i += 2
+		case e < 0x80 && !('a' <= e && e <= 'z' || 'A' <= e && e <= 'Z' || '0' <= e && e <= '9'):
+			lit = append(lit, e)
+		default:
+			// Character classes (e.g. \d), assertions (e.g. \b), backreferences, etc.
+			return nil, false
+		}
+	}
+	return lit, len(lit) > 0
+}
+
+// SuggestContent returns a content equivalent to a pcre matching a literal string, optionally
+// anchored at the start of the buffer, or of the previous match with R. The i modifier becomes
+// nocase, the content is in the buffer of the pcre unless a modifier (e.g. U) selects another, and
+// ^ becomes startswith, or distance:0 and within for a relative pcre. A trailing $ becomes
+// endswith, only with the E modifier as PCRE otherwise also matches before a final newline.
+//
+// It returns false for any other pcre: non-literal constructs, captures, or modifiers changing the
+// meaning of the pattern or the buffer inspected (m, x, B, K).
+func (p PCRE) SuggestContent() (*Content, bool) {
+	if len(p.Vars) > 0 {
+		return nil, false
+	}
+	for _, m := range p.Options {
+		if strings.IndexByte("mxBK", m) >= 0 || !isPCREModifier(m) {
+			return nil, false
+		}
+	}
+	pattern := p.Pattern
+	start := bytes.HasPrefix(pattern, []byte("^")) || p.HasModifier('A')
+	pattern = bytes.TrimPrefix(pattern, []byte("^"))
+	end := bytes.HasSuffix(pattern, []byte("$")) && !bytes.HasSuffix(pattern, []byte("\\$"))
+	if end {
+		if !p.HasModifier('E') || p.IsRelative() {
+			return nil, false
+		}
+		pattern = pattern[:len(pattern)-1]
+	}
+	lit, ok := pcreLiteral(pattern)
+	if !ok {
+		return nil, false
+	}
+
+	c := &Content{DataPosition: p.DataPosition, Pattern: lit, Negate: p.Negate, Nocase: p.HasModifier('i')}
+	if d, ok := p.TargetBuffer(); ok {
+		c.DataPosition = d
+	}
+	switch {
+	case p.IsRelative() && start:
+		c.Distance = &IntOrVar{}
+		c.Within = &IntOrVar{Int: len(lit)}
+	case p.IsRelative():
+		c.Distance = &IntOrVar{}
+	case start:
+		c.Options = append(c.Options, &ContentOption{Name: "startswith"})
+	}
+	if end {
+		c.Options = append(c.Options, &ContentOption{Name: "endswith"})
+	}
+	return c, true
+}
+
 // MetadataModifier returns a metadata that identifies a given modification.
 func MetadataModifier(s string) *Metadata {
 	return &Metadata{Key: "gonids", Value: s}
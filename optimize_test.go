@@ -140,6 +140,17 @@ func TestOptimizeHTTP(t *testing.T) {
 			t.Fatal(fmt.Sprintf("diff (-got +want):\n%s", diff))
 		}
 	}
+
+	// The metadata modifier is only added once.
+	r := &Rule{Matchers: []orderedMatcher{&Content{Pattern: []byte("abcd")}, &Content{Pattern: []byte("efghij")}}}
+	r.OptimizeFastPattern()
+	r.Contents()[0].FastPattern.Enabled = true
+	if !r.OptimizeFastPattern() {
+		t.Fatal("second call did not modify the rule")
+	}
+	if len(r.Metas) != 1 {
+		t.Fatalf("got metadata %v; expected a single modifier", r.Metas)
+	}
 }
 
 func TestSnortURILenFix(t *testing.T) {
@@ -274,6 +285,17 @@ func TestSnortURILenFix(t *testing.T) {
 			t.Fatal(fmt.Sprintf("diff (-got +want):\n%s", diff))
 		}
 	}
+
+	// The metadata modifier is only added once.
+	r := &Rule{Matchers: []orderedMatcher{&Content{Pattern: []byte("abcd")}, &Content{Pattern: []byte("efghij")}}}
+	r.OptimizeFastPattern()
+	r.Contents()[0].FastPattern.Enabled = true
+	if !r.OptimizeFastPattern() {
+		t.Fatal("second call did not modify the rule")
+	}
+	if len(r.Metas) != 1 {
+		t.Fatalf("got metadata %v; expected a single modifier", r.Metas)
+	}
 }
 
 func TestSnortHTTPHeaderFix(t *testing.T) {
@@ -389,6 +411,17 @@ func TestSnortHTTPHeaderFix(t *testing.T) {
 			t.Fatal(fmt.Sprintf("diff (-got +want):\n%s", diff))
 		}
 	}
+
+	// The metadata modifier is only added once.
+	r := &Rule{Matchers: []orderedMatcher{&Content{Pattern: []byte("abcd")}, &Content{Pattern: []byte("efghij")}}}
+	r.OptimizeFastPattern()
+	r.Contents()[0].FastPattern.Enabled = true
+	if !r.OptimizeFastPattern() {
+		t.Fatal("second call did not modify the rule")
+	}
+	if len(r.Metas) != 1 {
+		t.Fatalf("got metadata %v; expected a single modifier", r.Metas)
+	}
 }
 
 func TestUpgradeToSuri5(t *testing.T) {
@@ -522,4 +555,318 @@ func TestUpgradeToSuri5(t *testing.T) {
 			t.Fatal(fmt.Sprintf("diff (-got +want):\n%s", diff))
 		}
 	}
+
+	// The metadata modifier is only added once.
+	r := &Rule{Matchers: []orderedMatcher{&Content{Pattern: []byte("abcd")}, &Content{Pattern: []byte("efghij")}}}
+	r.OptimizeFastPattern()
+	r.Contents()[0].FastPattern.Enabled = true
+	if !r.OptimizeFastPattern() {
+		t.Fatal("second call did not modify the rule")
+	}
+	if len(r.Metas) != 1 {
+		t.Fatalf("got metadata %v; expected a single modifier", r.Metas)
+	}
+}
+
+func TestOptimizeFastPattern(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		input   *Rule
+		output  *Rule
+		wantMod bool
+	}{
+		{
+			name: "no contents",
+			input: &Rule{
+				Matchers: []orderedMatcher{
+					&PCRE{Pattern: []byte("foo")},
+				},
+			},
+			wantMod: false,
+		},
+		{
+			name: "only short contents",
+			input: &Rule{
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("ab")},
+					&Content{Pattern: []byte("de")},
+				},
+			},
+			wantMod: false,
+		},
+		{
+			name: "select longest",
+			input: &Rule{
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("abcd")},
+					&Content{Pattern: []byte("abcdefgh")},
+					&Content{Pattern: []byte("abcdefghij"), Negate: true},
+				},
+			},
+			output: &Rule{
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("abcd")},
+					&Content{Pattern: []byte("abcdefgh"), FastPattern: FastPattern{Enabled: true}},
+					&Content{Pattern: []byte("abcdefghij"), Negate: true},
+				},
+				Metas: Metadatas{
+					&Metadata{
+						Key:   "gonids",
+						Value: "fast_pattern",
+					},
+				},
+			},
+			wantMod: true,
+		},
+		{
+			name: "prefer entropy over repeated bytes",
+			input: &Rule{
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("AAAAAAAA"), FastPattern: FastPattern{Enabled: true}},
+					&Content{Pattern: []byte("evil.com")},
+				},
+			},
+			output: &Rule{
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("AAAAAAAA")},
+					&Content{Pattern: []byte("evil.com"), FastPattern: FastPattern{Enabled: true}},
+				},
+				Metas: Metadatas{
+					&Metadata{
+						Key:   "gonids",
+						Value: "fast_pattern",
+					},
+				},
+			},
+			wantMod: true,
+		},
+		{
+			name: "primary buffer",
+			input: &Rule{
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("abcdefghijkl")},
+					&Content{DataPosition: httpURI, Pattern: []byte("/index.php")},
+					&Content{DataPosition: httpURI, Pattern: []byte("?id=")},
+					&Content{DataPosition: httpURI, Pattern: []byte("&action=")},
+				},
+			},
+			output: &Rule{
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("abcdefghijkl")},
+					&Content{DataPosition: httpURI, Pattern: []byte("/index.php"), FastPattern: FastPattern{Enabled: true}},
+					&Content{DataPosition: httpURI, Pattern: []byte("?id=")},
+					&Content{DataPosition: httpURI, Pattern: []byte("&action=")},
+				},
+				Metas: Metadatas{
+					&Metadata{
+						Key:   "gonids",
+						Value: "fast_pattern",
+					},
+				},
+			},
+			wantMod: true,
+		},
+		{
+			name: "no candidate in the primary buffer",
+			input: &Rule{
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("evil.com")},
+					&Content{DataPosition: httpURI, Pattern: []byte("/a")},
+					&Content{DataPosition: httpURI, Pattern: []byte("?i")},
+					&Content{DataPosition: httpURI, Pattern: []byte("&x")},
+					&Content{DataPosition: httpURI, Pattern: []byte("&y")},
+					&Content{DataPosition: httpURI, Pattern: []byte("&z")},
+				},
+			},
+			output: &Rule{
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("evil.com")},
+					&Content{DataPosition: httpURI, Pattern: []byte("/a")},
+					&Content{DataPosition: httpURI, Pattern: []byte("?i")},
+					&Content{DataPosition: httpURI, Pattern: []byte("&x")},
+					&Content{DataPosition: httpURI, Pattern: []byte("&y")},
+					&Content{DataPosition: httpURI, Pattern: []byte("&z")},
+				},
+			},
+		},
+		{
+			name: "content modifier buffer",
+			input: &Rule{
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("abcd")},
+					&Content{Pattern: []byte("/index.php"), Options: []*ContentOption{{Name: "http_uri"}}},
+					&Content{DataPosition: httpURI, Pattern: []byte("?id=")},
+				},
+			},
+			output: &Rule{
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("abcd")},
+					&Content{Pattern: []byte("/index.php"), Options: []*ContentOption{{Name: "http_uri"}}, FastPattern: FastPattern{Enabled: true}},
+					&Content{DataPosition: httpURI, Pattern: []byte("?id=")},
+				},
+				Metas: Metadatas{
+					&Metadata{
+						Key:   "gonids",
+						Value: "fast_pattern",
+					},
+				},
+			},
+			wantMod: true,
+		},
+		{
+			name: "already optimal",
+			input: &Rule{
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("abcd")},
+					&Content{Pattern: []byte("abcdefgh"), FastPattern: FastPattern{Enabled: true, Only: true}},
+				},
+			},
+			wantMod: false,
+		},
+	} {
+		gotMod := tt.input.OptimizeFastPattern()
+		// Expected modification.
+		if gotMod != tt.wantMod {
+			t.Fatalf("%s: gotMod %v; expected %v", tt.name, gotMod, tt.wantMod)
+		}
+		// Actual modifications correctness.
+		diff := pretty.Compare(tt.output, tt.input)
+		if tt.wantMod && diff != "" {
+			t.Fatal(fmt.Sprintf("diff (-got +want):\n%s", diff))
+		}
+	}
+
+	// The metadata modifier is only added once.
+	r := &Rule{Matchers: []orderedMatcher{&Content{Pattern: []byte("abcd")}, &Content{Pattern: []byte("efghij")}}}
+	r.OptimizeFastPattern()
+	r.Contents()[0].FastPattern.Enabled = true
+	if !r.OptimizeFastPattern() {
+		t.Fatal("second call did not modify the rule")
+	}
+	if len(r.Metas) != 1 {
+		t.Fatalf("got metadata %v; expected a single modifier", r.Metas)
+	}
+}
+
+func TestPCRESuggestContent(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		input  string
+		negate bool
+		want   *Content
+	}{
+		{
+			name:  "literal",
+			input: `/foo\.php\x3f/`,
+			want:  &Content{Pattern: []byte("foo.php?")},
+		},
+		{
+			name:  "anchored nocase uri",
+			input: `/^\/admin/Ui`,
+			want: &Content{
+				DataPosition: httpURI,
+				Pattern:      []byte("/admin"),
+				Nocase:       true,
+				Options:      []*ContentOption{{Name: "startswith"}},
+			},
+		},
+		{
+			name:  "relative anchored",
+			input: `/^abc/R`,
+			want: &Content{
+				Pattern:  []byte("abc"),
+				Distance: &IntOrVar{}, Within: &IntOrVar{Int: 3},
+			},
+		},
+		{
+			name:  "relative",
+			input: `/abc/R`,
+			want: &Content{
+				Pattern:  []byte("abc"),
+				Distance: &IntOrVar{},
+			},
+		},
+		{
+			name:  "dollar end only",
+			input: `/^abc$/E`,
+			want: &Content{
+				Pattern: []byte("abc"),
+				Options: []*ContentOption{{Name: "startswith"}, {Name: "endswith"}},
+			},
+		},
+		{
+			name:   "negated",
+			input:  `/abc/`,
+			negate: true,
+			want:   &Content{Pattern: []byte("abc"), Negate: true},
+		},
+		{
+			name:  "dollar without E",
+			input: `/abc$/`,
+		},
+		{
+			name:  "character class",
+			input: `/a[bc]/`,
+		},
+		{
+			name:  "escape class",
+			input: `/a\d/`,
+		},
+		{
+			name:  "quantifier",
+			input: `/ab+/`,
+		},
+		{
+			name:  "alternation",
+			input: `/a|b/`,
+		},
+		{
+			name:  "multiline",
+			input: `/^abc/m`,
+		},
+		{
+			name:  "extended",
+			input: `/a b/x`,
+		},
+		{
+			name:  "invalid hex escape",
+			input: `/a\xzz/`,
+		},
+	} {
+		p, err := parsePCRE(tt.input)
+		if err != nil {
+			t.Fatalf("%s: parse pcre failed: %v", tt.name, err)
+		}
+		p.Negate = tt.negate
+		got, ok := p.SuggestContent()
+		if ok != (tt.want != nil) {
+			t.Fatalf("%s: got %v,%v; expected %v", tt.name, got, ok, tt.want)
+		}
+		diff := pretty.Compare(got, tt.want)
+		if diff != "" {
+			t.Fatal(fmt.Sprintf("%s: diff (-got +want):\n%s", tt.name, diff))
+		}
+	}
+}
+
+func TestPCRESuggestContentStickyBuffer(t *testing.T) {
+	r, err := ParseRule(`alert http any any -> any any (msg:"foo"; http.uri; pcre:"/foo/"; pkt_data; pcre:"/bar/W"; sid:1; rev:1;)`)
+	if err != nil {
+		t.Fatalf("parse rule failed: %v", err)
+	}
+	var got []*Content
+	for _, p := range r.PCREs() {
+		c, ok := p.SuggestContent()
+		if !ok {
+			t.Fatalf("got no suggestion for %v", p)
+		}
+		got = append(got, c)
+	}
+	want := []*Content{
+		{DataPosition: httpURI, Pattern: []byte("foo")},
+		{DataPosition: httpHost, Pattern: []byte("bar")},
+	}
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Fatal(fmt.Sprintf("diff (-got +want):\n%s", diff))
+	}
 }
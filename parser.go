@@ -20,47 +20,110 @@ limitations under the License.
 package gonids
 
 import (
+	"bufio"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 )
 
 // hexRE matches on hexadecimal content like |41 41 41| for example.
-var hexRE = regexp.MustCompile(`(?i)(\|(?:\s*[a-f0-9]{2}\s*)+\|)`)
+var hexRE = regexp.MustCompile(`(?i)^\|(?:\s*[a-f0-9]{2}\s*)+\|$`)
+
+// contentEscapes are the characters that are unescaped when preceded by a backslash in a content.
+const contentEscapes = `\";`
 
 // escapeRE matches char that needs to escaped in regexp.
 var escapeRE = regexp.MustCompile(`([()+.'\\])`)
 
+// pcreVarsRE matches the capture variables following the modifiers of a pcre.
+var pcreVarsRE = regexp.MustCompile(`/[A-Za-z]*((?:\s*,\s*(?:flow|pkt):[^,]+)+)$`)
+
 // metaSplitRE matches string in metadata
 var metaSplitRE = regexp.MustCompile(`,\s*`)
 
-// parseContent decodes rule content match. For now it only takes care of escaped and hex
-// encoded content.
+// parseContent decodes rule content match: hexadecimal sections between pipes, and the characters
+// escaped with a backslash (\;, \" and \\). Other backslashes are kept as is, including those
+// escaping a pipe. Sections between pipes that are not hexadecimal are kept as is.
 func parseContent(content string) ([]byte, error) {
-	// Decode and replace all occurrences of hexadecimal content.
-	var errpanic error
-	defer func() {
-		r := recover()
-		if r != nil {
-			errpanic = fmt.Errorf("recovered from panic: %v", r)
-		}
-	}()
-	b := hexRE.ReplaceAllStringFunc(content,
-		func(h string) string {
-			r, err := hex.DecodeString(strings.Replace(strings.Trim(h, "|"), " ", "", -1))
-			if err != nil {
-				panic("invalid hexRE regexp")
+	if err := validatePipes(content); err != nil {
+		return nil, err
+	}
+	var b []byte
+	for i := 0; i < len(content); i++ {
+		switch c := content[i]; {
+		case c == '\\' && i+1 < len(content):
+			i++
+			if strings.IndexByte(contentEscapes, content[i]) < 0 {
+				b = append(b, c)
+			}
+			b = append(b, content[i])
+		case c == '|':
+			end := closingPipe(content, i)
+			section := content[i : end+1]
+			h := strings.Join(strings.Fields(section[1:len(section)-1]), "")
+			if d, err := hex.DecodeString(h); hexRE.MatchString(section) && err == nil {
+				b = append(b, d...)
+			} else {
+				b = append(b, section...)
 			}
-			return string(r)
-		})
-	return []byte(b), errpanic
+			i = end
+		default:
+			b = append(b, c)
+		}
+	}
+	return b, nil
+}
+
+// closingPipe returns the index of the pipe closing the section opened at i, skipping escaped
+// pipes the same way validatePipes does.
+func closingPipe(content string, i int) int {
+	for i++; i < len(content); i++ {
+		switch content[i] {
+		case '\\':
+			i++
+		case '|':
+			return i
+		}
+	}
+	return len(content) - 1
+}
+
+// validatePipes returns an error if a content has an unpaired pipe. Escaped pipes are ignored.
+func validatePipes(content string) error {
+	var n int
+	escaped := false
+	for _, c := range content {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '|':
+			n++
+		}
+	}
+	if n%2 != 0 {
+		return fmt.Errorf("unpaired pipe in content %q", content)
+	}
+	return nil
 }
 
 // parsePCRE parses the components of a PCRE. Returns PCRE struct.
 func parsePCRE(s string) (*PCRE, error) {
+	// Modifiers can be followed by the variables to capture unnamed groups in (e.g. ", flow:name"),
+	// variable names can contain a '/'.
+	var vars []string
+	if m := pcreVarsRE.FindStringSubmatchIndex(s); m != nil {
+		vars = strings.Split(s[m[2]:m[3]], ",")[1:]
+		s = s[:m[2]]
+	}
+
 	c := strings.Count(s, "/")
 	if c < 2 {
 		return nil, fmt.Errorf("all pcre patterns must contain at least 2 '/', found: %d", c)
@@ -76,10 +139,27 @@ func parsePCRE(s string) (*PCRE, error) {
 		return nil, fmt.Errorf("couldn't find start of pattern")
 	}
 
-	return &PCRE{
+	p := &PCRE{
 		Pattern: []byte(s[i+1 : l]),
-		Options: []byte(s[l+1:]),
-	}, nil
+		Options: []byte(strings.TrimSpace(s[l+1:])),
+	}
+	for _, v := range vars {
+		p.Vars = append(p.Vars, strings.TrimSpace(v))
+	}
+	// Unknown modifier letters are kept, and reported by Validate.
+	var buffer byte
+	for _, m := range p.Options {
+		if !('a' <= m && m <= 'z' || 'A' <= m && m <= 'Z') {
+			return nil, fmt.Errorf("invalid pcre modifier %q", m)
+		}
+		if _, ok := pcreBufferModifiers[m]; ok {
+			if buffer != 0 && buffer != m {
+				return nil, fmt.Errorf("pcre cannot use both %c and %c buffer modifiers", buffer, m)
+			}
+			buffer = m
+		}
+	}
+	return p, nil
 }
 
 // parseLenMatch parses a LenMatch (like urilen).
@@ -174,6 +254,64 @@ func parseBase64Decode(k byteMatchType, s string) (*ByteMatch, error) {
 	return b, nil
 }
 
+// byteMathOperators are the operators supported by byte_math.
+var byteMathOperators = []string{"+", "-", "*", "/", "<<", ">>"}
+
+// parseByteMath parses a byte_math ByteMatch.
+func parseByteMath(k byteMatchType, s string) (*ByteMatch, error) {
+	if k != bMath {
+		return nil, fmt.Errorf("kind %v is not byte_math", k)
+	}
+	b := new(ByteMatch)
+	b.Kind = k
+
+	// Options to byte_math are specified by their keyword, followed by a value.
+	required := map[string]bool{"bytes": false, "offset": false, "oper": false, "rvalue": false, "result": false}
+	for _, p := range strings.Split(s, ",") {
+		v := strings.TrimSpace(p)
+		parts := strings.Fields(v)
+		if len(parts) == 0 {
+			return nil, fmt.Errorf("empty byte_math option")
+		}
+		if seen, ok := required[parts[0]]; ok {
+			if seen {
+				return nil, fmt.Errorf("byte_math option %s is repeated", parts[0])
+			}
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("byte_math option %s requires a single value: %s", parts[0], v)
+			}
+			required[parts[0]] = true
+		}
+		switch parts[0] {
+		case "bytes":
+			b.NumBytes = parts[1]
+		case "offset":
+			i, err := strconv.Atoi(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("offset is not an int: %s; %s", parts[1], err)
+			}
+			b.Offset = i
+		case "oper":
+			if !inSlice(parts[1], byteMathOperators) {
+				return nil, fmt.Errorf("invalid byte_math operator: %s", parts[1])
+			}
+			b.Operator = parts[1]
+		case "rvalue":
+			b.Value = parts[1]
+		case "result":
+			b.Variable = parts[1]
+		default:
+			b.Options = append(b.Options, strings.Join(parts, " "))
+		}
+	}
+	for _, o := range []string{"bytes", "offset", "oper", "rvalue", "result"} {
+		if !required[o] {
+			return nil, fmt.Errorf("byte_math option %s is required", o)
+		}
+	}
+	return b, nil
+}
+
 // parseByteMatch parses a ByteMatch.
 func parseByteMatch(k byteMatchType, s string) (*ByteMatch, error) {
 	b := new(ByteMatch)
@@ -208,8 +346,16 @@ func parseByteMatch(k byteMatchType, s string) (*ByteMatch, error) {
 	}
 
 	if k == bTest {
-		// Parse operator.
-		b.Operator = strings.TrimSpace(parts[1])
+		// Parse operator, which can be negated.
+		op := strings.TrimSpace(parts[1])
+		if strings.HasPrefix(op, "!") {
+			b.Negate = true
+			op = strings.TrimSpace(op[1:])
+		}
+		if !inSlice(op, byteTestOperators) {
+			return nil, fmt.Errorf("invalid %s operator: %s", b.Kind, parts[1])
+		}
+		b.Operator = op
 		// Parse value. Can use a variable.
 		b.Value = strings.TrimSpace(parts[2])
 		// Parse offset.
@@ -226,9 +372,118 @@ func parseByteMatch(k byteMatchType, s string) (*ByteMatch, error) {
 		b.Options = append(b.Options, parts[i])
 	}
 
+	if k == bJump || k == bTest || k == bExtract {
+		if err := b.parseTypedOptions(); err != nil {
+			return nil, err
+		}
+	}
+
 	return b, nil
 }
 
+// byteTestOperators are the operators supported by byte_test, they can be negated with a "!".
+var byteTestOperators = []string{"<", ">", "=", "<=", ">=", "&", "^"}
+
+// byteJumpOnlyOptions are the options of byte_jump that are not valid for byte_test.
+var byteJumpOnlyOptions = []string{"multiplier", "align", "from_beginning", "from_end", "post_offset"}
+
+// byteExtractJumpOptions are the options of byte_jump that are also valid for byte_extract.
+var byteExtractJumpOptions = []string{"multiplier", "align"}
+
+// parseTypedOptions moves the known options of a byte_jump, byte_test or byte_extract from Options
+// to typed fields. Unknown options are kept in Options.
+func (b *ByteMatch) parseTypedOptions() error {
+	var opts []string
+	for _, o := range b.Options {
+		parts := strings.Fields(o)
+		if len(parts) == 0 {
+			opts = append(opts, o)
+			continue
+		}
+		if b.Kind != bJump && inSlice(parts[0], byteJumpOnlyOptions) && (b.Kind != bExtract || !inSlice(parts[0], byteExtractJumpOptions)) {
+			return fmt.Errorf("%s is not a valid option for %s", parts[0], b.Kind)
+		}
+		// Keywords taking a value.
+		if inSlice(parts[0], []string{"multiplier", "post_offset", "bitmask"}) {
+			if len(parts) != 2 {
+				return fmt.Errorf("%s option %s requires a single value: %s", b.Kind, parts[0], o)
+			}
+			if parts[0] == "bitmask" {
+				b.Bitmask = parts[1]
+				continue
+			}
+			i, err := strconv.Atoi(parts[1])
+			if err != nil {
+				return fmt.Errorf("%s option %s is not an int: %s; %s", b.Kind, parts[0], parts[1], err)
+			}
+			if parts[0] == "multiplier" {
+				b.Multiplier = i
+			} else {
+				b.PostOffset = i
+			}
+			continue
+		}
+		if len(parts) != 1 {
+			opts = append(opts, o)
+			continue
+		}
+		switch o {
+		case "relative":
+			b.Relative = true
+		case "big", "little":
+			if b.Endianness != "" && b.Endianness != o {
+				return fmt.Errorf("%s cannot be both %s and %s endian", b.Kind, b.Endianness, o)
+			}
+			b.Endianness = o
+		case "string":
+			b.StringMode = true
+		case "hex", "dec", "oct":
+			if b.Base != "" && b.Base != o {
+				return fmt.Errorf("%s cannot use both %s and %s", b.Kind, b.Base, o)
+			}
+			b.Base = o
+		case "align":
+			b.Align = true
+		case "from_beginning":
+			b.FromBeginning = true
+		case "from_end":
+			b.FromEnd = true
+		case "dce":
+			b.DCE = true
+		default:
+			opts = append(opts, o)
+		}
+	}
+	if b.FromBeginning && b.FromEnd {
+		return fmt.Errorf("%s cannot use both from_beginning and from_end", b.Kind)
+	}
+	if b.Base != "" && !b.StringMode {
+		return fmt.Errorf("%s number type %s requires string", b.Kind, b.Base)
+	}
+	b.Options = opts
+	return nil
+}
+
+// parseIPRep parses an iprep (e.g. src,CnC,>,30).
+func parseIPRep(s string) (*IPRep, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("iprep requires 4 values, got %d: %s", len(parts), s)
+	}
+	for i, p := range parts {
+		parts[i] = strings.TrimSpace(p)
+	}
+	v, err := strconv.Atoi(parts[3])
+	if err != nil {
+		return nil, fmt.Errorf("iprep value is not an int: %s; %s", parts[3], err)
+	}
+	i := &IPRep{Direction: parts[0], Category: parts[1], Operator: parts[2], Value: v}
+	if err := i.Validate(); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
 // parseFlowbit parses a flowbit.
 func parseFlowbit(s string) (*Flowbit, error) {
 	parts := strings.Split(s, ",")
@@ -294,6 +549,84 @@ func parseXbit(s string) (*Xbit, error) {
 
 }
 
+// parseDCEIface parses the value of dce_iface: a UUID, optionally followed by a version comparison
+// and any_frag.
+func parseDCEIface(s string) (*DCEIface, error) {
+	parts := strings.Split(s, ",")
+	d := &DCEIface{UUID: strings.TrimSpace(parts[0])}
+	for _, p := range parts[1:] {
+		p = strings.TrimSpace(p)
+		switch {
+		case p == "any_frag":
+			d.AnyFrag = true
+		case p != "" && strings.ContainsAny(p[:1], "<>=!") && d.Operator == "":
+			v, err := strconv.Atoi(p[1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid dce_iface version %q", p)
+			}
+			d.Operator, d.Version = p[:1], v
+		default:
+			return nil, fmt.Errorf("invalid dce_iface option %q", p)
+		}
+	}
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// parseDCEOpnum parses the value of dce_opnum: a list of operation numbers and ranges (e.g.
+// 15,18-20).
+func parseDCEOpnum(s string) ([]*OpnumRange, error) {
+	var os []*OpnumRange
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		bounds := strings.SplitN(p, "-", 2)
+		min, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid dce_opnum %q", p)
+		}
+		max := min
+		if len(bounds) == 2 {
+			if max, err = strconv.Atoi(strings.TrimSpace(bounds[1])); err != nil {
+				return nil, fmt.Errorf("invalid dce_opnum %q", p)
+			}
+		}
+		if min < 0 || max < min || max > 65535 {
+			return nil, fmt.Errorf("invalid dce_opnum range %q", p)
+		}
+		os = append(os, &OpnumRange{Min: min, Max: max})
+	}
+	return os, nil
+}
+
+// parseFilestore parses the optional direction and scope of filestore from the item following
+// the keyword.
+func parseFilestore(i item) (*Filestore, error) {
+	fs := &Filestore{}
+	if i.typ != itemOptionValue {
+		return fs, nil
+	}
+	parts := strings.Split(i.value, ",")
+	if len(parts) > 2 {
+		return nil, fmt.Errorf("invalid filestore: %s", i.value)
+	}
+	fs.Direction = strings.TrimSpace(parts[0])
+	if !inSlice(fs.Direction, filestoreDirections) {
+		return nil, fmt.Errorf("invalid filestore direction: %s", fs.Direction)
+	}
+	if len(parts) == 2 {
+		fs.Scope = strings.TrimSpace(parts[1])
+		if !inSlice(fs.Scope, filestoreScopes) {
+			return nil, fmt.Errorf("invalid filestore scope: %s", fs.Scope)
+		}
+	}
+	return fs, nil
+}
+
+// luaScriptRE matches the allowed filenames of a Lua script.
+var luaScriptRE = regexp.MustCompile(`^[A-Za-z0-9_.\-/]+$`)
+
 // parseFlowint parses a flowint.
 func parseFlowint(s string) (*Flowint, error) {
 	parts := strings.Split(s, ",")
@@ -334,8 +667,9 @@ func inSlice(str string, strings []string) bool {
 	return false
 }
 
-// comment decodes a comment (commented rule, or just a comment.)
-func (r *Rule) comment(key item, l *lexer) error {
+// comment decodes a comment (commented rule, or just a comment.) A commented rule is parsed in
+// lossless mode if lossless is true.
+func (r *Rule) comment(key item, l *lexer, lossless bool) error {
 	if key.typ != itemComment {
 		panic("item is not a comment")
 	}
@@ -343,11 +677,11 @@ func (r *Rule) comment(key item, l *lexer) error {
 		// ignoring comment for rule with empty action
 		return nil
 	}
-	rule, err := parseRuleAux(key.value, true)
+	rule, err := parseRuleAux(key.value, true, lossless)
 
 	// If there was an error this means the comment is not a rule.
 	if err != nil {
-		return fmt.Errorf("this is not a rule: %s", err)
+		return fmt.Errorf("this is not a rule: %w", err)
 	}
 
 	// We parsed a rule, this was a comment so set the rule to disabled.
@@ -378,12 +712,14 @@ func (r *Rule) protocol(key item, l *lexer) error {
 
 // network decodes an IDS rule network (networks and ports) based on its key.
 func (r *Rule) network(key item, l *lexer) error {
-	items := strings.Split(strings.Trim(key.value, "[]"), ",")
 	// Validate that no items contain spaces.
-	for _, i := range items {
-		if len(strings.Fields(i)) > 1 || len(strings.TrimSpace(i)) != len(i) {
-			return fmt.Errorf("network component contains spaces: %v", i)
-		}
+	if len(strings.Fields(key.value)) > 1 {
+		return fmt.Errorf("network component contains spaces: %v", key.value)
+	}
+	// Nested groups and negations are kept as a single item, so they are written back unchanged.
+	items, err := splitNetList(key.value)
+	if err != nil {
+		return err
 	}
 	switch key.typ {
 	case itemSourceAddress:
@@ -418,28 +754,155 @@ func (r *Rule) direction(key item, l *lexer) error {
 
 var dataPosition = pktData
 
+// tagKeywords are the simple keywords parsed into Tags, or MultiTags if they are repeated, in the
+// order they are written. classtype, priority and target have typed fields and are only listed for
+// ordering.
+var tagKeywords = []string{"classtype", "flow", "tag", "priority", "target", "app-layer-protocol",
+	"flags", "ipopts", "fragbits", "fragoffset", "tos",
+	"window",
+	"threshold", "detection_filter",
+	"asn1"}
+
 // option decodes an IDS rule option based on its key.
 func (r *Rule) option(key item, l *lexer) error {
 	if key.typ != itemOptionKey {
 		panic("item is not an option key")
 	}
 	switch {
+	case key.value == "classtype":
+		nextItem := l.nextItem()
+		if nextItem.typ != itemOptionValue || strings.TrimSpace(nextItem.value) == "" {
+			return errors.New("no valid value for classtype")
+		}
+		if r.Classtype != "" {
+			return errors.New("duplicate classtype")
+		}
+		r.Classtype = strings.TrimSpace(nextItem.value)
+	case key.value == "priority":
+		nextItem := l.nextItem()
+		if nextItem.typ != itemOptionValue {
+			return errors.New("no value for option priority")
+		}
+		if r.Priority != 0 {
+			return errors.New("duplicate priority")
+		}
+		p, err := strconv.Atoi(strings.TrimSpace(nextItem.value))
+		if err != nil || p < 1 {
+			return fmt.Errorf("invalid priority %s", nextItem.value)
+		}
+		r.Priority = p
+	case key.value == "target":
+		nextItem := l.nextItem()
+		if nextItem.typ != itemOptionValue {
+			return errors.New("no value for option target")
+		}
+		if r.Target != "" {
+			return errors.New("duplicate target")
+		}
+		t := strings.TrimSpace(nextItem.value)
+		if !inSlice(t, targets) {
+			return fmt.Errorf("invalid target %s", nextItem.value)
+		}
+		r.Target = t
 	// TODO: Many of these simple tags could be factored into nicer structures.
-	case inSlice(key.value, []string{"classtype", "flow", "tag", "priority", "app-layer-protocol", "noalert",
-		"flags", "ipopts", "ip_proto", "geoip", "fragbits", "fragoffset", "tos",
-		"window",
-		"threshold", "detection_filter",
-		"dce_iface", "dce_opnum", "dce_stub_data",
-		"asn1"}):
+	case inSlice(key.value, tagKeywords):
 		nextItem := l.nextItem()
+		// Negated values (e.g. app-layer-protocol:!http) are kept with their "!".
+		var not string
+		if nextItem.typ == itemNot {
+			not = "!"
+			nextItem = l.nextItem()
+		}
 		if nextItem.typ != itemOptionValue {
 			return fmt.Errorf("no valid value for %s tag", key.value)
 		}
+		// Repeated values of other keywords are kept in MultiTags too, and reported by Validate.
+		if _, ok := r.Tags[key.value]; ok || inSlice(key.value, repeatableTags) {
+			if r.MultiTags == nil {
+				r.MultiTags = make(map[string][]string)
+			}
+			r.MultiTags[key.value] = append(r.MultiTags[key.value], not+nextItem.value)
+			break
+		}
 		if r.Tags == nil {
 			r.Tags = make(map[string]string)
 		}
-		r.Tags[key.value] = nextItem.value
-	case inSlice(key.value, []string{"sameip", "tls.store", "ftpbounce"}):
+		r.Tags[key.value] = not + nextItem.value
+	case key.value == "sameip":
+		r.SameIP = true
+	case key.value == "ip_proto":
+		p := &IPProto{}
+		nextItem := l.nextItem()
+		if nextItem.typ == itemNot {
+			p.Negate = true
+			nextItem = l.nextItem()
+		}
+		if nextItem.typ != itemOptionValue {
+			return errors.New("no valid value for ip_proto")
+		}
+		p.Proto = strings.TrimSpace(nextItem.value)
+		if strings.HasPrefix(p.Proto, "<") || strings.HasPrefix(p.Proto, ">") {
+			p.Operator = p.Proto[:1]
+			p.Proto = strings.TrimSpace(p.Proto[1:])
+		}
+		if err := p.Validate(); err != nil {
+			return err
+		}
+		r.IPProto = append(r.IPProto, p)
+	case key.value == "dce_iface":
+		if r.DCEIface != nil {
+			return errors.New("duplicate dce_iface")
+		}
+		nextItem := l.nextItem()
+		if nextItem.typ != itemOptionValue {
+			return errors.New("no valid value for dce_iface")
+		}
+		d, err := parseDCEIface(nextItem.value)
+		if err != nil {
+			return err
+		}
+		r.DCEIface = d
+	case key.value == "dce_opnum":
+		if r.DCEOpnums != nil {
+			return errors.New("duplicate dce_opnum")
+		}
+		nextItem := l.nextItem()
+		if nextItem.typ != itemOptionValue {
+			return errors.New("no valid value for dce_opnum")
+		}
+		os, err := parseDCEOpnum(nextItem.value)
+		if err != nil {
+			return err
+		}
+		r.DCEOpnums = os
+	case key.value == "geoip":
+		if r.GeoIP != nil {
+			return errors.New("duplicate geoip")
+		}
+		nextItem := l.nextItem()
+		if nextItem.typ != itemOptionValue {
+			return errors.New("no valid value for geoip")
+		}
+		parts := strings.Split(nextItem.value, ",")
+		for i, p := range parts {
+			parts[i] = strings.TrimSpace(p)
+		}
+		g := &GeoIP{Direction: parts[0], Countries: parts[1:]}
+		if err := g.Validate(); err != nil {
+			return err
+		}
+		r.GeoIP = g
+	case key.value == "iprep":
+		nextItem := l.nextItem()
+		if nextItem.typ != itemOptionValue {
+			return errors.New("no valid value for iprep")
+		}
+		i, err := parseIPRep(nextItem.value)
+		if err != nil {
+			return err
+		}
+		r.IPRep = append(r.IPRep, i)
+	case inSlice(key.value, statementKeywords):
 		r.Statements = append(r.Statements, key.value)
 	case inSlice(key.value, tlsTags):
 		t := &TLSTag{
@@ -451,6 +914,9 @@ func (r *Rule) option(key item, l *lexer) error {
 			nextItem = l.nextItem()
 		}
 		t.Value = nextItem.value
+		if t.Value == "" {
+			return fmt.Errorf("no valid value for %s", key.value)
+		}
 		r.TLSTags = append(r.TLSTags, t)
 	case key.value == "stream_size":
 		nextItem := l.nextItem()
@@ -484,12 +950,25 @@ func (r *Rule) option(key item, l *lexer) error {
 		}
 		metas := metaSplitRE.Split(nextItem.value, -1)
 		for _, kv := range metas {
-			metaTmp := strings.SplitN(kv, " ", 2)
-			if len(metaTmp) != 2 {
+			// Separators are normalized to a single space.
+			metaTmp := strings.Fields(kv)
+			if len(metaTmp) < 2 {
 				return fmt.Errorf("invalid metadata definition: %s", metaTmp)
 			}
-			r.Metas = append(r.Metas, &Metadata{Key: strings.TrimSpace(metaTmp[0]), Value: strings.TrimSpace(metaTmp[1])})
+			r.Metas = append(r.Metas, &Metadata{Key: metaTmp[0], Value: strings.Join(metaTmp[1:], " ")})
+		}
+	case key.value == "noalert":
+		r.NoAlert = true
+	case key.value == "gid":
+		nextItem := l.nextItem()
+		if nextItem.typ != itemOptionValue {
+			return errors.New("no value for option gid")
 		}
+		gid, err := strconv.Atoi(nextItem.value)
+		if err != nil || gid < 1 {
+			return fmt.Errorf("invalid gid %s", nextItem.value)
+		}
+		r.GID = gid
 	case key.value == "sid":
 		nextItem := l.nextItem()
 		if nextItem.typ != itemOptionValue {
@@ -535,29 +1014,34 @@ func (r *Rule) option(key item, l *lexer) error {
 			if err != nil {
 				return err
 			}
-			var options []*ContentOption
-			if key.value == "uricontent" {
-				options = append(options, &ContentOption{Name: "http_uri"})
-			}
 			con := &Content{
 				DataPosition: dataPosition,
 				Pattern:      c,
 				Negate:       negate,
-				Options:      options,
+			}
+			// uricontent is the legacy form of a content in the http_uri buffer, it does not
+			// change the buffer of the following contents.
+			if key.value == "uricontent" {
+				con.DataPosition = httpURI
 			}
 			r.Matchers = append(r.Matchers, con)
 		} else {
 			return fmt.Errorf("invalid type %q for option content", nextItem.typ)
 		}
-	case inSlice(key.value, []string{"http_cookie", "http_raw_cookie", "http_method", "http_header", "http_raw_header",
-		"http_uri", "http_raw_uri", "http_user_agent", "http_stat_code", "http_stat_msg",
-		"http_client_body", "http_server_body", "http_host", "nocase", "rawbytes", "startswith", "endswith"}):
+	case inSlice(key.value, contentModifiers):
 		lastContent := r.LastContent()
 		if lastContent == nil {
 			return fmt.Errorf("invalid content option %q with no content match", key.value)
 		}
 		lastContent.Options = append(lastContent.Options, &ContentOption{Name: key.value})
-	case inSlice(key.value, []string{"depth", "distance", "offset", "within"}):
+		lastContent.written = append(lastContent.written, key.value)
+	case key.value == "nocase":
+		lastContent := r.LastContent()
+		if lastContent == nil {
+			return fmt.Errorf("invalid content option %q with no content match", key.value)
+		}
+		lastContent.Nocase = true
+	case inSlice(key.value, positionOptions):
 		lastContent := r.LastContent()
 		if lastContent == nil {
 			return fmt.Errorf("invalid content option %q with no content match", key.value)
@@ -566,16 +1050,29 @@ func (r *Rule) option(key item, l *lexer) error {
 		if nextItem.typ != itemOptionValue {
 			return fmt.Errorf("no value for content option %s", key.value)
 		}
-
-		lastContent.Options = append(lastContent.Options, &ContentOption{Name: key.value, Value: nextItem.value})
+		v, err := ParseIntOrVar(nextItem.value)
+		if err != nil {
+			return fmt.Errorf("invalid value for content option %s: %v", key.value, err)
+		}
+		if lastContent.positionOption(key.value) != nil {
+			return fmt.Errorf("duplicate content option %s", key.value)
+		}
+		lastContent.setPositionOption(key.value, &v)
+		lastContent.written = append(lastContent.written, key.value)
 
 	case key.value == "fast_pattern":
 		lastContent := r.LastContent()
 		if lastContent == nil {
 			return fmt.Errorf("invalid content option %q with no content match", key.value)
 		}
+		for _, c := range r.Contents() {
+			if c.Prefilter {
+				return errors.New("fast_pattern cannot be used with prefilter")
+			}
+		}
 		var (
 			only   bool
+			chop   bool
 			offset int
 			length int
 		)
@@ -597,9 +1094,10 @@ func (r *Rule) option(key item, l *lexer) error {
 					return fmt.Errorf("fast_pattern length is not an int: %s; %s", s[1], err)
 				}
 				length = i
+				chop = true
 			}
 		}
-		lastContent.FastPattern = FastPattern{true, only, offset, length}
+		lastContent.FastPattern = FastPattern{Enabled: true, Only: only, Chop: chop, Offset: offset, Length: length}
 	case key.value == "pcre":
 		nextItem := l.nextItem()
 		negate := false
@@ -613,6 +1111,7 @@ func (r *Rule) option(key item, l *lexer) error {
 				return err
 			}
 			p.Negate = negate
+			p.DataPosition = dataPosition
 			r.Matchers = append(r.Matchers, p)
 		} else {
 			return fmt.Errorf("invalid type %q for option content", nextItem.typ)
@@ -632,8 +1131,20 @@ func (r *Rule) option(key item, l *lexer) error {
 		}
 
 		var b *ByteMatch
-		// Parse base64_decode differently as it has odd semantics.
-		if k == b64Decode {
+		// Parse base64_decode and byte_math differently as they have odd semantics.
+		switch k {
+		case bMath:
+			b, err = parseByteMath(k, nextItem.value)
+			if err != nil {
+				return fmt.Errorf("could not parse byteMath: %v", err)
+			}
+			// Both bytes and rvalue may be an int or a variable from byte_extract.
+			for _, v := range []string{b.NumBytes, b.Value} {
+				if _, err := strconv.Atoi(v); err != nil && !r.HasVar(v) {
+					return fmt.Errorf("byte_math value is not an int, or an extracted variable: %s; %s", v, err)
+				}
+			}
+		case b64Decode:
 			b, err = parseBase64Decode(k, nextItem.value)
 			if err != nil {
 				return fmt.Errorf("could not parse base64Decode: %v", err)
@@ -647,7 +1158,7 @@ func (r *Rule) option(key item, l *lexer) error {
 					return fmt.Errorf("bytes must be positive, non-zero values only: %d", i)
 				}
 			}
-		} else {
+		default:
 			b, err = parseByteMatch(k, nextItem.value)
 			if err != nil {
 				return fmt.Errorf("could not parse byteMatch: %v", err)
@@ -668,12 +1179,30 @@ func (r *Rule) option(key item, l *lexer) error {
 			return fmt.Errorf("%s is not a support lenMatch keyword", key.value)
 		}
 		nextItem := l.nextItem()
+		negate := nextItem.typ == itemNot
+		if negate {
+			nextItem = l.nextItem()
+		}
 		m, err := parseLenMatch(k, nextItem.value)
 		if err != nil {
 			return fmt.Errorf("could not parse LenMatch: %v", err)
 		}
+		m.Negate = negate
 		m.DataPosition = dataPosition
 		r.Matchers = append(r.Matchers, m)
+	case isTransform(key.value):
+		t := &Transform{
+			DataPosition: dataPosition,
+			Name:         key.value,
+		}
+		nextItem := l.nextItem()
+		if nextItem.typ == itemOptionValue || nextItem.typ == itemOptionValueString {
+			t.Value = nextItem.value
+		}
+		if needsValue := transformNames[key.value]; needsValue != (t.Value != "") {
+			return fmt.Errorf("invalid value for transform %s: %q", key.value, t.Value)
+		}
+		r.Matchers = append(r.Matchers, t)
 	case key.value == "flowbits":
 		nextItem := l.nextItem()
 		fb, err := parseFlowbit(nextItem.value)
@@ -688,6 +1217,39 @@ func (r *Rule) option(key item, l *lexer) error {
 			return fmt.Errorf("error parsing xbits: %v", err)
 		}
 		r.Xbits = append(r.Xbits, xb)
+	case inSlice(key.value, fileMatchKeywords):
+		nextItem := l.nextItem()
+		f := &FileMatch{Keyword: key.value}
+		if nextItem.typ == itemNot {
+			f.Negate = true
+			nextItem = l.nextItem()
+		}
+		if nextItem.typ != itemOptionValueString && nextItem.typ != itemOptionValue || nextItem.value == "" {
+			return fmt.Errorf("no valid value for %s", key.value)
+		}
+		f.Value = nextItem.value
+		r.FileMatches = append(r.FileMatches, f)
+	case key.value == "filestore":
+		fs, err := parseFilestore(l.nextItem())
+		if err != nil {
+			return err
+		}
+		r.Filestore = fs
+	case key.value == "lua" || key.value == "luajit":
+		nextItem := l.nextItem()
+		lua := &Lua{Keyword: key.value}
+		if nextItem.typ == itemNot {
+			lua.Negate = true
+			nextItem = l.nextItem()
+		}
+		if nextItem.typ != itemOptionValue {
+			return fmt.Errorf("no valid value for %s", key.value)
+		}
+		if !luaScriptRE.MatchString(nextItem.value) || strings.Contains(nextItem.value, "..") {
+			return fmt.Errorf("invalid %s script name: %q", key.value, nextItem.value)
+		}
+		lua.Script = nextItem.value
+		r.Lua = append(r.Lua, lua)
 	case key.value == "flowint":
 		nextItem := l.nextItem()
 		fi, err := parseFlowint(nextItem.value)
@@ -704,7 +1266,8 @@ func (r *Rule) option(key item, l *lexer) error {
 }
 
 // UnsupportedOptionError contains a partially parsed rule, and the options that aren't
-// supported for parsing.
+// supported for parsing. The unsupported options are kept in the rule as UnknownOption matchers,
+// so the rule can still be written back.
 type UnsupportedOptionError struct {
 	Rule    *Rule
 	Options []string
@@ -715,8 +1278,115 @@ func (uoe *UnsupportedOptionError) Error() string {
 	return fmt.Sprintf("rule contains unsupported option(s): %s", strings.Join(uoe.Options, ","))
 }
 
-// parseRuleAux parses an IDS rule, optionally ignoring comments.
-func parseRuleAux(rule string, commented bool) (*Rule, error) {
+// quoteValue returns a string value as written in the rule, the lexer removes its opening quote,
+// and its closing quote if it ends the value (e.g. "foo" but not "foo", 1).
+func quoteValue(s string) string {
+	return closeQuotes(`"` + s)
+}
+
+// closeQuotes returns a value as written in the rule, the lexer removes the closing quote ending a
+// value (e.g. foo, "bar"), which is added back if the quotes of s are not balanced.
+func closeQuotes(s string) string {
+	var quotes int
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			quotes++
+		}
+	}
+	if quotes%2 == 1 {
+		return s + `"`
+	}
+	return s
+}
+
+// prefilterTags are the keywords of Tags that support the prefilter keyword.
+var prefilterTags = []string{"flow", "flags", "fragbits", "fragoffset"}
+
+// prefilter applies the prefilter keyword to the option prev it follows. On a content, prefilter
+// is equivalent to fast_pattern, as in Suricata, so it cannot be used with a fast_pattern.
+func (r *Rule) prefilter(prev string) error {
+	if r.hasPrefilter() {
+		return errors.New("only one prefilter is allowed")
+	}
+	var last orderedMatcher
+	if len(r.Matchers) > 0 {
+		last = r.Matchers[len(r.Matchers)-1]
+	}
+	switch {
+	case inSlice(prev, allLenMatchTypeNames()):
+		if lm, ok := last.(*LenMatch); ok {
+			lm.Prefilter = true
+			return nil
+		}
+	case prev == "content", prev == "nocase", prev == "fast_pattern",
+		inSlice(prev, contentModifiers), inSlice(prev, positionOptions):
+		if c, ok := last.(*Content); ok {
+			if c.Negate {
+				return errors.New("prefilter cannot be used on a negated content")
+			}
+			for _, o := range r.Contents() {
+				if o.FastPattern.Enabled {
+					return errors.New("prefilter cannot be used with fast_pattern")
+				}
+			}
+			c.Prefilter = true
+			return nil
+		}
+	case inSlice(prev, prefilterTags):
+		if _, ok := r.Tags[prev]; ok {
+			r.PrefilterTag = prev
+			return nil
+		}
+	}
+	if prev == "" {
+		return errors.New("prefilter has no preceding keyword")
+	}
+	return fmt.Errorf("prefilter is not supported on %s", prev)
+}
+
+// isContentOption returns true if key is a modifier of the preceding content.
+func isContentOption(key string) bool {
+	return key == "nocase" || key == "fast_pattern" || inSlice(key, contentModifiers) ||
+		inSlice(key, positionOptions)
+}
+
+// fileMatchOption applies the content modifier key to the last file match. filemagic, filename and
+// fileext are content-like, the modifiers following them do not apply to the previous content, and
+// only nocase is supported.
+func (r *Rule) fileMatchOption(key string) error {
+	f := r.FileMatches[len(r.FileMatches)-1]
+	if key != "nocase" {
+		return fmt.Errorf("%s is not supported on %s", key, f.Keyword)
+	}
+	f.Nocase = true
+	return nil
+}
+
+// hasPrefilter returns true if a content, length match or tag of the rule is marked with
+// prefilter.
+func (r *Rule) hasPrefilter() bool {
+	if r.PrefilterTag != "" {
+		return true
+	}
+	for _, c := range r.Contents() {
+		if c.Prefilter {
+			return true
+		}
+	}
+	for _, lm := range r.LenMatchers() {
+		if lm.Prefilter {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRuleAux parses an IDS rule, optionally ignoring comments. Unsupported options are kept as
+// UnknownOption matchers, and reported with an UnsupportedOptionError unless lossless is true.
+func parseRuleAux(rule string, commented, lossless bool) (*Rule, error) {
 	l, err := lex(rule)
 	if err != nil {
 		return nil, err
@@ -725,14 +1395,21 @@ func parseRuleAux(rule string, commented bool) (*Rule, error) {
 	dataPosition = pktData
 	r := &Rule{}
 	var unsupportedOptions = make([]string, 0, 3)
+	// unknown is the last unsupported option, its value is the following item.
+	var unknown *UnknownOption
+	// prev is the key of the previous option, which prefilter applies to.
+	var prev string
+	// fileMatch is true if the last content-like option is a file match, which modifiers apply to.
+	var fileMatch bool
 	for item := l.nextItem(); item.typ != itemEOR && item.typ != itemEOF && err == nil; item = l.nextItem() {
 		switch item.typ {
 		case itemComment:
 			if r.Action != "" || commented {
 				// Ignore comment ending rule.
+				r.trimWrittenOrder()
 				return r, nil
 			}
-			err = r.comment(item, l)
+			err = r.comment(item, l, lossless)
 			// Error here means that the comment was not a commented rule.
 			// So we're not parsing a rule and we need to break out.
 			if err != nil {
@@ -749,13 +1426,42 @@ func parseRuleAux(rule string, commented bool) (*Rule, error) {
 		case itemDirection:
 			err = r.direction(item, l)
 		case itemOptionKey:
-			err = r.option(item, l)
+			unknown = nil
+			switch {
+			case fileMatch && (isContentOption(item.value) || item.value == "prefilter" && prev == "nocase"):
+				err = r.fileMatchOption(item.value)
+			case item.value == "prefilter":
+				err = r.prefilter(prev)
+			default:
+				err = r.option(item, l)
+			}
+			switch {
+			case inSlice(item.value, fileMatchKeywords):
+				fileMatch = true
+			case item.value == "content" || item.value == "uricontent":
+				fileMatch = false
+			}
+			prev = item.value
 			// We will continue to parse a rule with unsupported options.
 			if uerr, ok := err.(*UnsupportedOptionError); ok {
 				unsupportedOptions = append(unsupportedOptions, uerr.Options...)
+				unknown = &UnknownOption{Key: item.value}
+				r.Matchers = append(r.Matchers, unknown)
 				// This is ugly but allows the parsing to continue.
 				err = nil
 			}
+		case itemNot:
+			if unknown != nil {
+				unknown.Value = "!"
+			}
+		case itemOptionValue:
+			if unknown != nil {
+				unknown.Value += closeQuotes(item.value)
+			}
+		case itemOptionValueString:
+			if unknown != nil {
+				unknown.Value += quoteValue(item.value)
+			}
 		case itemError:
 			err = errors.New(item.value)
 		}
@@ -766,17 +1472,294 @@ func parseRuleAux(rule string, commented bool) (*Rule, error) {
 	}
 
 	// If we encountered one or more unsupported keys, return an UnsupportedOptionError.
-	if len(unsupportedOptions) > 0 {
+	if len(unsupportedOptions) > 0 && !lossless {
 		return nil, &UnsupportedOptionError{
 			Rule:    r,
 			Options: unsupportedOptions,
 		}
 	}
 
+	r.trimWrittenOrder()
 	return r, nil
 }
 
+// trimWrittenOrder forgets the order the options of contents were parsed in if it is the canonical
+// order, as it is how they are written anyway.
+func (r *Rule) trimWrittenOrder() {
+	for _, c := range r.Contents() {
+		if c.writtenCanonical() {
+			c.written = nil
+		}
+	}
+}
+
+// byteOrderMark is the UTF-8 encoded byte order mark some editors prepend to files.
+const byteOrderMark = "\ufeff"
+
 // ParseRule parses an IDS rule and returns a struct describing the rule.
-func ParseRule(rule string) (*Rule, error) {
-	return parseRuleAux(rule, false)
+// A leading byte order mark and Windows (CRLF) line endings are ignored. A commented rule, prefixed
+// by any combination of "#" and whitespace, is parsed as a disabled rule.
+// ParseRule does not panic on malformed input, internal panics are returned as errors.
+func ParseRule(rule string) (r *Rule, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			r, err = nil, fmt.Errorf("recovered from panic: %v", p)
+		}
+	}()
+	return parseRule(rule, false)
+}
+
+// ParseRuleLossless parses an IDS rule like ParseRule, but does not return an
+// UnsupportedOptionError for keywords that are not supported. They are kept as UnknownOption
+// matchers, and written back verbatim by String at their original position relative to the other
+// matchers. The original text of the rule is kept in Raw.
+func ParseRuleLossless(rule string) (r *Rule, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			r, err = nil, fmt.Errorf("recovered from panic: %v", p)
+		}
+	}()
+	r, err = parseRule(rule, true)
+	if err != nil {
+		return nil, err
+	}
+	r.Raw = rule
+	return r, nil
+}
+
+// parseRule normalizes the line endings of a rule and parses it.
+func parseRule(rule string, lossless bool) (*Rule, error) {
+	rule = strings.TrimPrefix(rule, byteOrderMark)
+	rule = strings.Replace(rule, "\r\n", "\n", -1)
+	return parseRuleAux(strings.TrimRight(rule, "\r"), false, lossless)
+}
+
+// ParseError describes a rule that could not be parsed by ParseRules.
+type ParseError struct {
+	// File is the name of the file the rule was read from, empty if unknown.
+	File string
+	// Line is the line number of the rule, starting at 1. For a rule on multiple lines, this is
+	// the first line.
+	Line int
+	// Raw is the text of the rule.
+	Raw string
+	// Err is the parse error.
+	Err error
+}
+
+// Error returns a string for a ParseError.
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s: %v", Origin{File: e.File, Line: e.Line}, e.Err)
+}
+
+// Unwrap returns the parse error.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ParseRules parses all rules read from r, one rule per line. Lines ending with a backslash are
+// continued on the next line. Empty lines and comments that are not commented rules are ignored,
+// a commented rule that fails to parse is reported like any other rule.
+// Both Unix (LF) and Windows (CRLF) line endings are supported, as is a leading byte order mark.
+// It returns every rule that was parsed, and a *ParseError for each rule that failed to parse, so
+// a bad rule does not prevent the others from being loaded.
+func ParseRules(r io.Reader) ([]*Rule, []error) {
+	return ParseRulesWithOrigin(r, "")
+}
+
+// ParseRulesWithOrigin parses all rules read from r like ParseRules, recording file as the file
+// name in the Origin of each rule and in each *ParseError.
+func ParseRulesWithOrigin(r io.Reader, file string) ([]*Rule, []error) {
+	return readRules(r, file, nil)
+}
+
+// isCommentedRule returns true if a comment starts like a rule, with an action and a direction
+// (e.g. #alert tcp any any -> any any), so that it is reported if it cannot be parsed.
+func isCommentedRule(s string) bool {
+	f := strings.Fields(strings.TrimLeft(s, "# \t"))
+	return len(f) > 5 && inSlice(f[0], ruleActions) && (f[4] == "->" || f[4] == "<>")
+}
+
+// includeRE matches an include directive, and captures the included path.
+var includeRE = regexp.MustCompile(`^\s*include\s+(.+?)\s*$`)
+
+// readRules parses all rules read from r. If include is not nil, include directives are not
+// parsed as rules, and include is called with the included path and line number of the directive
+// instead.
+func readRules(r io.Reader, file string, include func(path string, line int) ([]*Rule, []error)) ([]*Rule, []error) {
+	var (
+		rules []*Rule
+		errs  []error
+		raw   string
+		first int
+	)
+	br := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			errs = append(errs, &ParseError{File: file, Line: n, Err: err})
+			return rules, errs
+		}
+		if line == "" && err == io.EOF {
+			break
+		}
+		// Windows line endings and a byte order mark at the start of the file are removed, so
+		// that they do not end up in the last field of a rule, or hide a line continuation.
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if n == 1 {
+			line = strings.TrimPrefix(line, byteOrderMark)
+		}
+		if raw == "" {
+			first = n
+		}
+		if strings.HasSuffix(line, `\`) {
+			raw += strings.TrimSuffix(line, `\`)
+			if err == nil {
+				continue
+			}
+		} else {
+			raw += line
+		}
+		s := raw
+		raw = ""
+
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		if m := includeRE.FindStringSubmatch(s); m != nil && include != nil {
+			rs, es := include(strings.Trim(m[1], `"`), first)
+			rules = append(rules, rs...)
+			errs = append(errs, es...)
+			continue
+		}
+		rule, perr := ParseRule(s)
+		if perr != nil {
+			// A comment that is not a rule is not an error.
+			if strings.HasPrefix(strings.TrimSpace(s), "#") {
+				var uerr *UnsupportedOptionError
+				if !errors.As(perr, &uerr) && !isCommentedRule(s) {
+					continue
+				}
+			}
+			errs = append(errs, &ParseError{File: file, Line: first, Raw: s, Err: perr})
+			continue
+		}
+		rule.Origin = &Origin{File: file, Line: first}
+		rules = append(rules, rule)
+	}
+	return rules, errs
+}
+
+// ParseFile parses the rules of the file at path like ParseRules, recording path in the Origin of
+// each rule and in each *ParseError. An error opening or reading the file is returned in the
+// errors.
+func ParseFile(path string) ([]*Rule, []error) {
+	return ParseFileWithOptions(path, FileOptions{})
+}
+
+// FileOptions controls how ParseFileWithOptions reads rules files.
+type FileOptions struct {
+	// FollowIncludes parses the files referenced by "include path" lines, in place of the
+	// directive. Otherwise include lines are parsed as rules, and reported as errors.
+	FollowIncludes bool
+	// BaseDir is the directory relative include paths are resolved from. If empty, they are
+	// resolved from the directory of the including file.
+	BaseDir string
+}
+
+// ParseFileWithOptions parses the rules of the file at path like ParseFile, with the options in
+// opts. Rules of included files are returned in the order of the include directives, with the
+// Origin of the file they were read from. An include cycle, or an included file that cannot be
+// read, is reported as a *ParseError at the line of the directive. A file included several times
+// without a cycle is parsed each time.
+func ParseFileWithOptions(path string, opts FileOptions) ([]*Rule, []error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, []error{err}
+	}
+	defer f.Close()
+	if !opts.FollowIncludes {
+		return ParseRulesWithOrigin(f, path)
+	}
+	p := &fileParser{opts: opts}
+	return p.parse(f, path)
+}
+
+// fileParser parses rules files following include directives.
+type fileParser struct {
+	opts FileOptions
+	// stack holds the resolved paths of the files being parsed, to detect include cycles.
+	stack []string
+}
+
+// resolvePath returns the absolute path of a file with symbolic links resolved, so that include
+// cycles through links are detected. The absolute path is returned if links cannot be resolved.
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if real, err := filepath.EvalSymlinks(abs); err == nil {
+		return real, nil
+	}
+	return abs, nil
+}
+
+// parse parses the rules of file read from r, and the files it includes.
+func (p *fileParser) parse(r io.Reader, file string) ([]*Rule, []error) {
+	abs, err := resolvePath(file)
+	if err != nil {
+		return nil, []error{err}
+	}
+	p.stack = append(p.stack, abs)
+	defer func() { p.stack = p.stack[:len(p.stack)-1] }()
+
+	return readRules(r, file, func(path string, line int) ([]*Rule, []error) {
+		perr := func(err error) []error {
+			return []error{&ParseError{File: file, Line: line, Raw: "include " + path, Err: err}}
+		}
+		if !filepath.IsAbs(path) {
+			base := p.opts.BaseDir
+			if base == "" {
+				base = filepath.Dir(file)
+			}
+			path = filepath.Join(base, path)
+		}
+		abs, err := resolvePath(path)
+		if err != nil {
+			return nil, perr(err)
+		}
+		if inSlice(abs, p.stack) {
+			return nil, perr(fmt.Errorf("include cycle: %s", strings.Join(append(p.stack[indexOf(abs, p.stack):], abs), " -> ")))
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, perr(err)
+		}
+		defer f.Close()
+		return p.parse(f, path)
+	})
+}
+
+// ParseGlob parses the rules of all files matching pattern (e.g. "rules/*.rules"), in lexical
+// order of file names, with ParseFile. It returns the rules of all files, and the errors of all
+// files. An error is returned if pattern is malformed or matches no file.
+func ParseGlob(pattern string) ([]*Rule, []error) {
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, []error{err}
+	}
+	if len(paths) == 0 {
+		return nil, []error{fmt.Errorf("no file matches %s", pattern)}
+	}
+	var (
+		rules []*Rule
+		errs  []error
+	)
+	for _, p := range paths {
+		rs, es := ParseFile(p)
+		rules = append(rules, rs...)
+		errs = append(errs, es...)
+	}
+	return rules, errs
 }
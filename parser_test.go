@@ -16,8 +16,12 @@ limitations under the License.
 package gonids
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/kylelemons/godebug/pretty"
@@ -45,6 +49,41 @@ func TestParseContent(t *testing.T) {
 			input: "A|7C|B",
 			want:  []byte("A|B"),
 		},
+		{
+			name:  "escaped pipe",
+			input: `A\|B|43|`,
+			want:  []byte(`A\|BC`),
+		},
+		{
+			name:  "escaped delimiters",
+			input: `a\;b\"c\\d`,
+			want:  []byte(`a;b"c\d`),
+		},
+		{
+			name:  "other escapes",
+			input: `a\x41\`,
+			want:  []byte(`a\x41\`),
+		},
+		{
+			name:  "not hex",
+			input: "|3z 21|A|42|",
+			want:  []byte("|3z 21|AB"),
+		},
+		{
+			name:  "escaped pipe in pipe section",
+			input: `|41 \| 42|`,
+			want:  []byte(`|41 \| 42|`),
+		},
+		{
+			name:    "unpaired pipe",
+			input:   "A|42 43",
+			wantErr: true,
+		},
+		{
+			name:    "unpaired pipe after hex",
+			input:   "A|42|C|",
+			wantErr: true,
+		},
 	} {
 		got, err := parseContent(tt.input)
 		if !reflect.DeepEqual(got, tt.want) || (err != nil) != tt.wantErr {
@@ -53,6 +92,67 @@ func TestParseContent(t *testing.T) {
 	}
 }
 
+func TestParseContentRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "path traversal",
+			input: "|2F 2E 2E|",
+			want:  "/..",
+		},
+		{
+			name:  "hex adjacent to ascii",
+			input: "GET|20 2F 2E 2E|/etc|2F|passwd",
+			want:  "GET /../etc/passwd",
+		},
+		{
+			name:  "spaces inside pipes",
+			input: "a| 0D0A  00 |b",
+			want:  "a|0D 0A 00|b",
+		},
+		{
+			name:  "space between hex sections",
+			input: "|00| |3B|",
+			want:  "|00 20 3B|",
+		},
+		{
+			name:  "lowercase hex",
+			input: "|3a 7c|x",
+			want:  "|3A 7C|x",
+		},
+		{
+			name:  "not hex",
+			input: "|3z|",
+			want:  "|7C|3z|7C|",
+		},
+		{
+			name:  "escaped pipe in pipe section",
+			input: `|41 \| 42|`,
+			want:  `|7C|41 |5C 7C| 42|7C|`,
+		},
+	} {
+		p, err := parseContent(tt.input)
+		if err != nil {
+			t.Fatalf("%s: parse failed: %v", tt.name, err)
+		}
+		c := &Content{Pattern: p}
+		got := c.FormatPattern()
+		if got != tt.want {
+			t.Fatalf("%s: got %v; expected %v", tt.name, got, tt.want)
+		}
+		again, err := parseContent(got)
+		if err != nil {
+			t.Fatalf("%s: parse of %v failed: %v", tt.name, got, err)
+		}
+		if !reflect.DeepEqual(again, p) {
+			t.Fatalf("%s: pattern changed across a cycle: got %q; expected %q", tt.name, again, p)
+		}
+	}
+}
+
 func TestParseLenMatch(t *testing.T) {
 	for _, tt := range []struct {
 		name    string
@@ -221,12 +321,33 @@ func TestParseByteMatch(t *testing.T) {
 			input: "3,0,Certs.len, relative ,little",
 			kind:  bExtract,
 			want: &ByteMatch{
-				Kind:     bExtract,
-				NumBytes: "3",
-				Variable: "Certs.len",
-				Options:  []string{"relative", "little"},
+				Kind:       bExtract,
+				NumBytes:   "3",
+				Variable:   "Certs.len",
+				Relative:   true,
+				Endianness: "little",
 			},
 		},
+		{
+			name:  "byte_extract with byte_jump options",
+			input: "1,0,len,multiplier 2,align,string,dec",
+			kind:  bExtract,
+			want: &ByteMatch{
+				Kind:       bExtract,
+				NumBytes:   "1",
+				Variable:   "len",
+				Multiplier: 2,
+				Align:      true,
+				StringMode: true,
+				Base:       "dec",
+			},
+		},
+		{
+			name:    "byte_extract with from_beginning",
+			input:   "1,0,len,from_beginning",
+			kind:    bExtract,
+			wantErr: true,
+		},
 		{
 			name:  "basic byte_jump",
 			input: "3,0",
@@ -242,12 +363,58 @@ func TestParseByteMatch(t *testing.T) {
 			input: "3,0, relative, little",
 			kind:  bJump,
 			want: &ByteMatch{
-				Kind:     bJump,
-				NumBytes: "3",
-				Offset:   0,
-				Options:  []string{"relative", "little"},
+				Kind:       bJump,
+				NumBytes:   "3",
+				Offset:     0,
+				Relative:   true,
+				Endianness: "little",
 			},
 		},
+		{
+			name:  "byte_jump with all options",
+			input: "4,-2, relative, multiplier 2, big, string, hex, align, from_end, post_offset 4, dce, bitmask 0x3FF0, foo",
+			kind:  bJump,
+			want: &ByteMatch{
+				Kind:       bJump,
+				NumBytes:   "4",
+				Offset:     -2,
+				Relative:   true,
+				Multiplier: 2,
+				Endianness: "big",
+				StringMode: true,
+				Base:       "hex",
+				Align:      true,
+				FromEnd:    true,
+				PostOffset: 4,
+				DCE:        true,
+				Bitmask:    "0x3FF0",
+				Options:    []string{"foo"},
+			},
+		},
+		{
+			name:    "byte_jump conflicting endianness",
+			input:   "4,0,big,little",
+			kind:    bJump,
+			wantErr: true,
+		},
+		{
+			name:    "byte_jump from_beginning and from_end",
+			input:   "4,0,from_beginning,from_end",
+			kind:    bJump,
+			wantErr: true,
+		},
+		{
+			name:    "byte_jump number type without string",
+			input:   "4,0,dec",
+			kind:    bJump,
+			wantErr: true,
+		},
+		{
+			name:    "byte_jump invalid multiplier",
+			input:   "4,0,multiplier foo",
+			kind:    bJump,
+			wantErr: true,
+		},
 		{
 			name:  "basic byte_test",
 			input: "2,=,0x01,0",
@@ -265,14 +432,47 @@ func TestParseByteMatch(t *testing.T) {
 			input: "4,=,1337,1,relative,string,dec",
 			kind:  bTest,
 			want: &ByteMatch{
-				Kind:     bTest,
-				NumBytes: "4",
-				Operator: "=",
-				Value:    "1337",
-				Offset:   1,
-				Options:  []string{"relative", "string", "dec"},
+				Kind:       bTest,
+				NumBytes:   "4",
+				Operator:   "=",
+				Value:      "1337",
+				Offset:     1,
+				Relative:   true,
+				StringMode: true,
+				Base:       "dec",
 			},
 		},
+		{
+			name:  "byte_test negated with all options",
+			input: "4, !&, 0xF0, 2, relative, little, string, hex, dce, bitmask 0x3FF0",
+			kind:  bTest,
+			want: &ByteMatch{
+				Kind:       bTest,
+				NumBytes:   "4",
+				Negate:     true,
+				Operator:   "&",
+				Value:      "0xF0",
+				Offset:     2,
+				Relative:   true,
+				Endianness: "little",
+				StringMode: true,
+				Base:       "hex",
+				DCE:        true,
+				Bitmask:    "0x3FF0",
+			},
+		},
+		{
+			name:    "byte_test invalid operator",
+			input:   "4,=>,1,0",
+			kind:    bTest,
+			wantErr: true,
+		},
+		{
+			name:    "byte_test with byte_jump option",
+			input:   "4,=,1,0,post_offset 1",
+			kind:    bTest,
+			wantErr: true,
+		},
 		{
 			name:  "isdataat",
 			input: "4",
@@ -355,6 +555,134 @@ func TestParseBase64Decode(t *testing.T) {
 	}
 }
 
+func TestParseByteMath(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		input   string
+		kind    byteMatchType
+		want    *ByteMatch
+		wantErr bool
+	}{
+		{
+			name:  "basic byte_math",
+			input: "bytes 4, offset 0, oper +, rvalue 2, result var",
+			kind:  bMath,
+			want: &ByteMatch{
+				Kind:     bMath,
+				NumBytes: "4",
+				Operator: "+",
+				Value:    "2",
+				Variable: "var",
+			},
+		},
+		{
+			name:  "byte_math with options",
+			input: "bytes 2,offset -3 ,oper <<, rvalue len, result res, relative, endian little, string dec",
+			kind:  bMath,
+			want: &ByteMatch{
+				Kind:     bMath,
+				NumBytes: "2",
+				Offset:   -3,
+				Operator: "<<",
+				Value:    "len",
+				Variable: "res",
+				Options:  []string{"relative", "endian little", "string dec"},
+			},
+		},
+		{
+			name:    "invalid operator",
+			input:   "bytes 4, offset 0, oper %, rvalue 2, result var",
+			kind:    bMath,
+			wantErr: true,
+		},
+		{
+			name:    "missing result",
+			input:   "bytes 4, offset 0, oper +, rvalue 2",
+			kind:    bMath,
+			wantErr: true,
+		},
+		{
+			name:    "repeated option",
+			input:   "bytes 4, offset 0, oper +, rvalue 2, result var, bytes 2",
+			kind:    bMath,
+			wantErr: true,
+		},
+		{
+			name:    "invalid offset",
+			input:   "bytes 4, offset foo, oper +, rvalue 2, result var",
+			kind:    bMath,
+			wantErr: true,
+		},
+		{
+			name:    "wrong kind",
+			input:   "bytes 4, offset 0, oper +, rvalue 2, result var",
+			kind:    bTest,
+			wantErr: true,
+		},
+	} {
+		got, err := parseByteMath(tt.kind, tt.input)
+		diff := pretty.Compare(got, tt.want)
+		if diff != "" || (err != nil) != tt.wantErr {
+			t.Fatal(fmt.Sprintf("%s: gotErr:%#v, wantErr:%#v\n diff (-got +want):\n%s", tt.name, err, tt.wantErr, diff))
+		}
+	}
+}
+
+func TestParsePCRE(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		input   string
+		want    *PCRE
+		wantErr bool
+	}{
+		{
+			name:  "no modifiers",
+			input: "/foo/",
+			want:  &PCRE{Pattern: []byte("foo")},
+		},
+		{
+			name:  "modifiers order is kept",
+			input: "/foo/smRUi",
+			want:  &PCRE{Pattern: []byte("foo"), Options: []byte("smRUi")},
+		},
+		{
+			name:  "capture variables",
+			input: "/([a-z]+)\\/(.+)/GUR, flow:ua/ubuntu/repo,pkt:pkg",
+			want: &PCRE{
+				Pattern: []byte("([a-z]+)\\/(.+)"),
+				Options: []byte("GUR"),
+				Vars:    []string{"flow:ua/ubuntu/repo", "pkt:pkg"},
+			},
+		},
+		{
+			name:    "invalid capture",
+			input:   "/(foo)/i, foo:bar",
+			wantErr: true,
+		},
+		{
+			name:  "unknown modifier",
+			input: "/foo/iL",
+			want:  &PCRE{Pattern: []byte("foo"), Options: []byte("iL")},
+		},
+		{
+			name:    "conflicting buffers",
+			input:   "/foo/UH",
+			wantErr: true,
+		},
+		{
+			name:    "missing slash",
+			input:   "/foo",
+			wantErr: true,
+		},
+	} {
+		got, err := parsePCRE(tt.input)
+		diff := pretty.Compare(got, tt.want)
+		if diff != "" || (err != nil) != tt.wantErr {
+			t.Fatal(fmt.Sprintf("%s: gotErr:%#v, wantErr:%#v\n diff (-got +want):\n%s", tt.name, err, tt.wantErr, diff))
+		}
+	}
+}
+
 func TestParseFlowbit(t *testing.T) {
 	for _, tt := range []struct {
 		name    string
@@ -698,7 +1026,7 @@ func TestParseRule(t *testing.T) {
 				},
 				SID:         1337,
 				Description: "foo",
-				Tags:        map[string]string{"classtype": "foo"},
+				Classtype:   "foo",
 				Matchers: []orderedMatcher{
 					&Content{
 						Pattern: []byte("AA"), Negate: true},
@@ -729,7 +1057,7 @@ func TestParseRule(t *testing.T) {
 						Value:  "CN=*.googleusercontent.com",
 					},
 				},
-				Tags: map[string]string{"classtype": "foo"},
+				Classtype: "foo",
 				Matchers: []orderedMatcher{
 					&Content{
 						Pattern: []byte("AA"), Negate: true},
@@ -885,10 +1213,8 @@ func TestParseRule(t *testing.T) {
 					&Content{
 						Pattern: []byte("AA"),
 						Negate:  true,
-						Options: []*ContentOption{
-							{"http_header", ""},
-							{"offset", "3"},
-						},
+						Options: []*ContentOption{{"http_header", ""}},
+						Offset:  &IntOrVar{Int: 3},
 					},
 				},
 			},
@@ -945,11 +1271,11 @@ func TestParseRule(t *testing.T) {
 				Matchers: []orderedMatcher{
 					&Content{
 						Pattern: []byte("A"),
+						Nocase:  true,
 						Options: []*ContentOption{
 							{"http_header", ""},
-							{"nocase", ""},
 						},
-						FastPattern: FastPattern{Enabled: true, Offset: 0, Length: 42},
+						FastPattern: FastPattern{Enabled: true, Chop: true, Offset: 0, Length: 42},
 					},
 					&Content{
 						Pattern: []byte("B"),
@@ -980,9 +1306,9 @@ func TestParseRule(t *testing.T) {
 					&Content{
 						DataPosition: fileData,
 						Pattern:      []byte("A"),
+						Nocase:       true,
 						Options: []*ContentOption{
 							{"http_header", ""},
-							{"nocase", ""},
 						},
 					},
 					&Content{
@@ -1042,9 +1368,9 @@ func TestParseRule(t *testing.T) {
 					&Content{
 						DataPosition: fileData,
 						Pattern:      []byte("A"),
+						Nocase:       true,
 						Options: []*ContentOption{
 							{"http_header", ""},
-							{"nocase", ""},
 						},
 					},
 					&Content{
@@ -1120,9 +1446,7 @@ func TestParseRule(t *testing.T) {
 					&Content{
 						DataPosition: dnsQuery,
 						Pattern:      []byte("google.com"),
-						Options: []*ContentOption{
-							{"nocase", ""},
-						},
+						Nocase:       true,
 					},
 				},
 			},
@@ -1144,9 +1468,9 @@ func TestParseRule(t *testing.T) {
 				Revision:    6,
 				Description: "VRT BLACKLIST URI request for known malicious URI - /tongji.js",
 				References:  []*Reference{{Type: "url", Value: "labs.snort.org/docs/17904.html"}},
+				Classtype:   "trojan-activity",
 				Tags: map[string]string{
-					"flow":      "to_server,established",
-					"classtype": "trojan-activity",
+					"flow": "to_server,established",
 				},
 				Metas: Metadatas{
 					&Metadata{Key: "impact_flag", Value: "red"},
@@ -1197,9 +1521,9 @@ func TestParseRule(t *testing.T) {
 						Type:  "url",
 						Value: "www.google.com"},
 				},
+				Classtype: "trojan-activity",
 				Tags: map[string]string{
-					"flow":      "to_server,established",
-					"classtype": "trojan-activity",
+					"flow": "to_server,established",
 				},
 				Matchers: []orderedMatcher{
 					&Content{
@@ -1235,7 +1559,8 @@ func TestParseRule(t *testing.T) {
 						Type:  "url",
 						Value: "doc.emergingthreats.net/2009256"},
 				},
-				Tags: map[string]string{"flow": "established", "classtype": "shellcode-detect"},
+				Classtype: "shellcode-detect",
+				Tags:      map[string]string{"flow": "established"},
 				Metas: Metadatas{
 					&Metadata{Key: "created_at", Value: "2010_07_30"},
 					&Metadata{Key: "updated_at", Value: "2010_07_30"},
@@ -1245,10 +1570,8 @@ func TestParseRule(t *testing.T) {
 						Pattern: []byte{0x31, 0xc9, 0xb1, 0xfc, 0x80, 0x73, 0x0c},
 					},
 					&Content{
-						Pattern: []byte{0x43, 0xe2, 0x8b, 0x9f},
-						Options: []*ContentOption{
-							{"distance", "0"},
-						},
+						Pattern:  []byte{0x43, 0xe2, 0x8b, 0x9f},
+						Distance: &IntOrVar{},
 					},
 				},
 			},
@@ -1270,7 +1593,8 @@ func TestParseRule(t *testing.T) {
 				SID:         2025692,
 				Revision:    2,
 				Description: "ET CURRENT_EVENTS Chase Account Phish Landing Oct 22",
-				Tags:        map[string]string{"flow": "established,from_server", "classtype": "trojan-activity"},
+				Classtype:   "trojan-activity",
+				Tags:        map[string]string{"flow": "established,from_server"},
 				Metas: Metadatas{
 					&Metadata{Key: "former_category", Value: "CURRENT_EVENTS"},
 					&Metadata{Key: "created_at", Value: "2015_10_22"},
@@ -1284,27 +1608,21 @@ func TestParseRule(t *testing.T) {
 					&Content{
 						Pattern:      []byte("name=chalbhai"),
 						DataPosition: fileData,
-						Options: []*ContentOption{
-							{"nocase", ""},
-							{"distance", "0"},
-						},
-						FastPattern: FastPattern{Enabled: true},
+						Nocase:       true,
+						Distance:     &IntOrVar{},
+						FastPattern:  FastPattern{Enabled: true},
 					},
 					&Content{
 						Pattern:      []byte{0x72, 0x65, 0x71, 0x75, 0x69, 0x72, 0x65, 0x64, 0x20, 0x74, 0x69, 0x74, 0x6c, 0x65, 0x3d, 0x22, 0x50, 0x6c, 0x65, 0x61, 0x73, 0x65, 0x20, 0x45, 0x6e, 0x74, 0x65, 0x72, 0x20, 0x52, 0x69, 0x67, 0x68, 0x74, 0x20, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x22},
 						DataPosition: fileData,
-						Options: []*ContentOption{
-							{"nocase", ""},
-							{"distance", "0"},
-						},
+						Nocase:       true,
+						Distance:     &IntOrVar{},
 					},
 					&Content{
 						Pattern:      []byte{0x72, 0x65, 0x71, 0x75, 0x69, 0x72, 0x65, 0x64, 0x20, 0x74, 0x69, 0x74, 0x6c, 0x65, 0x3d, 0x22, 0x50, 0x6c, 0x65, 0x61, 0x73, 0x65, 0x20, 0x45, 0x6e, 0x74, 0x65, 0x72, 0x20, 0x52, 0x69, 0x67, 0x68, 0x74, 0x20, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x22},
 						DataPosition: fileData,
-						Options: []*ContentOption{
-							{"nocase", ""},
-							{"distance", "0"},
-						},
+						Nocase:       true,
+						Distance:     &IntOrVar{},
 					},
 				},
 			},
@@ -1382,17 +1700,16 @@ func TestParseRule(t *testing.T) {
 						Pattern: []byte{0xff, 0xfe},
 					},
 					&ByteMatch{
-						Kind:     bExtract,
-						NumBytes: "3",
-						Variable: "Certs.len",
-						Options:  []string{"relative", "little"},
+						Kind:       bExtract,
+						NumBytes:   "3",
+						Variable:   "Certs.len",
+						Relative:   true,
+						Endianness: "little",
 					},
 					&Content{
-						Pattern: []byte{0x55, 0x04, 0x0A, 0x0C, 0x0C},
-						Options: []*ContentOption{
-							{"distance", "3"},
-							{"within", "Certs.len"},
-						},
+						Pattern:  []byte{0x55, 0x04, 0x0A, 0x0C, 0x0C},
+						Distance: &IntOrVar{Int: 3},
+						Within:   &IntOrVar{Var: "Certs.len"},
 					},
 				},
 			},
@@ -1419,12 +1736,13 @@ func TestParseRule(t *testing.T) {
 						Pattern: []byte{0xff, 0xfe},
 					},
 					&ByteMatch{
-						Kind:     bTest,
-						NumBytes: "5",
-						Operator: "<",
-						Value:    "65537",
-						Offset:   0,
-						Options:  []string{"relative", "string"},
+						Kind:       bTest,
+						NumBytes:   "5",
+						Operator:   "<",
+						Value:      "65537",
+						Offset:     0,
+						Relative:   true,
+						StringMode: true,
 					},
 				},
 			},
@@ -1451,10 +1769,12 @@ func TestParseRule(t *testing.T) {
 						Pattern: []byte{0xff, 0xfe},
 					},
 					&ByteMatch{
-						Kind:     bJump,
-						NumBytes: "4",
-						Offset:   0,
-						Options:  []string{"relative", "little", "post_offset -1"},
+						Kind:       bJump,
+						NumBytes:   "4",
+						Offset:     0,
+						Relative:   true,
+						Endianness: "little",
+						PostOffset: -1,
 					},
 				},
 			},
@@ -1479,15 +1799,13 @@ func TestParseRule(t *testing.T) {
 				Matchers: []orderedMatcher{
 					&Content{
 						Pattern: []byte("aabb"),
-						Options: []*ContentOption{
-							{"depth", "4"},
-						},
+						Depth:   &IntOrVar{Int: 4},
 					},
 					&ByteMatch{
-						Kind:     bJump,
-						NumBytes: "2",
-						Offset:   3,
-						Options:  []string{"post_offset -1"},
+						Kind:       bJump,
+						NumBytes:   "2",
+						Offset:     3,
+						PostOffset: -1,
 					},
 					&ByteMatch{
 						Kind:     isDataAt,
@@ -1529,6 +1847,57 @@ func TestParseRule(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "byte_math",
+			rule: `alert tcp $HOME_NET any -> $EXTERNAL_NET any (msg:"byte_math"; content:"|00 01|"; byte_extract:1,0,len,relative; byte_math:bytes 2, offset 1, oper *, rvalue len, result total, relative; byte_test:2,>,total,0,relative; sid:123; rev:1;)`,
+			want: &Rule{
+				Action:   "alert",
+				Protocol: "tcp",
+				Source: Network{
+					Nets:  []string{"$HOME_NET"},
+					Ports: []string{"any"},
+				},
+				Destination: Network{
+					Nets:  []string{"$EXTERNAL_NET"},
+					Ports: []string{"any"},
+				},
+				SID:         123,
+				Revision:    1,
+				Description: "byte_math",
+				Matchers: []orderedMatcher{
+					&Content{
+						Pattern: []byte{0x00, 0x01},
+					},
+					&ByteMatch{
+						Kind:     bExtract,
+						NumBytes: "1",
+						Variable: "len",
+						Relative: true,
+					},
+					&ByteMatch{
+						Kind:     bMath,
+						NumBytes: "2",
+						Offset:   1,
+						Operator: "*",
+						Value:    "len",
+						Variable: "total",
+						Options:  []string{"relative"},
+					},
+					&ByteMatch{
+						Kind:     bTest,
+						NumBytes: "2",
+						Operator: ">",
+						Value:    "total",
+						Relative: true,
+					},
+				},
+			},
+		},
+		{
+			name:    "byte_math undefined variable",
+			rule:    `alert tcp $HOME_NET any -> $EXTERNAL_NET any (msg:"byte_math"; byte_math:bytes 2, offset 1, oper *, rvalue len, result total; sid:123; rev:1;)`,
+			wantErr: true,
+		},
 		{
 			name: "content with backslash at end",
 			rule: `alert http $HOME_NET any -> $EXTERNAL_NET any (msg:"ending backslash rule"; content:"foo\"; sid:12345; rev:2;)`, want: &Rule{
@@ -1599,9 +1968,9 @@ func TestParseRule(t *testing.T) {
 				SID:         1234,
 				Revision:    2,
 				Description: "Flowbits test",
+				Classtype:   "test_page",
 				Tags: map[string]string{
-					"flow":      "to_server,established",
-					"classtype": "test_page",
+					"flow": "to_server,established",
 				},
 				Flowbits: []*Flowbit{
 					{
@@ -1766,7 +2135,7 @@ func TestParseRule(t *testing.T) {
 					&Content{
 						DataPosition: httpURI,
 						Pattern:      []byte("bar"),
-						Options:      []*ContentOption{{"distance", "0"}},
+						Distance:     &IntOrVar{},
 					},
 				},
 			},
@@ -1847,6 +2216,11 @@ func TestParseRule(t *testing.T) {
 			rule:    `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1; content:"foo"; offset:"a";)`,
 			wantErr: true,
 		},
+		{
+			name:    "invalid depth value",
+			rule:    `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1; content:"foo"; depth:1-2;)`,
+			wantErr: true,
+		},
 		{
 			name:    "invalid content value",
 			rule:    `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1; content:!; offset:"a";)`,
@@ -1888,6 +2262,8 @@ func TestParseRule(t *testing.T) {
 						&Content{
 							Pattern: []byte("foo"),
 						},
+						&UnknownOption{Key: "zibzab", Value: "1"},
+						&UnknownOption{Key: "foobar", Value: `"wat"`},
 						&Content{
 							Pattern: []byte("baz"),
 						},
@@ -1898,48 +2274,1250 @@ func TestParseRule(t *testing.T) {
 				Options: []string{"zibzab", "foobar"},
 			},
 		},
-	} {
-		got, err := ParseRule(tt.rule)
-		diff := pretty.Compare(got, tt.want)
-		if diff != "" || (err != nil) != tt.wantErr {
-			t.Fatal(fmt.Sprintf("%s: gotErr:%#v, wantErr:%#v\n diff (-got +want):\n%s", tt.name, err, tt.wantErr, diff))
-		}
-		// Validate UnsupportedOptionError contents.
-		if uerr, ok := err.(*UnsupportedOptionError); ok {
-			diff := pretty.Compare(uerr, tt.optErr)
-			if diff != "" {
-				t.Fatal(fmt.Sprintf("%s: diff (-got +want)\n%s", tt.name, diff))
-			}
-		}
-	}
-}
-
-func TestInSlice(t *testing.T) {
-	for _, tt := range []struct {
-		str  string
-		strs []string
-		want bool
-	}{
-		{
-			str:  "pkt_data",
-			strs: []string{"foo", "bar", "baze"},
-			want: false,
-		},
 		{
-			str:  "pkt_data",
-			strs: []string{"foo", "pkt_data", "baze"},
-			want: true,
-		},
-	} {
-		got := inSlice(tt.str, tt.strs)
-		if got != tt.want {
-			t.Fatalf("got=%v; want=%v", got, tt.want)
-		}
-	}
-}
-
-// Test that parsing a string input and then parsing the stringer output of that struct are identical.
-func TestInEqualOut(t *testing.T) {
+			name: "gid",
+			rule: `alert tcp $HOME_NET any -> $EXTERNAL_NET any (msg:"foo"; content:"bar"; gid:3; sid:123; rev:1;)`,
+			want: &Rule{
+				Action:   "alert",
+				Protocol: "tcp",
+				Source: Network{
+					Nets:  []string{"$HOME_NET"},
+					Ports: []string{"any"},
+				},
+				Destination: Network{
+					Nets:  []string{"$EXTERNAL_NET"},
+					Ports: []string{"any"},
+				},
+				GID:         3,
+				SID:         123,
+				Revision:    1,
+				Description: "foo",
+				Matchers: []orderedMatcher{
+					&Content{
+						Pattern: []byte("bar"),
+					},
+				},
+			},
+		},
+		{
+			name:    "invalid gid",
+			rule:    `alert tcp $HOME_NET any -> $EXTERNAL_NET any (msg:"foo"; gid:0; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name: "noalert",
+			rule: `alert tcp $HOME_NET any -> $EXTERNAL_NET any (msg:"foo"; flowbits:set,foo; noalert; sid:123; rev:1;)`,
+			want: &Rule{
+				Action:   "alert",
+				Protocol: "tcp",
+				Source: Network{
+					Nets:  []string{"$HOME_NET"},
+					Ports: []string{"any"},
+				},
+				Destination: Network{
+					Nets:  []string{"$EXTERNAL_NET"},
+					Ports: []string{"any"},
+				},
+				SID:         123,
+				Revision:    1,
+				Description: "foo",
+				Flowbits:    []*Flowbit{{Action: "set", Value: "foo"}},
+				NoAlert:     true,
+			},
+		},
+		{
+			name: "transforms",
+			rule: `alert dns $HOME_NET any -> any any (msg:"foo"; dns.query; dotprefix; to_lowercase; content:".example.com"; endswith; sid:123; rev:1;)`,
+			want: &Rule{
+				Action:   "alert",
+				Protocol: "dns",
+				Source: Network{
+					Nets:  []string{"$HOME_NET"},
+					Ports: []string{"any"},
+				},
+				Destination: Network{
+					Nets:  []string{"any"},
+					Ports: []string{"any"},
+				},
+				SID:         123,
+				Revision:    1,
+				Description: "foo",
+				Matchers: []orderedMatcher{
+					&Transform{DataPosition: dnsQuery5, Name: "dotprefix"},
+					&Transform{DataPosition: dnsQuery5, Name: "to_lowercase"},
+					&Content{
+						DataPosition: dnsQuery5,
+						Pattern:      []byte(".example.com"),
+						Options:      []*ContentOption{{Name: "endswith"}},
+					},
+				},
+			},
+		},
+		{
+			name: "repeated tags",
+			rule: `alert tcp $HOME_NET any -> any any (msg:"foo"; app-layer-protocol:!http; classtype:misc-activity; app-layer-protocol:!tls; sid:123; rev:1;)`,
+			want: &Rule{
+				Action:   "alert",
+				Protocol: "tcp",
+				Source: Network{
+					Nets:  []string{"$HOME_NET"},
+					Ports: []string{"any"},
+				},
+				Destination: Network{
+					Nets:  []string{"any"},
+					Ports: []string{"any"},
+				},
+				SID:         123,
+				Revision:    1,
+				Description: "foo",
+				Classtype:   "misc-activity",
+				MultiTags:   map[string][]string{"app-layer-protocol": {"!http", "!tls"}},
+			},
+		},
+		{
+			name: "lua",
+			rule: `alert http $HOME_NET any -> any any (msg:"foo"; lua:!scripts/check-1.lua; luajit:fast_check.lua; sid:123; rev:1;)`,
+			want: &Rule{
+				Action:   "alert",
+				Protocol: "http",
+				Source: Network{
+					Nets:  []string{"$HOME_NET"},
+					Ports: []string{"any"},
+				},
+				Destination: Network{
+					Nets:  []string{"any"},
+					Ports: []string{"any"},
+				},
+				SID:         123,
+				Revision:    1,
+				Description: "foo",
+				Lua: []*Lua{
+					{Keyword: "lua", Negate: true, Script: "scripts/check-1.lua"},
+					{Keyword: "luajit", Script: "fast_check.lua"},
+				},
+			},
+		},
+		{
+			name:    "invalid lua script",
+			rule:    `alert http $HOME_NET any -> any any (msg:"foo"; lua:../../etc/passwd; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name:    "lua without script",
+			rule:    `alert http $HOME_NET any -> any any (msg:"foo"; lua; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name: "file keywords",
+			rule: `alert http $HOME_NET any -> any any (msg:"foo"; filemagic:"PDF document"; filename:!"evil.exe"; fileext:"pdf"; filesize:>100; filestore:to_client,file; sid:123; rev:1;)`,
+			want: &Rule{
+				Action:   "alert",
+				Protocol: "http",
+				Source: Network{
+					Nets:  []string{"$HOME_NET"},
+					Ports: []string{"any"},
+				},
+				Destination: Network{
+					Nets:  []string{"any"},
+					Ports: []string{"any"},
+				},
+				SID:         123,
+				Revision:    1,
+				Description: "foo",
+				Matchers: []orderedMatcher{
+					&LenMatch{
+						Kind:     fileSize,
+						Num:      100,
+						Operator: ">",
+					},
+				},
+				FileMatches: []*FileMatch{
+					{Keyword: "filemagic", Value: "PDF document"},
+					{Keyword: "filename", Negate: true, Value: "evil.exe"},
+					{Keyword: "fileext", Value: "pdf"},
+				},
+				Filestore: &Filestore{Direction: "to_client", Scope: "file"},
+			},
+		},
+		{
+			name: "file match nocase",
+			rule: `alert http $HOME_NET any -> any any (msg:"foo"; content:"abc"; filemagic:"PDF"; nocase; fileext:"pdf"; sid:123; rev:1;)`,
+			want: &Rule{
+				Action:   "alert",
+				Protocol: "http",
+				Source: Network{
+					Nets:  []string{"$HOME_NET"},
+					Ports: []string{"any"},
+				},
+				Destination: Network{
+					Nets:  []string{"any"},
+					Ports: []string{"any"},
+				},
+				SID:         123,
+				Revision:    1,
+				Description: "foo",
+				Matchers: []orderedMatcher{
+					&Content{
+						Pattern: []byte("abc"),
+					},
+				},
+				FileMatches: []*FileMatch{
+					{Keyword: "filemagic", Value: "PDF", Nocase: true},
+					{Keyword: "fileext", Value: "pdf"},
+				},
+			},
+		},
+		{
+			name: "file match nocase without content",
+			rule: `alert http $HOME_NET any -> any any (msg:"foo"; filename:"evil.exe"; nocase; sid:123; rev:1;)`,
+			want: &Rule{
+				Action:   "alert",
+				Protocol: "http",
+				Source: Network{
+					Nets:  []string{"$HOME_NET"},
+					Ports: []string{"any"},
+				},
+				Destination: Network{
+					Nets:  []string{"any"},
+					Ports: []string{"any"},
+				},
+				SID:         123,
+				Revision:    1,
+				Description: "foo",
+				FileMatches: []*FileMatch{
+					{Keyword: "filename", Value: "evil.exe", Nocase: true},
+				},
+			},
+		},
+		{
+			name: "filestore without options",
+			rule: `alert http $HOME_NET any -> any any (msg:"foo"; filestore; sid:123; rev:1;)`,
+			want: &Rule{
+				Action:   "alert",
+				Protocol: "http",
+				Source: Network{
+					Nets:  []string{"$HOME_NET"},
+					Ports: []string{"any"},
+				},
+				Destination: Network{
+					Nets:  []string{"any"},
+					Ports: []string{"any"},
+				},
+				SID:         123,
+				Revision:    1,
+				Description: "foo",
+				Filestore:   &Filestore{},
+			},
+		},
+		{
+			name: "dns keywords",
+			rule: `alert dns $HOME_NET any -> any any (msg:"foo"; dns.opcode:!4; dns.rcode:>2; dns.query.name; content:"evil"; dns.answer.name; dotprefix; content:".example.com"; sid:123; rev:1;)`,
+			want: &Rule{
+				Action:   "alert",
+				Protocol: "dns",
+				Source: Network{
+					Nets:  []string{"$HOME_NET"},
+					Ports: []string{"any"},
+				},
+				Destination: Network{
+					Nets:  []string{"any"},
+					Ports: []string{"any"},
+				},
+				SID:         123,
+				Revision:    1,
+				Description: "foo",
+				Matchers: []orderedMatcher{
+					&LenMatch{
+						Kind:   dnsOpcode,
+						Num:    4,
+						Negate: true,
+					},
+					&LenMatch{
+						Kind:     dnsRcode,
+						Num:      2,
+						Operator: ">",
+					},
+					&Content{
+						DataPosition: dnsQueryName,
+						Pattern:      []byte("evil"),
+					},
+					&Transform{
+						DataPosition: dnsAnswerName,
+						Name:         "dotprefix",
+					},
+					&Content{
+						DataPosition: dnsAnswerName,
+						Pattern:      []byte(".example.com"),
+					},
+				},
+			},
+		},
+		{
+			name: "sameip and ip_proto",
+			rule: `alert ip $HOME_NET any -> any any (msg:"foo"; sameip; ip_proto:!6; ip_proto:gre; ip_proto:<10; ip_proto:> 2; sid:123; rev:1;)`,
+			want: &Rule{
+				Action:   "alert",
+				Protocol: "ip",
+				Source: Network{
+					Nets:  []string{"$HOME_NET"},
+					Ports: []string{"any"},
+				},
+				Destination: Network{
+					Nets:  []string{"any"},
+					Ports: []string{"any"},
+				},
+				SID:         123,
+				Revision:    1,
+				Description: "foo",
+				SameIP:      true,
+				IPProto: []*IPProto{
+					{Proto: "6", Negate: true},
+					{Proto: "gre"},
+					{Proto: "10", Operator: "<"},
+					{Proto: "2", Operator: ">"},
+				},
+			},
+		},
+		{
+			name: "geoip",
+			rule: `alert ip $HOME_NET any -> any any (msg:"foo"; geoip:src, CN,ru; sid:123; rev:1;)`,
+			want: &Rule{
+				Action:   "alert",
+				Protocol: "ip",
+				Source: Network{
+					Nets:  []string{"$HOME_NET"},
+					Ports: []string{"any"},
+				},
+				Destination: Network{
+					Nets:  []string{"any"},
+					Ports: []string{"any"},
+				},
+				SID:         123,
+				Revision:    1,
+				Description: "foo",
+				GeoIP:       &GeoIP{Direction: "src", Countries: []string{"CN", "ru"}},
+			},
+		},
+		{
+			name: "prefilter",
+			rule: `alert tcp $HOME_NET any -> any any (msg:"foo"; dsize:>10; prefilter; content:"abc"; nocase; sid:123; rev:1;)`,
+			want: &Rule{
+				Action:   "alert",
+				Protocol: "tcp",
+				Source: Network{
+					Nets:  []string{"$HOME_NET"},
+					Ports: []string{"any"},
+				},
+				Destination: Network{
+					Nets:  []string{"any"},
+					Ports: []string{"any"},
+				},
+				SID:         123,
+				Revision:    1,
+				Description: "foo",
+				Matchers: []orderedMatcher{
+					&LenMatch{Kind: dSize, Num: 10, Operator: ">", Prefilter: true},
+					&Content{
+						Pattern: []byte("abc"),
+						Nocase:  true,
+					},
+				},
+			},
+		},
+		{
+			name: "prefilter on tag",
+			rule: `alert tcp $HOME_NET any -> any any (msg:"foo"; flags:S; prefilter; sid:123; rev:1;)`,
+			want: &Rule{
+				Action:   "alert",
+				Protocol: "tcp",
+				Source: Network{
+					Nets:  []string{"$HOME_NET"},
+					Ports: []string{"any"},
+				},
+				Destination: Network{
+					Nets:  []string{"any"},
+					Ports: []string{"any"},
+				},
+				SID:          123,
+				Revision:     1,
+				Description:  "foo",
+				Tags:         map[string]string{"flags": "S"},
+				PrefilterTag: "flags",
+			},
+		},
+		{
+			name: "prefilter on content",
+			rule: `alert tcp $HOME_NET any -> any any (msg:"foo"; content:"abc"; depth:10; prefilter; sid:123; rev:1;)`,
+			want: &Rule{
+				Action:   "alert",
+				Protocol: "tcp",
+				Source: Network{
+					Nets:  []string{"$HOME_NET"},
+					Ports: []string{"any"},
+				},
+				Destination: Network{
+					Nets:  []string{"any"},
+					Ports: []string{"any"},
+				},
+				SID:         123,
+				Revision:    1,
+				Description: "foo",
+				Matchers: []orderedMatcher{
+					&Content{
+						Pattern:   []byte("abc"),
+						Depth:     &IntOrVar{Int: 10},
+						Prefilter: true,
+					},
+				},
+			},
+		},
+		{
+			name: "typed tags",
+			rule: `alert ip $HOME_NET any -> any any (msg:"foo"; classtype:trojan-activity; priority: 2; target:dest_ip; threshold:type limit, track by_src, count 1, seconds 60; sid:123; rev:1;)`,
+			want: &Rule{
+				Action:   "alert",
+				Protocol: "ip",
+				Source: Network{
+					Nets:  []string{"$HOME_NET"},
+					Ports: []string{"any"},
+				},
+				Destination: Network{
+					Nets:  []string{"any"},
+					Ports: []string{"any"},
+				},
+				SID:         123,
+				Revision:    1,
+				Description: "foo",
+				Classtype:   "trojan-activity",
+				Priority:    2,
+				Target:      "dest_ip",
+				Tags:        map[string]string{"threshold": "type limit, track by_src, count 1, seconds 60"},
+			},
+		},
+		{
+			name: "iprep",
+			rule: `alert ip $HOME_NET any -> any any (msg:"foo"; iprep:src,CnC,>,30; iprep: dst , Bad , = , 0 ; sid:123; rev:1;)`,
+			want: &Rule{
+				Action:   "alert",
+				Protocol: "ip",
+				Source: Network{
+					Nets:  []string{"$HOME_NET"},
+					Ports: []string{"any"},
+				},
+				Destination: Network{
+					Nets:  []string{"any"},
+					Ports: []string{"any"},
+				},
+				SID:         123,
+				Revision:    1,
+				Description: "foo",
+				IPRep: []*IPRep{
+					{Direction: "src", Category: "CnC", Operator: ">", Value: 30},
+					{Direction: "dst", Category: "Bad", Operator: "=", Value: 0},
+				},
+			},
+		},
+		{
+			name: "escaped content delimiters",
+			rule: `alert tcp $HOME_NET any -> any any (msg:"foo"; content:"a\;b\"c"; pcre:"/x\;y\"z/"; sid:123; rev:1;)`,
+			want: &Rule{
+				Action:   "alert",
+				Protocol: "tcp",
+				Source: Network{
+					Nets:  []string{"$HOME_NET"},
+					Ports: []string{"any"},
+				},
+				Destination: Network{
+					Nets:  []string{"any"},
+					Ports: []string{"any"},
+				},
+				SID:         123,
+				Revision:    1,
+				Description: "foo",
+				Matchers: []orderedMatcher{
+					&Content{
+						Pattern: []byte(`a;b"c`),
+					},
+					&PCRE{
+						Pattern: []byte(`x\;y"z`),
+					},
+				},
+			},
+		},
+		{
+			name: "dcerpc keywords",
+			rule: `alert smb $HOME_NET any -> any any (msg:"foo"; dce_iface:4b324fc8-1670-01d3-1278-5a47bf6ee188,<2,any_frag; dce_opnum:15, 18-20; dce_stub_data; content:"|00 01|"; sid:123; rev:1;)`,
+			want: &Rule{
+				Action:   "alert",
+				Protocol: "smb",
+				Source: Network{
+					Nets:  []string{"$HOME_NET"},
+					Ports: []string{"any"},
+				},
+				Destination: Network{
+					Nets:  []string{"any"},
+					Ports: []string{"any"},
+				},
+				SID:         123,
+				Revision:    1,
+				Description: "foo",
+				Matchers: []orderedMatcher{
+					&Content{
+						DataPosition: dceStubData,
+						Pattern:      []byte{0x00, 0x01},
+					},
+				},
+				DCEIface: &DCEIface{
+					UUID:     "4b324fc8-1670-01d3-1278-5a47bf6ee188",
+					Operator: "<",
+					Version:  2,
+					AnyFrag:  true,
+				},
+				DCEOpnums: []*OpnumRange{{Min: 15, Max: 15}, {Min: 18, Max: 20}},
+			},
+		},
+		{
+			name:    "invalid dce_iface uuid",
+			rule:    `alert smb $HOME_NET any -> any any (msg:"foo"; dce_iface:4b324fc8-1670-01d3-1278; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid dce_opnum range",
+			rule:    `alert smb $HOME_NET any -> any any (msg:"foo"; dce_opnum:20-18; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid geoip direction",
+			rule:    `alert ip $HOME_NET any -> any any (msg:"foo"; geoip:source,CN; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid geoip country",
+			rule:    `alert ip $HOME_NET any -> any any (msg:"foo"; geoip:both,CHN; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name:    "geoip without country",
+			rule:    `alert ip $HOME_NET any -> any any (msg:"foo"; geoip:any; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name:    "repeated prefilter",
+			rule:    `alert tcp $HOME_NET any -> any any (msg:"foo"; dsize:>10; prefilter; ttl:1; prefilter; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name:    "unsupported prefilter",
+			rule:    `alert tcp $HOME_NET any -> any any (msg:"foo"; classtype:foo; prefilter; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name:    "prefilter on negated content",
+			rule:    `alert tcp $HOME_NET any -> any any (msg:"foo"; content:!"abc"; prefilter; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name:    "prefilter with fast_pattern",
+			rule:    `alert tcp $HOME_NET any -> any any (msg:"foo"; content:"a"; fast_pattern; content:"b"; prefilter; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name:    "duplicate content position",
+			rule:    `alert tcp $HOME_NET any -> any any (msg:"foo"; content:"abcd"; depth:4; depth:8; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name:    "fast_pattern after prefilter",
+			rule:    `alert tcp $HOME_NET any -> any any (msg:"foo"; content:"abcd"; prefilter; fast_pattern; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name:    "prefilter on content and length match",
+			rule:    `alert tcp $HOME_NET any -> any any (msg:"foo"; content:"abc"; prefilter; dsize:>10; prefilter; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name:    "prefilter first",
+			rule:    `alert tcp $HOME_NET any -> any any (prefilter; msg:"foo"; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid priority",
+			rule:    `alert ip $HOME_NET any -> any any (msg:"foo"; priority:high; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid target",
+			rule:    `alert ip $HOME_NET any -> any any (msg:"foo"; target:src; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name:    "duplicate classtype",
+			rule:    `alert ip $HOME_NET any -> any any (msg:"foo"; classtype:foo; classtype:bar; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid iprep direction",
+			rule:    `alert ip $HOME_NET any -> any any (msg:"foo"; iprep:source,CnC,>,30; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid iprep operator",
+			rule:    `alert ip $HOME_NET any -> any any (msg:"foo"; iprep:src,CnC,>=,30; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name:    "iprep value out of range",
+			rule:    `alert ip $HOME_NET any -> any any (msg:"foo"; iprep:src,CnC,>,128; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name:    "iprep missing value",
+			rule:    `alert ip $HOME_NET any -> any any (msg:"foo"; iprep:src,CnC,>; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name:    "ip_proto out of range",
+			rule:    `alert ip $HOME_NET any -> any any (msg:"foo"; ip_proto:256; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name:    "ip_proto comparison on a name",
+			rule:    `alert ip $HOME_NET any -> any any (msg:"foo"; ip_proto:<tcp; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown ip_proto name",
+			rule:    `alert ip $HOME_NET any -> any any (msg:"foo"; ip_proto:foo; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid filestore direction",
+			rule:    `alert http $HOME_NET any -> any any (msg:"foo"; filestore:sideways; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid filestore scope",
+			rule:    `alert http $HOME_NET any -> any any (msg:"foo"; filestore:both,forever; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name:    "filemagic without value",
+			rule:    `alert http $HOME_NET any -> any any (msg:"foo"; filemagic; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name:    "unsupported file match modifier",
+			rule:    `alert http $HOME_NET any -> any any (msg:"foo"; content:"abc"; filename:"evil"; depth:4; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name: "duplicate flow",
+			rule: `alert tcp $HOME_NET any -> any any (msg:"foo"; flow:established; flow:to_server; sid:123; rev:1;)`,
+			want: &Rule{
+				Action:   "alert",
+				Protocol: "tcp",
+				Source: Network{
+					Nets:  []string{"$HOME_NET"},
+					Ports: []string{"any"},
+				},
+				Destination: Network{
+					Nets:  []string{"any"},
+					Ports: []string{"any"},
+				},
+				SID:         123,
+				Revision:    1,
+				Description: "foo",
+				Tags:        map[string]string{"flow": "established"},
+				MultiTags:   map[string][]string{"flow": {"to_server"}},
+			},
+		},
+		{
+			name:    "duplicate tag",
+			rule:    `alert tcp $HOME_NET any -> any any (msg:"foo"; classtype:misc-activity; classtype:trojan-activity; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name:    "transform missing value",
+			rule:    `alert tcp $HOME_NET any -> any any (msg:"foo"; http.uri; xor; content:"foo"; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name:    "transform unexpected value",
+			rule:    `alert tcp $HOME_NET any -> any any (msg:"foo"; http.uri; to_lowercase:1; content:"foo"; sid:123; rev:1;)`,
+			wantErr: true,
+		},
+		{
+			name: "uricontent",
+			rule: `alert tcp $HOME_NET any -> $EXTERNAL_NET $HTTP_PORTS (msg:"foo"; uricontent:!"/index.php"; nocase; content:"bar"; sid:123; rev:1;)`,
+			want: &Rule{
+				Action:   "alert",
+				Protocol: "tcp",
+				Source: Network{
+					Nets:  []string{"$HOME_NET"},
+					Ports: []string{"any"},
+				},
+				Destination: Network{
+					Nets:  []string{"$EXTERNAL_NET"},
+					Ports: []string{"$HTTP_PORTS"},
+				},
+				SID:         123,
+				Revision:    1,
+				Description: "foo",
+				Matchers: []orderedMatcher{
+					&Content{
+						DataPosition: httpURI,
+						Pattern:      []byte("/index.php"),
+						Negate:       true,
+						Nocase:       true,
+					},
+					&Content{
+						Pattern: []byte("bar"),
+					},
+				},
+			},
+		},
+		{
+			name: "urilen",
+			rule: `alert tcp $HOME_NET any -> $EXTERNAL_NET $HTTP_PORTS (msg:"foo"; urilen:<20,norm; uricontent:"/a"; sid:123; rev:1;)`,
+			want: &Rule{
+				Action:   "alert",
+				Protocol: "tcp",
+				Source: Network{
+					Nets:  []string{"$HOME_NET"},
+					Ports: []string{"any"},
+				},
+				Destination: Network{
+					Nets:  []string{"$EXTERNAL_NET"},
+					Ports: []string{"$HTTP_PORTS"},
+				},
+				SID:         123,
+				Revision:    1,
+				Description: "foo",
+				Matchers: []orderedMatcher{
+					&LenMatch{
+						Kind:     uriLen,
+						Operator: "<",
+						Num:      20,
+						Options:  []string{"norm"},
+					},
+					&Content{
+						DataPosition: httpURI,
+						Pattern:      []byte("/a"),
+					},
+				},
+			},
+		},
+		{
+			name: "windows line endings",
+			rule: "alert tcp $HOME_NET any -> $EXTERNAL_NET any (msg:\"crlf\"\r\n; content:\"foo\";\r\n sid:1; rev:1;)\r\n",
+			want: &Rule{
+				Action:   "alert",
+				Protocol: "tcp",
+				Source: Network{
+					Nets:  []string{"$HOME_NET"},
+					Ports: []string{"any"},
+				},
+				Destination: Network{
+					Nets:  []string{"$EXTERNAL_NET"},
+					Ports: []string{"any"},
+				},
+				SID:         1,
+				Revision:    1,
+				Description: "crlf",
+				Matchers: []orderedMatcher{
+					&Content{
+						Pattern: []byte("foo"),
+					},
+				},
+			},
+		},
+		{
+			name: "byte order mark",
+			rule: "\ufeffalert tcp $HOME_NET any -> $EXTERNAL_NET any (msg:\"bom\"; content:\"foo\"; sid:1; rev:1;)\r\n",
+			want: &Rule{
+				Action:   "alert",
+				Protocol: "tcp",
+				Source: Network{
+					Nets:  []string{"$HOME_NET"},
+					Ports: []string{"any"},
+				},
+				Destination: Network{
+					Nets:  []string{"$EXTERNAL_NET"},
+					Ports: []string{"any"},
+				},
+				SID:         1,
+				Revision:    1,
+				Description: "bom",
+				Matchers: []orderedMatcher{
+					&Content{
+						Pattern: []byte("foo"),
+					},
+				},
+			},
+		},
+		{
+			name: "byte order mark disabled rule",
+			rule: "\ufeff#alert tcp $HOME_NET any -> $EXTERNAL_NET any (msg:\"bom\"; sid:1; rev:1;)\r\n",
+			want: &Rule{
+				Disabled: true,
+				Action:   "alert",
+				Protocol: "tcp",
+				Source: Network{
+					Nets:  []string{"$HOME_NET"},
+					Ports: []string{"any"},
+				},
+				Destination: Network{
+					Nets:  []string{"$EXTERNAL_NET"},
+					Ports: []string{"any"},
+				},
+				SID:         1,
+				Revision:    1,
+				Description: "bom",
+			},
+		},
+	} {
+		got, err := ParseRule(tt.rule)
+		diff := pretty.Compare(got, tt.want)
+		if diff != "" || (err != nil) != tt.wantErr {
+			t.Fatal(fmt.Sprintf("%s: gotErr:%#v, wantErr:%#v\n diff (-got +want):\n%s", tt.name, err, tt.wantErr, diff))
+		}
+		// Validate UnsupportedOptionError contents.
+		if uerr, ok := err.(*UnsupportedOptionError); ok {
+			diff := pretty.Compare(uerr, tt.optErr)
+			if diff != "" {
+				t.Fatal(fmt.Sprintf("%s: diff (-got +want)\n%s", tt.name, diff))
+			}
+			// The partial rule keeps the unsupported options, and round-trips.
+			if got := uerr.Rule.String(); got != tt.rule {
+				t.Fatalf("%s: got %v; expected %v", tt.name, got, tt.rule)
+			}
+		}
+	}
+}
+
+func TestParseByteExtractContentOptions(t *testing.T) {
+	rule := `alert tcp any any -> any any (msg:"foo"; byte_extract:2,0,len,relative,little; content:"abc"; offset:2; depth:len; content:"def"; distance:0; within:len; sid:1; rev:1;)`
+	r, err := ParseRule(rule)
+	if err != nil {
+		t.Fatalf("parse rule failed: %v", err)
+	}
+	if got := r.String(); got != rule {
+		t.Fatalf("got %v; expected %v", got, rule)
+	}
+	want := []orderedMatcher{
+		&ByteMatch{Kind: bExtract, NumBytes: "2", Variable: "len", Relative: true, Endianness: "little"},
+		&Content{Pattern: []byte("abc"), Offset: &IntOrVar{Int: 2}, Depth: &IntOrVar{Var: "len"}},
+		&Content{Pattern: []byte("def"), Distance: &IntOrVar{}, Within: &IntOrVar{Var: "len"}},
+	}
+	diff := pretty.Compare(r.Matchers, want)
+	if diff != "" {
+		t.Fatal(fmt.Sprintf("diff (-got +want):\n%s", diff))
+	}
+	if !usesVar(r.Matchers[1], "len") || usesVar(r.Matchers[1], "abc") {
+		t.Fatalf("usesVar: unexpected result for %v", r.Matchers[1])
+	}
+}
+
+func TestParseRuleLossless(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		rule    string
+		unknown []string
+	}{
+		{
+			name: "supported options",
+			rule: `alert tcp any any -> any any (msg:"foo"; content:"foo"; sid:1; rev:1;)`,
+		},
+		{
+			name:    "unsupported options",
+			rule:    `alert http $HOME_NET any -> $EXTERNAL_NET any (msg:"foo"; content:"foo"; zibzab:1; foobar:"wat"; content:"baz"; nowat; sid:4321; rev:1;)`,
+			unknown: []string{"zibzab", "foobar", "nowat"},
+		},
+		{
+			name:    "unsupported sticky buffer",
+			rule:    `alert http any any -> any any (msg:"foo"; http.uri; content:"/a"; http.request_header; content:"b"; negated:!"c", 1; sid:1; rev:1;)`,
+			unknown: []string{"http.request_header", "negated"},
+		},
+		{
+			name:    "quoted values",
+			rule:    `alert tcp any any -> any any (msg:"foo"; one:"a\"b"; two:"a", "b"; sid:1; rev:1;)`,
+			unknown: []string{"one", "two"},
+		},
+		{
+			name:    "disabled",
+			rule:    `#alert tcp any any -> any any (msg:"foo"; content:"foo"; zibzab:1; sid:1; rev:1;)`,
+			unknown: []string{"zibzab"},
+		},
+	} {
+		r, err := ParseRuleLossless(tt.rule)
+		if err != nil {
+			t.Fatalf("%s: parse rule failed: %v", tt.name, err)
+		}
+		if r.Raw != tt.rule {
+			t.Fatalf("%s: got raw %v; expected %v", tt.name, r.Raw, tt.rule)
+		}
+		if got := r.String(); got != tt.rule {
+			t.Fatalf("%s: got %v; expected %v", tt.name, got, tt.rule)
+		}
+		var unknown []string
+		for _, u := range r.UnknownOptions() {
+			unknown = append(unknown, u.Key)
+		}
+		if diff := pretty.Compare(unknown, tt.unknown); diff != "" {
+			t.Fatal(fmt.Sprintf("%s: diff (-got +want):\n%s", tt.name, diff))
+		}
+	}
+}
+
+func TestParseRuleLosslessRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		rule string
+	}{
+		{
+			name: "quoted value",
+			rule: `alert tcp any any -> any any (msg:"foo"; foo:"a b"; sid:1; rev:1;)`,
+		},
+		{
+			name: "embedded semicolon",
+			rule: `alert tcp any any -> any any (msg:"foo"; foo:"a;b"; bar:"c\;d"; sid:1; rev:1;)`,
+		},
+		{
+			name: "mixed list",
+			rule: `alert tcp any any -> any any (msg:"foo"; newkw:a, b, "c d"; other:"e", 1, "f;g"; sid:1; rev:1;)`,
+		},
+		{
+			name: "negated quoted value",
+			rule: `alert tcp any any -> any any (msg:"foo"; foo:!"a;b"; sid:1; rev:1;)`,
+		},
+	} {
+		r, err := ParseRuleLossless(tt.rule)
+		if err != nil {
+			t.Fatalf("%s: parse rule failed: %v", tt.name, err)
+		}
+		if got := r.String(); got != tt.rule {
+			t.Fatalf("%s: got %v; expected %v", tt.name, got, tt.rule)
+		}
+		got, err := ParseRuleLossless(r.String())
+		if err != nil {
+			t.Fatalf("%s: parse written rule failed: %v", tt.name, err)
+		}
+		got.Raw = r.Raw
+		if diff := pretty.Compare(got, r); diff != "" {
+			t.Fatal(fmt.Sprintf("%s: diff (-got +want):\n%s", tt.name, diff))
+		}
+	}
+}
+
+func TestUnsupportedOptionErrorRule(t *testing.T) {
+	for _, tt := range []string{
+		`alert tcp any any -> any any (msg:"foo"; content:"foo"; zibzab:1; sid:1; rev:1;)`,
+		`alert tcp any any -> any any (msg:"foo"; newkw:a, b, "c d"; content:"foo"; foo:"a;b"; sid:1; rev:1;)`,
+		`alert tcp any any -> any any (msg:"foo"; one:"a\"b"; two:!"a", "b"; sid:1; rev:1;)`,
+	} {
+		_, err := ParseRule(tt)
+		uerr, ok := err.(*UnsupportedOptionError)
+		if !ok {
+			t.Fatalf("%s: got err %v; expected UnsupportedOptionError", tt, err)
+		}
+		if got := uerr.Rule.String(); got != tt {
+			t.Fatalf("got %v; expected %v", got, tt)
+		}
+		_, err = ParseRule(uerr.Rule.String())
+		reerr, ok := err.(*UnsupportedOptionError)
+		if !ok {
+			t.Fatalf("%s: reparse got err %v; expected UnsupportedOptionError", tt, err)
+		}
+		if diff := pretty.Compare(reerr, uerr); diff != "" {
+			t.Fatal(fmt.Sprintf("%s: diff (-got +want):\n%s", tt, diff))
+		}
+	}
+}
+
+func TestParseDisabledRule(t *testing.T) {
+	const want = `#alert tcp any any -> any any (msg:"foo"; content:"#bar"; sid:1; rev:1;)`
+	for _, tt := range []string{
+		`#alert tcp any any -> any any (msg:"foo"; content:"#bar"; sid:1; rev:1;)`,
+		`# alert tcp any any -> any any (msg:"foo"; content:"#bar"; sid:1; rev:1;)`,
+		`## alert tcp any any -> any any (msg:"foo"; content:"#bar"; sid:1; rev:1;)`,
+		`  # #  alert tcp any any -> any any (msg:"foo"; content:"#bar"; sid:1; rev:1;)`,
+		"\t#\talert tcp any any -> any any (msg:\"foo\"; content:\"#bar\"; sid:1; rev:1;)",
+	} {
+		r, err := ParseRule(tt)
+		if err != nil {
+			t.Fatalf("%q: parse rule failed: %v", tt, err)
+		}
+		if !r.Disabled {
+			t.Fatalf("%q: rule is not disabled", tt)
+		}
+		if got := r.String(); got != want {
+			t.Fatalf("%q: got %v; expected %v", tt, got, want)
+		}
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	input := `# Test rules
+
+alert tcp any any -> any any (msg:"first"; sid:1; rev:1;)
+alert tcp any any -> any any (msg:"bad sid"; sid:foo; rev:1;)
+#alert tcp any any -> any any (msg:"disabled"; sid:2; rev:1;)
+alert tcp any any -> any any (msg:"multi line"; \
+    content:"foo"; sid:3; rev:1;)
+alert tcp any any -> any any (msg:"unsupported"; foo:bar; sid:4; rev:1;)
+#alert tcp any any -> any any (msg:"bad disabled"; sid:bar; rev:1;)
+# alert on any traffic, see below
+alert tcp any any -> any any (msg:"last"; sid:5; rev:1;)
+# alert tcp any any -> any any (msg:"unsupported disabled"; foo_kw:1; sid:6; rev:1;)`
+	rules, errs := ParseRules(strings.NewReader(input))
+	var sids []int
+	for _, r := range rules {
+		sids = append(sids, r.SID)
+	}
+	if diff := pretty.Compare(sids, []int{1, 2, 3, 5}); diff != "" {
+		t.Fatal(fmt.Sprintf("diff (-got +want):\n%s", diff))
+	}
+	if !rules[1].Disabled {
+		t.Fatalf("rule %d is not disabled", rules[1].SID)
+	}
+	var lines []int
+	for _, err := range errs {
+		pe, ok := err.(*ParseError)
+		if !ok {
+			t.Fatalf("got %T; expected *ParseError", err)
+		}
+		if pe.Raw == "" || pe.Err == nil {
+			t.Fatalf("incomplete error %#v", pe)
+		}
+		lines = append(lines, pe.Line)
+	}
+	if diff := pretty.Compare(lines, []int{4, 8, 9, 12}); diff != "" {
+		t.Fatal(fmt.Sprintf("diff (-got +want):\n%s", diff))
+	}
+	var uerr *UnsupportedOptionError
+	if !errors.As(errs[3], &uerr) || uerr.Rule.SID != 6 {
+		t.Fatalf("got %v; expected an UnsupportedOptionError for sid 6", errs[3])
+	}
+	var origins []*Origin
+	for _, r := range rules {
+		origins = append(origins, r.Origin)
+	}
+	if diff := pretty.Compare(origins, []*Origin{{Line: 3}, {Line: 5}, {Line: 6}, {Line: 11}}); diff != "" {
+		t.Fatal(fmt.Sprintf("origins: diff (-got +want):\n%s", diff))
+	}
+}
+
+func TestParseRulesWithOrigin(t *testing.T) {
+	input := `alert tcp any any -> any any (msg:"first"; sid:1; rev:1;)
+
+alert tcp any any -> any any (msg:"bad"; sid:2; rev:foo;)
+alert tcp any any -> any any (msg:"last"; sid:3; rev:1;)`
+	rules, errs := ParseRulesWithOrigin(strings.NewReader(input), "rules/local.rules")
+	var got []string
+	for _, r := range rules {
+		got = append(got, r.Origin.String())
+	}
+	if diff := pretty.Compare(got, []string{"rules/local.rules:1", "rules/local.rules:4"}); diff != "" {
+		t.Fatal(fmt.Sprintf("diff (-got +want):\n%s", diff))
+	}
+	if len(errs) != 1 {
+		t.Fatalf("got %d errors; expected 1", len(errs))
+	}
+	if got, want := errs[0].Error(), "rules/local.rules:3: invalid rev foo"; got != want {
+		t.Fatalf("got error %v; expected %v", got, want)
+	}
+}
+
+func TestParseGlob(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		pattern    string
+		wantRules  []string
+		wantErrs   []string
+		wantFailed bool
+	}{
+		{
+			name:      "file",
+			pattern:   "testdata/rules/a.rules",
+			wantRules: []string{"testdata/rules/a.rules:2"},
+			wantErrs:  []string{"testdata/rules/a.rules:3: invalid rev foo"},
+		},
+		{
+			name:      "glob",
+			pattern:   "testdata/rules/*.rules",
+			wantRules: []string{"testdata/rules/a.rules:2", "testdata/rules/b.rules:1", "testdata/rules/b.rules:3"},
+			wantErrs:  []string{"testdata/rules/a.rules:3: invalid rev foo"},
+		},
+		{
+			name:       "no match",
+			pattern:    "testdata/rules/*.conf",
+			wantFailed: true,
+		},
+		{
+			name:       "malformed pattern",
+			pattern:    "testdata/rules/[.rules",
+			wantFailed: true,
+		},
+	} {
+		rules, errs := ParseGlob(tt.pattern)
+		if tt.wantFailed {
+			if len(rules) != 0 || len(errs) != 1 {
+				t.Fatalf("%s: got %d rules, errors %v; expected a single error", tt.name, len(rules), errs)
+			}
+			continue
+		}
+		var got []string
+		for _, r := range rules {
+			got = append(got, r.Origin.String())
+		}
+		if diff := pretty.Compare(got, tt.wantRules); diff != "" {
+			t.Fatal(fmt.Sprintf("%s: rules: diff (-got +want):\n%s", tt.name, diff))
+		}
+		got = nil
+		for _, err := range errs {
+			got = append(got, err.Error())
+		}
+		if diff := pretty.Compare(got, tt.wantErrs); diff != "" {
+			t.Fatal(fmt.Sprintf("%s: errors: diff (-got +want):\n%s", tt.name, diff))
+		}
+	}
+
+	if _, errs := ParseFile("testdata/rules/missing.rules"); len(errs) != 1 || !os.IsNotExist(errs[0]) {
+		t.Fatalf("got errors %v; expected a not exist error", errs)
+	}
+}
+
+func TestParseFileWithOptions(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		path      string
+		opts      FileOptions
+		wantRules []string
+		wantErrs  []string
+	}{
+		{
+			name:      "includes not followed",
+			path:      "testdata/include/main.rules",
+			wantRules: []string{"testdata/include/main.rules:1", "testdata/include/main.rules:4"},
+			wantErrs:  []string{"testdata/include/main.rules:2", "testdata/include/main.rules:3"},
+		},
+		{
+			name: "includes",
+			path: "testdata/include/main.rules",
+			opts: FileOptions{FollowIncludes: true},
+			wantRules: []string{
+				"testdata/include/main.rules:1",
+				"testdata/include/sub/child.rules:1",
+				"testdata/include/main.rules:4",
+			},
+			wantErrs: []string{
+				"testdata/include/sub/child.rules:2: include cycle",
+				"testdata/include/main.rules:3: open testdata/include/missing.rules",
+			},
+		},
+		{
+			name:     "relative to including file",
+			path:     "testdata/include/sub/base.rules",
+			opts:     FileOptions{FollowIncludes: true},
+			wantErrs: []string{"testdata/include/sub/base.rules:1: open testdata/include/sub/leaf.rules"},
+		},
+		{
+			name:      "base dir",
+			path:      "testdata/include/sub/base.rules",
+			opts:      FileOptions{FollowIncludes: true, BaseDir: "testdata/include"},
+			wantRules: []string{"testdata/include/leaf.rules:1"},
+		},
+	} {
+		rules, errs := ParseFileWithOptions(tt.path, tt.opts)
+		var got []string
+		for _, r := range rules {
+			got = append(got, r.Origin.String())
+		}
+		if diff := pretty.Compare(got, tt.wantRules); diff != "" {
+			t.Fatal(fmt.Sprintf("%s: rules: diff (-got +want):\n%s", tt.name, diff))
+		}
+		if len(errs) != len(tt.wantErrs) {
+			t.Fatalf("%s: got errors %v; expected %v", tt.name, errs, tt.wantErrs)
+		}
+		for i, err := range errs {
+			if _, ok := err.(*ParseError); !ok || !strings.HasPrefix(err.Error(), tt.wantErrs[i]) {
+				t.Fatalf("%s: got error %v; expected %v", tt.name, err, tt.wantErrs[i])
+			}
+		}
+	}
+}
+
+func TestParseFileSymlinkCycle(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "main.rules")
+	rule := `alert tcp any any -> any any (msg:"foo"; sid:1; rev:1;)`
+	if err := os.WriteFile(main, []byte(rule+"\ninclude loop/main.rules\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(".", filepath.Join(dir, "loop")); err != nil {
+		t.Skipf("symlinks are not supported: %v", err)
+	}
+	rules, errs := ParseFileWithOptions(main, FileOptions{FollowIncludes: true})
+	if len(rules) != 1 {
+		t.Fatalf("got %d rules; expected 1", len(rules))
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "include cycle") {
+		t.Fatalf("got errors %v; expected an include cycle", errs)
+	}
+}
+
+func TestParseRulesCRLF(t *testing.T) {
+	input := "\ufeffalert tcp any any -> any any (msg:\"first\"; sid:1; rev:1;)\r\n" +
+		"alert tcp any any -> any any (msg:\"multi line\"; \\\r\n" +
+		"    content:\"foo\"; sid:2; rev:3;)\r\n" +
+		"alert tcp any any -> any any (msg:\"bad\"; sid:3; rev:foo;)\r\n"
+	rules, errs := ParseRules(strings.NewReader(input))
+	want := []string{
+		`alert tcp any any -> any any (msg:"first"; sid:1; rev:1;)`,
+		`alert tcp any any -> any any (msg:"multi line"; content:"foo"; sid:2; rev:3;)`,
+	}
+	var got []string
+	for _, r := range rules {
+		got = append(got, r.String())
+	}
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Fatal(fmt.Sprintf("diff (-got +want):\n%s", diff))
+	}
+	if len(errs) != 1 {
+		t.Fatalf("got %d errors; expected 1", len(errs))
+	}
+	if pe := errs[0].(*ParseError); pe.Line != 4 || strings.ContainsAny(pe.Raw, "\r\n") {
+		t.Fatalf("got error %#v", pe)
+	}
+}
+
+func TestInSlice(t *testing.T) {
+	for _, tt := range []struct {
+		str  string
+		strs []string
+		want bool
+	}{
+		{
+			str:  "pkt_data",
+			strs: []string{"foo", "bar", "baze"},
+			want: false,
+		},
+		{
+			str:  "pkt_data",
+			strs: []string{"foo", "pkt_data", "baze"},
+			want: true,
+		},
+	} {
+		got := inSlice(tt.str, tt.strs)
+		if got != tt.want {
+			t.Fatalf("got=%v; want=%v", got, tt.want)
+		}
+	}
+}
+
+// Test that parsing a string input and then parsing the stringer output of that struct are identical.
+func TestInEqualOut(t *testing.T) {
 	for _, tt := range []struct {
 		name  string
 		input string
@@ -1948,6 +3526,102 @@ func TestInEqualOut(t *testing.T) {
 			name:  "simple test",
 			input: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:"|28|foo"; content:".AA"; within:40;)`,
 		},
+		{
+			name:  "byte_test typed options",
+			input: `alert tcp any any -> any any (msg:"foo"; content:"foo"; byte_test:4, !>=, 10, 0, string, dec, relative; sid:1; rev:1;)`,
+		},
+		{
+			name:  "base64_decode and base64_data",
+			input: `alert tcp any any -> any any (msg:"foo"; content:"foo"; base64_decode:bytes 150,offset 17,relative; base64_data; content:"bar"; base64_decode; base64_data; content:"baz"; sid:1; rev:1;)`,
+		},
+		{
+			name:  "lua",
+			input: `alert http any any -> any any (msg:"foo"; content:"a"; lua:!check.lua; luajit:dir/fast.lua; sid:1; rev:1;)`,
+		},
+		{
+			name:  "http and tls buffers",
+			input: `alert http any any -> any any (msg:"foo"; http.location; content:"/login"; http.content_range; content:"bytes 0-"; http.request_line; content:"GET /"; startswith; sid:1; rev:1;)`,
+		},
+		{
+			name:  "tls.certs",
+			input: `alert tls any any -> any any (msg:"foo"; tls.certs; content:"|06 03 55 04 03|"; sid:1; rev:1;)`,
+		},
+		{
+			name:  "tls fingerprints",
+			input: `alert tls any any -> any any (msg:"foo"; ja3s.hash; content:"e7d705a3286e19ea42f587b344ee6865"; ja4.hash; content:"t13d1516h2_8daaf6152771_b186095e22b6"; sid:1; rev:1;)`,
+		},
+		{
+			name:  "byte_extract variables in content options",
+			input: `alert tcp any any -> any any (msg:"foo"; content:"|00|"; byte_extract:2,0,len,relative,little; byte_extract:1,2,off,relative; content:"abc"; offset:off; depth:len; content:"def"; distance:off; within:len; sid:1; rev:1;)`,
+		},
+		{
+			name:  "mixed hex and ascii",
+			input: `alert http any any -> any any (msg:"foo"; http.uri; content:"/cgi-bin/|2E 2E 7C 3B 00|x|20|"; sid:1; rev:1;)`,
+		},
+		{
+			name:  "escaped delimiters",
+			input: `alert tcp any any -> any any (msg:"foo"; content:"a|3B|b|22 5C|"; pcre:"/a\;b\"c/i"; sid:1; rev:1;)`,
+		},
+		{
+			name:  "dcerpc keywords",
+			input: `alert smb any any -> any any (msg:"foo"; dce_stub_data; content:"|05 00|"; dce_iface:4b324fc8-1670-01d3-1278-5a47bf6ee188,=1; dce_opnum:0,15,18-20; sid:1; rev:1;)`,
+		},
+		{
+			name:  "tls keywords",
+			input: `alert tls any any -> any any (msg:"foo"; tls.version:1.2; tls.fingerprint:!"4a:b6:c2:5d"; tls_cert_expired; tls_cert_valid; tls.store; sid:1; rev:1;)`,
+		},
+		{
+			name:  "dns keywords",
+			input: `alert dns any any -> any any (msg:"foo"; dns.opcode:!0; dns.rcode:3; dns.query.name; content:"evil"; dns.answer.name; content:"bad"; sid:1; rev:1;)`,
+		},
+		{
+			name:  "file keywords",
+			input: `alert http any any -> any any (msg:"foo"; filesize:100<>200; filemagic:!"PDF"; fileext:"pdf"; filestore:request; sid:1; rev:1;)`,
+		},
+		{
+			name:  "duplicate tags",
+			input: `alert tcp any any -> any any (msg:"foo"; flow:established; flow:to_server; content:"abc"; threshold:type limit, track by_src, count 1, seconds 60; threshold:type both, track by_dst, count 2, seconds 10; sid:1; rev:1;)`,
+		},
+		{
+			name:  "file match nocase",
+			input: `alert http any any -> any any (msg:"foo"; content:"abc"; filemagic:"PDF"; nocase; filename:"a.exe"; nocase; sid:1; rev:1;)`,
+		},
+		{
+			name:  "repeated tags",
+			input: `alert ip any any -> any any (msg:"foo"; classtype:misc-activity; app-layer-protocol:!http; app-layer-protocol:!tls; ip_proto:!6; ip_proto:!17; ip_proto:>50; geoip:both,CN,RU; iprep:any,CnC,<,50; iprep:both,Spam,>,10; sameip; sid:1; rev:1;)`,
+		},
+		{
+			name:  "prefilter",
+			input: `alert tcp any any -> any any (msg:"foo"; flow:established; prefilter; ttl:<5; content:"abc"; sid:1; rev:1;)`,
+		},
+		{
+			name:  "prefilter on content",
+			input: `alert tcp any any -> any any (msg:"foo"; content:"abc"; nocase; prefilter; content:"def"; distance:0; sid:1; rev:1;)`,
+		},
+		{
+			name:  "prefilter on length match",
+			input: `alert tcp any any -> any any (msg:"foo"; flow:established; content:"abc"; dsize:>10; prefilter; flags:S; sid:1; rev:1;)`,
+		},
+		{
+			name:  "typed tags",
+			input: `alert ip any any -> any any (msg:"foo"; flow:to_server; classtype:trojan-activity; tag:session,5,packets; priority:1; target:src_ip; detection_filter:track by_src, count 10, seconds 60; sid:1; rev:1;)`,
+		},
+		{
+			name:  "transforms",
+			input: `alert http any any -> any any (msg:"foo"; http.uri; url_decode; to_lowercase; content:"/foo"; http.host; xor:"0d0ac8ff"; content:"bar"; sid:1; rev:1;)`,
+		},
+		{
+			name:  "gid and noalert",
+			input: `alert tcp any any -> any any (msg:"foo"; content:"foo"; flowbits:set,foo; noalert; gid:3; sid:1; rev:1;)`,
+		},
+		{
+			name:  "fast_pattern chop with zero offset",
+			input: `alert tcp any any -> any any (msg:"foo"; content:"foobarbaz"; fast_pattern:0,20; sid:1; rev:1;)`,
+		},
+		{
+			name:  "fast_pattern chop with zero length",
+			input: `alert tcp any any -> any any (msg:"foo"; content:"foobarbaz"; fast_pattern:5,0; sid:1; rev:1;)`,
+		},
 		{
 			name:  "complex rule",
 			input: `alert http $EXTERNAL_NET any -> $HOME_NET any (msg:"FOO BAR BLAH"; flow:established,from_server; content:"200"; http_stat_code; file_data; content:"|3d 21 2d 2f|eyJjWEEEEEE"; fast_pattern; content:"|3z 21 2f 2d|"; pcre:"/^(?:[A-Z0-9+/]{1})*(?:[A-Z0-9+/]{1}==|[A-Z0-9+/]{7}=|[A-Z0-9+/]{9})/R"; metadata: former_category BOO; reference:url,this.is.sparta.com/fooblog; classtype:trojan-activity; sid:1111111; rev:1; metadata:affected_product Windows_XP_Vista_7_8_10_Server_32_64_Bit, attack_target Client_Endpoint, deployment Perimeter, tag FOOO, signature_severity Major, created_at 2018_06_25, performance_impact Low, updated_at 2018_09_23;)`,
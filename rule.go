@@ -17,10 +17,14 @@ package gonids
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"regexp"
+	"regexp/syntax"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode"
 )
 
 // Rule describes an IDS rule.
@@ -37,6 +41,8 @@ type Rule struct {
 	Destination Network
 	// Bidirectional indicates the directionality of a rule (-> or <>).
 	Bidirectional bool
+	// GID is the group identifier of the rule (gid). Zero means unset, which engines treat as 1.
+	GID int
 	// SID is the identifier of the rule.
 	SID int
 	// Revision is the revision of the rule.
@@ -45,10 +51,40 @@ type Rule struct {
 	Description string
 	// References contains references associated to the rule (e.g. CVE number).
 	References []*Reference
-	// Contents are all the decoded content matches.
+	// Classtype is the classtype of the rule, empty if unset.
+	Classtype string
+	// Priority is the priority of the rule, zero if unset.
+	Priority int
+	// Target is the side of the traffic targeted by the rule (src_ip or dest_ip), empty if unset.
+	Target string
+	// PrefilterTag is the keyword of Tags (e.g. flags) followed by the prefilter keyword, empty if
+	// none. Prefilter on length matches is set in LenMatch, and on contents in Content.
+	PrefilterTag string
+	// Tags holds the well-known simple keywords of the rule that have no typed field, with their
+	// value as written: flow, tag, flags, ipopts, fragbits, fragoffset, tos, window, threshold,
+	// detection_filter and asn1. Keywords with a typed field (classtype, priority, gid, rev, etc.)
+	// are never set in Tags, and unsupported keywords are kept as UnknownOption matchers.
 	Tags map[string]string
-	// Statements is a slice of string. These items are similar to Tags, but have no value. (e.g. 'sameip;')
+	// MultiTags holds the values of the simple keywords that can be repeated (app-layer-protocol),
+	// in order. For other simple keywords, the first value is in Tags and the repeated values are
+	// kept here, Validate reports them.
+	MultiTags map[string][]string
+	// Statements is a slice of string. These items are similar to Tags, but have no value. (e.g. 'ftpbounce;')
 	Statements []string
+	// SameIP is true if the rule has the sameip keyword (source and destination are the same).
+	SameIP bool
+	// IPProto is a slice of ip_proto matches.
+	IPProto []*IPProto
+	// GeoIP holds the geoip match, nil if not set.
+	GeoIP *GeoIP
+	// IPRep is a slice of iprep matches.
+	IPRep []*IPRep
+	// DCEIface holds the dce_iface match, nil if not set.
+	DCEIface *DCEIface
+	// DCEOpnums are the operation numbers matched by dce_opnum.
+	DCEOpnums []*OpnumRange
+	// NoAlert is true if the rule has the noalert keyword. flowbits:noalert is kept in Flowbits.
+	NoAlert bool
 	// TLSTags is a slice of TLS related matches.
 	TLSTags []*TLSTag
 	// StreamMatch holds stream_size parameters.
@@ -61,9 +97,41 @@ type Rule struct {
 	Xbits []*Xbit
 	// Flowints is a slice of Flowint
 	Flowints []*Flowint
+	// Lua is a slice of the Lua scripts used by the rule.
+	Lua []*Lua
+	// FileMatches is a slice of matches on file properties (filemagic, filename, fileext).
+	FileMatches []*FileMatch
+	// Filestore holds the filestore parameters, nil if the rule does not store files.
+	Filestore *Filestore
 	// Matchers are internally used to ensure relative matches are printed correctly.
 	// Make this private before checkin?
 	Matchers []orderedMatcher
+	// Raw is the original text of the rule, set by ParseRuleLossless.
+	Raw string
+	// Origin is the location the rule was parsed from, set by ParseRules and the file based
+	// helpers. It is nil for rules parsed with ParseRule, and is not part of the rule itself.
+	Origin *Origin
+	// AutoBumpRevision increments Revision each time the rule is modified by one of its mutation
+	// helpers (AddContent, InsertMatcher, AddReference, SetMetadata, etc.). It is not part of the
+	// rule itself, and is never set by the parser.
+	AutoBumpRevision bool `json:"-"`
+}
+
+// Origin is the location of a rule in a rules file.
+type Origin struct {
+	// File is the name of the file, empty if the rules were not read from a file.
+	File string
+	// Line is the line number of the rule, starting at 1. For a rule on multiple lines, this is
+	// the first line.
+	Line int
+}
+
+// String returns the location of a rule as file:line, or line N if the file is unknown.
+func (o Origin) String() string {
+	if o.File == "" {
+		return fmt.Sprintf("line %d", o.Line)
+	}
+	return fmt.Sprintf("%s:%d", o.File, o.Line)
 }
 
 type orderedMatcher interface {
@@ -100,11 +168,234 @@ type Xbit struct {
 	Expire string
 }
 
+// Lua describes a lua or luajit keyword, running a Lua script.
+type Lua struct {
+	// Keyword is lua or luajit.
+	Keyword string
+	// Negate is true if the rule matches when the script does not.
+	Negate bool
+	// Script is the filename of the script, relative to the rules directory.
+	Script string
+}
+
+// IPProto describes an ip_proto match on the protocol field of the IP header.
+type IPProto struct {
+	// Proto is the protocol, as a number (e.g. 6) or a name (e.g. tcp).
+	Proto string
+	// Negate is true if the protocol must not match.
+	Negate bool
+	// Operator is < or > to match protocols lower or greater than Proto, empty for an exact match.
+	Operator string
+}
+
+// ipProtoNames are the protocol names accepted by ip_proto, as in /etc/protocols.
+var ipProtoNames = []string{
+	"hopopt", "icmp", "igmp", "ggp", "ipv4", "ipip", "st", "tcp", "egp", "igp", "pup", "udp", "idp",
+	"ipv6", "ipv6-route", "ipv6-frag", "idrp", "rsvp", "gre", "esp", "ah", "ipv6-icmp", "ipv6-nonxt",
+	"ipv6-opts", "eigrp", "ospf", "pim", "vrrp", "l2tp", "sctp", "mpls-in-ip", "udplite",
+}
+
+// Validate returns an error if the protocol is not a number from 0 to 255, or a known name. A
+// comparison (< or >) requires a number, and cannot be negated.
+func (p IPProto) Validate() error {
+	if p.Operator != "" {
+		if p.Operator != "<" && p.Operator != ">" {
+			return fmt.Errorf("invalid ip_proto operator %q", p.Operator)
+		}
+		if p.Negate {
+			return fmt.Errorf("ip_proto %s%s cannot be negated", p.Operator, p.Proto)
+		}
+	}
+	if n, err := strconv.Atoi(p.Proto); err == nil {
+		if n < 0 || n > 255 {
+			return fmt.Errorf("ip_proto %d is not in 0-255", n)
+		}
+		return nil
+	}
+	if p.Operator != "" {
+		return fmt.Errorf("ip_proto %s%s must compare a number", p.Operator, p.Proto)
+	}
+	if !inSlice(strings.ToLower(p.Proto), ipProtoNames) {
+		return fmt.Errorf("unknown ip_proto %q", p.Proto)
+	}
+	return nil
+}
+
+// GeoIP describes a geoip match on the country of the addresses of a packet.
+type GeoIP struct {
+	// Direction is the address matched: src, dst, both or any.
+	Direction string
+	// Countries are the ISO 3166-1 alpha-2 country codes matched (e.g. CN).
+	Countries []string
+}
+
+// geoIPDirections are the valid directions of geoip.
+var geoIPDirections = []string{"src", "dst", "both", "any"}
+
+// countryCodeRE matches a two letter country code.
+var countryCodeRE = regexp.MustCompile(`^[A-Za-z]{2}$`)
+
+// Validate returns an error if the direction is invalid, or a country is not a two letter code.
+func (g GeoIP) Validate() error {
+	if !inSlice(g.Direction, geoIPDirections) {
+		return fmt.Errorf("invalid geoip direction %q", g.Direction)
+	}
+	if len(g.Countries) == 0 {
+		return errors.New("geoip has no country")
+	}
+	for _, c := range g.Countries {
+		if !countryCodeRE.MatchString(c) {
+			return fmt.Errorf("invalid geoip country code %q", c)
+		}
+	}
+	return nil
+}
+
+// String returns a string for a GeoIP.
+func (g GeoIP) String() string {
+	return fmt.Sprintf("geoip:%s,%s;", g.Direction, strings.Join(g.Countries, ","))
+}
+
+// IPRep describes an iprep match on the reputation of the addresses of a packet.
+type IPRep struct {
+	// Direction is the address matched: src, dst, both or any.
+	Direction string
+	// Category is the reputation category (e.g. CnC), as defined in the categories file.
+	Category string
+	// Operator is the comparison applied to the reputation score: <, > or =.
+	Operator string
+	// Value is the reputation score compared, from 0 to 127.
+	Value int
+}
+
+// ipRepOperators are the valid operators of iprep.
+var ipRepOperators = []string{"<", ">", "="}
+
+// Validate returns an error if the direction or operator is invalid, the category is empty, or the
+// value is not in 0-127.
+func (i IPRep) Validate() error {
+	if !inSlice(i.Direction, geoIPDirections) {
+		return fmt.Errorf("invalid iprep direction %q", i.Direction)
+	}
+	if i.Category == "" {
+		return errors.New("iprep has no category")
+	}
+	if !inSlice(i.Operator, ipRepOperators) {
+		return fmt.Errorf("invalid iprep operator %q", i.Operator)
+	}
+	if i.Value < 0 || i.Value > 127 {
+		return fmt.Errorf("iprep value %d is not in 0-127", i.Value)
+	}
+	return nil
+}
+
+// String returns a string for an IPRep.
+func (i IPRep) String() string {
+	return fmt.Sprintf("iprep:%s,%s,%s,%d;", i.Direction, i.Category, i.Operator, i.Value)
+}
+
+// DCEIface describes a dce_iface match on the interface UUID of a DCERPC request.
+type DCEIface struct {
+	// UUID is the interface UUID (e.g. 4b324fc8-1670-01d3-1278-5a47bf6ee188).
+	UUID string
+	// Operator is the comparison applied to Version: <, >, = or !. It is empty if the version is
+	// not matched.
+	Operator string
+	// Version is the interface version compared with Operator.
+	Version int
+	// AnyFrag is true if the match applies to any fragment (any_frag).
+	AnyFrag bool
+}
+
+// uuidRE matches a UUID.
+var uuidRE = regexp.MustCompile(`^[0-9A-Fa-f]{8}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{12}$`)
+
+// Validate returns an error if the UUID is malformed, or the version comparison is invalid.
+func (d DCEIface) Validate() error {
+	if !uuidRE.MatchString(d.UUID) {
+		return fmt.Errorf("invalid dce_iface uuid %q", d.UUID)
+	}
+	if d.Operator != "" && !inSlice(d.Operator, []string{"<", ">", "=", "!"}) {
+		return fmt.Errorf("invalid dce_iface operator %q", d.Operator)
+	}
+	if d.Operator != "" && (d.Version < 0 || d.Version > 65535) {
+		return fmt.Errorf("dce_iface version %d is not in 0-65535", d.Version)
+	}
+	return nil
+}
+
+// String returns a string for a DCEIface.
+func (d DCEIface) String() string {
+	var s strings.Builder
+	s.WriteString(fmt.Sprintf("dce_iface:%s", d.UUID))
+	if d.Operator != "" {
+		s.WriteString(fmt.Sprintf(",%s%d", d.Operator, d.Version))
+	}
+	if d.AnyFrag {
+		s.WriteString(",any_frag")
+	}
+	s.WriteString(";")
+	return s.String()
+}
+
+// OpnumRange is an operation number, or an inclusive range of operation numbers, matched by
+// dce_opnum. Min and Max are equal for a single operation number.
+type OpnumRange struct {
+	Min int
+	Max int
+}
+
+// String returns a string for an OpnumRange.
+func (o OpnumRange) String() string {
+	if o.Min == o.Max {
+		return strconv.Itoa(o.Min)
+	}
+	return fmt.Sprintf("%d-%d", o.Min, o.Max)
+}
+
+// dceOpnumString returns a dce_opnum keyword for a list of OpnumRange.
+func dceOpnumString(os []*OpnumRange) string {
+	ss := make([]string, len(os))
+	for i, o := range os {
+		ss[i] = o.String()
+	}
+	return fmt.Sprintf("dce_opnum:%s;", strings.Join(ss, ","))
+}
+
+// FileMatch describes a match on a property of a file: filemagic, filename or fileext.
+type FileMatch struct {
+	// Keyword is filemagic, filename or fileext.
+	Keyword string
+	// Negate is true if the property must not match.
+	Negate bool
+	// Value is the string matched, as written between quotes.
+	Value string
+	// Nocase is true if the nocase modifier follows the match.
+	Nocase bool
+}
+
+// fileMatchKeywords are the keywords parsed into a FileMatch.
+var fileMatchKeywords = []string{"filemagic", "filename", "fileext"}
+
+// Filestore describes the filestore keyword, and its optional direction and scope.
+type Filestore struct {
+	// Direction is request, response, both, to_server or to_client, empty if not set.
+	Direction string
+	// Scope is file, tx, ssn or flow, empty if not set.
+	Scope string
+}
+
+// filestoreDirections and filestoreScopes are the valid arguments of filestore.
+var (
+	filestoreDirections = []string{"request", "response", "both", "to_server", "to_client"}
+	filestoreScopes     = []string{"file", "tx", "ssn", "flow"}
+)
+
 // Metadatas allows for a Stringer on []*Metadata
 type Metadatas []*Metadata
 
-// Network describes the IP addresses and port numbers used in a rule.
-// TODO: Ensure all values either begin with $ (variable) or they are valid IPNet/int.
+// Network describes the IP addresses and port numbers used in a rule. Use Validate to ensure all
+// values are variables, or valid addresses and ports.
 type Network struct {
 	Nets  []string // Currently just []string because these can be variables $HOME_NET, not a valid IPNet.
 	Ports []string // Currently just []string because these can be variables $HTTP_PORTS, not just ints.
@@ -154,6 +445,8 @@ const (
 	// SMB Sticky Buffers
 	smbNamedPipe
 	smbShare
+	// DCERPC Sticky Buffers
+	dceStubData
 	//
 	// Suricata 5.0 Sticky Buffers
 	//
@@ -165,6 +458,7 @@ const (
 	httpClientBody
 	httpConnection5
 	httpContentLen5
+	httpContentRange
 	httpContentType5
 	httpCookie
 	httpHeader
@@ -194,17 +488,21 @@ const (
 	tlsCertSerial5
 	tlsCertFingerprint5
 	tlsSNI5
+	tlsCerts
 	// JA3 Sticky Buffers
 	ja3Hash5
 	ja3String5
 	ja3sHash
 	ja3sString
+	ja4Hash
 	// SSH Sticky Buffers
 	sshProto5
 	sshSoftware5
 	// Kerberos Sticky Buffers - Unchanged from Suricata 4.x
 	// DNS Sticky Buffers
 	dnsQuery5
+	dnsQueryName
+	dnsAnswerName
 	// SMB - Documentation lacking. Unknown.
 )
 
@@ -247,6 +545,8 @@ var stickyBuffers = map[DataPos]string{
 	// SMB Sticky Buffers
 	smbNamedPipe: "smb_named_pipe",
 	smbShare:     "smb_share",
+	// DCERPC Sticky Buffers
+	dceStubData: "dce_stub_data",
 	// Suricata 5.0 Sticky Buffers
 	fileData5: "file.data",
 	// HTTP Sticky Buffers
@@ -256,6 +556,7 @@ var stickyBuffers = map[DataPos]string{
 	httpClientBody:    "http.client_body",
 	httpConnection5:   "http.connection",
 	httpContentLen5:   "http.content_len",
+	httpContentRange:  "http.content_range",
 	httpContentType5:  "http.content_type",
 	httpCookie:        "http.cookie",
 	httpHeader:        "http.header",
@@ -285,17 +586,21 @@ var stickyBuffers = map[DataPos]string{
 	tlsCertSerial5:      "tls.cert_serial",
 	tlsCertFingerprint5: "tls.cert_fingerprint",
 	tlsSNI5:             "tls.sni",
+	tlsCerts:            "tls.certs",
 	// JA3 Sticky Buffers
 	ja3Hash5:   "ja3.hash",
 	ja3String5: "ja3.string",
 	ja3sHash:   "ja3s.hash",
 	ja3sString: "ja3s.string",
+	ja4Hash:    "ja4.hash",
 	// SSH Sticky Buffers
 	sshProto5:    "ssh.proto",
 	sshSoftware5: "ssh.software",
 	// Kerberos Sticky Buffers - Unchanged from Suricata 4.x
 	// DNS Sticky Buffers
-	dnsQuery5: "dns.query",
+	dnsQuery5:     "dns.query",
+	dnsQueryName:  "dns.query.name",
+	dnsAnswerName: "dns.answer.name",
 	// SMB - Documentation lacking. Unknown.
 }
 
@@ -330,8 +635,24 @@ type Content struct {
 	Pattern []byte
 	// Negate is true for negated content match.
 	Negate bool
-	// Options are the option associated to the content (e.g. http_header).
+	// Nocase is true for a case insensitive content match (nocase).
+	Nocase bool
+	// Options are the modifiers associated to the content (e.g. http_header), in the order they
+	// were written.
 	Options []*ContentOption
+	// Offset, Depth, Distance and Within are the position options of the content, nil if unset.
+	// Their value is an integer, or a variable set by byte_extract or byte_math.
+	Offset   *IntOrVar
+	Depth    *IntOrVar
+	Distance *IntOrVar
+	Within   *IntOrVar
+	// Prefilter is true if the content is followed by the prefilter keyword, which is equivalent to
+	// fast_pattern.
+	Prefilter bool
+	// written holds the names of the modifiers and position options in the order they were parsed,
+	// if it is not the canonical order. It is used to write them back in that order when
+	// RenderOptions.CanonicalOptionOrder is false.
+	written []string
 }
 
 // byteMatchType describes the kinds of byte matches and comparisons that are supported.
@@ -344,6 +665,7 @@ const (
 	bJump
 	isDataAt
 	b64Decode
+	bMath
 )
 
 var byteMatchTypeVals = map[byteMatchType]string{
@@ -352,6 +674,7 @@ var byteMatchTypeVals = map[byteMatchType]string{
 	bTest:     "byte_test",
 	isDataAt:  "isdataat",
 	b64Decode: "base64_decode",
+	bMath:     "byte_math",
 }
 
 // allbyteMatchTypeNames returns a slice of valid byte_* keywords.
@@ -403,6 +726,8 @@ func (b byteMatchType) minLen() int {
 		return 1
 	case b64Decode:
 		return 0
+	case bMath:
+		return 5
 	}
 	return -1
 }
@@ -414,18 +739,40 @@ type ByteMatch struct {
 	DataPosition DataPos
 	// Kind is a specific operation type we're taking.
 	Kind byteMatchType
-	// Negate indicates negation of a value, currently only used for isdataat.
+	// Negate indicates negation of a value, used for isdataat and the operator of byte_test.
 	Negate bool
-	// A variable name being extracted by byte_extract.
+	// A variable name being extracted by byte_extract, or the result of byte_math.
 	Variable string
 	// Number of bytes to operate on. "bytes to convert" in Snort Manual. This can be an int, or a var from byte_extract.
 	NumBytes string
-	// Operator for comparison in byte_test.
+	// Operator for comparison in byte_test, or arithmetic operator in byte_math.
 	Operator string
-	// Value to compare against using byte_test.
+	// Value to compare against using byte_test, or rvalue in byte_math.
 	Value string
 	// Offset within given buffer to operate on.
 	Offset int
+	// Relative is true if Offset is relative to the end of the previous match.
+	Relative bool
+	// Multiplier is applied to the value read by byte_jump, 0 if not set.
+	Multiplier int
+	// Endianness is "big" or "little", empty if not set.
+	Endianness string
+	// StringMode is true if the bytes are read as a string.
+	StringMode bool
+	// Base is the number type of a string: "hex", "dec" or "oct", empty if not set.
+	Base string
+	// Align rounds the number of bytes jumped by byte_jump up to the next 32bit boundary.
+	Align bool
+	// FromBeginning makes byte_jump start from the beginning of the buffer.
+	FromBeginning bool
+	// FromEnd makes byte_jump start from the end of the buffer.
+	FromEnd bool
+	// PostOffset is the number of bytes to skip after byte_jump, 0 if not set.
+	PostOffset int
+	// DCE is true if the endianness is set by the DCE/RPC protocol.
+	DCE bool
+	// Bitmask is applied to the value read, empty if not set.
+	Bitmask string
 	// Other specifics required for jump/test here. This might make sense to pull out into a "ByteMatchOption" later.
 	Options []string
 }
@@ -446,21 +793,27 @@ const (
 	tcpSeq
 	tcpACK
 	bSize
+	fileSize
+	dnsOpcode
+	dnsRcode
 )
 
 // lenMatchTypeVals map len types to string representations.
 var lenMatchTypeVals = map[lenMatchType]string{
-	iType:  "itype",
-	iCode:  "icode",
-	iID:    "icmp_id",
-	iSeq:   "icmp_seq",
-	uriLen: "urilen",
-	dSize:  "dsize",
-	ipTTL:  "ttl",
-	ipID:   "id",
-	tcpSeq: "seq",
-	tcpACK: "ack",
-	bSize:  "bsize",
+	iType:     "itype",
+	iCode:     "icode",
+	iID:       "icmp_id",
+	iSeq:      "icmp_seq",
+	uriLen:    "urilen",
+	dSize:     "dsize",
+	ipTTL:     "ttl",
+	ipID:      "id",
+	tcpSeq:    "seq",
+	tcpACK:    "ack",
+	bSize:     "bsize",
+	fileSize:  "filesize",
+	dnsOpcode: "dns.opcode",
+	dnsRcode:  "dns.rcode",
 }
 
 // allLenMatchTypeNames returns a slice of string containing all length match keywords.
@@ -489,22 +842,159 @@ type LenMatch struct {
 	Max          int
 	Num          int
 	Operator     string
-	Options      []string
+	// Negate is true if the value must not match (e.g. dns.opcode:!4).
+	Negate  bool
+	Options []string
+	// Prefilter is true if the match is followed by the prefilter keyword, so the engine uses it
+	// in the prefilter stage.
+	Prefilter bool
+}
+
+// Transform describes a transformation keyword (e.g. to_lowercase). A transform applies to the
+// sticky buffer it follows, and changes the data seen by the following matches on that buffer.
+type Transform struct {
+	// DataPosition is the sticky buffer the transform applies to.
+	DataPosition DataPos
+	// Name is the keyword of the transform (e.g. to_lowercase).
+	Name string
+	// Value is the argument of transforms that take one (e.g. the key of xor), empty otherwise.
+	Value string
+}
+
+// transformNames are the supported transform keywords, mapped to true if they require a value.
+var transformNames = map[string]bool{
+	"compress_whitespace":  false,
+	"dotprefix":            false,
+	"header_lowercase":     false,
+	"strip_pseudo_headers": false,
+	"strip_whitespace":     false,
+	"to_lowercase":         false,
+	"to_md5":               false,
+	"to_sha1":              false,
+	"to_sha256":            false,
+	"to_uppercase":         false,
+	"url_decode":           false,
+	"pcrexform":            true,
+	"xor":                  true,
+}
+
+// isTransform returns true if s is a supported transform keyword.
+func isTransform(s string) bool {
+	_, ok := transformNames[s]
+	return ok
+}
+
+// String returns a string for a Transform.
+func (t Transform) String() string {
+	if t.Value == "" {
+		return fmt.Sprintf("%s;", t.Name)
+	}
+	return fmt.Sprintf(`%s:"%s";`, t.Name, t.Value)
+}
+
+// UnknownOption is a keyword not supported by the parser. It is kept in Matchers at its original
+// position, and written back verbatim.
+type UnknownOption struct {
+	// Key is the keyword.
+	Key string
+	// Value is the value of the keyword as written, including quotes and negation. It is empty if
+	// the keyword has no value.
+	Value string
+}
+
+// String returns a string for an UnknownOption.
+func (u UnknownOption) String() string {
+	if u.Value == "" {
+		return fmt.Sprintf("%s;", u.Key)
+	}
+	return fmt.Sprintf("%s:%s;", u.Key, u.Value)
 }
 
 // PCRE describes a PCRE item of a rule.
 type PCRE struct {
-	Pattern []byte
-	Negate  bool
+	// DataPosition is the sticky buffer the PCRE follows. A modifier selecting a buffer (e.g. U)
+	// takes precedence, see TargetBuffer.
+	DataPosition DataPos
+	Pattern      []byte
+	Negate       bool
+	// Options holds the modifiers of the PCRE (e.g. "Ri"), in the order they were written.
 	Options []byte
+	// Vars are the variables unnamed groups are captured in (e.g. flow:name), in order.
+	Vars []string
+}
+
+// pcreCaptureRE matches named groups capturing into a flow or packet variable.
+var pcreCaptureRE = regexp.MustCompile(`\(\?P?<(flow|pkt)_([^>]+)>`)
+
+// Captures returns the variables captured by the PCRE as scope:name (e.g. flow:name), from named
+// groups (e.g. (?P<flow_name>...)) followed by the variables in Vars.
+func (p PCRE) Captures() []string {
+	var cs []string
+	for _, m := range pcreCaptureRE.FindAllSubmatch(p.Pattern, -1) {
+		cs = append(cs, fmt.Sprintf("%s:%s", m[1], m[2]))
+	}
+	return append(cs, p.Vars...)
+}
+
+// pcreCompileModifiers are the modifiers changing how a pcre is compiled, or its match limits.
+const pcreCompileModifiers = "ismxAEGO"
+
+// pcreBufferModifiers maps the pcre modifiers selecting a buffer to their sticky buffer.
+var pcreBufferModifiers = map[byte]DataPos{
+	'U': httpURI,
+	'I': httpURIRaw,
+	'P': httpClientBody,
+	'Q': httpServerBody,
+	'H': httpHeader,
+	'D': httpHeaderRaw,
+	'M': httpMethod,
+	'C': httpCookie,
+	'S': httpStatCode,
+	'Y': httpStatMsg,
+	'V': httpUserAgent,
+	'W': httpHost,
+	'Z': httpHostRaw,
+}
+
+// isPCREModifier returns true if m is a known pcre modifier.
+func isPCREModifier(m byte) bool {
+	if _, ok := pcreBufferModifiers[m]; ok {
+		return true
+	}
+	// R is relative, B is rawbytes and K is http_raw_cookie in Snort.
+	return strings.IndexByte(pcreCompileModifiers+"RBK", m) > -1
+}
+
+// HasModifier returns true if the PCRE has the modifier m.
+func (p PCRE) HasModifier(m byte) bool {
+	return bytes.IndexByte(p.Options, m) > -1
+}
+
+// IsRelative returns true if the PCRE is relative to the previous match (R modifier).
+func (p PCRE) IsRelative() bool {
+	return p.HasModifier('R')
+}
+
+// TargetBuffer returns the buffer selected by a modifier of the PCRE (e.g. http.uri for U), and
+// false if the PCRE applies to the current buffer.
+func (p PCRE) TargetBuffer() (DataPos, bool) {
+	for _, m := range p.Options {
+		if d, ok := pcreBufferModifiers[m]; ok {
+			return d, true
+		}
+	}
+	return pktData, false
 }
 
 // FastPattern describes various properties of a fast_pattern value for a content.
 type FastPattern struct {
 	Enabled bool
 	Only    bool
-	Offset  int
-	Length  int
+	// Chop is true if Offset and Length are set (fast_pattern:offset,length), so that zero is a
+	// valid value for both.
+	Chop   bool
+	Offset int
+	Length int
 }
 
 // ContentOption describes an option set on a rule content.
@@ -523,7 +1013,7 @@ type Reference struct {
 	Value string
 }
 
-// TODO: Add support for tls_cert_nobefore, tls_cert_notafter, tls_cert_expired, tls_cert_valid.
+// TODO: Add support for tls_cert_notbefore, tls_cert_notafter.
 // Valid keywords for extracting TLS matches. Does not include tls.store, or sticky buffers.
 var tlsTags = []string{"ssl_version", "ssl_state", "tls.version", "tls.subject", "tls.issuerdn", "tls.fingerprint"}
 
@@ -539,6 +1029,64 @@ type TLSTag struct {
 	Value string
 }
 
+// statementKeywords are the keywords without a value stored in Statements.
+var statementKeywords = []string{"tls.store", "ftpbounce", "tls_cert_expired", "tls.cert_expired", "tls_cert_valid", "tls.cert_valid"}
+
+// TLSVersion is a TLS version as written in tls.version (e.g. 1.2).
+type TLSVersion string
+
+// Known TLS versions.
+const (
+	SSLv3 TLSVersion = "3.0"
+	TLS10 TLSVersion = "1.0"
+	TLS11 TLSVersion = "1.1"
+	TLS12 TLSVersion = "1.2"
+	TLS13 TLSVersion = "1.3"
+)
+
+// TLSInfo is a typed view of the TLS keywords of a rule.
+type TLSInfo struct {
+	// Version is the value of tls.version, empty if not set.
+	Version TLSVersion
+	// VersionNegate is true for tls.version:!x.
+	VersionNegate bool
+	// Fingerprint is the value of tls.fingerprint, empty if not set.
+	Fingerprint string
+	// FingerprintNegate is true for tls.fingerprint:!"x".
+	FingerprintNegate bool
+	// CertExpired is true if the rule uses tls_cert_expired.
+	CertExpired bool
+	// CertValid is true if the rule uses tls_cert_valid.
+	CertValid bool
+	// Store is true if the rule uses tls.store.
+	Store bool
+}
+
+// TLS returns the TLS keywords of a rule. If a keyword is repeated, the first one is returned.
+func (r *Rule) TLS() TLSInfo {
+	var info TLSInfo
+	for i := len(r.TLSTags) - 1; i >= 0; i-- {
+		t := r.TLSTags[i]
+		switch t.Key {
+		case "tls.version":
+			info.Version, info.VersionNegate = TLSVersion(t.Value), t.Negate
+		case "tls.fingerprint":
+			info.Fingerprint, info.FingerprintNegate = t.Value, t.Negate
+		}
+	}
+	for _, s := range r.Statements {
+		switch s {
+		case "tls_cert_expired", "tls.cert_expired":
+			info.CertExpired = true
+		case "tls_cert_valid", "tls.cert_valid":
+			info.CertValid = true
+		case "tls.store":
+			info.Store = true
+		}
+	}
+	return info
+}
+
 // StreamCmp represents a stream comparison (stream_size:>20).
 type StreamCmp struct {
 	// Direction of traffic to inspect: server, client, both, either.
@@ -555,128 +1103,709 @@ func escape(r string) string {
 	return escapeRE.ReplaceAllString(r, `\$1`)
 }
 
-// within returns the within value for a specific content.
-func within(options []*ContentOption) string {
-	for _, o := range options {
-		if o.Name == "within" {
-			return o.Value
-		}
+// intOption returns the integer value of a position option of a content (e.g. offset), and false
+// if the option is not set or is a variable (e.g. set with byte_extract).
+func (c *Content) intOption(name string) (int, bool) {
+	v := c.positionOption(name)
+	if v == nil || v.Var != "" {
+		return 0, false
 	}
-	return ""
+	return v.Int, true
 }
 
-// RE returns all content matches as a single and simple regexp.
-func (r *Rule) RE() string {
-	var re string
-	for _, c := range r.Contents() {
-		// TODO: handle pcre, depth, offset, distance.
-		if d, err := strconv.Atoi(within(c.Options)); err == nil && d > 0 {
-			re += fmt.Sprintf(".{0,%d}", d)
-		} else {
-			re += ".*"
+// reGap returns a regexp matching the bytes skipped before a content.
+func reGap(c *Content, first bool) string {
+	n := len(c.Pattern)
+	distance, hasDistance := c.intOption("distance")
+	within, hasWithin := c.intOption("within")
+	if !first && (hasDistance || hasWithin) {
+		if distance < 0 {
+			return ".*"
 		}
-		re += escape(string(c.Pattern))
+		if !hasWithin {
+			return fmt.Sprintf(".{%d,}", distance)
+		}
+		max := distance + within - n
+		if max < distance {
+			max = distance
+		}
+		return fmt.Sprintf(".{%d,%d}", distance, max)
 	}
-	return re
+
+	// offset and depth are only meaningful for the first content, as the regexp is anchored there.
+	offset, hasOffset := c.intOption("offset")
+	depth, hasDepth := c.intOption("depth")
+	if !first || !(hasOffset || hasDepth) || offset < 0 {
+		return ".*"
+	}
+	if !hasDepth {
+		return fmt.Sprintf("^.{%d,}", offset)
+	}
+	max := offset + depth - n
+	if max < offset {
+		max = offset
+	}
+	return fmt.Sprintf("^.{%d,%d}", offset, max)
 }
 
-// CVE extracts CVE from a rule.
-func (r *Rule) CVE() string {
-	for _, ref := range r.References {
-		if ref.Type == "cve" {
-			return ref.Value
+// pcreREFlags maps PCRE modifiers to the equivalent Go regexp flags.
+var pcreREFlags = map[byte]byte{
+	'i': 'i',
+	'm': 'm',
+	's': 's',
+	'G': 'U',
+}
+
+// re returns the pattern of a PCRE as a Go regexp group, and false if it cannot be represented.
+// Leading and trailing anchors are removed as the PCRE is spliced within a larger regexp.
+func (p PCRE) re() (string, bool) {
+	if p.HasModifier('x') {
+		return "", false
+	}
+	pattern := strings.TrimPrefix(string(p.Pattern), "^")
+	if strings.HasSuffix(pattern, "$") {
+		// Only strip the anchor if the $ is not escaped.
+		n := len(pattern) - 1
+		var bs int
+		for i := n - 1; i >= 0 && pattern[i] == '\\'; i-- {
+			bs++
+		}
+		if bs%2 == 0 {
+			pattern = pattern[:n]
 		}
 	}
-	return ""
-}
 
-// LenMatchers returns all *LenMatch for a rule.
-func (r *Rule) LenMatchers() []*LenMatch {
-	lms := make([]*LenMatch, 0, len(r.Matchers))
-	for _, m := range r.Matchers {
-		if lm, ok := m.(*LenMatch); ok {
-			lms = append(lms, lm)
+	var flags []byte
+	for _, o := range p.Options {
+		if f, ok := pcreREFlags[o]; ok {
+			flags = append(flags, f)
 		}
 	}
-	return lms
+	re := fmt.Sprintf("(?%s:%s)", flags, pattern)
+	if _, err := regexp.Compile(re); err != nil {
+		return "", false
+	}
+	return re, true
 }
 
-// Contents returns all *Content for a rule.
-func (r *Rule) Contents() []*Content {
-	cs := make([]*Content, 0, len(r.Matchers))
-	for _, m := range r.Matchers {
-		if c, ok := m.(*Content); ok {
-			cs = append(cs, c)
+// pcreUnsupportedRE matches PCRE syntax that has no equivalent in Go regexps: lookarounds, atomic
+// groups, backreferences and recursion.
+var pcreUnsupportedRE = regexp.MustCompile(`\(\?<?[=!]|\(\?>|\(\?R\)|\\[1-9]|\\[gk]`)
+
+// ToGoRegexp compiles the pattern of a PCRE with the Go regexp package, to execute it against a
+// buffer.
+//
+// The i, m and s modifiers are mapped to the same Go flags, G to the U (ungreedy) flag, and A
+// anchors the pattern at the start of the buffer. Go regexps only match $ at the end of the buffer
+// (or of a line with m), as if E was always set. Modifiers selecting a buffer (e.g. R, U, H) or
+// changing match limits (O) do not change the pattern and are ignored.
+//
+// An error is returned for the x (extended) modifier, and for syntax not supported by Go such as
+// lookarounds, atomic groups, backreferences and recursion.
+//
+// PCREs match bytes, while Go regexps match UTF-8 encoded runes: \xff matches the two bytes of
+// U+00FF. An error is returned for literals and classes above 0x7F (e.g. \x80 or [\x80-\xff]),
+// which cannot be matched on bytes. . and negated classes (e.g. [^a] or \S) match a single byte if
+// it is not valid UTF-8, but a whole UTF-8 sequence otherwise, so they are only equivalent on
+// buffers without multi-byte UTF-8 sequences.
+func (p PCRE) ToGoRegexp() (*regexp.Regexp, error) {
+	re, _, err := p.goRegexp()
+	return re, err
+}
+
+// goRegexp returns the Go regexp of a PCRE, and true if it has . or negated classes, which do not
+// match bytes of multi-byte UTF-8 sequences like a PCRE does. See ToGoRegexp.
+func (p PCRE) goRegexp() (*regexp.Regexp, bool, error) {
+	if p.HasModifier('x') {
+		return nil, false, errors.New("pcre modifier x is not supported by Go regexps")
+	}
+	var flags []byte
+	for _, o := range p.Options {
+		if f, ok := pcreREFlags[o]; ok && bytes.IndexByte(flags, f) < 0 {
+			flags = append(flags, f)
 		}
 	}
-	return cs
+	pattern := string(p.Pattern)
+	if len(flags) > 0 {
+		pattern = fmt.Sprintf("(?%s)%s", flags, pattern)
+	}
+	if p.HasModifier('A') {
+		pattern = fmt.Sprintf(`\A(?:%s)`, pattern)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		if m := pcreUnsupportedRE.Find(p.Pattern); m != nil {
+			return nil, false, fmt.Errorf("pcre uses %q, which is not supported by Go regexps: %v", m, err)
+		}
+		return nil, false, fmt.Errorf("pcre cannot be compiled: %v", err)
+	}
+	// Parsing cannot fail, as the pattern compiled.
+	tree, _ := syntax.Parse(pattern, syntax.Perl)
+	anyRune, err := runeMatches(tree)
+	if err != nil {
+		return nil, false, err
+	}
+	return re, anyRune, nil
 }
 
-// LastContent returns the last *Content from Matchers
-func (r *Rule) LastContent() *Content {
-	for i := range r.Matchers {
-		if co, ok := r.Matchers[len(r.Matchers)-i-1].(*Content); ok {
-			return co
+// foldRunes are the runes above 0x7F that case-insensitive Go regexps add to ASCII letters (ſ and
+// the Kelvin sign). They are ignored when checking patterns for runes above 0x7F.
+var foldRunes = []rune{0x17f, 0x212a}
+
+// runeMatches returns an error if a regexp matches literals or classes above 0x7F, and true if it
+// matches any rune above 0x7F (. or a negated class).
+func runeMatches(re *syntax.Regexp) (bool, error) {
+	var anyRune bool
+	switch re.Op {
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		anyRune = true
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			if r > 0x7f && !inRunes(r, foldRunes) {
+				return false, fmt.Errorf("pcre literal %q above 0x7f is not supported by Go regexps", r)
+			}
+		}
+	case syntax.OpCharClass:
+		// Count the runes above 0x7F in the class: none, or all of them for a negated class.
+		var n rune
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			lo, hi := re.Rune[i], re.Rune[i+1]
+			if lo < 0x80 {
+				lo = 0x80
+			}
+			if hi < lo {
+				continue
+			}
+			n += hi - lo + 1
+			for _, f := range foldRunes {
+				if f >= lo && f <= hi {
+					n--
+				}
+			}
+		}
+		switch n {
+		case 0:
+		case unicode.MaxRune - 0x7f - rune(len(foldRunes)):
+			anyRune = true
+		default:
+			return false, fmt.Errorf("pcre class %s above 0x7f is not supported by Go regexps", re)
 		}
 	}
-	return nil
+	for _, sub := range re.Sub {
+		a, err := runeMatches(sub)
+		if err != nil {
+			return false, err
+		}
+		anyRune = anyRune || a
+	}
+	return anyRune, nil
 }
 
-// ByteMatchers returns all *ByteMatch for a rule.
-func (r *Rule) ByteMatchers() []*ByteMatch {
-	bs := make([]*ByteMatch, 0, len(r.Matchers))
-	for _, m := range r.Matchers {
-		if b, ok := m.(*ByteMatch); ok {
-			bs = append(bs, b)
+// inRunes returns true if r is in rs.
+func inRunes(r rune, rs []rune) bool {
+	for _, v := range rs {
+		if v == r {
+			return true
 		}
 	}
-	return bs
+	return false
 }
 
-// PCREs returns all *PCRE for a rule.
-func (r *Rule) PCREs() []*PCRE {
-	var ps []*PCRE
+// RE returns all content and pcre matches as a single and simple regexp, in the order they
+// appear in the rule.
+//
+// The gap before each content is bounded by its distance and within options, and the first content
+// is anchored if it has an offset or depth. Negated contents and pcres are skipped, as they cannot be
+// expressed without a negative lookahead which is not supported by Go regexps.
+//
+// PCREs are spliced without their anchors. The i, m and s modifiers are kept, and G is converted to
+// the Go U (ungreedy) flag. Other modifiers cannot be represented and are dropped: A (anchored), E
+// (dollar end only), and buffer selection modifiers such as R, U, I, P, Q, H, D, M, C, K, S, Y, V,
+// W, Z, B and O. A PCRE with the x (extended) modifier, whose whitespace and comments would change
+// meaning, or using syntax not supported by Go (e.g. lookarounds or backreferences) is skipped.
+func (r *Rule) RE() string {
+	var re string
+	first, skipped := true, false
 	for _, m := range r.Matchers {
-		if p, ok := m.(*PCRE); ok {
-			ps = append(ps, p)
+		switch v := m.(type) {
+		case *Content:
+			if v.Negate {
+				skipped = true
+				continue
+			}
+			if skipped && !first {
+				// The content may be relative to the skipped matcher, so its position is unknown.
+				re += ".*"
+			} else {
+				re += reGap(v, first)
+			}
+			re += escape(string(v.Pattern))
+		case *PCRE:
+			p, ok := v.re()
+			if v.Negate || !ok {
+				skipped = true
+				continue
+			}
+			re += ".*" + p
+		default:
+			continue
 		}
+		first, skipped = false, false
 	}
-	return ps
+	return re
 }
 
-func netString(netPart []string) string {
-	var s strings.Builder
-	if len(netPart) > 1 {
-		s.WriteString("[")
-	}
-	for i, n := range netPart {
-		s.WriteString(n)
-		if i < len(netPart)-1 {
-			s.WriteString(",")
+// CVE extracts CVE from a rule.
+func (r *Rule) CVE() string {
+	for _, ref := range r.References {
+		if strings.EqualFold(ref.Type, "cve") {
+			return ref.Value
 		}
 	}
-	if len(netPart) > 1 {
-		s.WriteString("]")
+	return ""
+}
+
+// CVEs returns all CVE references of a rule in order, normalized to the CVE-YYYY-NNNN form and
+// without duplicates.
+func (r *Rule) CVEs() []string {
+	var cves []string
+	seen := make(map[string]bool)
+	for _, v := range r.ReferencesOfType("cve") {
+		v = strings.ToUpper(strings.TrimSpace(v))
+		if !strings.HasPrefix(v, "CVE-") {
+			v = "CVE-" + v
+		}
+		if !seen[v] {
+			seen[v] = true
+			cves = append(cves, v)
+		}
 	}
-	return s.String()
+	return cves
 }
 
-// String retunrs a string for a Network.
-func (n Network) String() string {
-	return fmt.Sprintf("%s %s", netString(n.Nets), netString(n.Ports))
+// referencePrefixes maps reference types to the URL prefix their values are appended to, as found
+// in the default reference.config.
+var referencePrefixes = map[string]string{
+	"bugtraq":         "http://www.securityfocus.com/bid/",
+	"bid":             "http://www.securityfocus.com/bid/",
+	"cve":             "http://cve.mitre.org/cgi-bin/cvename.cgi?name=",
+	"secunia":         "http://www.secunia.com/advisories/",
+	"arachnids":       "http://www.whitehats.com/info/IDS",
+	"mcafee":          "http://vil.nai.com/vil/content/v_",
+	"nessus":          "http://cgi.nessus.org/plugins/dump.php3?id=",
+	"url":             "http://",
+	"et":              "http://doc.emergingthreats.net/",
+	"etpro":           "http://doc.emergingthreatspro.com/",
+	"telus":           "http://",
+	"osvdb":           "http://osvdb.org/show/osvdb/",
+	"threatexpert":    "http://www.threatexpert.com/report.aspx?md5=",
+	"md5":             "http://www.threatexpert.com/report.aspx?md5=",
+	"exploitdb":       "http://www.exploit-db.com/exploits/",
+	"openpacket":      "https://www.openpacket.org/capture/grab/",
+	"securitytracker": "http://securitytracker.com/id?",
+	"xforce":          "http://xforce.iss.net/xforce/xfdb/",
 }
 
-// String returns a string for a FastPattern.
-func (f FastPattern) String() string {
-	if !f.Enabled {
-		return ""
-	}
-	// This is an invalid state.
-	if f.Only && (f.Offset != 0 || f.Length != 0) {
-		return ""
+// ReferencePrefixes returns a copy of the URL prefixes of the known reference types, keyed by
+// lowercase type, as used by Reference.URL.
+func ReferencePrefixes() map[string]string {
+	ps := make(map[string]string, len(referencePrefixes))
+	for t, p := range referencePrefixes {
+		ps[t] = p
 	}
+	return ps
+}
 
-	var s strings.Builder
+// ReferencesOfType returns the values of all references of type t, in order. Types are compared
+// case-insensitively.
+func (r *Rule) ReferencesOfType(t string) []string {
+	var vs []string
+	for _, ref := range r.References {
+		if strings.EqualFold(ref.Type, t) {
+			vs = append(vs, ref.Value)
+		}
+	}
+	return vs
+}
+
+// AddReference adds a reference to the rule, unless an identical reference is already present.
+func (r *Rule) AddReference(t, v string) {
+	ref := &Reference{Type: t, Value: v}
+	if !r.hasReference(ref) {
+		r.References = append(r.References, ref)
+		r.modified()
+	}
+}
+
+// URL returns the URL of a reference, using the prefixes of ReferencePrefixes. Values already containing a scheme
+// are returned unchanged. Returns false if the reference type has no known prefix.
+func (r Reference) URL() (string, bool) {
+	if strings.Contains(r.Value, "://") {
+		return r.Value, true
+	}
+	p, ok := referencePrefixes[strings.ToLower(r.Type)]
+	if !ok {
+		return "", false
+	}
+	return p + r.Value, true
+}
+
+// URLs returns the URLs of all references of the rule that can be resolved, in order.
+func (r *Rule) URLs() []string {
+	var us []string
+	for _, ref := range r.References {
+		if u, ok := ref.URL(); ok {
+			us = append(us, u)
+		}
+	}
+	return us
+}
+
+// matcherDataPos returns the data position of a matcher, and false if the matcher has none.
+func matcherDataPos(m orderedMatcher) (DataPos, bool) {
+	switch v := m.(type) {
+	case *Content:
+		return v.DataPosition, true
+	case *LenMatch:
+		return v.DataPosition, true
+	case *ByteMatch:
+		return v.DataPosition, true
+	case *Transform:
+		return v.DataPosition, true
+	}
+	return pktData, false
+}
+
+// targets are the valid values of the target keyword.
+var targets = []string{"src_ip", "dest_ip"}
+
+// typedTags returns the keyword and value of the simple keywords with a typed field that are set
+// (classtype, priority and target), in this order.
+func (r *Rule) typedTags() [][2]string {
+	var ts [][2]string
+	if r.Classtype != "" {
+		ts = append(ts, [2]string{"classtype", r.Classtype})
+	}
+	if r.Priority != 0 {
+		ts = append(ts, [2]string{"priority", strconv.Itoa(r.Priority)})
+	}
+	if r.Target != "" {
+		ts = append(ts, [2]string{"target", r.Target})
+	}
+	return ts
+}
+
+// repeatableTags are the simple keywords that can be repeated in a rule, they are parsed into
+// MultiTags. Other simple keywords are parsed into Tags.
+var repeatableTags = []string{"app-layer-protocol"}
+
+// Sid returns the sid of a rule, 0 if it is unset.
+func (r *Rule) Sid() int {
+	return r.SID
+}
+
+// Gid returns the gid of a rule, false if it is unset.
+func (r *Rule) Gid() (int, bool) {
+	return r.GID, r.GID > 0
+}
+
+// effectiveGID returns the gid of a rule, defaulting to 1 if it is unset.
+func (r *Rule) effectiveGID() int {
+	if r.GID == 0 {
+		return 1
+	}
+	return r.GID
+}
+
+// Rev returns the revision of a rule, false if it is unset.
+func (r *Rule) Rev() (int, bool) {
+	return r.Revision, r.Revision > 0
+}
+
+// File returns the name of the file the rule was parsed from, false if it is unknown.
+func (r *Rule) File() (string, bool) {
+	if r.Origin == nil || r.Origin.File == "" {
+		return "", false
+	}
+	return r.Origin.File, true
+}
+
+// Line returns the line number the rule was parsed from, false if it is unknown.
+func (r *Rule) Line() (int, bool) {
+	if r.Origin == nil || r.Origin.Line < 1 {
+		return 0, false
+	}
+	return r.Origin.Line, true
+}
+
+// BumpRevision increments the revision of a rule.
+func (r *Rule) BumpRevision() {
+	r.Revision++
+}
+
+// SetRevision sets the revision of a rule.
+func (r *Rule) SetRevision(n int) {
+	r.Revision = n
+}
+
+// modified is called by mutation helpers after changing a rule, and bumps its revision if
+// AutoBumpRevision is set.
+func (r *Rule) modified() {
+	if r.AutoBumpRevision {
+		r.BumpRevision()
+	}
+}
+
+// LenMatchers returns all *LenMatch for a rule.
+func (r *Rule) LenMatchers() []*LenMatch {
+	lms := make([]*LenMatch, 0, len(r.Matchers))
+	for _, m := range r.Matchers {
+		if lm, ok := m.(*LenMatch); ok {
+			lms = append(lms, lm)
+		}
+	}
+	return lms
+}
+
+// Contents returns all *Content for a rule.
+func (r *Rule) Contents() []*Content {
+	cs := make([]*Content, 0, len(r.Matchers))
+	for _, m := range r.Matchers {
+		if c, ok := m.(*Content); ok {
+			cs = append(cs, c)
+		}
+	}
+	return cs
+}
+
+// buffer returns the buffer a content applies to, either with a sticky buffer or with a content
+// modifier (e.g. http_uri).
+func (c *Content) buffer() DataPos {
+	for _, o := range c.Options {
+		if sticky, ok := cOptToStickyBuffer[o.Name]; ok {
+			return sticky
+		}
+	}
+	return c.DataPosition
+}
+
+// inBuffer returns true if a content applies to a buffer.
+func (c *Content) inBuffer(d DataPos) bool {
+	return c.buffer() == d
+}
+
+// ContentsForBuffer returns all *Content for a rule that apply to a buffer, in the order of
+// Matchers. Contents using a content modifier (e.g. http_uri) are returned for the matching sticky
+// buffer (e.g. http.uri).
+func (r *Rule) ContentsForBuffer(d DataPos) []*Content {
+	var cs []*Content
+	for _, c := range r.Contents() {
+		if c.inBuffer(d) {
+			cs = append(cs, c)
+		}
+	}
+	return cs
+}
+
+// HasContentInBuffer returns true if a rule has at least one *Content that applies to a buffer.
+func (r *Rule) HasContentInBuffer(d DataPos) bool {
+	for _, c := range r.Contents() {
+		if c.inBuffer(d) {
+			return true
+		}
+	}
+	return false
+}
+
+// LastContent returns the last *Content from Matchers
+func (r *Rule) LastContent() *Content {
+	for i := range r.Matchers {
+		if co, ok := r.Matchers[len(r.Matchers)-i-1].(*Content); ok {
+			return co
+		}
+	}
+	return nil
+}
+
+// isFastPattern returns true if a content is marked with fast_pattern or prefilter.
+func (c *Content) isFastPattern() bool {
+	return c.FastPattern.Enabled || c.Prefilter
+}
+
+// FastPatternBytes returns a copy of the bytes of the fast pattern of a rule: the part of the
+// content marked fast_pattern selected by its offset and length, or the longest content if none is
+// marked. Negated contents are never returned. It returns false if the rule has no such content.
+func (r *Rule) FastPatternBytes() ([]byte, bool) {
+	var longest *Content
+	for _, c := range r.Contents() {
+		if c.Negate {
+			continue
+		}
+		if c.isFastPattern() {
+			return append([]byte(nil), c.fastPatternBytes()...), true
+		}
+		if longest == nil || len(c.Pattern) > len(longest.Pattern) {
+			longest = c
+		}
+	}
+	if longest == nil {
+		return nil, false
+	}
+	return append([]byte(nil), longest.Pattern...), true
+}
+
+// ByteMatchers returns all *ByteMatch for a rule.
+func (r *Rule) ByteMatchers() []*ByteMatch {
+	bs := make([]*ByteMatch, 0, len(r.Matchers))
+	for _, m := range r.Matchers {
+		if b, ok := m.(*ByteMatch); ok {
+			bs = append(bs, b)
+		}
+	}
+	return bs
+}
+
+// PCREs returns all *PCRE for a rule.
+func (r *Rule) PCREs() []*PCRE {
+	var ps []*PCRE
+	for _, m := range r.Matchers {
+		if p, ok := m.(*PCRE); ok {
+			ps = append(ps, p)
+		}
+	}
+	return ps
+}
+
+// UnknownOptions returns all *UnknownOption for a rule, the keywords the parser did not support.
+func (r *Rule) UnknownOptions() []*UnknownOption {
+	var us []*UnknownOption
+	for _, m := range r.Matchers {
+		if u, ok := m.(*UnknownOption); ok {
+			us = append(us, u)
+		}
+	}
+	return us
+}
+
+// Transforms returns all *Transform for a rule.
+func (r *Rule) Transforms() []*Transform {
+	var ts []*Transform
+	for _, m := range r.Matchers {
+		if t, ok := m.(*Transform); ok {
+			ts = append(ts, t)
+		}
+	}
+	return ts
+}
+
+// TransformsForBuffer returns the names of all transforms applied to a buffer, in order.
+func (r *Rule) TransformsForBuffer(d DataPos) []string {
+	var ns []string
+	for _, t := range r.Transforms() {
+		if t.DataPosition == d {
+			ns = append(ns, t.Name)
+		}
+	}
+	return ns
+}
+
+// ContentTransforms returns the names of the transforms applied to the buffer of a content, in
+// order. Transforms apply to every match on a sticky buffer until the buffer changes, so only the
+// transforms of the buffer c is in are returned.
+func (r *Rule) ContentTransforms(c *Content) []string {
+	for i, m := range r.Matchers {
+		if m != c {
+			continue
+		}
+		// Walk back to the first matcher of the buffer.
+		start := i
+		for j := i - 1; j >= 0; j-- {
+			if d, ok := bufferSwitch(r.Matchers[j]); ok {
+				if d != c.DataPosition {
+					break
+				}
+				start = j
+			}
+		}
+		var ns []string
+		for _, t := range r.bufferTransforms(start) {
+			ns = append(ns, t.Name)
+		}
+		return ns
+	}
+	return nil
+}
+
+// bufferSwitch returns the buffer of a matcher written after a sticky buffer keyword, false if the
+// matcher does not change the buffer when written.
+func bufferSwitch(m orderedMatcher) (DataPos, bool) {
+	switch v := m.(type) {
+	case *Content:
+		return v.DataPosition, true
+	case *LenMatch:
+		return v.DataPosition, true
+	case *Transform:
+		return v.DataPosition, true
+	}
+	return pktData, false
+}
+
+// bufferTransforms returns the transforms of the buffer used by the matcher at position start,
+// until a matcher uses a different buffer.
+func (r *Rule) bufferTransforms(start int) []*Transform {
+	d, _ := bufferSwitch(r.Matchers[start])
+	var ts []*Transform
+	for _, m := range r.Matchers[start:] {
+		if md, ok := bufferSwitch(m); ok && md != d {
+			break
+		}
+		if t, ok := m.(*Transform); ok {
+			ts = append(ts, t)
+		}
+	}
+	return ts
+}
+
+func netString(netPart []string) string {
+	var s strings.Builder
+	if len(netPart) > 1 {
+		s.WriteString("[")
+	}
+	for i, n := range netPart {
+		s.WriteString(n)
+		if i < len(netPart)-1 {
+			s.WriteString(",")
+		}
+	}
+	if len(netPart) > 1 {
+		s.WriteString("]")
+	}
+	return s.String()
+}
+
+// String retunrs a string for a Network.
+func (n Network) String() string {
+	return fmt.Sprintf("%s %s", netString(n.Nets), netString(n.Ports))
+}
+
+// chopped returns true if the FastPattern uses the chop mode. Non zero Offset or Length imply it,
+// even if Chop is not set.
+func (f FastPattern) chopped() bool {
+	return f.Chop || f.Offset != 0 || f.Length != 0
+}
+
+// String returns a string for a FastPattern. An empty string is returned if the FastPattern is
+// disabled or invalid, use Validate to detect the latter.
+func (f FastPattern) String() string {
+	if !f.Enabled {
+		return ""
+	}
+	// This is an invalid state, it is reported by Validate.
+	if f.Only && f.chopped() {
+		return ""
+	}
+
+	var s strings.Builder
 	s.WriteString("fast_pattern")
 	if f.Only {
 		s.WriteString(":only;")
@@ -684,7 +1813,7 @@ func (f FastPattern) String() string {
 	}
 
 	// "only" and "chop" modes are mutually exclusive.
-	if f.Offset != 0 || f.Length != 0 {
+	if f.chopped() {
 		s.WriteString(fmt.Sprintf(":%d,%d", f.Offset, f.Length))
 	}
 
@@ -692,33 +1821,131 @@ func (f FastPattern) String() string {
 	return s.String()
 }
 
+// positionStrings returns the position options of a content as written in a rule, in canonical
+// order: offset, depth, distance and within.
+func (c Content) positionStrings() []string {
+	var ps []string
+	for _, name := range positionOptions {
+		if v := c.positionOption(name); v != nil {
+			ps = append(ps, fmt.Sprintf("%s:%s;", name, v))
+		}
+	}
+	return ps
+}
+
+// writtenCanonical returns true if the options of a content were parsed in canonical order:
+// modifiers first, followed by offset, depth, distance and within.
+func (c Content) writtenCanonical() bool {
+	last := -1
+	for _, name := range c.written {
+		i := indexOf(name, positionOptions)
+		if i < last || (i < 0 && last >= 0) {
+			return false
+		}
+		if i >= 0 {
+			last = i
+		}
+	}
+	return true
+}
+
+// optionStrings returns the modifiers and position options of a content as written in a rule. In
+// canonical order, modifiers are written in their original order followed by the position options.
+// Otherwise options are written in the order they were parsed, and options added since are written
+// after them in canonical order.
+func (c Content) optionStrings(canonical bool) []string {
+	var ss []string
+	used := make([]bool, len(c.Options))
+	done := make(map[string]bool)
+	if !canonical {
+		for _, name := range c.written {
+			if inSlice(name, positionOptions) {
+				if v := c.positionOption(name); v != nil && !done[name] {
+					ss = append(ss, fmt.Sprintf("%s:%s;", name, v))
+					done[name] = true
+				}
+				continue
+			}
+			for i, o := range c.Options {
+				if !used[i] && o.Name == name {
+					ss = append(ss, o.String())
+					used[i] = true
+					break
+				}
+			}
+		}
+	}
+	for i, o := range c.Options {
+		if !used[i] {
+			ss = append(ss, o.String())
+		}
+	}
+	for _, name := range positionOptions {
+		if v := c.positionOption(name); v != nil && !done[name] {
+			ss = append(ss, fmt.Sprintf("%s:%s;", name, v))
+		}
+	}
+	return ss
+}
+
 // String returns a string for a ContentOption.
 func (co ContentOption) String() string {
-	if inSlice(co.Name, []string{"depth", "distance", "offset", "within"}) {
+	if inSlice(co.Name, positionOptions) {
 		return fmt.Sprintf("%s:%v;", co.Name, co.Value)
 	}
 	return fmt.Sprintf("%s;", co.Name)
 }
 
+// Validate returns an error if the name of a ContentOption is not known, if it is a position
+// option (e.g. offset) that must be set in the typed field of the content, or if a content
+// modifier (e.g. http_uri) has a value.
+func (co ContentOption) Validate() error {
+	switch {
+	case inSlice(co.Name, positionOptions):
+		return fmt.Errorf("content option %s is set in Options instead of its typed field", co.Name)
+	case inSlice(co.Name, contentModifiers):
+		if co.Value != "" {
+			return fmt.Errorf("content option %s does not take a value, got %q", co.Name, co.Value)
+		}
+	default:
+		return fmt.Errorf("unknown content option %q", co.Name)
+	}
+	return nil
+}
+
 // String returns a string for a Reference.
 func (r Reference) String() string {
 	return fmt.Sprintf("reference:%s,%s;", r.Type, r.Value)
 }
 
-// String returns a string for a Content (ignoring sticky buffers.)
+// String returns a string for a Content (ignoring sticky buffers), with options in canonical order:
+// content modifiers (e.g. http_uri) in their original order, followed by offset, depth, distance
+// and within, and nocase and fast_pattern last. Use Rule.StringOpts without CanonicalOptionOrder to
+// write them in the order they were parsed.
 func (c Content) String() string {
+	return c.string(true)
+}
+
+// string returns a string for a Content, with options in canonical order if canonical is true.
+func (c Content) string(canonical bool) string {
 	var s strings.Builder
 	s.WriteString("content:")
 	if c.Negate {
 		s.WriteString("!")
 	}
 	s.WriteString(fmt.Sprintf(`"%s";`, c.FormatPattern()))
-	for _, o := range c.Options {
+	for _, o := range c.optionStrings(canonical) {
 		s.WriteString(fmt.Sprintf(" %s", o))
 	}
+	if c.Nocase {
+		s.WriteString(" nocase;")
+	}
 	if c.FastPattern.Enabled {
 		s.WriteString(fmt.Sprintf(" %s", c.FastPattern))
 	}
+	if c.Prefilter {
+		s.WriteString(" prefilter;")
+	}
 
 	return s.String()
 }
@@ -740,6 +1967,59 @@ func (b ByteMatch) base64DecodeString() string {
 	return fmt.Sprintf("%s:%s;", byteMatchTypeVals[b.Kind], strings.Join(parts, ","))
 }
 
+// byteMathString returns a string for a byte_math ByteMatch.
+func (b ByteMatch) byteMathString() string {
+	parts := []string{
+		fmt.Sprintf("bytes %s", b.NumBytes),
+		fmt.Sprintf("offset %d", b.Offset),
+		fmt.Sprintf("oper %s", b.Operator),
+		fmt.Sprintf("rvalue %s", b.Value),
+		fmt.Sprintf("result %s", b.Variable),
+	}
+	parts = append(parts, b.Options...)
+	return fmt.Sprintf("%s:%s;", byteMatchTypeVals[b.Kind], strings.Join(parts, ", "))
+}
+
+// typedOptions returns the typed options of a ByteMatch, in the order specified by the Suricata
+// documentation.
+func (b ByteMatch) typedOptions() []string {
+	var opts []string
+	if b.Relative {
+		opts = append(opts, "relative")
+	}
+	if b.Multiplier != 0 {
+		opts = append(opts, fmt.Sprintf("multiplier %d", b.Multiplier))
+	}
+	if b.Endianness != "" {
+		opts = append(opts, b.Endianness)
+	}
+	if b.StringMode {
+		opts = append(opts, "string")
+	}
+	if b.Base != "" {
+		opts = append(opts, b.Base)
+	}
+	if b.Align {
+		opts = append(opts, "align")
+	}
+	if b.FromBeginning {
+		opts = append(opts, "from_beginning")
+	}
+	if b.FromEnd {
+		opts = append(opts, "from_end")
+	}
+	if b.PostOffset != 0 {
+		opts = append(opts, fmt.Sprintf("post_offset %d", b.PostOffset))
+	}
+	if b.DCE {
+		opts = append(opts, "dce")
+	}
+	if b.Bitmask != "" {
+		opts = append(opts, fmt.Sprintf("bitmask %s", b.Bitmask))
+	}
+	return opts
+}
+
 // String returns a string for a ByteMatch.
 func (b ByteMatch) String() string {
 	// TODO: Support dataPos?
@@ -753,7 +2033,11 @@ func (b ByteMatch) String() string {
 	case bJump:
 		s.WriteString(fmt.Sprintf("%s,%d", b.NumBytes, b.Offset))
 	case bTest:
-		s.WriteString(fmt.Sprintf("%s,%s,%s,%d", b.NumBytes, b.Operator, b.Value, b.Offset))
+		s.WriteString(fmt.Sprintf("%s,", b.NumBytes))
+		if b.Negate {
+			s.WriteString("!")
+		}
+		s.WriteString(fmt.Sprintf("%s,%s,%d", b.Operator, b.Value, b.Offset))
 	case isDataAt:
 		if b.Negate {
 			s.WriteString("!")
@@ -762,6 +2046,11 @@ func (b ByteMatch) String() string {
 	// Logic for this case is a bit different so it's handled outside.
 	case b64Decode:
 		return b.base64DecodeString()
+	case bMath:
+		return b.byteMathString()
+	}
+	for _, o := range b.typedOptions() {
+		s.WriteString(fmt.Sprintf(",%s", o))
 	}
 	for _, o := range b.Options {
 		s.WriteString(fmt.Sprintf(",%s", o))
@@ -774,6 +2063,9 @@ func (b ByteMatch) String() string {
 func (i LenMatch) String() string {
 	var s strings.Builder
 	s.WriteString(fmt.Sprintf("%s:", i.Kind))
+	if i.Negate {
+		s.WriteString("!")
+	}
 	switch {
 	case i.Operator == "<>":
 		s.WriteString(fmt.Sprintf("%d%s%d", i.Min, i.Operator, i.Max))
@@ -786,15 +2078,85 @@ func (i LenMatch) String() string {
 		s.WriteString(fmt.Sprintf(",%s", o))
 	}
 	s.WriteString(";")
+	if i.Prefilter {
+		s.WriteString(" prefilter;")
+	}
+	return s.String()
+}
+
+// String returns a string for an IPProto.
+func (p IPProto) String() string {
+	if p.Negate {
+		return fmt.Sprintf("ip_proto:!%s;", p.Proto)
+	}
+	return fmt.Sprintf("ip_proto:%s%s;", p.Operator, p.Proto)
+}
+
+// String returns a string for a FileMatch.
+func (f FileMatch) String() string {
+	var s strings.Builder
+	s.WriteString(fmt.Sprintf("%s:", f.Keyword))
+	if f.Negate {
+		s.WriteString("!")
+	}
+	s.WriteString(fmt.Sprintf(`"%s";`, f.Value))
+	if f.Nocase {
+		s.WriteString(" nocase;")
+	}
+	return s.String()
+}
+
+// String returns a string for a Filestore.
+func (f Filestore) String() string {
+	switch {
+	case f.Scope != "":
+		return fmt.Sprintf("filestore:%s,%s;", f.Direction, f.Scope)
+	case f.Direction != "":
+		return fmt.Sprintf("filestore:%s;", f.Direction)
+	}
+	return "filestore;"
+}
+
+// String returns a string for a Lua.
+func (l Lua) String() string {
+	var s strings.Builder
+	s.WriteString(fmt.Sprintf("%s:", l.Keyword))
+	if l.Negate {
+		s.WriteString("!")
+	}
+	s.WriteString(fmt.Sprintf("%s;", l.Script))
 	return s.String()
 }
 
-// String returns a string for all of the metadata values.
+// sorted returns a copy of the metadata sorted by key, then value.
+func (ms Metadatas) sorted() Metadatas {
+	s := make(Metadatas, len(ms))
+	copy(s, ms)
+	sort.SliceStable(s, func(i, j int) bool {
+		if s[i].Key != s[j].Key {
+			return s[i].Key < s[j].Key
+		}
+		return s[i].Value < s[j].Value
+	})
+	return s
+}
+
+// String returns a string for all of the metadata values, in the order they were parsed or added.
+// Use Rule.StringOpts with SortMetadata, or Rule.SortMetadata, to write them sorted.
 func (ms Metadatas) String() string {
+	return ms.string(false)
+}
+
+// string returns a string for all of the metadata values, sorted by key and value if canonical is
+// true.
+func (ms Metadatas) string(canonical bool) string {
 	var s strings.Builder
 	if len(ms) < 1 {
 		return ""
 	}
+	if canonical {
+		ms = ms.sorted()
+	}
 	s.WriteString("metadata:")
 	for i, m := range ms {
 		if i < len(ms)-1 {
@@ -842,7 +2204,11 @@ func (p PCRE) String() string {
 	if p.Negate {
 		s.WriteString("!")
 	}
-	s.WriteString(fmt.Sprintf(`"/%s/%s";`, pattern, p.Options))
+	s.WriteString(fmt.Sprintf(`"/%s/%s`, pattern, p.Options))
+	for _, v := range p.Vars {
+		s.WriteString(fmt.Sprintf(", %s", v))
+	}
+	s.WriteString(`";`)
 	return s.String()
 }
 
@@ -885,11 +2251,134 @@ func (xb Xbit) String() string {
 	return s.String()
 }
 
-// String returns a string for a rule.
+// Disable disables a rule, it is written commented out with a single leading "#". Calling Disable
+// on a disabled rule has no effect.
+func (r *Rule) Disable() {
+	r.Disabled = true
+}
+
+// Enable enables a rule. Calling Enable on an enabled rule has no effect.
+func (r *Rule) Enable() {
+	r.Disabled = false
+}
+
+// ruleActions are the actions of a rule supported by Suricata.
+var ruleActions = []string{"alert", "pass", "drop", "reject", "rejectsrc", "rejectdst", "rejectboth"}
+
+// blockingActions are the actions blocking traffic when running inline.
+var blockingActions = []string{"drop", "reject", "rejectsrc", "rejectdst", "rejectboth"}
+
+// SetAction sets the action of a rule, and returns an error if it is not supported (alert, pass,
+// drop, reject, rejectsrc, rejectdst or rejectboth).
+func (r *Rule) SetAction(a string) error {
+	if !inSlice(a, ruleActions) {
+		return fmt.Errorf("unsupported action %q", a)
+	}
+	if r.Action != a {
+		r.Action = a
+		r.modified()
+	}
+	return nil
+}
+
+// IsBlocking returns true if the rule blocks traffic when running inline (drop and the reject
+// actions), false for alert-only and pass rules.
+func (r *Rule) IsBlocking() bool {
+	return inSlice(r.Action, blockingActions)
+}
+
+// IsNoAlert returns true if the rule does not generate alerts, because of the noalert keyword or
+// flowbits:noalert. These are typically helper rules setting flowbits for other rules.
+func (r *Rule) IsNoAlert() bool {
+	if r.NoAlert {
+		return true
+	}
+	for _, fb := range r.Flowbits {
+		if fb.Action == "noalert" {
+			return true
+		}
+	}
+	return false
+}
+
+// RenderOptions controls how StringOpts writes a rule.
+type RenderOptions struct {
+	// IncludeDisabledComment writes disabled rules commented out with DisabledPrefix. Otherwise
+	// disabled rules are written like enabled rules.
+	IncludeDisabledComment bool
+	// DisabledPrefix is written before disabled rules, "#" if empty.
+	DisabledPrefix string
+	// SortTags writes tags sorted by keyword, otherwise they are written in the conventional order
+	// of tagKeywords. flow is always written first.
+	SortTags bool
+	// SortMetadata writes metadata sorted by key, then value, otherwise it is written in the order
+	// it was parsed or added.
+	SortMetadata bool
+	// DottedBuffers writes Suricata 4 sticky buffers with their Suricata 5 name (e.g. dns_query is
+	// written as dns.query).
+	DottedBuffers bool
+	// CanonicalOptionOrder writes the options of contents in canonical order: modifiers (e.g.
+	// http_uri), followed by offset, depth, distance and within. Otherwise they are written in the
+	// order they were parsed. nocase and fast_pattern are always written last.
+	CanonicalOptionOrder bool
+}
+
+// DefaultRenderOptions returns the options used by String: disabled rules are commented out,
+// content options are written in canonical order, and tags are sorted alphabetically by keyword.
+// They do not depend on package state.
+func DefaultRenderOptions() RenderOptions {
+	return RenderOptions{
+		IncludeDisabledComment: true,
+		CanonicalOptionOrder:   true,
+		SortTags:               true,
+	}
+}
+
+// buffer returns the name of a sticky buffer.
+func (o RenderOptions) buffer(d DataPos) string {
+	if v, ok := suri4StickyTo5Sticky[d]; ok && o.DottedBuffers {
+		return v.String()
+	}
+	return d.String()
+}
+
+// sortTags sorts tag keywords as specified by SortTags.
+func (o RenderOptions) sortTags(tags []string) {
+	if o.SortTags {
+		sort.Strings(tags)
+		return
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		ki, kj := indexOf(tags[i], tagKeywords), indexOf(tags[j], tagKeywords)
+		// Keywords not in tagKeywords are written last.
+		if ki < 0 {
+			ki = len(tagKeywords)
+		}
+		if kj < 0 {
+			kj = len(tagKeywords)
+		}
+		if ki != kj {
+			return ki < kj
+		}
+		return tags[i] < tags[j]
+	})
+}
+
+// String returns a string for a rule. Settings that cannot be written are omitted, use Validate to
+// detect them before writing a rule.
 func (r Rule) String() string {
+	return r.StringOpts(DefaultRenderOptions())
+}
+
+// StringOpts returns a string for a rule, written as specified by opts.
+func (r Rule) StringOpts(opts RenderOptions) string {
 	var s strings.Builder
-	if r.Disabled {
-		s.WriteString("#")
+	if r.Disabled && opts.IncludeDisabledComment {
+		prefix := opts.DisabledPrefix
+		if prefix == "" {
+			prefix = "#"
+		}
+		s.WriteString(prefix)
 	}
 	s.WriteString(fmt.Sprintf("%s %s %s ", r.Action, r.Protocol, r.Source))
 	if !r.Bidirectional {
@@ -903,23 +2392,34 @@ func (r Rule) String() string {
 	// Pull flow out of tags if it exists, we like flow at the beginning of rules.
 	if v, ok := r.Tags["flow"]; ok {
 		s.WriteString(fmt.Sprintf("flow:%s; ", v))
+		if r.PrefilterTag == "flow" {
+			s.WriteString("prefilter; ")
+		}
+	}
+	for _, v := range r.MultiTags["flow"] {
+		s.WriteString(fmt.Sprintf("flow:%s; ", v))
 	}
 
 	// Write out matchers in order (because things can be relative.)
+	// Transforms are written right after the sticky buffer they apply to.
 	if len(r.Matchers) > 0 {
 		d := pktData
-		for _, m := range r.Matchers {
-			if c, ok := m.(*Content); ok {
-				if d != c.DataPosition {
-					d = c.DataPosition
-					s.WriteString(fmt.Sprintf("%s; ", d))
+		written := make(map[*Transform]bool)
+		for i, m := range r.Matchers {
+			if pos, ok := bufferSwitch(m); ok && d != pos {
+				d = pos
+				s.WriteString(fmt.Sprintf("%s; ", opts.buffer(d)))
+				for _, t := range r.bufferTransforms(i) {
+					s.WriteString(fmt.Sprintf("%s ", t))
+					written[t] = true
 				}
 			}
-			if c, ok := m.(*LenMatch); ok {
-				if d != c.DataPosition {
-					d = c.DataPosition
-					s.WriteString(fmt.Sprintf("%s; ", d))
-				}
+			if t, ok := m.(*Transform); ok && written[t] {
+				continue
+			}
+			if c, ok := m.(*Content); ok {
+				s.WriteString(fmt.Sprintf("%s ", c.string(opts.CanonicalOptionOrder)))
+				continue
 			}
 			s.WriteString(fmt.Sprintf("%s ", m))
 		}
@@ -936,20 +2436,77 @@ func (r Rule) String() string {
 	}
 
 	if len(r.Metas) > 0 {
-		s.WriteString(fmt.Sprintf("%s ", r.Metas))
+		s.WriteString(fmt.Sprintf("%s ", r.Metas.string(opts.SortMetadata)))
 	}
 
-	for k, v := range r.Tags {
+	// Tags are written in sorted order so the output is stable. Repeated values of MultiTags are
+	// written in order.
+	typed := r.typedTags()
+	tags := make([]string, 0, len(typed)+len(r.Tags)+len(r.MultiTags))
+	for _, t := range typed {
+		tags = append(tags, t[0])
+	}
+	for k := range r.Tags {
 		if k == "flow" {
 			continue
 		}
-		s.WriteString(fmt.Sprintf("%s:%s; ", k, v))
+		tags = append(tags, k)
+	}
+	for k := range r.MultiTags {
+		if _, ok := r.Tags[k]; !ok && k != "flow" {
+			tags = append(tags, k)
+		}
+	}
+	opts.sortTags(tags)
+	for _, k := range tags {
+		for _, t := range typed {
+			if t[0] == k {
+				s.WriteString(fmt.Sprintf("%s:%s; ", k, t[1]))
+			}
+		}
+		if v, ok := r.Tags[k]; ok {
+			s.WriteString(fmt.Sprintf("%s:%s; ", k, v))
+			if k == r.PrefilterTag {
+				s.WriteString("prefilter; ")
+			}
+		}
+		for _, v := range r.MultiTags[k] {
+			s.WriteString(fmt.Sprintf("%s:%s; ", k, v))
+		}
+	}
+
+	for _, p := range r.IPProto {
+		s.WriteString(fmt.Sprintf("%s ", p))
+	}
+
+	if r.GeoIP != nil {
+		s.WriteString(fmt.Sprintf("%s ", r.GeoIP))
+	}
+
+	for _, i := range r.IPRep {
+		s.WriteString(fmt.Sprintf("%s ", i))
+	}
+
+	if r.DCEIface != nil {
+		s.WriteString(fmt.Sprintf("%s ", r.DCEIface))
+	}
+
+	if len(r.DCEOpnums) > 0 {
+		s.WriteString(fmt.Sprintf("%s ", dceOpnumString(r.DCEOpnums)))
+	}
+
+	if r.SameIP {
+		s.WriteString("sameip; ")
 	}
 
 	for _, v := range r.Statements {
 		s.WriteString(fmt.Sprintf("%s; ", v))
 	}
 
+	if r.NoAlert {
+		s.WriteString("noalert; ")
+	}
+
 	for _, fb := range r.Flowbits {
 		s.WriteString(fmt.Sprintf("%s ", fb))
 	}
@@ -958,6 +2515,18 @@ func (r Rule) String() string {
 		s.WriteString(fmt.Sprintf("%s ", fi))
 	}
 
+	for _, l := range r.Lua {
+		s.WriteString(fmt.Sprintf("%s ", l))
+	}
+
+	for _, f := range r.FileMatches {
+		s.WriteString(fmt.Sprintf("%s ", f))
+	}
+
+	if r.Filestore != nil {
+		s.WriteString(fmt.Sprintf("%s ", r.Filestore))
+	}
+
 	for _, xb := range r.Xbits {
 		s.WriteString(fmt.Sprintf("%s ", xb))
 	}
@@ -966,14 +2535,150 @@ func (r Rule) String() string {
 		s.WriteString(fmt.Sprintf("%s ", ref))
 	}
 
+	if r.GID != 0 {
+		s.WriteString(fmt.Sprintf("gid:%d; ", r.GID))
+	}
 	s.WriteString(fmt.Sprintf("sid:%d; rev:%d;)", r.SID, r.Revision))
 	return s.String()
 
 }
 
+// NocaseStyle describes how a regexp for a nocase content is made case insensitive.
+type NocaseStyle int
+
+const (
+	// NocaseFlag prefixes the regexp with the (?i) flag.
+	NocaseFlag NocaseStyle = iota
+	// NocaseClasses replaces each letter with a character class (e.g. [aA]), for regexp engines
+	// that do not support flags.
+	NocaseClasses
+)
+
+// IntOrVar is the value of an option that is either an integer, or a variable (e.g. set with
+// byte_extract).
+type IntOrVar struct {
+	// Int is the integer value, when Var is empty.
+	Int int
+	// Var is the name of the variable.
+	Var string
+}
+
+// varNameRE matches a valid variable name.
+var varNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// ParseIntOrVar parses an integer or a variable name.
+func ParseIntOrVar(s string) (IntOrVar, error) {
+	s = strings.TrimSpace(s)
+	if i, err := strconv.Atoi(s); err == nil {
+		return IntOrVar{Int: i}, nil
+	}
+	if varNameRE.MatchString(s) {
+		return IntOrVar{Var: s}, nil
+	}
+	return IntOrVar{}, fmt.Errorf("%q is not an integer or a variable", s)
+}
+
+// String returns a string for an IntOrVar.
+func (v IntOrVar) String() string {
+	if v.Var != "" {
+		return v.Var
+	}
+	return strconv.Itoa(v.Int)
+}
+
+// MarshalText returns an IntOrVar as written in a rule.
+func (v IntOrVar) MarshalText() ([]byte, error) {
+	return []byte(v.String()), nil
+}
+
+// UnmarshalText sets an IntOrVar from an integer or a variable name.
+func (v *IntOrVar) UnmarshalText(text []byte) error {
+	p, err := ParseIntOrVar(string(text))
+	if err != nil {
+		return err
+	}
+	*v = p
+	return nil
+}
+
+// positionOption returns the value of a position option of a content (offset, depth, distance or
+// within), nil if it is not set.
+func (c *Content) positionOption(name string) *IntOrVar {
+	switch name {
+	case "offset":
+		return c.Offset
+	case "depth":
+		return c.Depth
+	case "distance":
+		return c.Distance
+	case "within":
+		return c.Within
+	}
+	return nil
+}
+
+// setPositionOption sets the value of a position option of a content, v is nil to unset it.
+func (c *Content) setPositionOption(name string, v *IntOrVar) {
+	switch name {
+	case "offset":
+		c.Offset = v
+	case "depth":
+		c.Depth = v
+	case "distance":
+		c.Distance = v
+	case "within":
+		c.Within = v
+	}
+}
+
+// positionOptions are the content options setting the position of a match, in canonical order.
+var positionOptions = []string{"offset", "depth", "distance", "within"}
+
+// contentModifiers are the content options without a value (e.g. http_uri).
+var contentModifiers = []string{"http_cookie", "http_raw_cookie", "http_method", "http_header", "http_raw_header",
+	"http_uri", "http_raw_uri", "http_user_agent", "http_stat_code", "http_stat_msg",
+	"http_client_body", "http_server_body", "http_host", "rawbytes", "startswith", "endswith"}
+
+// indexOf returns the index of s in ss, or -1.
+func indexOf(s string, ss []string) int {
+	for i, v := range ss {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// RemoveOption removes all options with the given name from a content.
+func (c *Content) RemoveOption(name string) {
+	var opts []*ContentOption
+	for _, o := range c.Options {
+		if o.Name != name {
+			opts = append(opts, o)
+		}
+	}
+	c.Options = opts
+}
+
+// hasOption returns true if the content has an option with the given name.
+func (c *Content) hasOption(name string) bool {
+	for _, o := range c.Options {
+		if o.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 // ToRegexp returns a string that can be used as a regular expression
 // to identify content matches in an ASCII dump of a packet capture (tcpdump -A).
+// A nocase content returns a case insensitive regexp using the (?i) flag.
 func (c *Content) ToRegexp() string {
+	return c.ToRegexpWithStyle(NocaseFlag)
+}
+
+// ToRegexpWithStyle is like ToRegexp, style specifies how a nocase content is made case insensitive.
+func (c *Content) ToRegexpWithStyle(style NocaseStyle) string {
 	var buffer bytes.Buffer
 	for _, b := range c.Pattern {
 		if b > 126 || b < 32 {
@@ -982,15 +2687,70 @@ func (c *Content) ToRegexp() string {
 			buffer.WriteByte(b)
 		}
 	}
-	return regexp.QuoteMeta(buffer.String())
+	re := regexp.QuoteMeta(buffer.String())
+	if !c.Nocase {
+		return re
+	}
+	if style == NocaseClasses {
+		var s strings.Builder
+		for _, r := range re {
+			if l, u := unicode.ToLower(r), unicode.ToUpper(r); l != u {
+				s.WriteString(fmt.Sprintf("[%c%c]", l, u))
+			} else {
+				s.WriteRune(r)
+			}
+		}
+		return s.String()
+	}
+	return "(?i)" + re
+}
+
+// NewContent returns a content matching the raw bytes of pattern, with the given options. The
+// pattern is escaped by FormatPattern when the content is written in a rule. A nocase option sets
+// Nocase, and a valid position option (e.g. depth) sets its typed field.
+func NewContent(pattern []byte, opts ...ContentOption) *Content {
+	c := &Content{}
+	c.SetPattern(pattern)
+	for _, o := range opts {
+		if o.Name == "nocase" {
+			c.Nocase = true
+			continue
+		}
+		if inSlice(o.Name, positionOptions) {
+			if v, err := ParseIntOrVar(o.Value); err == nil {
+				c.setPositionOption(o.Name, &v)
+				continue
+			}
+		}
+		o := o
+		c.Options = append(c.Options, &o)
+	}
+	return c
+}
+
+// SetPattern sets the raw bytes matched by a content. The pattern is copied.
+func (c *Content) SetPattern(pattern []byte) {
+	c.Pattern = append([]byte(nil), pattern...)
+}
+
+// hexPatternByte returns true if a byte must be hex encoded in a content. This is every byte
+// outside of 0x23-0x7E (control characters, DEL, non-ASCII, '!' and '"'), and the characters
+// with a meaning in a rule (':', ';', '|' and '\\'). A space is only written as is outside of a
+// hex section.
+func hexPatternByte(b byte) bool {
+	return b < '#' || b > '~' || b == ':' || b == ';' || b == '|' || b == '\\'
 }
 
-// FormatPattern returns a string for a Pattern in a content
+// FormatPattern returns a string for a Pattern in a content. Bytes are hex encoded as needed, so
+// the output is always a valid content that parses back to the same Pattern.
 func (c *Content) FormatPattern() string {
 	var buffer bytes.Buffer
 	pipe := false
-	for _, b := range c.Pattern {
-		if b != ' ' && (b > 126 || b < 35 || b == ':' || b == ';' || b == '|') {
+	for i, b := range c.Pattern {
+		// Spaces between two hex encoded bytes stay in the hex section.
+		next := bytes.TrimLeft(c.Pattern[i:], " ")
+		inHex := b == ' ' && pipe && len(next) > 0 && hexPatternByte(next[0])
+		if inHex || (b != ' ' && hexPatternByte(b)) {
 			if !pipe {
 				buffer.WriteByte('|')
 				pipe = true
@@ -1024,9 +2784,168 @@ func (r *Rule) InsertMatcher(m orderedMatcher, pos int) error {
 	r.Matchers = append(r.Matchers, &Content{})
 	copy(r.Matchers[pos+1:], r.Matchers[pos:])
 	r.Matchers[pos] = m
+	r.modified()
+	return nil
+}
+
+// isRelative returns true if a matcher is relative to the previous one.
+func isRelative(m orderedMatcher) bool {
+	switch v := m.(type) {
+	case *Content:
+		return v.relative()
+	case *PCRE:
+		return v.IsRelative()
+	case *ByteMatch:
+		return v.Relative || inSlice("relative", v.Options)
+	case *LenMatch:
+		return inSlice("relative", v.Options)
+	}
+	return false
+}
+
+// removeRelative removes the options making a matcher relative to the previous one.
+func removeRelative(m orderedMatcher) {
+	switch v := m.(type) {
+	case *Content:
+		v.Distance, v.Within = nil, nil
+	case *PCRE:
+		v.Options = bytes.Replace(v.Options, []byte("R"), nil, -1)
+	case *ByteMatch:
+		v.Relative = false
+		v.Options = removeString(v.Options, "relative")
+	case *LenMatch:
+		v.Options = removeString(v.Options, "relative")
+	}
+}
+
+// removeString returns ss without any occurrence of s.
+func removeString(ss []string, s string) []string {
+	var out []string
+	for _, v := range ss {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// DeleteMatcher removes the matcher at a position. If the following matcher is relative to the
+// deleted one, its relative options (e.g. distance, within, relative) are removed when
+// stripRelative is true. Otherwise an error is returned and the rule is not modified, as is the case
+// when deleting a byte_extract or byte_math whose variable is used by another matcher.
+func (r *Rule) DeleteMatcher(pos int, stripRelative bool) error {
+	if pos < 0 || pos >= len(r.Matchers) {
+		return fmt.Errorf("cannot delete matcher, position %d out of range [0,%d)", pos, len(r.Matchers))
+	}
+
+	if b, ok := r.Matchers[pos].(*ByteMatch); ok && b.Variable != "" {
+		for i, m := range r.Matchers {
+			if i != pos && usesVar(m, b.Variable) {
+				return fmt.Errorf("cannot delete matcher, variable %s is used by matcher %d", b.Variable, i)
+			}
+		}
+	}
+
+	if pos+1 < len(r.Matchers) && isRelative(r.Matchers[pos+1]) {
+		if !stripRelative {
+			return fmt.Errorf("cannot delete matcher, matcher %d is relative to it", pos+1)
+		}
+		removeRelative(r.Matchers[pos+1])
+	}
+
+	copy(r.Matchers[pos:], r.Matchers[pos+1:])
+	r.Matchers[len(r.Matchers)-1] = nil
+	r.Matchers = r.Matchers[:len(r.Matchers)-1]
+	r.modified()
 	return nil
 }
 
+// usesVar returns true if a matcher uses a variable extracted by byte_extract or byte_math.
+func usesVar(m orderedMatcher, name string) bool {
+	return inSlice(name, variableRefs(m))
+}
+
+// AddContent appends a content to the matchers of a rule. Matchers is the only storage for
+// contents, pcres, byte and length matches, and Contents(), PCREs(), ByteMatchers() and
+// LenMatchers() are views of it, so appending with AddContent, AddPCRE, AddByteMatch or AddLenMatch
+// keeps all of them consistent and ordered.
+func (r *Rule) AddContent(c *Content) {
+	r.Matchers = append(r.Matchers, c)
+	r.modified()
+}
+
+// AddPCRE appends a pcre to the matchers of a rule.
+func (r *Rule) AddPCRE(p *PCRE) {
+	r.Matchers = append(r.Matchers, p)
+	r.modified()
+}
+
+// AddByteMatch appends a byte match to the matchers of a rule.
+func (r *Rule) AddByteMatch(b *ByteMatch) {
+	r.Matchers = append(r.Matchers, b)
+	r.modified()
+}
+
+// AddLenMatch appends a length match to the matchers of a rule.
+func (r *Rule) AddLenMatch(l *LenMatch) {
+	r.Matchers = append(r.Matchers, l)
+	r.modified()
+}
+
+// ReplaceContent replaces every occurrence of old in the patterns of the contents of a rule with
+// new, and returns the number of replacements. Occurrences are matched ignoring ASCII case in
+// nocase contents. The options and data position of the contents are kept, and bytes of new that
+// must be escaped (e.g. ";" or binary data) are written in hex by String. A fast_pattern offset
+// and length past the end of the new pattern are cleared, so the whole content is the fast
+// pattern. Positional options are not adjusted to the length of the new pattern, use Validate to
+// detect a depth or within that became shorter than the pattern. Nothing is replaced and 0 is
+// returned if old is empty, or if new is empty as a content cannot have an empty pattern.
+func (r *Rule) ReplaceContent(old, new []byte) int {
+	if len(old) == 0 || len(new) == 0 {
+		return 0
+	}
+	var total int
+	var changed bool
+	for _, c := range r.Contents() {
+		p, n := replacePattern(c.Pattern, old, new, c.Nocase)
+		if n == 0 {
+			continue
+		}
+		total += n
+		if bytes.Equal(p, c.Pattern) {
+			continue
+		}
+		c.Pattern = p
+		changed = true
+		if f := &c.FastPattern; f.Offset+f.Length > len(p) {
+			f.Chop, f.Offset, f.Length = false, 0, 0
+		}
+	}
+	if changed {
+		r.modified()
+	}
+	return total
+}
+
+// replacePattern returns a copy of p with the occurrences of old replaced with new, ignoring ASCII
+// case if nocase is true, and the number of replacements.
+func replacePattern(p, old, new []byte, nocase bool) ([]byte, int) {
+	o := &Content{Pattern: old, Nocase: nocase}
+	var out []byte
+	var n int
+	for i := 0; i < len(p); {
+		if i+len(old) <= len(p) && o.equalAt(p, i) {
+			out = append(out, new...)
+			i += len(old)
+			n++
+			continue
+		}
+		out = append(out, p[i])
+		i++
+	}
+	return out, n
+}
+
 // HasVar returns true if a variable with the provided name exists.
 func (r *Rule) HasVar(s string) bool {
 	for _, m := range r.Matchers {
@@ -1048,3 +2967,180 @@ func (r *Rule) GetSidMsg() string {
 	}
 	return sidmsg.String()
 }
+
+// hasReference returns true if the rule already has an identical reference.
+func (r *Rule) hasReference(ref *Reference) bool {
+	for _, rr := range r.References {
+		if rr.Type == ref.Type && rr.Value == ref.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// Metadata returns all values of the metadata key, in order.
+func (r *Rule) Metadata(key string) []string {
+	var vs []string
+	for _, m := range r.Metas {
+		if m.Key == key {
+			vs = append(vs, m.Value)
+		}
+	}
+	return vs
+}
+
+// AddMetadata appends a value to the metadata key, unless the rule already has it. Use this for
+// keys that can repeat (e.g. mitre_technique_id).
+func (r *Rule) AddMetadata(key, value string) {
+	m := &Metadata{Key: key, Value: value}
+	if !r.hasMetadata(m) {
+		r.Metas = append(r.Metas, m)
+		r.modified()
+	}
+}
+
+// SetMetadata replaces all values of the metadata key with value. The metadata keeps the position
+// of the first existing value of the key, or is appended if the key is not present.
+func (r *Rule) SetMetadata(key, value string) {
+	if vs := r.Metadata(key); len(vs) == 1 && vs[0] == value {
+		return
+	}
+	var metas Metadatas
+	set := false
+	for _, m := range r.Metas {
+		if m.Key != key {
+			metas = append(metas, m)
+			continue
+		}
+		if !set {
+			metas = append(metas, &Metadata{Key: key, Value: value})
+			set = true
+		}
+	}
+	if !set {
+		metas = append(metas, &Metadata{Key: key, Value: value})
+	}
+	r.Metas = metas
+	r.modified()
+}
+
+// MergeMetadata adds the metadata in kv to the rule, for bulk enrichment. If overwrite is true,
+// existing values of a key are replaced as in SetMetadata, otherwise the value is appended as in
+// AddMetadata. Existing metadata keeps its order, and new keys are appended sorted by key.
+func (r *Rule) MergeMetadata(kv map[string]string, overwrite bool) {
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	changed := false
+	for _, k := range keys {
+		m := &Metadata{Key: k, Value: kv[k]}
+		vs := r.Metadata(k)
+		switch {
+		case len(vs) == 1 && vs[0] == m.Value, !overwrite && r.hasMetadata(m):
+			continue
+		case overwrite && len(vs) > 0:
+			var metas Metadatas
+			for _, mm := range r.Metas {
+				if mm.Key != k {
+					metas = append(metas, mm)
+				} else if m != nil {
+					metas = append(metas, m)
+					m = nil
+				}
+			}
+			r.Metas = metas
+		default:
+			r.Metas = append(r.Metas, m)
+		}
+		changed = true
+	}
+	if changed {
+		r.modified()
+	}
+}
+
+// DeleteMetadata removes all values of the metadata key.
+func (r *Rule) DeleteMetadata(key string) {
+	var metas Metadatas
+	for _, m := range r.Metas {
+		if m.Key != key {
+			metas = append(metas, m)
+		}
+	}
+	if len(metas) != len(r.Metas) {
+		r.modified()
+	}
+	r.Metas = metas
+}
+
+// NormalizeMetadata collapses whitespace in metadata keys and values to single spaces, so that
+// rules with equal metadata are serialized identically. If sorted is true, metadata is also sorted
+// by key, then value, otherwise the original order is preserved.
+func (r *Rule) NormalizeMetadata(sorted bool) {
+	for _, m := range r.Metas {
+		m.Key = strings.Join(strings.Fields(m.Key), " ")
+		m.Value = strings.Join(strings.Fields(m.Value), " ")
+	}
+	if sorted {
+		r.SortMetadata()
+	}
+}
+
+// SortMetadata sorts the metadata of a rule by key, then value. Combined with sorted tags (see
+// RenderOptions), rules with the same metadata in a different order are written identically.
+func (r *Rule) SortMetadata() {
+	if r.Metas != nil {
+		r.Metas = r.Metas.sorted()
+	}
+}
+
+// hasMetadata returns true if the rule already has an identical metadata.
+func (r *Rule) hasMetadata(m *Metadata) bool {
+	for _, mm := range r.Metas {
+		if mm.Key == m.Key && mm.Value == m.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// Merge adds the references and metadata of other that are not already present in the rule.
+func (r *Rule) Merge(other *Rule) {
+	if r.merge(other) {
+		r.modified()
+	}
+}
+
+// merge implements Merge, and returns true if anything was added.
+func (r *Rule) merge(other *Rule) bool {
+	var merged bool
+	for _, ref := range other.References {
+		if !r.hasReference(ref) {
+			r.References = append(r.References, &Reference{Type: ref.Type, Value: ref.Value})
+			merged = true
+		}
+	}
+	for _, m := range other.Metas {
+		if !r.hasMetadata(m) {
+			r.Metas = append(r.Metas, &Metadata{Key: m.Key, Value: m.Value})
+			merged = true
+		}
+	}
+	return merged
+}
+
+// MergeWithSource merges other into the rule like Merge, and records the provenance of the
+// merged data as a "source" metadata with the provided label. The label is only added once, and
+// only if the merge added something.
+func (r *Rule) MergeWithSource(other *Rule, source string) {
+	if !r.merge(other) {
+		return
+	}
+	if m := (&Metadata{Key: "source", Value: source}); !r.hasMetadata(m) {
+		r.Metas = append(r.Metas, m)
+	}
+	r.modified()
+}
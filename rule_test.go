@@ -51,6 +51,14 @@ func TestContentToRegexp(t *testing.T) {
 			},
 			want: `abcd;:\.\.e\.f`,
 		},
+		{
+			name: "nocase content",
+			input: &Content{
+				Pattern: []byte("aB.1"),
+				Nocase:  true,
+			},
+			want: `(?i)aB\.1`,
+		},
 	} {
 		got := tt.input.ToRegexp()
 		if !reflect.DeepEqual(got, tt.want) {
@@ -59,6 +67,154 @@ func TestContentToRegexp(t *testing.T) {
 	}
 }
 
+func TestContentToRegexpWithStyle(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		input *Content
+		style NocaseStyle
+		want  string
+	}{
+		{
+			name: "case sensitive content",
+			input: &Content{
+				Pattern: []byte("aB.1"),
+			},
+			style: NocaseClasses,
+			want:  `aB\.1`,
+		},
+		{
+			name: "nocase flag",
+			input: &Content{
+				Pattern: []byte("aB.1"),
+				Nocase:  true,
+			},
+			style: NocaseFlag,
+			want:  `(?i)aB\.1`,
+		},
+		{
+			name: "nocase classes",
+			input: &Content{
+				Pattern: []byte("aB.1\r\n"),
+				Nocase:  true,
+			},
+			style: NocaseClasses,
+			want:  `[aA][bB]\.1\.\.`,
+		},
+	} {
+		got := tt.input.ToRegexpWithStyle(tt.style)
+		if got != tt.want {
+			t.Fatalf("%s: got %v; expected %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNewContent(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		pattern []byte
+		opts    []ContentOption
+		want    string
+	}{
+		{
+			name:    "simple content",
+			pattern: []byte("abcd"),
+			want:    `content:"abcd";`,
+		},
+		{
+			name:    "special characters",
+			pattern: []byte("a|b\"c;d\x00"),
+			opts:    []ContentOption{{Name: "nocase"}, {Name: "depth", Value: "10"}},
+			want:    `content:"a|7C|b|22|c|3B|d|00|"; depth:10; nocase;`,
+		},
+	} {
+		c := NewContent(tt.pattern, tt.opts...)
+		if got := c.String(); got != tt.want {
+			t.Fatalf("%s: got %v; expected %v", tt.name, got, tt.want)
+		}
+		// The pattern must survive a round trip through the parser.
+		r, err := ParseRule(fmt.Sprintf(`alert tcp any any -> any any (msg:"foo"; %s sid:1; rev:1;)`, c))
+		if err != nil {
+			t.Fatalf("%s: parse rule failed: %v", tt.name, err)
+		}
+		if diff := pretty.Compare(r.Contents()[0], c); diff != "" {
+			t.Fatal(fmt.Sprintf("%s: diff (-got +want):\n%s", tt.name, diff))
+		}
+	}
+}
+
+func TestContentSetPattern(t *testing.T) {
+	b := []byte("abc")
+	c := NewContent(nil)
+	c.SetPattern(b)
+	b[0] = 'z'
+	if got := string(c.Pattern); got != "abc" {
+		t.Fatalf("SetPattern: got %v; expected abc", got)
+	}
+}
+
+func TestContentPositionOptions(t *testing.T) {
+	r, err := ParseRule(`alert tcp any any -> any any (msg:"foo"; byte_extract:2,0,len; content:"abc"; nocase; depth:10; offset:2; content:"def"; within:len; distance:-1; sid:1; rev:1;)`)
+	if err != nil {
+		t.Fatalf("parse rule failed: %v", err)
+	}
+	cs := r.Contents()
+	wantContents := []*Content{
+		{Pattern: []byte("abc"), Nocase: true, Offset: &IntOrVar{Int: 2}, Depth: &IntOrVar{Int: 10}, written: []string{"depth", "offset"}},
+		{Pattern: []byte("def"), Distance: &IntOrVar{Int: -1}, Within: &IntOrVar{Var: "len"}, written: []string{"within", "distance"}},
+	}
+	if diff := pretty.Compare(cs, wantContents); diff != "" {
+		t.Fatal(fmt.Sprintf("diff (-got +want):\n%s", diff))
+	}
+
+	// Options are written in canonical order, whatever the order they were set in.
+	cs[0].Depth = &IntOrVar{Int: 5}
+	cs[0].Distance = &IntOrVar{Var: "len"}
+	cs[1].Offset = &IntOrVar{Int: 1}
+	cs[1].Within = nil
+	if got, want := cs[0].String(), `content:"abc"; offset:2; depth:5; distance:len; nocase;`; got != want {
+		t.Fatalf("got %v; expected %v", got, want)
+	}
+	if got, want := cs[1].String(), `content:"def"; offset:1; distance:-1;`; got != want {
+		t.Fatalf("got %v; expected %v", got, want)
+	}
+	// Without CanonicalOptionOrder, options are written in the order they were parsed, followed by
+	// the options added since.
+	opts := DefaultRenderOptions()
+	opts.CanonicalOptionOrder = false
+	want := `alert tcp any any -> any any (msg:"foo"; byte_extract:2,0,len; content:"abc"; depth:5; offset:2; distance:len; nocase; content:"def"; distance:-1; offset:1; sid:1; rev:1;)`
+	if got := r.StringOpts(opts); got != want {
+		t.Fatalf("got %v; expected %v", got, want)
+	}
+
+	// NewContent sets the typed fields from position options.
+	c := NewContent([]byte("abc"), ContentOption{Name: "http_uri"}, ContentOption{Name: "depth", Value: "3"})
+	if diff := pretty.Compare(c, &Content{Pattern: []byte("abc"), Options: []*ContentOption{{Name: "http_uri"}}, Depth: &IntOrVar{Int: 3}}); diff != "" {
+		t.Fatal(fmt.Sprintf("NewContent: diff (-got +want):\n%s", diff))
+	}
+}
+
+func TestParseIntOrVar(t *testing.T) {
+	for _, tt := range []struct {
+		input   string
+		want    IntOrVar
+		wantErr bool
+	}{
+		{input: "10", want: IntOrVar{Int: 10}},
+		{input: " -3", want: IntOrVar{Int: -3}},
+		{input: "Certs.len", want: IntOrVar{Var: "Certs.len"}},
+		{input: "10a", wantErr: true},
+		{input: "", wantErr: true},
+	} {
+		got, err := ParseIntOrVar(tt.input)
+		if tt.wantErr != (err != nil) {
+			t.Fatalf("%q: got err %v; expected err %v", tt.input, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Fatalf("%q: got %v; expected %v", tt.input, got, tt.want)
+		}
+	}
+}
+
 func TestContentFormatPattern(t *testing.T) {
 	for _, tt := range []struct {
 		name    string
@@ -92,7 +248,14 @@ func TestContentFormatPattern(t *testing.T) {
 			input: &Content{
 				Pattern: []byte(`C:\\WINDOWS\\system32\\`),
 			},
-			want: `C|3A|\\WINDOWS\\system32\\`,
+			want: `C|3A 5C 5C|WINDOWS|5C 5C|system32|5C 5C|`,
+		},
+		{
+			name: "quote and semicolon",
+			input: &Content{
+				Pattern: []byte(`say "hi";`),
+			},
+			want: `say |22|hi|22 3B|`,
 		},
 		{
 			name: "content with hex pipe",
@@ -101,6 +264,20 @@ func TestContentFormatPattern(t *testing.T) {
 			},
 			want: `C|7C|B`,
 		},
+		{
+			name: "spaces in hex section",
+			input: &Content{
+				Pattern: []byte("a \x00  \x7F b"),
+			},
+			want: `a |00 20 20 7F| b`,
+		},
+		{
+			name: "trailing space after hex",
+			input: &Content{
+				Pattern: []byte("\x00 "),
+			},
+			want: `|00| `,
+		},
 	} {
 		got := tt.input.FormatPattern()
 		if !reflect.DeepEqual(got, tt.want) {
@@ -109,6 +286,48 @@ func TestContentFormatPattern(t *testing.T) {
 	}
 }
 
+func TestContentFormatPatternAllBytes(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		lo, hi byte
+		hex    bool
+	}{
+		{name: "control characters", lo: 0x00, hi: 0x1F, hex: true},
+		{name: "space", lo: ' ', hi: ' '},
+		{name: "bang and quote", lo: '!', hi: '"', hex: true},
+		{name: "printable before colon", lo: '#', hi: '9'},
+		{name: "colon and semicolon", lo: ':', hi: ';', hex: true},
+		{name: "printable before backslash", lo: '<', hi: '['},
+		{name: "backslash", lo: '\\', hi: '\\', hex: true},
+		{name: "printable before pipe", lo: ']', hi: '{'},
+		{name: "pipe", lo: '|', hi: '|', hex: true},
+		{name: "printable after pipe", lo: '}', hi: '~'},
+		{name: "del and non-ascii", lo: 0x7F, hi: 0xFF, hex: true},
+	} {
+		for b := int(tt.lo); b <= int(tt.hi); b++ {
+			c := &Content{Pattern: []byte{'a', byte(b), ' ', byte(b)}}
+			want := fmt.Sprintf("a%c %c", b, b)
+			if tt.hex {
+				want = fmt.Sprintf("a|%.2X 20 %.2X|", b, b)
+			}
+			if b == ' ' {
+				want = "a   "
+			}
+			got := c.FormatPattern()
+			if got != want {
+				t.Fatalf("%s: byte %#x: got %v; expected %v", tt.name, b, got, want)
+			}
+			r, err := ParseRule(fmt.Sprintf(`alert tcp any any -> any any (msg:"foo"; content:"%s"; sid:1;)`, got))
+			if err != nil {
+				t.Fatalf("%s: byte %#x: %v", tt.name, b, err)
+			}
+			if p := r.Contents()[0].Pattern; !reflect.DeepEqual(p, c.Pattern) {
+				t.Fatalf("%s: byte %#x: parsed %q; expected %q", tt.name, b, p, c.Pattern)
+			}
+		}
+	}
+}
+
 func TestFastPatternString(t *testing.T) {
 	for _, tt := range []struct {
 		name  string
@@ -148,6 +367,33 @@ func TestFastPatternString(t *testing.T) {
 			},
 			want: "fast_pattern:0,5;",
 		},
+		{
+			name: "fast_pattern:`chop` with 0 length",
+			input: FastPattern{
+				Enabled: true,
+				Chop:    true,
+				Offset:  5,
+				Length:  0,
+			},
+			want: "fast_pattern:5,0;",
+		},
+		{
+			name: "fast_pattern:`chop` with 0 offset and length",
+			input: FastPattern{
+				Enabled: true,
+				Chop:    true,
+			},
+			want: "fast_pattern:0,0;",
+		},
+		{
+			name: "invalid state with chop",
+			input: FastPattern{
+				Enabled: true,
+				Only:    true,
+				Chop:    true,
+			},
+			want: "",
+		},
 		{
 			name: "invalid state",
 			input: FastPattern{
@@ -355,6 +601,67 @@ func TestByteMatchString(t *testing.T) {
 			},
 			want: `byte_test:3,>,300,42;`,
 		},
+		{
+			name: "byte_math basic",
+			input: ByteMatch{
+				Kind:     bMath,
+				NumBytes: "4",
+				Operator: "+",
+				Value:    "2",
+				Variable: "var",
+			},
+			want: `byte_math:bytes 4, offset 0, oper +, rvalue 2, result var;`,
+		},
+		{
+			name: "byte_math with options",
+			input: ByteMatch{
+				Kind:     bMath,
+				NumBytes: "2",
+				Offset:   -3,
+				Operator: ">>",
+				Value:    "len",
+				Variable: "res",
+				Options:  []string{"relative", "endian little"},
+			},
+			want: `byte_math:bytes 2, offset -3, oper >>, rvalue len, result res, relative, endian little;`,
+		},
+		{
+			name: "byte_jump typed options",
+			input: ByteMatch{
+				Kind:          bJump,
+				NumBytes:      "4",
+				Offset:        2,
+				Bitmask:       "0x3FF0",
+				DCE:           true,
+				PostOffset:    -1,
+				FromBeginning: true,
+				Align:         true,
+				Base:          "dec",
+				StringMode:    true,
+				Endianness:    "little",
+				Multiplier:    2,
+				Relative:      true,
+				Options:       []string{"foo"},
+			},
+			want: `byte_jump:4,2,relative,multiplier 2,little,string,dec,align,from_beginning,post_offset -1,dce,bitmask 0x3FF0,foo;`,
+		},
+		{
+			name: "byte_test typed options",
+			input: ByteMatch{
+				Kind:       bTest,
+				NumBytes:   "4",
+				Negate:     true,
+				Operator:   "<=",
+				Value:      "42",
+				Offset:     1,
+				Bitmask:    "0xFF",
+				Base:       "oct",
+				StringMode: true,
+				Endianness: "big",
+				Relative:   true,
+			},
+			want: `byte_test:4,!<=,42,1,relative,big,string,oct,bitmask 0xFF;`,
+		},
 		{
 			name: "byte_jump basic",
 			input: ByteMatch{
@@ -600,15 +907,8 @@ func TestContentString(t *testing.T) {
 			name: "content with multiple options",
 			input: Content{
 				Pattern: []byte("AA"),
-				Options: []*ContentOption{
-					{
-						Name: "http_uri",
-					},
-					{
-						Name:  "depth",
-						Value: "0",
-					},
-				},
+				Options: []*ContentOption{{Name: "http_uri"}},
+				Depth:   &IntOrVar{},
 			},
 			want: `content:"AA"; http_uri; depth:0;`,
 		},
@@ -616,27 +916,47 @@ func TestContentString(t *testing.T) {
 			name: "content with multiple options and fast_pattern",
 			input: Content{
 				Pattern: []byte("AA"),
-				Options: []*ContentOption{
-					{
-						Name: "http_uri",
-					},
-					{
-						Name:  "depth",
-						Value: "0",
-					},
-				},
+				Options: []*ContentOption{{Name: "http_uri"}},
+				Depth:   &IntOrVar{},
 				FastPattern: FastPattern{
 					Enabled: true,
 				},
 			},
 			want: `content:"AA"; http_uri; depth:0; fast_pattern;`,
 		},
+		{
+			name: "content options in canonical order",
+			input: Content{
+				Pattern:     []byte("AA"),
+				Nocase:      true,
+				Options:     []*ContentOption{{Name: "http_uri"}, {Name: "rawbytes"}},
+				Within:      &IntOrVar{Int: 10},
+				Distance:    &IntOrVar{},
+				Depth:       &IntOrVar{Int: 4},
+				Offset:      &IntOrVar{Int: 1},
+				FastPattern: FastPattern{Enabled: true},
+			},
+			want: `content:"AA"; http_uri; rawbytes; offset:1; depth:4; distance:0; within:10; nocase; fast_pattern;`,
+		},
 	} {
 		got := tt.input.String()
 		if got != tt.want {
 			t.Fatalf("%s: got %v -- expected %v", tt.name, got, tt.want)
 		}
 	}
+
+	r, err := ParseRule(`alert http any any -> any any (msg:"foo"; content:"BB"; content:"AA"; within:10; http_uri; distance:0; sid:1; rev:1;)`)
+	if err != nil {
+		t.Fatalf("parse rule failed: %v", err)
+	}
+	if got, want := r.String(), `alert http any any -> any any (msg:"foo"; content:"BB"; content:"AA"; http_uri; distance:0; within:10; sid:1; rev:1;)`; got != want {
+		t.Fatalf("canonical order: got %v -- expected %v", got, want)
+	}
+	opts := DefaultRenderOptions()
+	opts.CanonicalOptionOrder = false
+	if got, want := r.StringOpts(opts), `alert http any any -> any any (msg:"foo"; content:"BB"; content:"AA"; within:10; http_uri; distance:0; sid:1; rev:1;)`; got != want {
+		t.Fatalf("preserved order: got %v -- expected %v", got, want)
+	}
 }
 
 func TestPCREString(t *testing.T) {
@@ -677,6 +997,188 @@ func TestPCREString(t *testing.T) {
 	}
 }
 
+func TestPCREModifiers(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		input        PCRE
+		wantRelative bool
+		wantBuffer   DataPos
+		wantOK       bool
+	}{
+		{
+			name:  "no modifiers",
+			input: PCRE{Pattern: []byte("foo")},
+		},
+		{
+			name:         "relative",
+			input:        PCRE{Pattern: []byte("foo"), Options: []byte("Ri")},
+			wantRelative: true,
+		},
+		{
+			name:       "uri",
+			input:      PCRE{Pattern: []byte("foo"), Options: []byte("iU")},
+			wantBuffer: httpURI,
+			wantOK:     true,
+		},
+		{
+			name:         "relative client body",
+			input:        PCRE{Pattern: []byte("foo"), Options: []byte("PR")},
+			wantRelative: true,
+			wantBuffer:   httpClientBody,
+			wantOK:       true,
+		},
+	} {
+		if got := tt.input.IsRelative(); got != tt.wantRelative {
+			t.Fatalf("%s: IsRelative got %v; want %v", tt.name, got, tt.wantRelative)
+		}
+		got, ok := tt.input.TargetBuffer()
+		if got != tt.wantBuffer || ok != tt.wantOK {
+			t.Fatalf("%s: TargetBuffer got %v,%v; want %v,%v", tt.name, got, ok, tt.wantBuffer, tt.wantOK)
+		}
+	}
+	p := PCRE{Pattern: []byte("foo"), Options: []byte("si")}
+	if !p.HasModifier('i') || !p.HasModifier('s') || p.HasModifier('m') {
+		t.Fatalf("HasModifier: unexpected result for %s", p.Options)
+	}
+}
+
+func TestPCREToGoRegexp(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		input   PCRE
+		match   string
+		noMatch string
+		wantErr bool
+	}{
+		{
+			name:    "nocase",
+			input:   PCRE{Pattern: []byte(`foo\d+`), Options: []byte("i")},
+			match:   "xFOO12",
+			noMatch: "foo",
+		},
+		{
+			name:    "dotall and multiline",
+			input:   PCRE{Pattern: []byte(`^a.b$`), Options: []byte("smR")},
+			match:   "x\na\nb\ny",
+			noMatch: "xa\nb",
+		},
+		{
+			name:    "ungreedy",
+			input:   PCRE{Pattern: []byte(`^a.+b$`), Options: []byte("G")},
+			match:   "axxb",
+			noMatch: "ab",
+		},
+		{
+			name:    "anchored",
+			input:   PCRE{Pattern: []byte(`foo|bar`), Options: []byte("AU")},
+			match:   "bar/",
+			noMatch: "/bar",
+		},
+		{
+			name:    "hex escapes",
+			input:   PCRE{Pattern: []byte(`\x2f[a-z]{3}\x3b`)},
+			match:   "GET /abc;",
+			noMatch: "/ab;",
+		},
+		{
+			name:    "nocase ascii class",
+			input:   PCRE{Pattern: []byte(`^[a-z]+$`), Options: []byte("i")},
+			match:   "KeY",
+			noMatch: "k3y",
+		},
+		{
+			name:    "negated class",
+			input:   PCRE{Pattern: []byte(`^[^/]+/`)},
+			match:   "abc/",
+			noMatch: "/abc",
+		},
+		{
+			name:    "hex escape above 0x7f",
+			input:   PCRE{Pattern: []byte(`\xff\xfe`)},
+			wantErr: true,
+		},
+		{
+			name:    "class above 0x7f",
+			input:   PCRE{Pattern: []byte(`[\x80-\xff]{4}`)},
+			wantErr: true,
+		},
+		{
+			name:    "negated class above 0x7f",
+			input:   PCRE{Pattern: []byte(`[^\x80-\xff]`)},
+			wantErr: true,
+		},
+		{
+			name:    "extended",
+			input:   PCRE{Pattern: []byte(`foo bar`), Options: []byte("x")},
+			wantErr: true,
+		},
+		{
+			name:    "lookahead",
+			input:   PCRE{Pattern: []byte(`foo(?!bar)`)},
+			wantErr: true,
+		},
+		{
+			name:    "backreference",
+			input:   PCRE{Pattern: []byte(`(a)\1`)},
+			wantErr: true,
+		},
+		{
+			name:    "invalid",
+			input:   PCRE{Pattern: []byte(`foo(`)},
+			wantErr: true,
+		},
+	} {
+		re, err := tt.input.ToGoRegexp()
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%s: got err %v; expected err %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		if !re.MatchString(tt.match) {
+			t.Fatalf("%s: %v does not match %q", tt.name, re, tt.match)
+		}
+		if re.MatchString(tt.noMatch) {
+			t.Fatalf("%s: %v matches %q", tt.name, re, tt.noMatch)
+		}
+	}
+}
+
+func TestPCRECaptures(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			name:  "no captures",
+			input: `pcre:"/(foo)/i";`,
+		},
+		{
+			name:  "named groups",
+			input: `pcre:"/(?P<flow_user>[a-z]+):(?<pkt_pass>.*)(?P<other>x)/";`,
+			want:  []string{"flow:user", "pkt:pass"},
+		},
+		{
+			name:  "capture variables",
+			input: `pcre:"/(?P<pkt_a>a)(b)(c)/R, flow:b, pkt:c";`,
+			want:  []string{"pkt:a", "flow:b", "pkt:c"},
+		},
+	} {
+		r, err := ParseRule(fmt.Sprintf(`alert tcp any any -> any any (msg:"foo"; %s sid:1; rev:1;)`, tt.input))
+		if err != nil {
+			t.Fatalf("%s: parse rule failed: %v", tt.name, err)
+		}
+		p := r.PCREs()[0]
+		if diff := pretty.Compare(p.Captures(), tt.want); diff != "" {
+			t.Fatal(fmt.Sprintf("%s: diff (-got +want):\n%s", tt.name, diff))
+		}
+		if got := p.String(); got != tt.input {
+			t.Fatalf("%s: got %v; expected %v", tt.name, got, tt.input)
+		}
+	}
+}
+
 func TestFlowbitsString(t *testing.T) {
 	for _, tt := range []struct {
 		name  string
@@ -907,9 +1409,7 @@ func TestRuleString(t *testing.T) {
 				SID:         1337,
 				Revision:    2,
 				Description: "foo",
-				Tags: map[string]string{
-					"classtype": "trojan-activity",
-				},
+				Classtype:   "trojan-activity",
 				Matchers: []orderedMatcher{
 					&Content{
 						Pattern: []byte("AA"),
@@ -1052,45 +1552,185 @@ func TestRuleString(t *testing.T) {
 	}
 }
 
-func TestRE(t *testing.T) {
+func TestStringOpts(t *testing.T) {
+	r, err := ParseRule(`#alert dns any any -> any any (msg:"foo"; dns_query; content:"bar"; nocase; distance:0; depth:10; priority:1; flags:S; sid:1; rev:1;)`)
+	if err != nil {
+		t.Fatalf("parse rule failed: %v", err)
+	}
 	for _, tt := range []struct {
-		rule string
+		name string
+		opts RenderOptions
 		want string
 	}{
 		{
-			rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:"|28|foo"; content:".AA"; within:40;)`,
-			want: `.*\(foo.{0,40}\.AA`,
+			name: "default",
+			opts: DefaultRenderOptions(),
+			want: `#alert dns any any -> any any (msg:"foo"; dns_query; content:"bar"; depth:10; distance:0; nocase; flags:S; priority:1; sid:1; rev:1;)`,
+		},
+		{
+			name: "no options",
+			want: `alert dns any any -> any any (msg:"foo"; dns_query; content:"bar"; distance:0; depth:10; nocase; priority:1; flags:S; sid:1; rev:1;)`,
+		},
+		{
+			name: "all options",
+			opts: RenderOptions{
+				IncludeDisabledComment: true,
+				DisabledPrefix:         "# ",
+				CanonicalOptionOrder:   true,
+				SortTags:               true,
+				DottedBuffers:          true,
+			},
+			want: `# alert dns any any -> any any (msg:"foo"; dns.query; content:"bar"; depth:10; distance:0; nocase; flags:S; priority:1; sid:1; rev:1;)`,
 		},
 	} {
-		r, err := ParseRule(tt.rule)
-		if err != nil {
-			t.Fatalf("re: parse rule failed: %v", err)
+		if got := r.StringOpts(tt.opts); got != tt.want {
+			t.Fatalf("%s: got %v; expected %v", tt.name, got, tt.want)
 		}
-		if got := r.RE(); got != tt.want {
-			t.Fatalf("re: got=%v; want=%v", got, tt.want)
+	}
+	if got, want := r.String(), r.StringOpts(DefaultRenderOptions()); got != want {
+		t.Fatalf("got %v; expected %v", got, want)
+	}
+	want := RenderOptions{IncludeDisabledComment: true, CanonicalOptionOrder: true, SortTags: true}
+	if diff := pretty.Compare(DefaultRenderOptions(), want); diff != "" {
+		t.Fatal(fmt.Sprintf("DefaultRenderOptions: diff (-got +want):\n%s", diff))
+	}
+}
+
+func TestDisableEnable(t *testing.T) {
+	const enabled = `alert tcp any any -> any any (msg:"foo"; sid:1; rev:1;)`
+	r, err := ParseRule(enabled)
+	if err != nil {
+		t.Fatalf("parse rule failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		r.Disable()
+		if got, want := r.String(), "#"+enabled; got != want {
+			t.Fatalf("got %v; expected %v", got, want)
+		}
+	}
+	// A disabled rule is stable through a round-trip.
+	d, err := ParseRule(r.String())
+	if err != nil {
+		t.Fatalf("parse disabled rule failed: %v", err)
+	}
+	if !d.Disabled || d.String() != r.String() {
+		t.Fatalf("got %v; expected %v", d, r)
+	}
+	for i := 0; i < 2; i++ {
+		r.Enable()
+		if got := r.String(); got != enabled {
+			t.Fatalf("got %v; expected %v", got, enabled)
 		}
 	}
 }
 
-func TestLastContent(t *testing.T) {
+func TestSetAction(t *testing.T) {
 	for _, tt := range []struct {
-		rule string
-		want *Content
+		action       string
+		wantErr      bool
+		wantBlocking bool
 	}{
-		{
-			rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:"bar";)`,
+		{action: "alert"},
+		{action: "pass"},
+		{action: "drop", wantBlocking: true},
+		{action: "reject", wantBlocking: true},
+		{action: "rejectsrc", wantBlocking: true},
+		{action: "rejectdst", wantBlocking: true},
+		{action: "rejectboth", wantBlocking: true},
+		{action: "Drop", wantErr: true},
+		{action: "log", wantErr: true},
+		{action: "", wantErr: true},
+	} {
+		r := &Rule{Action: "alert", Revision: 1, AutoBumpRevision: true}
+		err := r.SetAction(tt.action)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%q: got err %v; expected err %v", tt.action, err, tt.wantErr)
+		}
+		if tt.wantErr {
+			if r.Action != "alert" || r.Revision != 1 {
+				t.Fatalf("%q: rule changed on error: %v, rev %d", tt.action, r.Action, r.Revision)
+			}
+			continue
+		}
+		if r.Action != tt.action {
+			t.Fatalf("%q: got action %v", tt.action, r.Action)
+		}
+		if got := r.IsBlocking(); got != tt.wantBlocking {
+			t.Fatalf("%q: IsBlocking got %v; expected %v", tt.action, got, tt.wantBlocking)
+		}
+		// The revision is only bumped if the action changed.
+		wantRev := 2
+		if tt.action == "alert" {
+			wantRev = 1
+		}
+		if r.Revision != wantRev {
+			t.Fatalf("%q: got revision %d; expected %d", tt.action, r.Revision, wantRev)
+		}
+	}
+}
+
+func TestRE(t *testing.T) {
+	for _, tt := range []struct {
+		rule string
+		want string
+	}{
+		{
+			rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:"|28|foo"; content:".AA"; within:40;)`,
+			want: `.*\(foo.{0,37}\.AA`,
+		},
+		{
+			rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:"foo"; offset:2; depth:10; content:"bar"; distance:4; within:8; content:"baz"; distance:1;)`,
+			want: `^.{2,9}foo.{4,9}bar.{1,}baz`,
+		},
+		{
+			rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:"foo"; depth:3; content:"bar"; offset:10;)`,
+			want: `^.{0,0}foo.*bar`,
+		},
+		{
+			rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:"foo"; offset:5; content:"bar"; distance:-2; within:5;)`,
+			want: `^.{5,}foo.*bar`,
+		},
+		{
+			rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:!"foo"; depth:3; content:"bar"; within:10; content:!"baz"; content:"qux"; distance:0; within:3;)`,
+			want: `.*bar.*qux`,
+		},
+		{
+			rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:"foo"; pcre:"/^a[0-9]+b$/Ri"; content:"bar"; within:5; pcre:"/c\$/Gs";)`,
+			want: `.*foo.*(?i:a[0-9]+b).{0,2}bar.*(?Us:c\$)`,
+		},
+		{
+			rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; pcre:"/(?=a)b/"; content:"bar"; distance:0; pcre:!"/baz/"; content:"qux"; within:4;)`,
+			want: `.*bar.*qux`,
+		},
+		{
+			rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:"foo"; pcre:"/a b # c/x"; content:"bar"; distance:0;)`,
+			want: `.*foo.*bar`,
+		},
+	} {
+		r, err := ParseRule(tt.rule)
+		if err != nil {
+			t.Fatalf("re: parse rule failed: %v", err)
+		}
+		if got := r.RE(); got != tt.want {
+			t.Fatalf("re: got=%v; want=%v", got, tt.want)
+		}
+	}
+}
+
+func TestLastContent(t *testing.T) {
+	for _, tt := range []struct {
+		rule string
+		want *Content
+	}{
+		{
+			rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:"bar";)`,
 			want: &Content{Pattern: []byte("bar")},
 		},
 		{
 			rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:"bar"; pcre:"/foo.*bar/iU"; content:"foo"; within:40; pcre:"/foo.*bar.*baz/iU";)`,
 			want: &Content{
 				Pattern: []byte("foo"),
-				Options: []*ContentOption{
-					{
-						Name:  "within",
-						Value: "40",
-					},
-				},
+				Within:  &IntOrVar{Int: 40},
 			},
 		},
 		{
@@ -1109,6 +1749,101 @@ func TestLastContent(t *testing.T) {
 	}
 }
 
+func TestContentsForBuffer(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		rule   string
+		buffer DataPos
+		want   []*Content
+	}{
+		{
+			name:   "no contents",
+			rule:   `alert http $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; dsize:10;)`,
+			buffer: httpURI,
+		},
+		{
+			name:   "sticky buffers",
+			rule:   `alert http $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:"a"; http.uri; content:"b"; content:"c"; distance:0; http.host; content:"d"; http.uri; content:"e";)`,
+			buffer: httpURI,
+			want: []*Content{
+				{DataPosition: httpURI, Pattern: []byte("b")},
+				{DataPosition: httpURI, Pattern: []byte("c"), Distance: &IntOrVar{}},
+				{DataPosition: httpURI, Pattern: []byte("e")},
+			},
+		},
+		{
+			name:   "content modifiers",
+			rule:   `alert tcp $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:"a"; http_uri; content:"b"; http_header; uricontent:"c";)`,
+			buffer: httpURI,
+			want: []*Content{
+				{Pattern: []byte("a"), Options: []*ContentOption{{"http_uri", ""}}},
+				{DataPosition: httpURI, Pattern: []byte("c")},
+			},
+		},
+		{
+			name:   "pkt_data",
+			rule:   `alert tcp $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:"a"; http_uri; content:"b";)`,
+			buffer: pktData,
+			want: []*Content{
+				{Pattern: []byte("b")},
+			},
+		},
+	} {
+		r, err := ParseRule(tt.rule)
+		if err != nil {
+			t.Fatalf("%s: parse rule failed: %v", tt.name, err)
+		}
+		got := r.ContentsForBuffer(tt.buffer)
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Fatal(fmt.Sprintf("%s: diff (-got +want):\n%s", tt.name, diff))
+		}
+		if has := r.HasContentInBuffer(tt.buffer); has != (len(tt.want) > 0) {
+			t.Fatalf("%s: HasContentInBuffer got %v; want %v", tt.name, has, len(tt.want) > 0)
+		}
+	}
+}
+
+func TestTransformsForBuffer(t *testing.T) {
+	r, err := ParseRule(`alert http $HOME_NET any -> $EXTERNAL_NET any (msg:"foo"; content:"a"; http.uri; url_decode; to_lowercase; content:"/b"; content:"c"; http.host; content:"d"; http.uri; strip_whitespace; content:"e"; sid:1; rev:1;)`)
+	if err != nil {
+		t.Fatalf("parse rule failed: %v", err)
+	}
+	if diff := pretty.Compare(r.TransformsForBuffer(httpURI), []string{"url_decode", "to_lowercase", "strip_whitespace"}); diff != "" {
+		t.Fatal(fmt.Sprintf("TransformsForBuffer: diff (-got +want):\n%s", diff))
+	}
+	if got := r.TransformsForBuffer(httpHost); got != nil {
+		t.Fatalf("TransformsForBuffer: got %v; expected no transforms", got)
+	}
+
+	for _, tt := range []struct {
+		pattern string
+		want    []string
+	}{
+		{pattern: "a"},
+		{pattern: "/b", want: []string{"url_decode", "to_lowercase"}},
+		{pattern: "c", want: []string{"url_decode", "to_lowercase"}},
+		{pattern: "d"},
+		{pattern: "e", want: []string{"strip_whitespace"}},
+	} {
+		var c *Content
+		for _, v := range r.Contents() {
+			if string(v.Pattern) == tt.pattern {
+				c = v
+			}
+		}
+		if diff := pretty.Compare(r.ContentTransforms(c), tt.want); diff != "" {
+			t.Fatal(fmt.Sprintf("ContentTransforms(%s): diff (-got +want):\n%s", tt.pattern, diff))
+		}
+	}
+
+	// A transform added after the contents of its buffer is written right after the buffer.
+	r.Matchers = append(r.Matchers, &Transform{DataPosition: httpURI, Name: "to_uppercase"})
+	want := `alert http $HOME_NET any -> $EXTERNAL_NET any (msg:"foo"; content:"a"; http.uri; url_decode; to_lowercase; content:"/b"; content:"c"; http.host; content:"d"; http.uri; strip_whitespace; to_uppercase; content:"e"; sid:1; rev:1;)`
+	if got := r.String(); got != want {
+		t.Fatalf("got %v; expected %v", got, want)
+	}
+}
+
 func TestDataPosString(t *testing.T) {
 	for _, tt := range []struct {
 		val  DataPos
@@ -1447,6 +2182,347 @@ func TestInsertMatcher(t *testing.T) {
 	}
 }
 
+func TestDeleteMatcher(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		input         string
+		pos           int
+		stripRelative bool
+		want          string
+		wantErr       bool
+	}{
+		{
+			name:  "delete content",
+			input: `alert tcp any any -> any any (msg:"foo"; content:"foo"; content:"bar"; sid:1; rev:1;)`,
+			pos:   0,
+			want:  `alert tcp any any -> any any (msg:"foo"; content:"bar"; sid:1; rev:1;)`,
+		},
+		{
+			name:  "delete last",
+			input: `alert tcp any any -> any any (msg:"foo"; content:"foo"; content:"bar"; distance:0; sid:1; rev:1;)`,
+			pos:   1,
+			want:  `alert tcp any any -> any any (msg:"foo"; content:"foo"; sid:1; rev:1;)`,
+		},
+		{
+			name:    "relative content",
+			input:   `alert tcp any any -> any any (msg:"foo"; content:"foo"; content:"bar"; distance:0; within:5; sid:1; rev:1;)`,
+			pos:     0,
+			wantErr: true,
+		},
+		{
+			name:          "strip relative content",
+			input:         `alert tcp any any -> any any (msg:"foo"; content:"foo"; content:"bar"; nocase; distance:0; within:5; sid:1; rev:1;)`,
+			pos:           0,
+			stripRelative: true,
+			want:          `alert tcp any any -> any any (msg:"foo"; content:"bar"; nocase; sid:1; rev:1;)`,
+		},
+		{
+			name:          "strip relative pcre",
+			input:         `alert tcp any any -> any any (msg:"foo"; content:"foo"; pcre:"/bar/Ri"; sid:1; rev:1;)`,
+			pos:           0,
+			stripRelative: true,
+			want:          `alert tcp any any -> any any (msg:"foo"; pcre:"/bar/i"; sid:1; rev:1;)`,
+		},
+		{
+			name:          "strip relative byte_test",
+			input:         `alert tcp any any -> any any (msg:"foo"; content:"foo"; byte_test:1,>,2,0,relative,little; sid:1; rev:1;)`,
+			pos:           0,
+			stripRelative: true,
+			want:          `alert tcp any any -> any any (msg:"foo"; byte_test:1,>,2,0,little; sid:1; rev:1;)`,
+		},
+		{
+			name:          "used variable",
+			input:         `alert tcp any any -> any any (msg:"foo"; byte_extract:1,0,len; content:"foo"; content:"bar"; within:len; sid:1; rev:1;)`,
+			pos:           0,
+			stripRelative: true,
+			wantErr:       true,
+		},
+		{
+			name:    "out of range",
+			input:   `alert tcp any any -> any any (msg:"foo"; content:"foo"; sid:1; rev:1;)`,
+			pos:     1,
+			wantErr: true,
+		},
+	} {
+		r, err := ParseRule(tt.input)
+		if err != nil {
+			t.Fatalf("%s: parse rule failed: %v", tt.name, err)
+		}
+		err = r.DeleteMatcher(tt.pos, tt.stripRelative)
+		if tt.wantErr != (err != nil) {
+			t.Fatalf("%s: gotErr=%v; wantErr=%v", tt.name, err, tt.wantErr)
+		}
+		want := tt.want
+		if tt.wantErr {
+			want = tt.input
+		}
+		if got := r.String(); got != want {
+			t.Fatalf("%s: got %v; expected %v", tt.name, got, want)
+		}
+	}
+}
+
+func TestAddMatchers(t *testing.T) {
+	r, err := ParseRule(`alert tcp $HOME_NET any -> $EXTERNAL_NET any (msg:"foo"; content:"foo"; sid:1; rev:1;)`)
+	if err != nil {
+		t.Fatalf("parse rule failed: %v", err)
+	}
+	r.AddByteMatch(&ByteMatch{Kind: bExtract, NumBytes: "2", Variable: "len", Relative: true})
+	r.AddContent(&Content{Pattern: []byte("bar"), Within: &IntOrVar{Var: "len"}})
+	r.AddPCRE(&PCRE{Pattern: []byte("baz"), Options: []byte("R")})
+	r.AddLenMatch(&LenMatch{Kind: dSize, Operator: ">", Num: 10})
+
+	want := `alert tcp $HOME_NET any -> $EXTERNAL_NET any (msg:"foo"; content:"foo"; byte_extract:2,0,len,relative; content:"bar"; within:len; pcre:"/baz/R"; dsize:>10; sid:1; rev:1;)`
+	if got := r.String(); got != want {
+		t.Fatalf("got %v; expected %v", got, want)
+	}
+	if len(r.Contents()) != 2 || len(r.ByteMatchers()) != 1 || len(r.PCREs()) != 1 || len(r.LenMatchers()) != 1 {
+		t.Fatalf("typed views do not match the matchers: %v", r.Matchers)
+	}
+}
+
+func TestRevisionBookkeeping(t *testing.T) {
+	r, err := ParseRule(`alert tcp any any -> any any (msg:"foo"; content:"foo"; sid:1; rev:3;)`)
+	if err != nil {
+		t.Fatalf("parse rule failed: %v", err)
+	}
+	r.AddContent(&Content{Pattern: []byte("bar")})
+	if r.Revision != 3 {
+		t.Fatalf("got rev %d; expected 3 without AutoBumpRevision", r.Revision)
+	}
+	r.BumpRevision()
+	if r.Revision != 4 {
+		t.Fatalf("got rev %d; expected 4", r.Revision)
+	}
+
+	r.AutoBumpRevision = true
+	r.AddPCRE(&PCRE{Pattern: []byte("baz")})
+	if err := r.DeleteMatcher(1, false); err != nil {
+		t.Fatalf("delete matcher failed: %v", err)
+	}
+	r.AddReference("cve", "2021-1234")
+	// Adding an existing reference or deleting a missing metadata key is not a modification.
+	r.AddReference("cve", "2021-1234")
+	r.DeleteMetadata("created_at")
+	r.SetMetadata("updated_at", "2021_01_01")
+	if r.Revision != 8 {
+		t.Fatalf("got rev %d; expected 8", r.Revision)
+	}
+	// Calls leaving the rule unchanged do not bump the revision.
+	r.SetMetadata("updated_at", "2021_01_01")
+	r.MergeMetadata(map[string]string{"updated_at": "2021_01_01"}, true)
+	r.Merge(&Rule{References: []*Reference{{Type: "cve", Value: "2021-1234"}}})
+	r.ReplaceContent([]byte("foo"), []byte("foo"))
+	if err := r.SetAction("alert"); err != nil {
+		t.Fatalf("set action failed: %v", err)
+	}
+	if r.Revision != 8 {
+		t.Fatalf("got rev %d after no-op changes; expected 8", r.Revision)
+	}
+
+	r.SetRevision(1)
+	want := `alert tcp any any -> any any (msg:"foo"; content:"foo"; pcre:"/baz/"; metadata:updated_at 2021_01_01; reference:cve,2021-1234; sid:1; rev:1;)`
+	if got := r.String(); got != want {
+		t.Fatalf("got %v; expected %v", got, want)
+	}
+}
+
+func TestCVEs(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			name:  "no cve",
+			input: `reference:url,www.example.com;`,
+		},
+		{
+			name:  "multiple cves",
+			input: `reference:cve,2021-1234; reference:url,www.example.com; reference:cve,cve-2020-0001; reference:cve,CVE-2021-1234; reference:cve,2019-9999;`,
+			want:  []string{"CVE-2021-1234", "CVE-2020-0001", "CVE-2019-9999"},
+		},
+		{
+			name:  "uppercase type",
+			input: `reference:CVE,2021-1234; reference:Cve,2020-0001;`,
+			want:  []string{"CVE-2021-1234", "CVE-2020-0001"},
+		},
+	} {
+		r, err := ParseRule(fmt.Sprintf(`alert tcp any any -> any any (msg:"foo"; %s sid:1; rev:1;)`, tt.input))
+		if err != nil {
+			t.Fatalf("%s: parse rule failed: %v", tt.name, err)
+		}
+		if diff := pretty.Compare(r.CVEs(), tt.want); diff != "" {
+			t.Fatal(fmt.Sprintf("%s: diff (-got +want):\n%s", tt.name, diff))
+		}
+	}
+}
+
+func TestReferences(t *testing.T) {
+	r, err := ParseRule(`alert tcp any any -> any any (msg:"foo"; reference:cve,2021-1234; reference:url,www.example.com/a; reference:cve,2021-5678; reference:foo,bar; reference:url,https://example.org; sid:1; rev:1;)`)
+	if err != nil {
+		t.Fatalf("parse rule failed: %v", err)
+	}
+	if diff := pretty.Compare(r.ReferencesOfType("cve"), []string{"2021-1234", "2021-5678"}); diff != "" {
+		t.Fatal(fmt.Sprintf("ReferencesOfType: diff (-got +want):\n%s", diff))
+	}
+	r.AddReference("cve", "2021-1234")
+	r.AddReference("md5", "0123456789abcdef")
+	want := []string{
+		"http://cve.mitre.org/cgi-bin/cvename.cgi?name=2021-1234",
+		"http://www.example.com/a",
+		"http://cve.mitre.org/cgi-bin/cvename.cgi?name=2021-5678",
+		"https://example.org",
+		"http://www.threatexpert.com/report.aspx?md5=0123456789abcdef",
+	}
+	if diff := pretty.Compare(r.URLs(), want); diff != "" {
+		t.Fatal(fmt.Sprintf("URLs: diff (-got +want):\n%s", diff))
+	}
+
+	// ReferencePrefixes returns a copy, changing it does not change the URLs.
+	ps := ReferencePrefixes()
+	ps["cve"] = "https://example.com/"
+	if got := r.URLs()[0]; got != want[0] {
+		t.Fatalf("got %v; expected %v", got, want[0])
+	}
+}
+
+func TestMetadataHelpers(t *testing.T) {
+	r, err := ParseRule(`alert tcp any any -> any any (msg:"foo"; metadata:mitre_technique_id T1001, created_at 2020_01_01, mitre_technique_id T1002; sid:1; rev:1;)`)
+	if err != nil {
+		t.Fatalf("parse rule failed: %v", err)
+	}
+	if diff := pretty.Compare(r.Metadata("mitre_technique_id"), []string{"T1001", "T1002"}); diff != "" {
+		t.Fatal(fmt.Sprintf("Metadata: diff (-got +want):\n%s", diff))
+	}
+
+	r.AddMetadata("mitre_technique_id", "T1001")
+	r.AddMetadata("mitre_technique_id", "T1003")
+	r.SetMetadata("created_at", "2021_01_01")
+	r.SetMetadata("updated_at", "2021_02_01")
+	want := `metadata:mitre_technique_id T1001, created_at 2021_01_01, mitre_technique_id T1002, mitre_technique_id T1003, updated_at 2021_02_01;`
+	if got := r.Metas.String(); got != want {
+		t.Fatalf("got %v; expected %v", got, want)
+	}
+
+	r.SetMetadata("mitre_technique_id", "T1000")
+	r.DeleteMetadata("created_at")
+	want = `metadata:mitre_technique_id T1000, updated_at 2021_02_01;`
+	if got := r.Metas.String(); got != want {
+		t.Fatalf("got %v; expected %v", got, want)
+	}
+	if got := r.Metadata("created_at"); got != nil {
+		t.Fatalf("got %v; expected no values", got)
+	}
+}
+
+func TestMergeMetadata(t *testing.T) {
+	kv := map[string]string{
+		"updated_at":         "2021_02_01",
+		"mitre_technique_id": "T1003",
+		"created_at":         "2020_01_01",
+		"mitre_tactic_id":    "TA0011",
+	}
+	for _, tt := range []struct {
+		name      string
+		overwrite bool
+		want      string
+	}{
+		{
+			name: "append",
+			want: `metadata:mitre_technique_id T1001, created_at 2020_01_01, mitre_technique_id T1002, updated_at 2020_06_01, mitre_tactic_id TA0011, mitre_technique_id T1003, updated_at 2021_02_01;`,
+		},
+		{
+			name:      "overwrite",
+			overwrite: true,
+			want:      `metadata:mitre_technique_id T1003, created_at 2020_01_01, updated_at 2021_02_01, mitre_tactic_id TA0011;`,
+		},
+	} {
+		r, err := ParseRule(`alert tcp any any -> any any (msg:"foo"; metadata:mitre_technique_id T1001, created_at 2020_01_01, mitre_technique_id T1002, updated_at 2020_06_01; sid:1; rev:1;)`)
+		if err != nil {
+			t.Fatalf("%s: parse rule failed: %v", tt.name, err)
+		}
+		r.AutoBumpRevision = true
+		r.MergeMetadata(kv, tt.overwrite)
+		if got := r.Metas.String(); got != tt.want {
+			t.Fatalf("%s: got %v; expected %v", tt.name, got, tt.want)
+		}
+		if r.Revision != 2 {
+			t.Fatalf("%s: got revision %d; expected 2", tt.name, r.Revision)
+		}
+		// Merging again is a no-op.
+		r.MergeMetadata(kv, tt.overwrite)
+		if got := r.Metas.String(); got != tt.want || r.Revision != 2 {
+			t.Fatalf("%s: second merge got %v, revision %d", tt.name, got, r.Revision)
+		}
+	}
+}
+
+func TestNormalizeMetadata(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		input  string
+		sorted bool
+		want   string
+	}{
+		{
+			name:  "parsed spacing",
+			input: "metadata:  updated_at   2020_01_01,created_at\t2019_01_01 ,  tag  a  b;",
+			want:  "metadata:updated_at 2020_01_01, created_at 2019_01_01, tag a b;",
+		},
+		{
+			name:   "sorted",
+			input:  "metadata:updated_at 2020_01_01, tag b, created_at 2019_01_01, tag a;",
+			sorted: true,
+			want:   "metadata:created_at 2019_01_01, tag a, tag b, updated_at 2020_01_01;",
+		},
+	} {
+		r, err := ParseRule(fmt.Sprintf(`alert tcp any any -> any any (msg:"foo"; %s sid:1; rev:1;)`, tt.input))
+		if err != nil {
+			t.Fatalf("%s: parse rule failed: %v", tt.name, err)
+		}
+		r.NormalizeMetadata(tt.sorted)
+		if got := r.Metas.String(); got != tt.want {
+			t.Fatalf("%s: got %v; expected %v", tt.name, got, tt.want)
+		}
+	}
+
+	r := &Rule{Metas: Metadatas{{Key: " tag ", Value: "a   b "}}}
+	r.NormalizeMetadata(false)
+	if got, want := r.Metas.String(), "metadata:tag a b;"; got != want {
+		t.Fatalf("got %v; expected %v", got, want)
+	}
+}
+
+func TestSortMetadata(t *testing.T) {
+	a, err := ParseRule(`alert tcp any any -> any any (msg:"foo"; metadata:updated_at 2020_01_01, tag b, created_at 2019_01_01, tag a; sid:1; rev:1;)`)
+	if err != nil {
+		t.Fatalf("parse rule failed: %v", err)
+	}
+	b, err := ParseRule(`alert tcp any any -> any any (msg:"foo"; metadata:tag a, created_at 2019_01_01; metadata:updated_at 2020_01_01, tag b; sid:1; rev:1;)`)
+	if err != nil {
+		t.Fatalf("parse rule failed: %v", err)
+	}
+	want := `alert tcp any any -> any any (msg:"foo"; metadata:created_at 2019_01_01, tag a, tag b, updated_at 2020_01_01; sid:1; rev:1;)`
+
+	opts := DefaultRenderOptions()
+	opts.SortMetadata = true
+	for _, r := range []*Rule{a, b} {
+		if got := r.StringOpts(opts); got != want {
+			t.Fatalf("SortMetadata option: got %v; expected %v", got, want)
+		}
+	}
+	if a.String() == want {
+		t.Fatalf("metadata sorted without SortMetadata: %v", a)
+	}
+
+	a.SortMetadata()
+	b.SortMetadata()
+	if a.String() != want || b.String() != want {
+		t.Fatalf("SortMetadata: got %v and %v; expected %v", a, b, want)
+	}
+}
+
 func TestRuleGetSidMsg(t *testing.T) {
 	for _, tt := range []struct {
 		name  string
@@ -1500,3 +2576,344 @@ func TestRuleGetSidMsg(t *testing.T) {
 		}
 	}
 }
+
+func TestTagGetters(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		input     *Rule
+		wantSid   int
+		wantGid   int
+		wantGidOK bool
+		wantRev   int
+		wantRevOK bool
+	}{
+		{
+			name:  "unset",
+			input: &Rule{},
+		},
+		{
+			name: "valid",
+			input: &Rule{
+				GID:      1,
+				SID:      1234,
+				Revision: 3,
+			},
+			wantSid:   1234,
+			wantGid:   1,
+			wantGidOK: true,
+			wantRev:   3,
+			wantRevOK: true,
+		},
+	} {
+		if got := tt.input.Sid(); got != tt.wantSid {
+			t.Fatalf("%s: Sid() got %v; expected %v", tt.name, got, tt.wantSid)
+		}
+		if got, ok := tt.input.Gid(); got != tt.wantGid || ok != tt.wantGidOK {
+			t.Fatalf("%s: Gid() got %v,%v; expected %v,%v", tt.name, got, ok, tt.wantGid, tt.wantGidOK)
+		}
+		if got, ok := tt.input.Rev(); got != tt.wantRev || ok != tt.wantRevOK {
+			t.Fatalf("%s: Rev() got %v,%v; expected %v,%v", tt.name, got, ok, tt.wantRev, tt.wantRevOK)
+		}
+	}
+}
+
+func TestOriginGetters(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		input      *Rule
+		wantFile   string
+		wantFileOK bool
+		wantLine   int
+		wantLineOK bool
+		wantString string
+	}{
+		{
+			name:  "unset",
+			input: &Rule{},
+		},
+		{
+			name:       "line only",
+			input:      &Rule{Origin: &Origin{Line: 3}},
+			wantLine:   3,
+			wantLineOK: true,
+			wantString: "line 3",
+		},
+		{
+			name:       "file and line",
+			input:      &Rule{Origin: &Origin{File: "local.rules", Line: 12}},
+			wantFile:   "local.rules",
+			wantFileOK: true,
+			wantLine:   12,
+			wantLineOK: true,
+			wantString: "local.rules:12",
+		},
+	} {
+		if got, ok := tt.input.File(); got != tt.wantFile || ok != tt.wantFileOK {
+			t.Fatalf("%s: File() got %v,%v; expected %v,%v", tt.name, got, ok, tt.wantFile, tt.wantFileOK)
+		}
+		if got, ok := tt.input.Line(); got != tt.wantLine || ok != tt.wantLineOK {
+			t.Fatalf("%s: Line() got %v,%v; expected %v,%v", tt.name, got, ok, tt.wantLine, tt.wantLineOK)
+		}
+		if tt.input.Origin != nil {
+			if got := tt.input.Origin.String(); got != tt.wantString {
+				t.Fatalf("%s: String() got %v; expected %v", tt.name, got, tt.wantString)
+			}
+		}
+	}
+}
+
+func TestTLS(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		input string
+		want  TLSInfo
+	}{
+		{
+			name:  "no tls keywords",
+			input: `alert tls any any -> any any (msg:"foo"; sid:1; rev:1;)`,
+		},
+		{
+			name:  "all tls keywords",
+			input: `alert tls any any -> any any (msg:"foo"; tls.version:!1.3; tls.fingerprint:"4a:b6:c2:5d"; tls_cert_expired; tls.cert_valid; tls.store; sid:1; rev:1;)`,
+			want: TLSInfo{
+				Version:       TLS13,
+				VersionNegate: true,
+				Fingerprint:   "4a:b6:c2:5d",
+				CertExpired:   true,
+				CertValid:     true,
+				Store:         true,
+			},
+		},
+		{
+			name:  "repeated version",
+			input: `alert tls any any -> any any (msg:"foo"; tls.version:1.2; tls.version:!1.0; sid:1; rev:1;)`,
+			want:  TLSInfo{Version: TLS12},
+		},
+	} {
+		r, err := ParseRule(tt.input)
+		if err != nil {
+			t.Fatalf("%s: parse failed: %v", tt.name, err)
+		}
+		diff := pretty.Compare(r.TLS(), tt.want)
+		if diff != "" {
+			t.Fatal(fmt.Sprintf("%s: diff (-got +want):\n%s", tt.name, diff))
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		input  *Rule
+		other  *Rule
+		source string
+		want   *Rule
+	}{
+		{
+			name: "merge",
+			input: &Rule{
+				Revision:         1,
+				AutoBumpRevision: true,
+				References:       []*Reference{{Type: "cve", Value: "2020-1"}},
+				Metas:            Metadatas{{Key: "foo", Value: "bar"}},
+			},
+			other: &Rule{
+				References: []*Reference{{Type: "cve", Value: "2020-1"}, {Type: "url", Value: "example.com"}},
+				Metas:      Metadatas{{Key: "foo", Value: "bar"}, {Key: "foo", Value: "baz"}},
+			},
+			want: &Rule{
+				Revision:         2,
+				AutoBumpRevision: true,
+				References:       []*Reference{{Type: "cve", Value: "2020-1"}, {Type: "url", Value: "example.com"}},
+				Metas:            Metadatas{{Key: "foo", Value: "bar"}, {Key: "foo", Value: "baz"}},
+			},
+		},
+		{
+			name: "merge with source",
+			input: &Rule{
+				Revision:         1,
+				AutoBumpRevision: true,
+				Metas:            Metadatas{{Key: "foo", Value: "bar"}},
+			},
+			other: &Rule{
+				References: []*Reference{{Type: "url", Value: "example.com"}},
+			},
+			source: "feedA",
+			want: &Rule{
+				Revision:         2,
+				AutoBumpRevision: true,
+				References:       []*Reference{{Type: "url", Value: "example.com"}},
+				Metas:            Metadatas{{Key: "foo", Value: "bar"}, {Key: "source", Value: "feedA"}},
+			},
+		},
+		{
+			name: "no-op merge with source",
+			input: &Rule{
+				Revision:         1,
+				AutoBumpRevision: true,
+				References:       []*Reference{{Type: "url", Value: "example.com"}},
+			},
+			other: &Rule{
+				References: []*Reference{{Type: "url", Value: "example.com"}},
+			},
+			source: "feedB",
+			want: &Rule{
+				Revision:         1,
+				AutoBumpRevision: true,
+				References:       []*Reference{{Type: "url", Value: "example.com"}},
+			},
+		},
+		{
+			name: "re-merge with source",
+			input: &Rule{
+				Revision:         1,
+				AutoBumpRevision: true,
+				References:       []*Reference{{Type: "url", Value: "example.com"}},
+				Metas:            Metadatas{{Key: "source", Value: "feedA"}},
+			},
+			other: &Rule{
+				References: []*Reference{{Type: "url", Value: "example.com"}},
+				Metas:      Metadatas{{Key: "source", Value: "feedA"}},
+			},
+			source: "feedA",
+			want: &Rule{
+				Revision:         1,
+				AutoBumpRevision: true,
+				References:       []*Reference{{Type: "url", Value: "example.com"}},
+				Metas:            Metadatas{{Key: "source", Value: "feedA"}},
+			},
+		},
+	} {
+		if tt.source != "" {
+			tt.input.MergeWithSource(tt.other, tt.source)
+		} else {
+			tt.input.Merge(tt.other)
+		}
+		diff := pretty.Compare(tt.input, tt.want)
+		if diff != "" {
+			t.Fatal(fmt.Sprintf("%s: diff (-got +want):\n%s", tt.name, diff))
+		}
+	}
+}
+
+func TestIsNoAlert(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		rule string
+		want bool
+	}{
+		{
+			name: "alerting rule",
+			rule: `alert tcp any any -> any any (msg:"foo"; flowbits:set,foo; sid:1; rev:1;)`,
+		},
+		{
+			name: "flowbits noalert",
+			rule: `alert tcp any any -> any any (msg:"foo"; flowbits:set,foo; flowbits:noalert; sid:1; rev:1;)`,
+			want: true,
+		},
+		{
+			name: "noalert keyword",
+			rule: `alert tcp any any -> any any (msg:"foo"; flowbits:set,foo; noalert; sid:1; rev:1;)`,
+			want: true,
+		},
+	} {
+		r, err := ParseRule(tt.rule)
+		if err != nil {
+			t.Fatalf("%s: parse failed: %v", tt.name, err)
+		}
+		if got := r.IsNoAlert(); got != tt.want {
+			t.Fatalf("%s: got %v; expected %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReplaceContent(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		input string
+		old   string
+		new   string
+		want  string
+		count int
+	}{
+		{
+			name:  "keeps options and buffer",
+			input: `alert dns any any -> any any (msg:"foo"; dns.query; content:"evil.com"; nocase; endswith; fast_pattern; pkt_data; content:"|00|evil.com|00|"; offset:4; sid:1; rev:1;)`,
+			old:   "evil.com",
+			new:   "bad.net",
+			want:  `alert dns any any -> any any (msg:"foo"; dns.query; content:"bad.net"; endswith; nocase; fast_pattern; pkt_data; content:"|00|bad.net|00|"; offset:4; sid:1; rev:2;)`,
+			count: 2,
+		},
+		{
+			name:  "fast_pattern past the new pattern",
+			input: `alert dns any any -> any any (msg:"foo"; dns.query; content:"www.evil.com.io"; fast_pattern:5,10; sid:1; rev:1;)`,
+			old:   "evil.com.io",
+			new:   "bad.io",
+			want:  `alert dns any any -> any any (msg:"foo"; dns.query; content:"www.bad.io"; fast_pattern; sid:1; rev:2;)`,
+			count: 1,
+		},
+		{
+			name:  "fast_pattern within the new pattern",
+			input: `alert dns any any -> any any (msg:"foo"; dns.query; content:"x.evil.com.io"; fast_pattern:2,4; sid:1; rev:1;)`,
+			old:   "evil.com.io",
+			new:   "bad.io",
+			want:  `alert dns any any -> any any (msg:"foo"; dns.query; content:"x.bad.io"; fast_pattern:2,4; sid:1; rev:2;)`,
+			count: 1,
+		},
+		{
+			name:  "nocase",
+			input: `alert http any any -> any any (msg:"foo"; http.host; content:"EVIL.com"; nocase; http.uri; content:"/EVIL.com/evil.com"; sid:1; rev:1;)`,
+			old:   "evil.com",
+			new:   "bad.net",
+			want:  `alert http any any -> any any (msg:"foo"; http.host; content:"bad.net"; nocase; http.uri; content:"/EVIL.com/bad.net"; sid:1; rev:2;)`,
+			count: 2,
+		},
+		{
+			name:  "escaping",
+			input: `alert tcp any any -> any any (msg:"foo"; content:"id=abc|3B| x"; sid:1; rev:1;)`,
+			old:   "abc",
+			new:   "a;b\"c|\x00",
+			want:  `alert tcp any any -> any any (msg:"foo"; content:"id=a|3B|b|22|c|7C 00 3B| x"; sid:1; rev:2;)`,
+			count: 1,
+		},
+		{
+			name:  "no match",
+			input: `alert tcp any any -> any any (msg:"foo"; content:"abc"; pcre:"/abc/"; sid:1; rev:1;)`,
+			old:   "abd",
+			new:   "xyz",
+			want:  `alert tcp any any -> any any (msg:"foo"; content:"abc"; pcre:"/abc/"; sid:1; rev:1;)`,
+		},
+		{
+			name:  "empty old",
+			input: `alert tcp any any -> any any (msg:"foo"; content:"abc"; sid:1; rev:1;)`,
+			new:   "xyz",
+			want:  `alert tcp any any -> any any (msg:"foo"; content:"abc"; sid:1; rev:1;)`,
+		},
+		{
+			name:  "empty new",
+			input: `alert tcp any any -> any any (msg:"foo"; content:"abc"; sid:1; rev:1;)`,
+			old:   "abc",
+			want:  `alert tcp any any -> any any (msg:"foo"; content:"abc"; sid:1; rev:1;)`,
+		},
+	} {
+		r, err := ParseRule(tt.input)
+		if err != nil {
+			t.Fatalf("%s: parse rule failed: %v", tt.name, err)
+		}
+		r.AutoBumpRevision = true
+		if got := r.ReplaceContent([]byte(tt.old), []byte(tt.new)); got != tt.count {
+			t.Fatalf("%s: got %d replacements; expected %d", tt.name, got, tt.count)
+		}
+		if got := r.String(); got != tt.want {
+			t.Fatalf("%s: got %v; expected %v", tt.name, got, tt.want)
+		}
+		// The rule must parse back to the same patterns.
+		p, err := ParseRule(r.String())
+		if err != nil {
+			t.Fatalf("%s: parse replaced rule failed: %v", tt.name, err)
+		}
+		if diff := pretty.Compare(p.Contents(), r.Contents()); diff != "" {
+			t.Fatal(fmt.Sprintf("%s: diff (-got +want):\n%s", tt.name, diff))
+		}
+	}
+}
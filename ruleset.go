@@ -0,0 +1,486 @@
+/* Copyright 2020 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// flowbitNames returns the names of the flowbits used by the rule with one of the actions, in
+// order and without duplicates. Values combining names (a|b or a&b) are split.
+func (r *Rule) flowbitNames(actions ...string) []string {
+	var names []string
+	for _, fb := range r.Flowbits {
+		if !inSlice(fb.Action, actions) {
+			continue
+		}
+		for _, n := range strings.FieldsFunc(fb.Value, func(r rune) bool { return r == '|' || r == '&' }) {
+			n = strings.TrimSpace(n)
+			if n != "" && !inSlice(n, names) {
+				names = append(names, n)
+			}
+		}
+	}
+	return names
+}
+
+// SetBits returns the names of the flowbits set by the rule.
+func (r *Rule) SetBits() []string {
+	return r.flowbitNames("set")
+}
+
+// CheckedBits returns the names of the flowbits the rule depends on (isset, isnotset and toggle).
+func (r *Rule) CheckedBits() []string {
+	return r.flowbitNames("isset", "isnotset", "toggle")
+}
+
+// HasFlowbitDependency returns true if the rule depends on flowbits set by other rules.
+func (r *Rule) HasFlowbitDependency() bool {
+	return len(r.CheckedBits()) > 0
+}
+
+// FlowbitUsage describes the rules using a flowbit.
+type FlowbitUsage struct {
+	// Producers are the rules setting the flowbit.
+	Producers []*Rule
+	// Consumers are the rules checking the flowbit.
+	Consumers []*Rule
+}
+
+// FlowbitGraph maps flowbit names to the rules producing and consuming them.
+type FlowbitGraph map[string]*FlowbitUsage
+
+// BuildFlowbitGraph returns the producers and consumers of each flowbit used in a ruleset.
+func BuildFlowbitGraph(rules []*Rule) FlowbitGraph {
+	g := make(FlowbitGraph)
+	usage := func(name string) *FlowbitUsage {
+		u, ok := g[name]
+		if !ok {
+			u = &FlowbitUsage{}
+			g[name] = u
+		}
+		return u
+	}
+	for _, r := range rules {
+		for _, n := range r.SetBits() {
+			u := usage(n)
+			u.Producers = append(u.Producers, r)
+		}
+		for _, n := range r.CheckedBits() {
+			u := usage(n)
+			u.Consumers = append(u.Consumers, r)
+		}
+	}
+	return g
+}
+
+// DeadDependencies returns the sorted names of the flowbits that are checked but never set.
+func (g FlowbitGraph) DeadDependencies() []string {
+	var names []string
+	for n, u := range g {
+		if len(u.Producers) == 0 && len(u.Consumers) > 0 {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DeadFlowbitKind describes how a flowbit chain is broken.
+type DeadFlowbitKind int
+
+const (
+	// NeverSet is a flowbit checked (isset, isnotset or toggle) but never set.
+	NeverSet DeadFlowbitKind = iota
+	// NeverChecked is a flowbit set but never checked.
+	NeverChecked
+)
+
+var deadFlowbitKindVals = map[DeadFlowbitKind]string{
+	NeverSet:     "never set",
+	NeverChecked: "never checked",
+}
+
+// String returns a description of a DeadFlowbitKind.
+func (k DeadFlowbitKind) String() string {
+	return deadFlowbitKindVals[k]
+}
+
+// DeadFlowbit describes a broken flowbit chain.
+type DeadFlowbit struct {
+	// Name is the name of the flowbit.
+	Name string
+	// Kind is how the chain is broken, the flowbit is never set or never checked.
+	Kind DeadFlowbitKind
+	// SIDs are the sids of the rules checking the flowbit if it is never set, or setting it if it is
+	// never checked.
+	SIDs []int
+}
+
+// String returns a description of a DeadFlowbit, e.g. "login: never set (sids 2, 3)".
+func (d DeadFlowbit) String() string {
+	sids := make([]string, len(d.SIDs))
+	for i, sid := range d.SIDs {
+		sids[i] = strconv.Itoa(sid)
+	}
+	return fmt.Sprintf("%s: %s (sids %s)", d.Name, d.Kind, strings.Join(sids, ", "))
+}
+
+// ruleSIDs returns the sids of rules.
+func ruleSIDs(rules []*Rule) []int {
+	sids := make([]int, len(rules))
+	for i, r := range rules {
+		sids[i] = r.SID
+	}
+	return sids
+}
+
+// DeadFlowbits returns every broken flowbit chain of the graph, sorted by flowbit name: flowbits
+// checked (isset, isnotset or toggle) but never set, and flowbits set but never checked.
+func (g FlowbitGraph) DeadFlowbits() []DeadFlowbit {
+	names := make([]string, 0, len(g))
+	for n := range g {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var dead []DeadFlowbit
+	for _, n := range names {
+		u := g[n]
+		switch {
+		case len(u.Producers) == 0:
+			dead = append(dead, DeadFlowbit{Name: n, Kind: NeverSet, SIDs: ruleSIDs(u.Consumers)})
+		case len(u.Consumers) == 0:
+			dead = append(dead, DeadFlowbit{Name: n, Kind: NeverChecked, SIDs: ruleSIDs(u.Producers)})
+		}
+	}
+	return dead
+}
+
+// DeadFlowbits describes every broken flowbit chain in a ruleset with the sids of the rules
+// involved, sorted by flowbit name. Use BuildFlowbitGraph and FlowbitGraph.DeadFlowbits for the
+// structured form.
+func DeadFlowbits(rules []*Rule) []string {
+	var ds []string
+	for _, d := range BuildFlowbitGraph(rules).DeadFlowbits() {
+		ds = append(ds, d.String())
+	}
+	return ds
+}
+
+// FieldDiff describes a field of a rule that changed.
+type FieldDiff struct {
+	// Field is the name of the field (e.g. msg, or matcher 2 for the third matcher).
+	Field string
+	// Old is the value in the old rule, empty if the field was added.
+	Old string
+	// New is the value in the new rule, empty if the field was removed.
+	New string
+}
+
+// RuleChange describes a rule present in both rulesets, that changed.
+type RuleChange struct {
+	SID int
+	Old *Rule
+	New *Rule
+	// Functional is false if the rules have the same Hash, for example if only the revision or
+	// the metadata changed.
+	Functional bool
+	// Fields are the changed fields, in the order they are written in a rule.
+	Fields []FieldDiff
+}
+
+// RulesetDiff describes the changes between two rulesets. Rules are sorted by SID.
+type RulesetDiff struct {
+	Added    []*Rule
+	Removed  []*Rule
+	Modified []*RuleChange
+}
+
+// ruleField is a named field of a rule, for field level diffs.
+type ruleField struct {
+	name  string
+	value string
+}
+
+// diffFields returns the fields of a rule compared by DiffRulesets, in the order they are written.
+func (r *Rule) diffFields() []ruleField {
+	var fs []ruleField
+	add := func(name, value string) {
+		if value != "" {
+			fs = append(fs, ruleField{name, value})
+		}
+	}
+	add("disabled", strconv.FormatBool(r.Disabled))
+	add("action", r.Action)
+	add("protocol", r.Protocol)
+	add("source", r.Source.String())
+	add("bidirectional", strconv.FormatBool(r.Bidirectional))
+	add("destination", r.Destination.String())
+	add("msg", r.Description)
+	for i, m := range r.Matchers {
+		v := m.String()
+		if d, ok := matcherDataPos(m); ok {
+			if c, isContent := m.(*Content); isContent {
+				d = c.buffer()
+			}
+			v = fmt.Sprintf("%s; %s", d, v)
+		}
+		add(fmt.Sprintf("matcher %d", i), v)
+	}
+	if r.StreamMatch != nil {
+		add("stream_size", r.StreamMatch.String())
+	}
+	for i, t := range r.TLSTags {
+		add(fmt.Sprintf("tls %d", i), t.String())
+	}
+	add("metadata", r.Metas.String())
+	for _, t := range r.typedTags() {
+		add(t[0], t[1])
+	}
+	tags := make([]string, 0, len(r.Tags))
+	for k := range r.Tags {
+		tags = append(tags, k)
+	}
+	sort.Strings(tags)
+	for _, k := range tags {
+		add(k, r.Tags[k])
+	}
+	multiTags := make([]string, 0, len(r.MultiTags))
+	for k := range r.MultiTags {
+		multiTags = append(multiTags, k)
+	}
+	sort.Strings(multiTags)
+	for _, k := range multiTags {
+		add(k, strings.Join(r.MultiTags[k], "; "))
+	}
+	add("prefilter", r.PrefilterTag)
+	add("statements", strings.Join(r.Statements, "; "))
+	add("sameip", strconv.FormatBool(r.SameIP))
+	if r.GeoIP != nil {
+		add("geoip", r.GeoIP.String())
+	}
+	for i, p := range r.IPRep {
+		add(fmt.Sprintf("iprep %d", i), p.String())
+	}
+	if r.DCEIface != nil {
+		add("dce_iface", r.DCEIface.String())
+	}
+	if len(r.DCEOpnums) > 0 {
+		add("dce_opnum", dceOpnumString(r.DCEOpnums))
+	}
+	for i, p := range r.IPProto {
+		add(fmt.Sprintf("ip_proto %d", i), p.String())
+	}
+	add("noalert", strconv.FormatBool(r.NoAlert))
+	for i, fb := range r.Flowbits {
+		add(fmt.Sprintf("flowbits %d", i), fb.String())
+	}
+	for i, fi := range r.Flowints {
+		add(fmt.Sprintf("flowint %d", i), fi.String())
+	}
+	for i, xb := range r.Xbits {
+		add(fmt.Sprintf("xbits %d", i), xb.String())
+	}
+	for i, l := range r.Lua {
+		add(fmt.Sprintf("lua %d", i), l.String())
+	}
+	for i, f := range r.FileMatches {
+		add(fmt.Sprintf("file %d", i), f.String())
+	}
+	if r.Filestore != nil {
+		add("filestore", r.Filestore.String())
+	}
+	for i, ref := range r.References {
+		add(fmt.Sprintf("reference %d", i), ref.String())
+	}
+	add("gid", strconv.Itoa(r.effectiveGID()))
+	add("rev", strconv.Itoa(r.Revision))
+	return fs
+}
+
+// diffRules returns the fields that differ between two rules.
+func diffRules(oldRule, newRule *Rule) []FieldDiff {
+	oldFields, newFields := oldRule.diffFields(), newRule.diffFields()
+	values := make(map[string]string)
+	for _, f := range oldFields {
+		values[f.name] = f.value
+	}
+	var diffs []FieldDiff
+	seen := make(map[string]bool)
+	for _, f := range newFields {
+		seen[f.name] = true
+		if values[f.name] != f.value {
+			diffs = append(diffs, FieldDiff{Field: f.name, Old: values[f.name], New: f.value})
+		}
+	}
+	for _, f := range oldFields {
+		if !seen[f.name] {
+			diffs = append(diffs, FieldDiff{Field: f.name, Old: f.value})
+		}
+	}
+	return diffs
+}
+
+// rulesBySID returns the rules keyed by SID, and the sorted SIDs. If a SID is used more than once,
+// the last rule is kept.
+func rulesBySID(rules []*Rule) (map[int]*Rule, []int) {
+	m := make(map[int]*Rule)
+	var sids []int
+	for _, r := range rules {
+		if _, ok := m[r.SID]; !ok {
+			sids = append(sids, r.SID)
+		}
+		m[r.SID] = r
+	}
+	sort.Ints(sids)
+	return m, sids
+}
+
+// DiffRulesets returns the rules added, removed and modified between two rulesets, keyed by SID.
+// Modified rules include a field level diff, and whether the change is functional, using Hash.
+func DiffRulesets(oldRules, newRules []*Rule) RulesetDiff {
+	var d RulesetDiff
+	oldBySID, oldSIDs := rulesBySID(oldRules)
+	newBySID, newSIDs := rulesBySID(newRules)
+	for _, sid := range oldSIDs {
+		if _, ok := newBySID[sid]; !ok {
+			d.Removed = append(d.Removed, oldBySID[sid])
+		}
+	}
+	for _, sid := range newSIDs {
+		n := newBySID[sid]
+		o, ok := oldBySID[sid]
+		if !ok {
+			d.Added = append(d.Added, n)
+			continue
+		}
+		fields := diffRules(o, n)
+		if len(fields) == 0 {
+			continue
+		}
+		d.Modified = append(d.Modified, &RuleChange{
+			SID:        sid,
+			Old:        o,
+			New:        n,
+			Functional: o.Hash() != n.Hash(),
+			Fields:     fields,
+		})
+	}
+	return d
+}
+
+// DuplicateSID is a group of rules sharing a SID.
+type DuplicateSID struct {
+	// GID is the gid of the rules, 0 if gids are ignored.
+	GID int
+	SID int
+	// Rules are the rules sharing the SID, in ruleset order.
+	Rules []*Rule
+}
+
+// DuplicateSIDs returns the rules of a ruleset sharing a SID, keyed by SID and in ruleset order.
+// The gid is ignored, use DuplicateSIDGroups to only report collisions within a gid.
+func DuplicateSIDs(rules []*Rule) map[int][]*Rule {
+	dups := make(map[int][]*Rule)
+	for _, d := range DuplicateSIDGroups(rules, false) {
+		dups[d.SID] = d.Rules
+	}
+	return dups
+}
+
+// DuplicateSIDGroups returns the groups of rules of a ruleset sharing a SID, sorted by GID and
+// SID. If scopeByGID is true, rules only collide if they also have the same gid (an unset gid is
+// 1), otherwise the gid is ignored.
+func DuplicateSIDGroups(rules []*Rule, scopeByGID bool) []DuplicateSID {
+	type key struct{ gid, sid int }
+	groups := make(map[key][]*Rule)
+	var keys []key
+	for _, r := range rules {
+		k := key{sid: r.SID}
+		if scopeByGID {
+			k.gid = r.effectiveGID()
+		}
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], r)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].gid != keys[j].gid {
+			return keys[i].gid < keys[j].gid
+		}
+		return keys[i].sid < keys[j].sid
+	})
+	var dups []DuplicateSID
+	for _, k := range keys {
+		if rs := groups[k]; len(rs) > 1 {
+			dups = append(dups, DuplicateSID{GID: k.gid, SID: k.sid, Rules: rs})
+		}
+	}
+	return dups
+}
+
+// CollectFastPatterns returns the fast pattern of each rule of a ruleset (see
+// Rule.FastPatternBytes), keyed by sid. Rules without a fast pattern are not included.
+func CollectFastPatterns(rules []*Rule) map[int][]byte {
+	patterns := make(map[int][]byte)
+	for _, r := range rules {
+		if p, ok := r.FastPatternBytes(); ok {
+			patterns[r.SID] = p
+		}
+	}
+	return patterns
+}
+
+// GeoIPCountries returns the country codes used in the geoip matches of a ruleset, in upper case,
+// mapped to the rules using them in order.
+func GeoIPCountries(rules []*Rule) map[string][]*Rule {
+	countries := make(map[string][]*Rule)
+	for _, r := range rules {
+		if r.GeoIP == nil {
+			continue
+		}
+		seen := make(map[string]bool)
+		for _, c := range r.GeoIP.Countries {
+			c = strings.ToUpper(c)
+			if !seen[c] {
+				seen[c] = true
+				countries[c] = append(countries[c], r)
+			}
+		}
+	}
+	return countries
+}
+
+// LuaScripts returns the Lua scripts used by the rules of a ruleset, mapped to the rules using
+// them in order. A rule using a script more than once is only listed once.
+func LuaScripts(rules []*Rule) map[string][]*Rule {
+	scripts := make(map[string][]*Rule)
+	for _, r := range rules {
+		seen := make(map[string]bool)
+		for _, l := range r.Lua {
+			if !seen[l.Script] {
+				seen[l.Script] = true
+				scripts[l.Script] = append(scripts[l.Script], r)
+			}
+		}
+	}
+	return scripts
+}
@@ -0,0 +1,262 @@
+/* Copyright 2020 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+// parseRules parses rules for tests, and fails the test on error.
+func parseRules(t *testing.T, rules ...string) []*Rule {
+	t.Helper()
+	var rs []*Rule
+	for _, s := range rules {
+		r, err := ParseRule(s)
+		if err != nil {
+			t.Fatalf("parse rule %q failed: %v", s, err)
+		}
+		rs = append(rs, r)
+	}
+	return rs
+}
+
+func TestFlowbitAccessors(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		input       string
+		wantSet     []string
+		wantChecked []string
+	}{
+		{
+			name:  "no flowbits",
+			input: `alert tcp any any -> any any (msg:"foo"; sid:1; rev:1;)`,
+		},
+		{
+			name:    "set",
+			input:   `alert tcp any any -> any any (msg:"foo"; flowbits:set,a; flowbits:set,b; flowbits:noalert; sid:1; rev:1;)`,
+			wantSet: []string{"a", "b"},
+		},
+		{
+			name:        "checked",
+			input:       `alert tcp any any -> any any (msg:"foo"; flowbits:isset,a|b; flowbits:isnotset,c; flowbits:toggle,d; flowbits:unset,a; sid:1; rev:1;)`,
+			wantChecked: []string{"a", "b", "c", "d"},
+		},
+	} {
+		r := parseRules(t, tt.input)[0]
+		if diff := pretty.Compare(r.SetBits(), tt.wantSet); diff != "" {
+			t.Fatal(fmt.Sprintf("%s: SetBits diff (-got +want):\n%s", tt.name, diff))
+		}
+		if diff := pretty.Compare(r.CheckedBits(), tt.wantChecked); diff != "" {
+			t.Fatal(fmt.Sprintf("%s: CheckedBits diff (-got +want):\n%s", tt.name, diff))
+		}
+		if got, want := r.HasFlowbitDependency(), tt.wantChecked != nil; got != want {
+			t.Fatalf("%s: got %v; expected %v", tt.name, got, want)
+		}
+	}
+}
+
+func TestBuildFlowbitGraph(t *testing.T) {
+	rs := parseRules(t,
+		`alert tcp any any -> any any (msg:"set"; flowbits:set,login; flowbits:noalert; sid:1; rev:1;)`,
+		`alert tcp any any -> any any (msg:"check"; flowbits:isset,login; sid:2; rev:1;)`,
+		`alert tcp any any -> any any (msg:"dead"; flowbits:isset,login&admin; sid:3; rev:1;)`,
+	)
+	g := BuildFlowbitGraph(rs)
+	var sids []string
+	for _, n := range []string{"login", "admin"} {
+		for _, p := range g[n].Producers {
+			sids = append(sids, fmt.Sprintf("%s>%d", n, p.SID))
+		}
+		for _, c := range g[n].Consumers {
+			sids = append(sids, fmt.Sprintf("%s<%d", n, c.SID))
+		}
+	}
+	if diff := pretty.Compare(sids, []string{"login>1", "login<2", "login<3", "admin<3"}); diff != "" {
+		t.Fatal(fmt.Sprintf("diff (-got +want):\n%s", diff))
+	}
+	if diff := pretty.Compare(g.DeadDependencies(), []string{"admin"}); diff != "" {
+		t.Fatal(fmt.Sprintf("diff (-got +want):\n%s", diff))
+	}
+}
+
+func TestDeadFlowbits(t *testing.T) {
+	rs := parseRules(t,
+		`alert tcp any any -> any any (msg:"set"; flowbits:set,login; flowbits:set,unused; flowbits:noalert; sid:1; rev:1;)`,
+		`alert tcp any any -> any any (msg:"check"; flowbits:isset,login; sid:2; rev:1;)`,
+		`alert tcp any any -> any any (msg:"dead"; flowbits:isset,login&admin; sid:3; rev:1;)`,
+		`alert tcp any any -> any any (msg:"toggle"; flowbits:toggle,admin; sid:4; rev:1;)`,
+		`alert tcp any any -> any any (msg:"unused"; flowbits:set,unused; sid:5; rev:1;)`,
+	)
+	want := []DeadFlowbit{
+		{Name: "admin", Kind: NeverSet, SIDs: []int{3, 4}},
+		{Name: "unused", Kind: NeverChecked, SIDs: []int{1, 5}},
+	}
+	if diff := pretty.Compare(BuildFlowbitGraph(rs).DeadFlowbits(), want); diff != "" {
+		t.Fatal(fmt.Sprintf("diff (-got +want):\n%s", diff))
+	}
+	wantStrings := []string{"admin: never set (sids 3, 4)", "unused: never checked (sids 1, 5)"}
+	if diff := pretty.Compare(DeadFlowbits(rs), wantStrings); diff != "" {
+		t.Fatal(fmt.Sprintf("diff (-got +want):\n%s", diff))
+	}
+	wantStrings = []string{"unused: never checked (sids 1)"}
+	if diff := pretty.Compare(DeadFlowbits(rs[:2]), wantStrings); diff != "" {
+		t.Fatal(fmt.Sprintf("diff (-got +want):\n%s", diff))
+	}
+}
+
+func TestDiffRulesets(t *testing.T) {
+	oldRules := parseRules(t,
+		`alert tcp any any -> any any (msg:"removed"; sid:1; rev:1;)`,
+		`alert tcp any any -> any any (msg:"unchanged"; content:"a"; sid:2; rev:1;)`,
+		`alert tcp any any -> any any (msg:"rev bump"; content:"a"; metadata:updated_at 2020_01_01; sid:3; rev:1;)`,
+		`alert tcp any any -> any any (msg:"modified"; content:"a"; content:"b"; nocase; classtype:misc-activity; sid:4; rev:1;)`,
+	)
+	newRules := parseRules(t,
+		`alert tcp any any -> any any (msg:"modified"; content:"a"; content:"c"; classtype:trojan-activity; reference:cve,2020-1234; sid:4; rev:2;)`,
+		`alert tcp any any -> any any (msg:"rev bump"; content:"a"; metadata:updated_at 2020_02_01; sid:3; rev:2;)`,
+		`alert tcp any any -> any any (msg:"unchanged"; content:"a"; sid:2; rev:1;)`,
+		`alert tcp any any -> any any (msg:"added"; sid:5; rev:1;)`,
+	)
+	d := DiffRulesets(oldRules, newRules)
+	var got []string
+	for _, r := range d.Added {
+		got = append(got, fmt.Sprintf("added %d", r.SID))
+	}
+	for _, r := range d.Removed {
+		got = append(got, fmt.Sprintf("removed %d", r.SID))
+	}
+	for _, c := range d.Modified {
+		got = append(got, fmt.Sprintf("modified %d functional:%v", c.SID, c.Functional))
+	}
+	want := []string{"added 5", "removed 1", "modified 3 functional:false", "modified 4 functional:true"}
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Fatal(fmt.Sprintf("diff (-got +want):\n%s", diff))
+	}
+
+	wantFields := []FieldDiff{
+		{Field: "matcher 1", Old: `pkt_data; content:"b"; nocase;`, New: `pkt_data; content:"c";`},
+		{Field: "classtype", Old: "misc-activity", New: "trojan-activity"},
+		{Field: "reference 0", New: "reference:cve,2020-1234;"},
+		{Field: "rev", Old: "1", New: "2"},
+	}
+	if diff := pretty.Compare(d.Modified[1].Fields, wantFields); diff != "" {
+		t.Fatal(fmt.Sprintf("diff (-got +want):\n%s", diff))
+	}
+	if _, err := json.Marshal(d); err != nil {
+		t.Fatalf("marshal diff failed: %v", err)
+	}
+}
+
+func TestDuplicateSIDs(t *testing.T) {
+	rs := parseRules(t,
+		`alert tcp any any -> any any (msg:"a"; sid:1; rev:1;)`,
+		`alert tcp any any -> any any (msg:"b"; gid:1; sid:1; rev:1;)`,
+		`alert tcp any any -> any any (msg:"c"; gid:2; sid:1; rev:1;)`,
+		`alert tcp any any -> any any (msg:"d"; gid:2; sid:2; rev:1;)`,
+		`alert tcp any any -> any any (msg:"e"; sid:3; rev:1;)`,
+		`alert tcp any any -> any any (msg:"f"; gid:2; sid:1; rev:1;)`,
+	)
+	type group struct {
+		GID, SID int
+		Msgs     []string
+	}
+	for _, tt := range []struct {
+		name       string
+		scopeByGID bool
+		want       []group
+	}{
+		{
+			name: "ignore gid",
+			want: []group{{SID: 1, Msgs: []string{"a", "b", "c", "f"}}},
+		},
+		{
+			name:       "scope by gid",
+			scopeByGID: true,
+			want:       []group{{GID: 1, SID: 1, Msgs: []string{"a", "b"}}, {GID: 2, SID: 1, Msgs: []string{"c", "f"}}},
+		},
+	} {
+		var got []group
+		for _, d := range DuplicateSIDGroups(rs, tt.scopeByGID) {
+			g := group{GID: d.GID, SID: d.SID}
+			for _, r := range d.Rules {
+				g.Msgs = append(g.Msgs, r.Description)
+			}
+			got = append(got, g)
+		}
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Fatal(fmt.Sprintf("%s: diff (-got +want):\n%s", tt.name, diff))
+		}
+	}
+
+	dups := DuplicateSIDs(rs)
+	if len(dups) != 1 || len(dups[1]) != 4 || dups[1][0] != rs[0] || dups[1][3] != rs[5] {
+		t.Fatalf("got %v; expected the 4 rules with sid 1", dups)
+	}
+}
+
+func TestCollectFastPatterns(t *testing.T) {
+	rs := parseRules(t,
+		`alert tcp any any -> any any (msg:"a"; content:"longer"; content:"fp"; fast_pattern; sid:1; rev:1;)`,
+		`alert tcp any any -> any any (msg:"b"; content:"abc"; content:!"negated"; content:"abcdef"; sid:2; rev:1;)`,
+		`alert tcp any any -> any any (msg:"c"; content:"xxhello"; fast_pattern:2,5; sid:3; rev:1;)`,
+		`alert tcp any any -> any any (msg:"d"; content:!"abc"; sid:4; rev:1;)`,
+		`alert tcp any any -> any any (msg:"e"; dsize:>10; sid:5; rev:1;)`,
+	)
+	want := map[int][]byte{1: []byte("fp"), 2: []byte("abcdef"), 3: []byte("hello")}
+	if diff := pretty.Compare(CollectFastPatterns(rs), want); diff != "" {
+		t.Fatal(fmt.Sprintf("diff (-got +want):\n%s", diff))
+	}
+}
+
+func TestGeoIPCountries(t *testing.T) {
+	rs := parseRules(t,
+		`alert ip any any -> any any (msg:"a"; geoip:src,CN,RU; sid:1; rev:1;)`,
+		`alert ip any any -> any any (msg:"b"; geoip:any,cn,IR,CN; sid:2; rev:1;)`,
+		`alert ip any any -> any any (msg:"c"; sid:3; rev:1;)`,
+	)
+	got := make(map[string][]int)
+	for country, rules := range GeoIPCountries(rs) {
+		for _, r := range rules {
+			got[country] = append(got[country], r.SID)
+		}
+	}
+	want := map[string][]int{"CN": {1, 2}, "RU": {1}, "IR": {2}}
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Fatal(fmt.Sprintf("diff (-got +want):\n%s", diff))
+	}
+}
+
+func TestLuaScripts(t *testing.T) {
+	rs := parseRules(t,
+		`alert tcp any any -> any any (msg:"a"; lua:a.lua; lua:!a.lua; sid:1; rev:1;)`,
+		`alert tcp any any -> any any (msg:"b"; luajit:scripts/b.lua; lua:a.lua; sid:2; rev:1;)`,
+		`alert tcp any any -> any any (msg:"c"; sid:3; rev:1;)`,
+	)
+	got := make(map[string][]int)
+	for script, rules := range LuaScripts(rs) {
+		for _, r := range rules {
+			got[script] = append(got[script], r.SID)
+		}
+	}
+	want := map[string][]int{"a.lua": {1, 2}, "scripts/b.lua": {2}}
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Fatal(fmt.Sprintf("diff (-got +want):\n%s", diff))
+	}
+}
@@ -0,0 +1,340 @@
+/* Copyright 2020 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"math"
+	"strings"
+)
+
+// BufferCoverage summarizes the content matches of a rule for a single buffer.
+type BufferCoverage struct {
+	// Contents is the number of non-negated contents.
+	Contents int
+	// Negated is the number of negated contents.
+	Negated int
+	// Bytes is the total length of all content patterns, negated or not.
+	Bytes int
+	// FastPattern is true if any content in the buffer is marked fast_pattern.
+	FastPattern bool
+	// Longest is the longest non-negated content, nil if there is none.
+	Longest *Content
+}
+
+// CoverageReport returns a summary of the content matches of a rule, grouped by buffer. Contents
+// using a content modifier (e.g. http_uri) are grouped with the matching sticky buffer, as in
+// ContentsForBuffer.
+func (r *Rule) CoverageReport() map[DataPos]BufferCoverage {
+	report := make(map[DataPos]BufferCoverage)
+	for _, c := range r.Contents() {
+		d := c.buffer()
+		bc := report[d]
+		bc.Bytes += len(c.Pattern)
+		if c.isFastPattern() {
+			bc.FastPattern = true
+		}
+		if c.Negate {
+			bc.Negated++
+		} else {
+			bc.Contents++
+			if bc.Longest == nil || len(c.Pattern) > len(bc.Longest.Pattern) {
+				bc.Longest = c
+			}
+		}
+		report[d] = bc
+	}
+	return report
+}
+
+// RuleStats counts the matchers and keywords of a rule, for profiling rulesets.
+type RuleStats struct {
+	// Contents is the number of contents, negated or not.
+	Contents int
+	// ContentBytes is the total length of all content patterns.
+	ContentBytes int
+	// PCREs is the number of pcres.
+	PCREs int
+	// ByteMatchers is the number of byte_* matchers, isdataat and base64_decode.
+	ByteMatchers int
+	// LenMatchers is the number of length matches (e.g. dsize, urilen).
+	LenMatchers int
+	// Flowbits is the number of flowbits.
+	Flowbits int
+	// FastPattern is true if a content is marked fast_pattern.
+	FastPattern bool
+}
+
+// Stats returns the counts of the matchers and keywords of a rule. It does not allocate.
+func (r *Rule) Stats() RuleStats {
+	s := RuleStats{Flowbits: len(r.Flowbits)}
+	for _, m := range r.Matchers {
+		switch v := m.(type) {
+		case *Content:
+			s.Contents++
+			s.ContentBytes += len(v.Pattern)
+			if v.isFastPattern() {
+				s.FastPattern = true
+			}
+		case *PCRE:
+			s.PCREs++
+		case *ByteMatch:
+			s.ByteMatchers++
+		case *LenMatch:
+			s.LenMatchers++
+		}
+	}
+	return s
+}
+
+// AggregateStats summarizes a ruleset. Percentages are between 0 and 100.
+type AggregateStats struct {
+	// Rules is the number of rules.
+	Rules int `json:"rules"`
+	// Disabled is the number of disabled rules.
+	Disabled int `json:"disabled"`
+	// ByAction is the number of rules for each action.
+	ByAction map[string]int `json:"by_action"`
+	// ByProtocol is the number of rules for each protocol.
+	ByProtocol map[string]int `json:"by_protocol"`
+	// PCRERules is the number of rules with at least one pcre.
+	PCRERules int `json:"pcre_rules"`
+	// PCREPercent is the percentage of rules with at least one pcre.
+	PCREPercent float64 `json:"pcre_percent"`
+	// Contents is the total number of contents.
+	Contents int `json:"contents"`
+	// AvgContentLength is the average length of the content patterns.
+	AvgContentLength float64 `json:"avg_content_length"`
+	// FastPatternRules is the number of rules with a content marked fast_pattern.
+	FastPatternRules int `json:"fast_pattern_rules"`
+	// FastPatternCoverage is the percentage of rules with contents that mark one as fast_pattern.
+	FastPatternCoverage float64 `json:"fast_pattern_coverage"`
+}
+
+// percent returns n as a percentage of total, 0 if total is 0.
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+// RulesetStats returns statistics aggregated across rules, computed from the Stats of each rule.
+func RulesetStats(rules []*Rule) AggregateStats {
+	a := AggregateStats{
+		Rules:      len(rules),
+		ByAction:   make(map[string]int),
+		ByProtocol: make(map[string]int),
+	}
+	var contentBytes, contentRules int
+	for _, r := range rules {
+		s := r.Stats()
+		if r.Disabled {
+			a.Disabled++
+		}
+		a.ByAction[r.Action]++
+		a.ByProtocol[r.Protocol]++
+		if s.PCREs > 0 {
+			a.PCRERules++
+		}
+		a.Contents += s.Contents
+		contentBytes += s.ContentBytes
+		if s.Contents > 0 {
+			contentRules++
+		}
+		if s.FastPattern {
+			a.FastPatternRules++
+		}
+	}
+	a.PCREPercent = percent(a.PCRERules, a.Rules)
+	a.FastPatternCoverage = percent(a.FastPatternRules, contentRules)
+	if a.Contents > 0 {
+		a.AvgContentLength = float64(contentBytes) / float64(a.Contents)
+	}
+	return a
+}
+
+// Thresholds used to score the specificity of contents and rules.
+const (
+	// specificityMaxLen is the length at which a content gets the full length score.
+	specificityMaxLen = 16
+	// commonContentFactor is applied to the specificity of the contents seen in most traffic
+	// (e.g. "GET", "index.php").
+	commonContentFactor = 0.1
+	// pcreSpecificity is the specificity of a pcre, which is not scored from its pattern.
+	pcreSpecificity = 0.25
+)
+
+// ContentMetrics are the components of the specificity of a content.
+type ContentMetrics struct {
+	// Length is the length of the pattern in bytes.
+	Length int
+	// Entropy is the Shannon entropy of the pattern, in bits per byte (0 to 8).
+	Entropy float64
+	// NormalizedEntropy is Entropy divided by the highest entropy possible for the length of the
+	// pattern (0 to 1). It is 0 for patterns shorter than 2 bytes.
+	NormalizedEntropy float64
+	// Printable is the fraction of the pattern that is printable ASCII (0 to 1).
+	Printable float64
+	// Common is true if the pattern is a string seen in most traffic (e.g. "GET").
+	Common bool
+}
+
+// Metrics returns the components of the specificity of a content.
+func (c *Content) Metrics() ContentMetrics {
+	m := ContentMetrics{Length: len(c.Pattern)}
+	if m.Length == 0 {
+		return m
+	}
+	var counts [256]int
+	var printable int
+	for _, b := range c.Pattern {
+		counts[b]++
+		if b >= 0x20 && b < 0x7f {
+			printable++
+		}
+	}
+	for _, n := range counts {
+		if n == 0 {
+			continue
+		}
+		p := float64(n) / float64(m.Length)
+		m.Entropy -= p * math.Log2(p)
+	}
+	if max := math.Log2(math.Min(float64(m.Length), 256)); max > 0 {
+		m.NormalizedEntropy = m.Entropy / max
+	}
+	m.Printable = float64(printable) / float64(m.Length)
+	m.Common = inSlice(strings.ToLower(strings.Trim(string(c.Pattern), "\r\n :/?")), bannedContents)
+	return m
+}
+
+// Specificity returns how specific a content is, from 0 (matches most traffic) to 1. The score is
+// the length score (the length up to specificityMaxLen bytes, divided by specificityMaxLen),
+// weighted by the normalized entropy for half of its value, and by the fraction of non-printable
+// bytes for a fifth of its value, as binary patterns are rarer than text. Common contents are
+// multiplied by commonContentFactor. A negated content has no specificity.
+func (c *Content) Specificity() float64 {
+	if c.Negate {
+		return 0
+	}
+	m := c.Metrics()
+	length := math.Min(float64(m.Length), specificityMaxLen) / specificityMaxLen
+	s := length * (0.5 + 0.5*m.NormalizedEntropy) * (0.8 + 0.2*(1-m.Printable))
+	if m.Common {
+		s *= commonContentFactor
+	}
+	return s
+}
+
+// SpecificityScore returns how specific a rule is, from 0 to 1, combining the specificity of its
+// contents and pcres (each pcre counts as pcreSpecificity) as independent probabilities: the score
+// is 1 - (1-s1)*(1-s2)*... A rule with no content or pcre has a score of 0. Low scores flag rules
+// likely to cause false positives, such as a single short common content.
+func (r *Rule) SpecificityScore() float64 {
+	miss := 1.0
+	for _, c := range r.Contents() {
+		miss *= 1 - c.Specificity()
+	}
+	for _, p := range r.PCREs() {
+		if !p.Negate {
+			miss *= 1 - pcreSpecificity
+		}
+	}
+	return 1 - miss
+}
+
+// matchStart returns the smallest position where a content can start, relative to the start of the
+// buffer for absolute contents, or to the end of the previous match for relative contents
+// (distance, within). Variables (e.g. set with byte_extract) count as 0.
+func (c *Content) matchStart() int {
+	start, _ := c.intOption("offset")
+	if c.relative() {
+		start, _ = c.intOption("distance")
+	}
+	if start < 0 {
+		return 0
+	}
+	return start
+}
+
+// relative returns true if a content is positioned relative to the previous match.
+func (c *Content) relative() bool {
+	return c.Distance != nil || c.Within != nil
+}
+
+// MinMatchLength returns the smallest number of bytes a content consumes, from the start of the
+// buffer, or from the end of the previous match for relative contents (distance, within): the
+// offset or distance, plus the length of the pattern. A negative distance counts as 0.
+func (c *Content) MinMatchLength() int {
+	return c.matchStart() + len(c.Pattern)
+}
+
+// MaxMatchLength returns the largest number of bytes a content consumes, counted like
+// MinMatchLength: offset plus depth, distance plus within, or the length of the pattern with
+// startswith. It returns -1 if the match is unbounded, or bounded by a variable.
+func (c *Content) MaxMatchLength() int {
+	if c.hasOption("startswith") {
+		return len(c.Pattern)
+	}
+	start, limit := "offset", "depth"
+	if c.relative() {
+		start, limit = "distance", "within"
+	}
+	n, ok := c.intOption(limit)
+	if !ok {
+		return -1
+	}
+	if c.positionOption(start) != nil {
+		v, ok := c.intOption(start)
+		if !ok {
+			return -1
+		}
+		n += v
+	}
+	if n < 0 {
+		return -1
+	}
+	return n
+}
+
+// MinBufferLength returns the smallest length a buffer must have for the contents of a rule to
+// match, the largest over all buffers. Relative contents start at their distance from the minimum
+// end of the previous non-negated content in the same buffer, a negative distance overlapping the
+// previous match, and negated contents are ignored. It returns 0 if the rule has no content.
+func (r *Rule) MinBufferLength() int {
+	ends := make(map[DataPos]int)
+	var min int
+	for _, c := range r.Contents() {
+		if c.Negate {
+			continue
+		}
+		d := c.buffer()
+		end := c.MinMatchLength()
+		if c.relative() {
+			distance, _ := c.intOption("distance")
+			start := ends[d] + distance
+			if start < 0 {
+				start = 0
+			}
+			end = start + len(c.Pattern)
+		}
+		ends[d] = end
+		if end > min {
+			min = end
+		}
+	}
+	return min
+}
@@ -0,0 +1,410 @@
+/* Copyright 2020 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestCoverageReport(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		input string
+		want  map[DataPos]BufferCoverage
+	}{
+		{
+			name:  "no contents",
+			input: `alert tcp any any -> any any (msg:"foo"; dsize:>10; sid:1; rev:1;)`,
+			want:  map[DataPos]BufferCoverage{},
+		},
+		{
+			name:  "single buffer",
+			input: `alert tcp any any -> any any (msg:"foo"; content:"abc"; content:!"de"; content:"fghij"; fast_pattern; sid:1; rev:1;)`,
+			want: map[DataPos]BufferCoverage{
+				pktData: {
+					Contents:    2,
+					Negated:     1,
+					Bytes:       10,
+					FastPattern: true,
+					Longest: &Content{
+						Pattern:     []byte("fghij"),
+						FastPattern: FastPattern{Enabled: true},
+					},
+				},
+			},
+		},
+		{
+			name:  "content modifier",
+			input: `alert http any any -> any any (msg:"foo"; content:"abc"; http_uri; content:"defgh"; sid:1; rev:1;)`,
+			want: map[DataPos]BufferCoverage{
+				pktData: {
+					Contents: 1,
+					Bytes:    5,
+					Longest: &Content{
+						Pattern: []byte("defgh"),
+					},
+				},
+				httpURI: {
+					Contents: 1,
+					Bytes:    3,
+					Longest: &Content{
+						Pattern: []byte("abc"),
+						Options: []*ContentOption{{Name: "http_uri"}},
+					},
+				},
+			},
+		},
+		{
+			name:  "multiple buffers",
+			input: `alert http any any -> any any (msg:"foo"; content:"abc"; http.uri; content:"/index"; content:"php"; dns.query; content:!"foo"; sid:1; rev:1;)`,
+			want: map[DataPos]BufferCoverage{
+				pktData: {
+					Contents: 1,
+					Bytes:    3,
+					Longest: &Content{
+						Pattern: []byte("abc"),
+					},
+				},
+				httpURI: {
+					Contents: 2,
+					Bytes:    9,
+					Longest: &Content{
+						DataPosition: httpURI,
+						Pattern:      []byte("/index"),
+					},
+				},
+				dnsQuery5: {
+					Negated: 1,
+					Bytes:   3,
+				},
+			},
+		},
+	} {
+		r, err := ParseRule(tt.input)
+		if err != nil {
+			t.Fatalf("%s: parse rule failed: %v", tt.name, err)
+		}
+		diff := pretty.Compare(r.CoverageReport(), tt.want)
+		if diff != "" {
+			t.Fatal(fmt.Sprintf("%s: diff (-got +want):\n%s", tt.name, diff))
+		}
+	}
+}
+
+func TestRuleStats(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		input string
+		want  RuleStats
+	}{
+		{
+			name:  "no matchers",
+			input: `alert tcp any any -> any any (msg:"foo"; sid:1;)`,
+		},
+		{
+			name: "all matchers",
+			input: `alert http any any -> any any (msg:"foo"; flowbits:isset,a; flowbits:set,b; content:"foo"; fast_pattern; ` +
+				`content:!"ab"; http.uri; content:"/x"; pcre:"/a/R"; pcre:"/b/U"; byte_test:1,=,1,0,relative; isdataat:1,relative; ` +
+				`urilen:>3; sid:1;)`,
+			want: RuleStats{
+				Contents:     3,
+				ContentBytes: 7,
+				PCREs:        2,
+				ByteMatchers: 2,
+				LenMatchers:  1,
+				Flowbits:     2,
+				FastPattern:  true,
+			},
+		},
+	} {
+		r, err := ParseRule(tt.input)
+		if err != nil {
+			t.Fatalf("%s: parse rule failed: %v", tt.name, err)
+		}
+		diff := pretty.Compare(r.Stats(), tt.want)
+		if diff != "" {
+			t.Fatal(fmt.Sprintf("%s: diff (-got +want):\n%s", tt.name, diff))
+		}
+	}
+
+	r, err := ParseRule(`alert tcp any any -> any any (msg:"foo"; content:"foo"; pcre:"/a/"; sid:1;)`)
+	if err != nil {
+		t.Fatalf("parse rule failed: %v", err)
+	}
+	if n := testing.AllocsPerRun(10, func() { r.Stats() }); n != 0 {
+		t.Fatalf("Stats allocated %v times", n)
+	}
+}
+
+func TestRulesetStats(t *testing.T) {
+	var rules []*Rule
+	for _, rule := range []string{
+		`alert http any any -> any any (msg:"foo"; content:"abcd"; fast_pattern; pcre:"/a/R"; sid:1;)`,
+		`alert tcp any any -> any any (msg:"foo"; content:"ab"; content:"abcdef"; sid:2;)`,
+		`#drop tcp any any -> any any (msg:"foo"; flowbits:isset,a; sid:3;)`,
+		`alert dns any any -> any any (msg:"foo"; pcre:"/b/"; sid:4;)`,
+	} {
+		r, err := ParseRule(rule)
+		if err != nil {
+			t.Fatalf("parse rule failed: %v", err)
+		}
+		rules = append(rules, r)
+	}
+	want := AggregateStats{
+		Rules:               4,
+		Disabled:            1,
+		ByAction:            map[string]int{"alert": 3, "drop": 1},
+		ByProtocol:          map[string]int{"http": 1, "tcp": 2, "dns": 1},
+		PCRERules:           2,
+		PCREPercent:         50,
+		Contents:            3,
+		AvgContentLength:    4,
+		FastPatternRules:    1,
+		FastPatternCoverage: 50,
+	}
+	got := RulesetStats(rules)
+	diff := pretty.Compare(got, want)
+	if diff != "" {
+		t.Fatal(fmt.Sprintf("diff (-got +want):\n%s", diff))
+	}
+
+	b, err := json.Marshal(RulesetStats(nil))
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	if want := `{"rules":0,"disabled":0,"by_action":{},"by_protocol":{},"pcre_rules":0,"pcre_percent":0,"contents":0,"avg_content_length":0,"fast_pattern_rules":0,"fast_pattern_coverage":0}`; string(b) != want {
+		t.Fatalf("got %s; expected %s", b, want)
+	}
+}
+
+func TestContentMetrics(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		input *Content
+		want  ContentMetrics
+	}{
+		{
+			name:  "empty",
+			input: &Content{},
+		},
+		{
+			name:  "single byte",
+			input: &Content{Pattern: []byte("a")},
+			want:  ContentMetrics{Length: 1, Printable: 1},
+		},
+		{
+			name:  "uniform",
+			input: &Content{Pattern: []byte("abcd")},
+			want:  ContentMetrics{Length: 4, Entropy: 2, NormalizedEntropy: 1, Printable: 1},
+		},
+		{
+			name:  "binary",
+			input: &Content{Pattern: []byte{0x00, 0x00, 'a', 'a'}},
+			want:  ContentMetrics{Length: 4, Entropy: 1, NormalizedEntropy: 0.5, Printable: 0.5},
+		},
+		{
+			name:  "common",
+			input: &Content{Pattern: []byte("GET ")},
+			want:  ContentMetrics{Length: 4, Entropy: 2, NormalizedEntropy: 1, Printable: 1, Common: true},
+		},
+	} {
+		diff := pretty.Compare(tt.input.Metrics(), tt.want)
+		if diff != "" {
+			t.Fatal(fmt.Sprintf("%s: diff (-got +want):\n%s", tt.name, diff))
+		}
+	}
+}
+
+func TestSpecificity(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		input *Content
+		want  float64
+	}{
+		{
+			name:  "empty",
+			input: &Content{},
+			want:  0,
+		},
+		{
+			name:  "uniform",
+			input: &Content{Pattern: []byte("abcd")},
+			want:  0.2,
+		},
+		{
+			name:  "long binary",
+			input: &Content{Pattern: []byte("\x00\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f")},
+			want:  1,
+		},
+		{
+			name:  "common",
+			input: &Content{Pattern: []byte("GET ")},
+			want:  0.02,
+		},
+		{
+			name:  "negated",
+			input: &Content{Pattern: []byte("abcd"), Negate: true},
+			want:  0,
+		},
+	} {
+		if got := tt.input.Specificity(); math.Abs(got-tt.want) > 1e-9 {
+			t.Fatalf("%s: got %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSpecificityScore(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		input string
+		want  float64
+	}{
+		{
+			name:  "no contents",
+			input: `alert tcp any any -> any any (msg:"foo"; dsize:>10; sid:1; rev:1;)`,
+			want:  0,
+		},
+		{
+			name:  "single content",
+			input: `alert tcp any any -> any any (msg:"foo"; content:"abcd"; sid:1; rev:1;)`,
+			want:  0.2,
+		},
+		{
+			name:  "contents and pcre",
+			input: `alert tcp any any -> any any (msg:"foo"; content:"abcd"; content:"abcd"; pcre:"/x/"; sid:1; rev:1;)`,
+			want:  1 - 0.8*0.8*0.75,
+		},
+	} {
+		r, err := ParseRule(tt.input)
+		if err != nil {
+			t.Fatalf("%s: parse rule failed: %v", tt.name, err)
+		}
+		if got := r.SpecificityScore(); math.Abs(got-tt.want) > 1e-9 {
+			t.Fatalf("%s: got %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMatchLength(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		input   string
+		wantMin int
+		wantMax int
+	}{
+		{
+			name:    "unbounded",
+			input:   `content:"abc";`,
+			wantMin: 3,
+			wantMax: -1,
+		},
+		{
+			name:    "offset and depth",
+			input:   `content:"abc"; offset:2; depth:5;`,
+			wantMin: 5,
+			wantMax: 7,
+		},
+		{
+			name:    "depth",
+			input:   `content:"abc"; depth:5;`,
+			wantMin: 3,
+			wantMax: 5,
+		},
+		{
+			name:    "distance and within",
+			input:   `content:"x"; content:"abc"; distance:1; within:4;`,
+			wantMin: 4,
+			wantMax: 5,
+		},
+		{
+			name:    "negative distance",
+			input:   `content:"x"; content:"abc"; distance:-1;`,
+			wantMin: 3,
+			wantMax: -1,
+		},
+		{
+			name:    "startswith",
+			input:   `content:"abc"; startswith;`,
+			wantMin: 3,
+			wantMax: 3,
+		},
+		{
+			name:    "variable",
+			input:   `byte_extract:1,0,off; content:"abc"; offset:off; depth:3;`,
+			wantMin: 3,
+			wantMax: -1,
+		},
+	} {
+		r, err := ParseRule(`alert tcp any any -> any any (msg:"foo"; ` + tt.input + ` sid:1; rev:1;)`)
+		if err != nil {
+			t.Fatalf("%s: parse rule failed: %v", tt.name, err)
+		}
+		c := r.LastContent()
+		if got := c.MinMatchLength(); got != tt.wantMin {
+			t.Fatalf("%s: MinMatchLength() got %d; want %d", tt.name, got, tt.wantMin)
+		}
+		if got := c.MaxMatchLength(); got != tt.wantMax {
+			t.Fatalf("%s: MaxMatchLength() got %d; want %d", tt.name, got, tt.wantMax)
+		}
+	}
+}
+
+func TestMinBufferLength(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		input string
+		want  int
+	}{
+		{
+			name:  "no contents",
+			input: `dsize:>10;`,
+			want:  0,
+		},
+		{
+			name:  "relative chain",
+			input: `content:"abc"; offset:2; content:"de"; distance:3; content:!"zzzzzzzzzzzz"; content:"f"; within:1;`,
+			want:  11,
+		},
+		{
+			name:  "negative distance overlaps the previous match",
+			input: `content:"abcdef"; content:"ef"; distance:-2;`,
+			want:  6,
+		},
+		{
+			name:  "negative distance before the start of the buffer",
+			input: `content:"ab"; content:"xyz"; distance:-5;`,
+			want:  3,
+		},
+		{
+			name:  "largest buffer",
+			input: `content:"abc"; http.uri; content:"/abcdef"; content:"g"; distance:0;`,
+			want:  8,
+		},
+	} {
+		r, err := ParseRule(`alert http any any -> any any (msg:"foo"; ` + tt.input + ` sid:1; rev:1;)`)
+		if err != nil {
+			t.Fatalf("%s: parse rule failed: %v", tt.name, err)
+		}
+		if got := r.MinBufferLength(); got != tt.want {
+			t.Fatalf("%s: got %d; want %d", tt.name, got, tt.want)
+		}
+	}
+}
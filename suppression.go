@@ -0,0 +1,192 @@
+/* Copyright 2020 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Suppression is an entry of a threshold.config file (suppress, threshold or event_filter),
+// applying to the rule with GID and SID.
+type Suppression struct {
+	// Kind is suppress, threshold or event_filter.
+	Kind string
+	// GID and SID identify the rule. A GID or SID of 0 matches all rules, as in Suricata.
+	GID int
+	SID int
+	// Track is by_src, by_dst or by_either for suppressions, by_src, by_dst, by_rule or by_both
+	// for thresholds. It is optional for suppressions.
+	Track string
+	// IP is the address suppressed, required for suppressions with Track.
+	IP string
+	// Type is limit, threshold or both, for thresholds.
+	Type string
+	// Count and Seconds are the parameters of thresholds.
+	Count   int
+	Seconds int
+}
+
+// Validate returns an error if the Suppression is not valid.
+func (s Suppression) Validate() error {
+	switch s.Kind {
+	case "suppress":
+		if s.Track == "" {
+			if s.IP != "" {
+				return fmt.Errorf("suppress with ip %s has no track", s.IP)
+			}
+			return nil
+		}
+		if !inSlice(s.Track, []string{"by_src", "by_dst", "by_either"}) {
+			return fmt.Errorf("invalid suppress track %q", s.Track)
+		}
+		if s.IP == "" {
+			return fmt.Errorf("suppress %s has no ip", s.Track)
+		}
+		return validateNetList([]string{s.IP}, validateAddress)
+	case "threshold", "event_filter":
+		if !inSlice(s.Type, []string{"limit", "threshold", "both"}) {
+			return fmt.Errorf("invalid %s type %q", s.Kind, s.Type)
+		}
+		if !inSlice(s.Track, []string{"by_src", "by_dst", "by_rule", "by_both"}) {
+			return fmt.Errorf("invalid %s track %q", s.Kind, s.Track)
+		}
+		if s.Count < 1 || s.Seconds < 1 {
+			return fmt.Errorf("%s count and seconds must be positive", s.Kind)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid suppression kind %q", s.Kind)
+}
+
+// String returns the threshold.config line of a Suppression.
+func (s Suppression) String() string {
+	var parts []string
+	parts = append(parts, fmt.Sprintf("%s gen_id %d", s.Kind, s.GID), fmt.Sprintf("sig_id %d", s.SID))
+	if s.Kind == "suppress" {
+		if s.Track != "" {
+			parts = append(parts, "track "+s.Track, "ip "+s.IP)
+		}
+		return strings.Join(parts, ", ")
+	}
+	parts = append(parts, s.thresholdValue())
+	return strings.Join(parts, ", ")
+}
+
+// thresholdValue returns the value of the threshold keyword equivalent to a threshold or
+// event_filter.
+func (s Suppression) thresholdValue() string {
+	return fmt.Sprintf("type %s, track %s, count %d, seconds %d", s.Type, s.Track, s.Count, s.Seconds)
+}
+
+// thresholdParams returns the parameters of the value of a threshold or detection_filter keyword,
+// so values can be compared regardless of spacing and ordering.
+func thresholdParams(v string) map[string]string {
+	params := make(map[string]string)
+	for _, p := range strings.Split(v, ",") {
+		f := strings.Fields(p)
+		if len(f) == 2 {
+			params[f[0]] = f[1]
+		}
+	}
+	return params
+}
+
+// negateNet returns nets excluding ip.
+func negateNet(nets []string, ip string) ([]string, error) {
+	for _, n := range nets {
+		switch n {
+		case "!" + ip:
+			return nets, nil
+		case ip:
+			return nil, fmt.Errorf("suppressed ip %s is matched explicitly", ip)
+		}
+	}
+	if len(nets) == 0 || (len(nets) == 1 && nets[0] == "any") {
+		return []string{"!" + ip}, nil
+	}
+	return append(append([]string(nil), nets...), "!"+ip), nil
+}
+
+// ApplySuppression merges a threshold.config entry into the rule, so it is self-contained:
+//
+//   - A suppress without track sets NoAlert, as the rule does not alert anymore.
+//   - A suppress with track excludes the ip from the rule's source (by_src), destination (by_dst)
+//     or both (by_either).
+//   - A threshold or event_filter sets the threshold keyword.
+//
+// An error is returned if the entry does not apply to the rule, or conflicts with it: a different
+// threshold, a detection_filter (Suricata does not allow both), or a suppress by_src or by_dst on a
+// bidirectional rule. The rule is not changed on error, and nothing is changed if the equivalent
+// keyword is already present.
+func (r *Rule) ApplySuppression(s Suppression) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	if (s.GID != 0 && s.GID != r.effectiveGID()) || (s.SID != 0 && s.SID != r.SID) {
+		return fmt.Errorf("%s does not apply to rule %d:%d", s, r.effectiveGID(), r.SID)
+	}
+
+	if s.Kind == "suppress" {
+		if s.Track == "" {
+			if !r.NoAlert {
+				r.NoAlert = true
+				r.modified()
+			}
+			return nil
+		}
+		if r.Bidirectional && s.Track != "by_either" {
+			return fmt.Errorf("cannot apply suppress %s to a bidirectional rule", s.Track)
+		}
+		src, dst := r.Source.Nets, r.Destination.Nets
+		var err error
+		if s.Track != "by_dst" {
+			if src, err = negateNet(src, s.IP); err != nil {
+				return fmt.Errorf("cannot apply suppress to source: %v", err)
+			}
+		}
+		if s.Track != "by_src" {
+			if dst, err = negateNet(dst, s.IP); err != nil {
+				return fmt.Errorf("cannot apply suppress to destination: %v", err)
+			}
+		}
+		if netString(src) != netString(r.Source.Nets) || netString(dst) != netString(r.Destination.Nets) {
+			r.Source.Nets, r.Destination.Nets = src, dst
+			r.modified()
+		}
+		return nil
+	}
+
+	if v, ok := r.Tags["detection_filter"]; ok {
+		return fmt.Errorf("%s conflicts with detection_filter:%s", s, v)
+	}
+	want := s.thresholdValue()
+	if v, ok := r.Tags["threshold"]; ok {
+		have := thresholdParams(v)
+		for k, p := range thresholdParams(want) {
+			if have[k] != p {
+				return fmt.Errorf("%s conflicts with threshold:%s", s, v)
+			}
+		}
+		return nil
+	}
+	if r.Tags == nil {
+		r.Tags = make(map[string]string)
+	}
+	r.Tags["threshold"] = want
+	r.modified()
+	return nil
+}
@@ -0,0 +1,168 @@
+/* Copyright 2020 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"testing"
+)
+
+func TestSuppressionString(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		input Suppression
+		want  string
+	}{
+		{
+			name:  "suppress",
+			input: Suppression{Kind: "suppress", GID: 1, SID: 123},
+			want:  "suppress gen_id 1, sig_id 123",
+		},
+		{
+			name:  "suppress with ip",
+			input: Suppression{Kind: "suppress", GID: 1, SID: 123, Track: "by_src", IP: "10.0.0.0/8"},
+			want:  "suppress gen_id 1, sig_id 123, track by_src, ip 10.0.0.0/8",
+		},
+		{
+			name:  "threshold",
+			input: Suppression{Kind: "threshold", GID: 1, SID: 123, Type: "limit", Track: "by_dst", Count: 1, Seconds: 60},
+			want:  "threshold gen_id 1, sig_id 123, type limit, track by_dst, count 1, seconds 60",
+		},
+	} {
+		if got := tt.input.String(); got != tt.want {
+			t.Fatalf("%s: got %v; expected %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestApplySuppression(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		rule    string
+		input   Suppression
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "suppress",
+			rule:  `alert tcp $HOME_NET any -> any any (msg:"foo"; sid:123; rev:1;)`,
+			input: Suppression{Kind: "suppress", GID: 1, SID: 123},
+			want:  `alert tcp $HOME_NET any -> any any (msg:"foo"; noalert; sid:123; rev:2;)`,
+		},
+		{
+			name:  "suppress by_src",
+			rule:  `alert tcp $HOME_NET any -> any any (msg:"foo"; sid:123; rev:1;)`,
+			input: Suppression{Kind: "suppress", GID: 1, SID: 123, Track: "by_src", IP: "10.0.0.1"},
+			want:  `alert tcp [$HOME_NET,!10.0.0.1] any -> any any (msg:"foo"; sid:123; rev:2;)`,
+		},
+		{
+			name:  "suppress by_dst any",
+			rule:  `alert tcp $HOME_NET any -> any any (msg:"foo"; sid:123; rev:1;)`,
+			input: Suppression{Kind: "suppress", SID: 123, Track: "by_dst", IP: "10.0.0.0/8"},
+			want:  `alert tcp $HOME_NET any -> !10.0.0.0/8 any (msg:"foo"; sid:123; rev:2;)`,
+		},
+		{
+			name:  "suppress by_either bidirectional",
+			rule:  `alert tcp $HOME_NET any <> any any (msg:"foo"; sid:123; rev:1;)`,
+			input: Suppression{Kind: "suppress", SID: 123, Track: "by_either", IP: "10.0.0.1"},
+			want:  `alert tcp [$HOME_NET,!10.0.0.1] any <> !10.0.0.1 any (msg:"foo"; sid:123; rev:2;)`,
+		},
+		{
+			name:  "already suppressed",
+			rule:  `alert tcp [$HOME_NET,!10.0.0.1] any -> any any (msg:"foo"; sid:123; rev:1;)`,
+			input: Suppression{Kind: "suppress", GID: 1, SID: 123, Track: "by_src", IP: "10.0.0.1"},
+			want:  `alert tcp [$HOME_NET,!10.0.0.1] any -> any any (msg:"foo"; sid:123; rev:1;)`,
+		},
+		{
+			name:    "suppress by_src bidirectional",
+			rule:    `alert tcp $HOME_NET any <> any any (msg:"foo"; sid:123; rev:1;)`,
+			input:   Suppression{Kind: "suppress", SID: 123, Track: "by_src", IP: "10.0.0.1"},
+			wantErr: true,
+		},
+		{
+			name:    "suppress only source",
+			rule:    `alert tcp 10.0.0.1 any -> any any (msg:"foo"; sid:123; rev:1;)`,
+			input:   Suppression{Kind: "suppress", SID: 123, Track: "by_src", IP: "10.0.0.1"},
+			wantErr: true,
+		},
+		{
+			name:  "threshold",
+			rule:  `alert tcp $HOME_NET any -> any any (msg:"foo"; sid:123; rev:1;)`,
+			input: Suppression{Kind: "threshold", GID: 1, SID: 123, Type: "limit", Track: "by_src", Count: 1, Seconds: 60},
+			want:  `alert tcp $HOME_NET any -> any any (msg:"foo"; threshold:type limit, track by_src, count 1, seconds 60; sid:123; rev:2;)`,
+		},
+		{
+			name:  "equivalent threshold",
+			rule:  `alert tcp $HOME_NET any -> any any (msg:"foo"; threshold:track by_src,type limit,seconds 60,count 1; sid:123; rev:1;)`,
+			input: Suppression{Kind: "event_filter", GID: 1, SID: 123, Type: "limit", Track: "by_src", Count: 1, Seconds: 60},
+			want:  `alert tcp $HOME_NET any -> any any (msg:"foo"; threshold:track by_src,type limit,seconds 60,count 1; sid:123; rev:1;)`,
+		},
+		{
+			name:    "conflicting threshold",
+			rule:    `alert tcp $HOME_NET any -> any any (msg:"foo"; threshold:type limit, track by_src, count 1, seconds 60; sid:123; rev:1;)`,
+			input:   Suppression{Kind: "threshold", GID: 1, SID: 123, Type: "limit", Track: "by_src", Count: 5, Seconds: 60},
+			wantErr: true,
+		},
+		{
+			name:    "conflicting detection_filter",
+			rule:    `alert tcp $HOME_NET any -> any any (msg:"foo"; detection_filter:track by_src, count 10, seconds 60; sid:123; rev:1;)`,
+			input:   Suppression{Kind: "threshold", GID: 1, SID: 123, Type: "limit", Track: "by_src", Count: 1, Seconds: 60},
+			wantErr: true,
+		},
+		{
+			name:    "other sid",
+			rule:    `alert tcp $HOME_NET any -> any any (msg:"foo"; sid:123; rev:1;)`,
+			input:   Suppression{Kind: "suppress", GID: 1, SID: 124},
+			wantErr: true,
+		},
+		{
+			name:    "other gid",
+			rule:    `alert tcp $HOME_NET any -> any any (msg:"foo"; sid:123; rev:1;)`,
+			input:   Suppression{Kind: "suppress", GID: 3, SID: 123},
+			wantErr: true,
+		},
+		{
+			name:    "invalid ip",
+			rule:    `alert tcp $HOME_NET any -> any any (msg:"foo"; sid:123; rev:1;)`,
+			input:   Suppression{Kind: "suppress", SID: 123, Track: "by_src", IP: "10.0.0.256"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid threshold",
+			rule:    `alert tcp $HOME_NET any -> any any (msg:"foo"; sid:123; rev:1;)`,
+			input:   Suppression{Kind: "threshold", SID: 123, Type: "limit", Track: "by_either", Count: 1, Seconds: 60},
+			wantErr: true,
+		},
+	} {
+		r, err := ParseRule(tt.rule)
+		if err != nil {
+			t.Fatalf("%s: parse failed: %v", tt.name, err)
+		}
+		r.AutoBumpRevision = true
+		err = r.ApplySuppression(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%s: got err %v; expected err %v", tt.name, err, tt.wantErr)
+		}
+		if tt.wantErr {
+			if got := r.String(); got != tt.rule {
+				t.Fatalf("%s: rule changed on error: %v", tt.name, got)
+			}
+			continue
+		}
+		if got := r.String(); got != tt.want {
+			t.Fatalf("%s: got %v; expected %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,608 @@
+/* Copyright 2020 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ValidationErrors holds all of the problems found when validating a rule.
+type ValidationErrors []error
+
+// Error returns all of the validation errors as a single string.
+func (v ValidationErrors) Error() string {
+	s := make([]string, len(v))
+	for i, err := range v {
+		s[i] = err.Error()
+	}
+	return strings.Join(s, "; ")
+}
+
+// transportProtocols are the network and transport protocols supported in the header of a rule.
+var transportProtocols = []string{"ip", "tcp", "udp", "icmp", "icmpv4", "icmpv6", "sctp", "pkthdr", "tcp-pkt", "tcp-stream", "ipv4", "ipv6", "ip4", "ip6"}
+
+// appLayerProtocols are the application layer protocols supported in the header of a rule.
+var appLayerProtocols = []string{
+	"http", "http1", "http2", "ftp", "ftp-data", "tls", "smb", "dcerpc", "smtp", "imap", "pop3", "dns",
+	"ssh", "modbus", "dnp3", "enip", "nfs", "ike", "ikev2", "krb5", "ntp", "dhcp", "snmp", "sip", "rfb",
+	"mqtt", "rdp", "tftp", "quic", "bittorrent-dht", "websocket", "ldap", "telnet",
+}
+
+// IsAppLayer returns true if the protocol of the rule is an application layer protocol (e.g. http).
+func (r *Rule) IsAppLayer() bool {
+	return inSlice(strings.ToLower(r.Protocol), appLayerProtocols)
+}
+
+// ValidateProtocol returns an error if the protocol of the rule is not a known Suricata protocol.
+// If allowUnknown is true, any protocol name is accepted as long as it is not empty.
+func (r *Rule) ValidateProtocol(allowUnknown bool) error {
+	p := strings.ToLower(r.Protocol)
+	if p == "" {
+		return errors.New("protocol is empty")
+	}
+	if allowUnknown || inSlice(p, transportProtocols) || inSlice(p, appLayerProtocols) {
+		return nil
+	}
+	return fmt.Errorf("unknown protocol %q", r.Protocol)
+}
+
+// bufferProtocols maps the prefix of sticky buffer names (e.g. "http" for http.uri or http_uri) to
+// the protocols of rules that can inspect them. Buffers with no entry are valid for any protocol.
+var bufferProtocols = map[string][]string{
+	"http": {"ip", "tcp", "http", "http1", "http2"},
+	"tls":  {"ip", "tcp", "tls"},
+	"ja3":  {"ip", "tcp", "tls"},
+	"ja3s": {"ip", "tcp", "tls"},
+	"ja4":  {"ip", "tcp", "udp", "tls", "quic"},
+	"ssh":  {"ip", "tcp", "ssh"},
+	"krb5": {"ip", "tcp", "udp", "krb5"},
+	"dns":  {"ip", "tcp", "udp", "dns"},
+	"smb":  {"ip", "tcp", "smb"},
+	"dce":  {"ip", "tcp", "udp", "smb", "dcerpc"},
+}
+
+// bufferPrefix returns the prefix of the name of a sticky buffer (e.g. "http" for http.uri).
+func bufferPrefix(d DataPos) string {
+	name := strings.FieldsFunc(d.String(), func(r rune) bool { return r == '_' || r == '.' })
+	if len(name) == 0 {
+		return ""
+	}
+	return name[0]
+}
+
+// bufferAppLayers maps the prefix of sticky buffer names to the application layer protocol they
+// belong to. Buffers with no entry (e.g. pkt_data, file_data) are not specific to a protocol.
+var bufferAppLayers = map[string]string{
+	"http": "http",
+	"tls":  "tls",
+	"ja3":  "tls",
+	"ja3s": "tls",
+	"ja4":  "tls",
+	"ssh":  "ssh",
+	"krb5": "krb5",
+	"dns":  "dns",
+	"smb":  "smb",
+	"dce":  "dcerpc",
+}
+
+// AppLayerBuffers returns the sorted application layer protocols implied by the buffers a rule
+// inspects (e.g. http for http.uri or a content using http_uri, tls for tls.sni or ja3.hash).
+func (r *Rule) AppLayerBuffers() []string {
+	seen := make(map[string]bool)
+	var ps []string
+	for _, m := range r.Matchers {
+		d, ok := matcherDataPos(m)
+		if c, isContent := m.(*Content); isContent {
+			d = c.buffer()
+		}
+		if !ok {
+			continue
+		}
+		if p, ok := bufferAppLayers[bufferPrefix(d)]; ok && !seen[p] {
+			seen[p] = true
+			ps = append(ps, p)
+		}
+	}
+	sort.Strings(ps)
+	return ps
+}
+
+// bufferProtocolError returns an error if the buffer d cannot be inspected by a rule using the
+// protocol p.
+func bufferProtocolError(d DataPos, p string) error {
+	protocols, ok := bufferProtocols[bufferPrefix(d)]
+	if !ok || inSlice(strings.ToLower(p), protocols) {
+		return nil
+	}
+	return fmt.Errorf("buffer %s is not compatible with protocol %s", d, p)
+}
+
+// Validate returns an error if the fast_pattern settings cannot be written in a rule.
+func (f FastPattern) Validate() error {
+	if !f.Enabled {
+		return nil
+	}
+	if f.Only && f.chopped() {
+		return errors.New("fast_pattern only cannot be used with an offset and length")
+	}
+	if f.Offset < 0 || f.Length < 0 {
+		return fmt.Errorf("fast_pattern offset and length must be positive: %d,%d", f.Offset, f.Length)
+	}
+	return nil
+}
+
+// ValidateOptions controls the checks of ValidateWithOptions.
+type ValidateOptions struct {
+	// FastPatternMinLen is the length under which a fast_pattern content is reported as too short.
+	FastPatternMinLen int
+}
+
+// DefaultValidateOptions returns the options used by Validate: fast_pattern contents shorter than
+// 3 bytes are reported.
+func DefaultValidateOptions() ValidateOptions {
+	return ValidateOptions{FastPatternMinLen: minFastPatternLen}
+}
+
+// minFastPatternEntropy is the normalized entropy (see ContentMetrics) under which a fast_pattern
+// content is reported as low entropy, e.g. a repeated byte.
+const minFastPatternEntropy = 0.5
+
+// fastPatternBytes returns the part of a content used as fast pattern.
+func (c *Content) fastPatternBytes() []byte {
+	f := c.FastPattern
+	if f.Offset > 0 || f.Length > 0 {
+		end := f.Offset + f.Length
+		if f.Offset >= 0 && f.Length > 0 && end <= len(c.Pattern) {
+			return c.Pattern[f.Offset:end]
+		}
+	}
+	return c.Pattern
+}
+
+// EstimateFastPatternQuality returns the specificity (see Content.Specificity) of the content
+// selected with fast_pattern, and an error if it is a poor choice: shorter than minLen bytes, low
+// entropy, or a common content (e.g. "GET"). The error suggests the most specific other content as
+// a candidate when there is one. It returns 0 and nil if no content is marked fast_pattern.
+//
+// Validate reports the same problems, with the minimum length of its ValidateOptions.
+func (r *Rule) EstimateFastPatternQuality(minLen int) (float64, error) {
+	var fp *Content
+	var best *Content
+	for _, c := range r.Contents() {
+		if c.isFastPattern() && fp == nil {
+			fp = c
+			continue
+		}
+		if !c.Negate && (best == nil || c.Specificity() > best.Specificity()) {
+			best = c
+		}
+	}
+	if fp == nil {
+		return 0, nil
+	}
+	chosen := &Content{Pattern: fp.fastPatternBytes(), Negate: fp.Negate}
+	score := chosen.Specificity()
+	m := chosen.Metrics()
+	var problem string
+	switch {
+	case m.Length < minLen:
+		problem = fmt.Sprintf("is too short (%d bytes, minimum %d)", m.Length, minLen)
+	case m.NormalizedEntropy < minFastPatternEntropy:
+		problem = fmt.Sprintf("has a low entropy (%.2f bits per byte)", m.Entropy)
+	case m.Common:
+		problem = "is too common"
+	default:
+		return score, nil
+	}
+	err := fmt.Sprintf("fast_pattern content %q %s", chosen.Pattern, problem)
+	if best != nil && best.Specificity() > score {
+		err += fmt.Sprintf(", consider %q", best.Pattern)
+	}
+	return score, errors.New(err)
+}
+
+// fingerprintFormat describes the format of the hashes in a TLS fingerprint buffer.
+type fingerprintFormat struct {
+	// full matches a complete hash.
+	full *regexp.Regexp
+	// partial matches the characters allowed in a part of a hash.
+	partial *regexp.Regexp
+}
+
+var (
+	md5Format = fingerprintFormat{
+		full:    regexp.MustCompile(`^[0-9a-f]{32}$`),
+		partial: regexp.MustCompile(`^[0-9a-f]+$`),
+	}
+	// A JA4 hash is made of a readable prefix (e.g. t13d1516h2) and two truncated SHA-256.
+	ja4Format = fingerprintFormat{
+		full:    regexp.MustCompile(`^[tqd][0-9a-z]{2}[di][0-9]{4}[0-9a-z]{2}_[0-9a-f]{12}_[0-9a-f]{12}$`),
+		partial: regexp.MustCompile(`^[0-9a-z_]+$`),
+	}
+)
+
+// fingerprintBuffers maps the TLS fingerprint hash buffers to the format of their hashes.
+var fingerprintBuffers = map[DataPos]fingerprintFormat{
+	ja3Hash:  md5Format,
+	ja3Hash5: md5Format,
+	ja3sHash: md5Format,
+	ja4Hash:  ja4Format,
+}
+
+// ValidateJA3 returns an error if a content in a JA3, JA3S or JA4 hash buffer cannot match a well
+// formed hash: a content with no options must be a complete hash, one with options (e.g. depth,
+// startswith) must only use the characters of a hash. Hashes are lowercase, uppercase characters
+// are only accepted with nocase. Contents in other buffers are always valid.
+func (c *Content) ValidateJA3() error {
+	d := c.buffer()
+	f, ok := fingerprintBuffers[d]
+	if !ok {
+		return nil
+	}
+	p := string(c.Pattern)
+	if c.Nocase {
+		p = strings.ToLower(p)
+	}
+	re := f.partial
+	if len(c.Options) == 0 && len(c.positionStrings()) == 0 {
+		re = f.full
+	}
+	if !re.MatchString(p) {
+		return fmt.Errorf("malformed %s content: %q", d, c.Pattern)
+	}
+	return nil
+}
+
+// positionErrors returns the conflicts between the positional options of a content that make the
+// content invalid, or impossible to match:
+//   - offset and depth cannot be mixed with distance and within,
+//   - startswith cannot be mixed with any positional option,
+//   - offset cannot be negative, depth and within must be positive,
+//   - depth and within cannot be shorter than the pattern.
+//
+// Depth is counted from the offset, so an offset larger than the depth is valid. Options set with
+// a variable (e.g. byte_extract) are only checked for mixing.
+func (c *Content) positionErrors() []error {
+	var errs []error
+	conflict := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("content %s: %s", c.FormatPattern(), fmt.Sprintf(format, args...)))
+	}
+	hasOffset, hasDepth := c.Offset != nil, c.Depth != nil
+	hasDistance, hasWithin := c.Distance != nil, c.Within != nil
+	if (hasOffset || hasDepth) && (hasDistance || hasWithin) {
+		conflict("offset and depth cannot be used with distance and within")
+	}
+	if c.hasOption("startswith") && (hasOffset || hasDepth || hasDistance || hasWithin) {
+		conflict("startswith cannot be used with offset, depth, distance or within")
+	}
+	if offset, ok := c.intOption("offset"); ok && offset < 0 {
+		conflict("offset %d is negative", offset)
+	}
+	for _, name := range []string{"depth", "within"} {
+		v, ok := c.intOption(name)
+		switch {
+		case !ok:
+		case v <= 0:
+			conflict("%s %d must be positive", name, v)
+		case v < len(c.Pattern):
+			conflict("%s %d is shorter than the pattern (%d bytes)", name, v, len(c.Pattern))
+		}
+	}
+	return errs
+}
+
+// requestLineRE matches the beginning of an HTTP request line, a method followed by a space.
+var requestLineRE = regexp.MustCompile(`^[A-Za-z-]+( |$)`)
+
+// lineBufferError returns an error if a content in http.request_line or http.response_line cannot
+// match a line: the buffers do not include the line ending, and a content using startswith must
+// begin with a method, or with the HTTP version for a response line.
+func lineBufferError(c *Content) error {
+	d := c.buffer()
+	if d != httpRequestLine && d != httpRequestLine5 && d != httpResponseLine && d != httpResponseLine5 {
+		return nil
+	}
+	if c.Negate {
+		return nil
+	}
+	if bytes.ContainsAny(c.Pattern, "\r\n") {
+		return fmt.Errorf("%s content %q contains a line ending", d, c.Pattern)
+	}
+	var startsWith bool
+	for _, o := range c.Options {
+		if o.Name == "startswith" {
+			startsWith = true
+		}
+	}
+	if !startsWith {
+		return nil
+	}
+	p := c.Pattern
+	if c.Nocase {
+		p = bytes.ToUpper(p)
+	}
+	switch d {
+	case httpRequestLine, httpRequestLine5:
+		if !requestLineRE.Match(p) {
+			return fmt.Errorf("%s content %q does not start with a method", d, c.Pattern)
+		}
+	default:
+		if !bytes.HasPrefix(p, []byte("HTTP/")) && !bytes.HasPrefix([]byte("HTTP/"), p) {
+			return fmt.Errorf("%s content %q does not start with an HTTP version", d, c.Pattern)
+		}
+	}
+	return nil
+}
+
+// transformBuffers lists the buffers a transform can be applied to, for transforms that are only
+// meaningful on some buffers. Other transforms apply to any sticky buffer.
+var transformBuffers = map[string][]DataPos{
+	"dotprefix":            {dnsQuery, dnsQuery5, dnsQueryName, dnsAnswerName, tlsSNI, tlsSNI5, httpHost, httpHostRaw},
+	"header_lowercase":     {httpHeader, httpHeaderRaw},
+	"strip_pseudo_headers": {httpHeader, httpHeaderRaw},
+}
+
+// transformError returns an error if a transform cannot be applied to its buffer.
+func transformError(t *Transform) error {
+	if t.DataPosition == pktData {
+		return fmt.Errorf("transform %s must follow a sticky buffer", t.Name)
+	}
+	ds, ok := transformBuffers[t.Name]
+	if !ok {
+		return nil
+	}
+	for _, d := range ds {
+		if d == t.DataPosition {
+			return nil
+		}
+	}
+	return fmt.Errorf("transform %s cannot be applied to %s", t.Name, t.DataPosition)
+}
+
+// validateBase64 returns an error if a match on base64_data is not preceded by a base64_decode,
+// the buffer is empty until a base64_decode populates it.
+func (r *Rule) validateBase64() error {
+	var decoded bool
+	for i, m := range r.Matchers {
+		if b, ok := m.(*ByteMatch); ok && b.Kind == b64Decode {
+			decoded = true
+			continue
+		}
+		if d, ok := matcherDataPos(m); ok && d == base64Data && !decoded {
+			return fmt.Errorf("matcher %d: base64_data is not preceded by base64_decode", i)
+		}
+	}
+	return nil
+}
+
+// ByteVariables returns the variables defined by the byte_extract and byte_math of a rule, keyed
+// by name.
+func (r *Rule) ByteVariables() map[string]*ByteMatch {
+	vars := make(map[string]*ByteMatch)
+	for _, m := range r.Matchers {
+		if b, ok := m.(*ByteMatch); ok && b.Variable != "" && (b.Kind == bExtract || b.Kind == bMath) {
+			vars[b.Variable] = b
+		}
+	}
+	return vars
+}
+
+// variableRefs returns the names of the variables a matcher uses: numbers of bytes and values of
+// byte matchers, and position options of contents.
+func variableRefs(m orderedMatcher) []string {
+	var vs []string
+	add := func(s string) {
+		if v, err := ParseIntOrVar(s); err == nil && v.Var != "" {
+			vs = append(vs, v.Var)
+		}
+	}
+	switch v := m.(type) {
+	case *ByteMatch:
+		add(v.NumBytes)
+		if v.Kind == bTest || v.Kind == bMath {
+			add(v.Value)
+		}
+	case *Content:
+		for _, name := range positionOptions {
+			if p := v.positionOption(name); p != nil && p.Var != "" {
+				vs = append(vs, p.Var)
+			}
+		}
+	}
+	return vs
+}
+
+// variableErrors returns an error for each variable used before it is defined by a byte_extract or
+// byte_math. Variables defined but never used are valid, see UnusedVariables.
+func (r *Rule) variableErrors() []error {
+	var errs []error
+	defined := make(map[string]bool)
+	for i, m := range r.Matchers {
+		for _, v := range variableRefs(m) {
+			if !defined[v] {
+				errs = append(errs, fmt.Errorf("matcher %d: variable %s is not defined by a preceding byte_extract or byte_math", i, v))
+			}
+		}
+		if b, ok := m.(*ByteMatch); ok && b.Variable != "" && (b.Kind == bExtract || b.Kind == bMath) {
+			defined[b.Variable] = true
+		}
+	}
+	return errs
+}
+
+// classtypeRE matches valid classtype names, as defined in classification.config.
+var classtypeRE = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// tagErrors returns errors for invalid values of the simple keywords with a typed field, for those
+// keywords set in Tags, where they would be written in addition to the typed field, and for
+// invalid or repeated prefilters, including a prefilter on a negated or fast_pattern content.
+func (r *Rule) tagErrors() []error {
+	var errs []error
+	if r.Classtype != "" && !classtypeRE.MatchString(r.Classtype) {
+		errs = append(errs, fmt.Errorf("invalid classtype %q", r.Classtype))
+	}
+	if r.Priority < 0 || r.Priority > 255 {
+		errs = append(errs, fmt.Errorf("priority %d is out of range (1-255)", r.Priority))
+	}
+	if r.Target != "" && !inSlice(r.Target, targets) {
+		errs = append(errs, fmt.Errorf("invalid target %q", r.Target))
+	}
+	for _, k := range []string{"classtype", "priority", "target", "gid", "sid", "rev", "msg"} {
+		if _, ok := r.Tags[k]; ok {
+			errs = append(errs, fmt.Errorf("%s is set in Tags instead of its typed field", k))
+		}
+	}
+	var dups []string
+	for k := range r.MultiTags {
+		if !inSlice(k, repeatableTags) {
+			dups = append(dups, k)
+		}
+	}
+	sort.Strings(dups)
+	for _, k := range dups {
+		errs = append(errs, fmt.Errorf("duplicate %s tag", k))
+	}
+	var prefilters int
+	for i, c := range r.Contents() {
+		if !c.Prefilter {
+			continue
+		}
+		prefilters++
+		// A prefilter on another content than the fast_pattern is reported as a second fast_pattern.
+		if c.FastPattern.Enabled {
+			errs = append(errs, fmt.Errorf("content %d: prefilter cannot be used with fast_pattern", i))
+		}
+		if c.Negate {
+			errs = append(errs, fmt.Errorf("content %d: prefilter cannot be used on a negated content", i))
+		}
+	}
+	for _, lm := range r.LenMatchers() {
+		if lm.Prefilter {
+			prefilters++
+		}
+	}
+	if r.PrefilterTag != "" {
+		prefilters++
+		if _, ok := r.Tags[r.PrefilterTag]; !ok || !inSlice(r.PrefilterTag, prefilterTags) {
+			errs = append(errs, fmt.Errorf("prefilter is not supported on %q", r.PrefilterTag))
+		}
+	}
+	if prefilters > 1 {
+		errs = append(errs, fmt.Errorf("only one prefilter is allowed, found %d", prefilters))
+	}
+	return errs
+}
+
+// Validate checks a rule for problems that would produce an invalid or lossy rule when written with
+// String. It returns nil if the rule is valid, or ValidationErrors listing every problem found.
+func (r *Rule) Validate() error {
+	return r.ValidateWithOptions(DefaultValidateOptions())
+}
+
+// ValidateWithOptions checks a rule like Validate, with the thresholds specified in opts.
+func (r *Rule) ValidateWithOptions(opts ValidateOptions) error {
+	var errs ValidationErrors
+	var fastPatterns int
+	var invalidFastPattern bool
+	for i, c := range r.Contents() {
+		if err := c.FastPattern.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("content %d: %v", i, err))
+			invalidFastPattern = true
+		} else if f := c.FastPattern; f.Enabled && f.Offset+f.Length > len(c.Pattern) {
+			errs = append(errs, fmt.Errorf("content %d: fast_pattern %d,%d is past the end of the %d byte pattern",
+				i, f.Offset, f.Length, len(c.Pattern)))
+			invalidFastPattern = true
+		}
+		if c.isFastPattern() {
+			fastPatterns++
+		}
+		for _, o := range c.Options {
+			if err := o.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("content %d: %v", i, err))
+			}
+		}
+		if err := c.ValidateJA3(); err != nil {
+			errs = append(errs, fmt.Errorf("content %d: %v", i, err))
+		}
+		if err := lineBufferError(c); err != nil {
+			errs = append(errs, fmt.Errorf("content %d: %v", i, err))
+		}
+		errs = append(errs, c.positionErrors()...)
+	}
+	if fastPatterns > 1 {
+		errs = append(errs, fmt.Errorf("only one fast_pattern is allowed, found %d", fastPatterns))
+	}
+	// The quality of the fast_pattern is only meaningful once it is valid.
+	if fastPatterns == 1 && !invalidFastPattern {
+		if _, err := r.EstimateFastPatternQuality(opts.FastPatternMinLen); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if r.Protocol != "" {
+		seen := make(map[DataPos]bool)
+		for _, m := range r.Matchers {
+			d, ok := matcherDataPos(m)
+			if c, isContent := m.(*Content); isContent {
+				d = c.buffer()
+			}
+			if !ok || seen[d] {
+				continue
+			}
+			seen[d] = true
+			if err := bufferProtocolError(d, r.Protocol); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	for i, p := range r.PCREs() {
+		for _, m := range p.Options {
+			if !isPCREModifier(m) {
+				errs = append(errs, fmt.Errorf("pcre %d: invalid modifier %q", i, m))
+			}
+		}
+	}
+
+	for _, t := range r.Transforms() {
+		if err := transformError(t); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := r.validateBase64(); err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, r.variableErrors()...)
+	errs = append(errs, r.tagErrors()...)
+
+	if err := r.Source.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("source: %v", err))
+	}
+	if err := r.Destination.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("destination: %v", err))
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
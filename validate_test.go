@@ -0,0 +1,580 @@
+/* Copyright 2020 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestFastPatternValidate(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		input   FastPattern
+		wantErr bool
+	}{
+		{
+			name:  "disabled",
+			input: FastPattern{Only: true, Offset: 2},
+		},
+		{
+			name:  "fast_pattern",
+			input: FastPattern{Enabled: true},
+		},
+		{
+			name:  "only",
+			input: FastPattern{Enabled: true, Only: true},
+		},
+		{
+			name:  "chop",
+			input: FastPattern{Enabled: true, Offset: 2, Length: 4},
+		},
+		{
+			name:    "only and chop",
+			input:   FastPattern{Enabled: true, Only: true, Offset: 2, Length: 4},
+			wantErr: true,
+		},
+		{
+			name:    "negative offset",
+			input:   FastPattern{Enabled: true, Offset: -1, Length: 4},
+			wantErr: true,
+		},
+	} {
+		err := tt.input.Validate()
+		if tt.wantErr != (err != nil) {
+			t.Fatalf("%s: gotErr=%v; wantErr=%v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestContentOptionValidate(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		input   ContentOption
+		wantErr bool
+	}{
+		{
+			name:  "modifier",
+			input: ContentOption{Name: "http_uri"},
+		},
+		{
+			name:    "unknown option",
+			input:   ContentOption{Name: "withn", Value: "5"},
+			wantErr: true,
+		},
+		{
+			name:    "position option",
+			input:   ContentOption{Name: "within", Value: "5"},
+			wantErr: true,
+		},
+		{
+			name:    "modifier with value",
+			input:   ContentOption{Name: "rawbytes", Value: "1"},
+			wantErr: true,
+		},
+	} {
+		err := tt.input.Validate()
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%s: got err %v; expected err %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		input   *Rule
+		wantErr int
+	}{
+		{
+			name: "valid rule",
+			input: &Rule{
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("foo"), FastPattern: FastPattern{Enabled: true, Only: true}},
+					&Content{Pattern: []byte("bar")},
+				},
+			},
+		},
+		{
+			name: "invalid fast_pattern",
+			input: &Rule{
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("foo"), FastPattern: FastPattern{Enabled: true, Only: true, Length: 2}},
+				},
+			},
+			wantErr: 1,
+		},
+		{
+			name: "unknown, valued and position content options",
+			input: &Rule{
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("foo"), Options: []*ContentOption{
+						{Name: "withn", Value: "5"},
+						{Name: "http_uri", Value: "1"},
+						{Name: "depth", Value: "3"},
+					}},
+				},
+			},
+			wantErr: 3,
+		},
+		{
+			name: "multiple fast_pattern",
+			input: &Rule{
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("foo"), FastPattern: FastPattern{Enabled: true}},
+					&Content{Pattern: []byte("bar"), FastPattern: FastPattern{Enabled: true, Only: true, Offset: 1}},
+				},
+			},
+			wantErr: 2,
+		},
+		{
+			name: "incompatible buffers",
+			input: &Rule{
+				Protocol: "udp",
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("foo"), Options: []*ContentOption{{Name: "http_uri"}}},
+					&Content{Pattern: []byte("bar"), DataPosition: httpURI},
+					&Content{Pattern: []byte("baz"), DataPosition: tlsSNI5},
+					&Content{Pattern: []byte("qux"), DataPosition: dnsQuery5},
+					&Content{Pattern: []byte("quux"), DataPosition: fileData},
+				},
+			},
+			wantErr: 2,
+		},
+		{
+			name: "compatible buffers",
+			input: &Rule{
+				Protocol: "tcp",
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("foo"), DataPosition: httpURI},
+					&LenMatch{Kind: uriLen, DataPosition: httpURI},
+					&Content{Pattern: []byte("bar"), DataPosition: tlsSNI},
+				},
+			},
+		},
+		{
+			name: "base64_data after base64_decode",
+			input: &Rule{
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("foo")},
+					&ByteMatch{Kind: b64Decode, NumBytes: "10", Options: []string{"relative"}},
+					&Content{Pattern: []byte("bar"), DataPosition: base64Data},
+				},
+			},
+		},
+		{
+			name: "base64_data without base64_decode",
+			input: &Rule{
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("bar"), DataPosition: base64Data},
+					&ByteMatch{Kind: b64Decode},
+					&Content{Pattern: []byte("baz"), DataPosition: base64Data},
+				},
+			},
+			wantErr: 1,
+		},
+		{
+			name: "valid transforms",
+			input: &Rule{
+				Matchers: []orderedMatcher{
+					&Transform{DataPosition: dnsQuery5, Name: "dotprefix"},
+					&Transform{DataPosition: dnsQuery5, Name: "to_lowercase"},
+					&Content{Pattern: []byte(".example.com"), DataPosition: dnsQuery5},
+				},
+			},
+		},
+		{
+			name: "invalid transforms",
+			input: &Rule{
+				Matchers: []orderedMatcher{
+					&Transform{DataPosition: pktData, Name: "to_lowercase"},
+					&Transform{DataPosition: httpURI, Name: "dotprefix"},
+					&Content{Pattern: []byte("foo"), DataPosition: httpURI},
+				},
+			},
+			wantErr: 2,
+		},
+		{
+			name: "poor fast_pattern",
+			input: &Rule{
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("ab"), FastPattern: FastPattern{Enabled: true}},
+					&Content{Pattern: []byte("/evil/gate.php")},
+				},
+			},
+			wantErr: 1,
+		},
+		{
+			name: "fast_pattern past the pattern",
+			input: &Rule{
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("bad.io"), FastPattern: FastPattern{Enabled: true, Chop: true, Offset: 5, Length: 11}},
+				},
+			},
+			wantErr: 1,
+		},
+		{
+			name: "low entropy fast_pattern",
+			input: &Rule{
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("AAAA"), FastPattern: FastPattern{Enabled: true}},
+				},
+			},
+			wantErr: 1,
+		},
+		{
+			name: "valid positions",
+			input: &Rule{
+				Matchers: []orderedMatcher{
+					&ByteMatch{Kind: bExtract, NumBytes: "1", Variable: "len"},
+					&Content{Pattern: []byte("abc"), Offset: &IntOrVar{Int: 10}, Depth: &IntOrVar{Int: 3}},
+					&Content{Pattern: []byte("def"), Distance: &IntOrVar{Int: -2}, Within: &IntOrVar{Int: 5}},
+					&Content{Pattern: []byte("ghi"), Depth: &IntOrVar{Var: "len"}},
+				},
+			},
+		},
+		{
+			name: "byte variables",
+			input: &Rule{
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("abc"), Offset: &IntOrVar{Var: "late"}},
+					&ByteMatch{Kind: bExtract, NumBytes: "1", Variable: "late"},
+					&ByteMatch{Kind: bExtract, NumBytes: "2", Variable: "unused"},
+					&ByteMatch{Kind: bExtract, NumBytes: "1", Variable: "len"},
+					&ByteMatch{Kind: bTest, NumBytes: "len", Operator: ">", Value: "missing"},
+					&ByteMatch{Kind: bJump, NumBytes: "undefined"},
+				},
+			},
+			wantErr: 3,
+		},
+		{
+			name: "contradictory positions",
+			input: &Rule{
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("abcde"), Depth: &IntOrVar{Int: 3}},
+					&Content{Pattern: []byte("abc"), Offset: &IntOrVar{Int: -1}, Within: &IntOrVar{}},
+					&Content{Pattern: []byte("abc"), Options: []*ContentOption{{Name: "startswith"}}, Distance: &IntOrVar{Int: 1}},
+				},
+			},
+			wantErr: 5,
+		},
+		{
+			name: "prefilters",
+			input: &Rule{
+				Matchers: []orderedMatcher{
+					&LenMatch{Kind: dSize, Num: 10, Operator: ">", Prefilter: true},
+				},
+				PrefilterTag: "classtype",
+			},
+			wantErr: 2,
+		},
+		{
+			name: "prefilter and fast_pattern",
+			input: &Rule{
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("abcd"), FastPattern: FastPattern{Enabled: true}},
+					&Content{Pattern: []byte("efgh"), Prefilter: true},
+				},
+			},
+			wantErr: 1,
+		},
+		{
+			name: "prefilter on fast_pattern content",
+			input: &Rule{
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("abcd"), FastPattern: FastPattern{Enabled: true}, Prefilter: true},
+				},
+			},
+			wantErr: 1,
+		},
+		{
+			name: "prefilter on negated content",
+			input: &Rule{
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("abcd"), Negate: true, Prefilter: true},
+					&Content{Pattern: []byte("efgh")},
+				},
+			},
+			wantErr: 1,
+		},
+		{
+			name: "typed tags",
+			input: &Rule{
+				Classtype: "trojan activity",
+				Priority:  256,
+				Target:    "src",
+				Tags:      map[string]string{"classtype": "trojan-activity", "threshold": "type limit, track by_src, count 1, seconds 60"},
+			},
+			wantErr: 4,
+		},
+		{
+			name: "duplicate tags",
+			input: &Rule{
+				Tags:      map[string]string{"flow": "established"},
+				MultiTags: map[string][]string{"flow": {"to_server"}, "app-layer-protocol": {"http", "tls"}},
+			},
+			wantErr: 1,
+		},
+		{
+			name: "valid line buffers",
+			input: &Rule{
+				Protocol: "http",
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("post "), DataPosition: httpRequestLine5, Nocase: true, Options: []*ContentOption{{Name: "startswith"}}},
+					&Content{Pattern: []byte("/gate.php HTTP/1.1"), DataPosition: httpRequestLine5},
+					&Content{Pattern: []byte("HTTP/1"), DataPosition: httpResponseLine5, Options: []*ContentOption{{Name: "startswith"}}},
+					&Content{Pattern: []byte("HTT"), DataPosition: httpResponseLine, Options: []*ContentOption{{Name: "startswith"}}},
+				},
+			},
+		},
+		{
+			name: "invalid line buffers",
+			input: &Rule{
+				Protocol: "http",
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("GET /\r\n"), DataPosition: httpRequestLine5},
+					&Content{Pattern: []byte("/index.php"), DataPosition: httpRequestLine5, Options: []*ContentOption{{Name: "startswith"}}},
+					&Content{Pattern: []byte("200 OK"), DataPosition: httpResponseLine5, Options: []*ContentOption{{Name: "startswith"}}},
+				},
+			},
+			wantErr: 3,
+		},
+		{
+			name: "valid fingerprints",
+			input: &Rule{
+				Protocol: "tls",
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("e7d705a3286e19ea42f587b344ee6865"), DataPosition: ja3Hash5},
+					&Content{Pattern: []byte("E7D705A3286E19EA42F587B344EE6865"), DataPosition: ja3sHash, Nocase: true},
+					&Content{Pattern: []byte("t13d1516h2_8daaf6152771_b186095e22b6"), DataPosition: ja4Hash},
+					&Content{Pattern: []byte("t13d"), DataPosition: ja4Hash, Options: []*ContentOption{{Name: "startswith"}}},
+				},
+			},
+		},
+		{
+			name: "malformed fingerprints",
+			input: &Rule{
+				Protocol: "tls",
+				Matchers: []orderedMatcher{
+					&Content{Pattern: []byte("e7d705a3286e19ea42f587b344ee686"), DataPosition: ja3Hash5},
+					&Content{Pattern: []byte("E7D705A3286E19EA42F587B344EE6865"), DataPosition: ja3Hash},
+					&Content{Pattern: []byte("foo"), DataPosition: ja3sHash, Depth: &IntOrVar{Int: 3}},
+					&Content{Pattern: []byte("t13d1516h2_8daaf6152771"), DataPosition: ja4Hash},
+					&Content{Pattern: []byte("771,4865-4866,0-23,29-23,0"), DataPosition: ja3String5},
+				},
+			},
+			wantErr: 4,
+		},
+		{
+			name: "unknown pcre modifiers",
+			input: &Rule{
+				Matchers: []orderedMatcher{
+					&PCRE{Pattern: []byte("foo"), Options: []byte("iL")},
+					&PCRE{Pattern: []byte("bar"), Options: []byte("RU")},
+				},
+			},
+			wantErr: 1,
+		},
+		{
+			name: "invalid networks",
+			input: &Rule{
+				Source:      Network{Nets: []string{"1.2.3.4/40"}, Ports: []string{"any"}},
+				Destination: Network{Nets: []string{"$HOME_NET"}, Ports: []string{"80:foo"}},
+			},
+			wantErr: 2,
+		},
+	} {
+		err := tt.input.Validate()
+		if tt.wantErr == 0 {
+			if err != nil {
+				t.Fatalf("%s: got unexpected error %v", tt.name, err)
+			}
+			continue
+		}
+		errs, ok := err.(ValidationErrors)
+		if !ok {
+			t.Fatalf("%s: got %T; expected ValidationErrors", tt.name, err)
+		}
+		if len(errs) != tt.wantErr {
+			t.Fatalf("%s: got %d errors (%v); expected %d", tt.name, len(errs), errs, tt.wantErr)
+		}
+	}
+}
+
+func TestRuleValidateWithOptions(t *testing.T) {
+	r := &Rule{
+		Matchers: []orderedMatcher{
+			&Content{Pattern: []byte("abcd"), FastPattern: FastPattern{Enabled: true}},
+		},
+	}
+	if err := r.Validate(); err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+	if err := r.ValidateWithOptions(ValidateOptions{FastPatternMinLen: 5}); err == nil {
+		t.Fatal("got no error for a fast_pattern shorter than 5 bytes")
+	}
+}
+
+func TestEstimateFastPatternQuality(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		input   string
+		minLen  int
+		want    float64
+		wantErr string
+	}{
+		{
+			name:   "no fast_pattern",
+			input:  `alert tcp any any -> any any (msg:"foo"; content:"ab"; sid:1; rev:1;)`,
+			minLen: 3,
+		},
+		{
+			name:   "good fast_pattern",
+			input:  `alert tcp any any -> any any (msg:"foo"; content:"abcd"; fast_pattern; content:"x"; sid:1; rev:1;)`,
+			minLen: 3,
+			want:   0.2,
+		},
+		{
+			name:    "too short",
+			input:   `alert tcp any any -> any any (msg:"foo"; content:"abcd"; fast_pattern; content:"abcdefgh"; sid:1; rev:1;)`,
+			minLen:  5,
+			want:    0.2,
+			wantErr: `fast_pattern content "abcd" is too short (4 bytes, minimum 5), consider "abcdefgh"`,
+		},
+		{
+			name:    "low entropy",
+			input:   `alert tcp any any -> any any (msg:"foo"; content:"|00 00 00 00|"; fast_pattern; sid:1; rev:1;)`,
+			minLen:  3,
+			want:    0.25 * 0.5,
+			wantErr: `fast_pattern content "\x00\x00\x00\x00" has a low entropy (0.00 bits per byte)`,
+		},
+		{
+			name:    "chopped common",
+			input:   `alert http any any -> any any (msg:"foo"; content:"xxGET "; fast_pattern:2,4; content:"/gate.php"; sid:1; rev:1;)`,
+			minLen:  3,
+			want:    0.02,
+			wantErr: `fast_pattern content "GET " is too common, consider "/gate.php"`,
+		},
+	} {
+		r, err := ParseRule(tt.input)
+		if err != nil {
+			t.Fatalf("%s: parse failed: %v", tt.name, err)
+		}
+		got, err := r.EstimateFastPatternQuality(tt.minLen)
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Fatalf("%s: got %v; want %v", tt.name, got, tt.want)
+		}
+		var gotErr string
+		if err != nil {
+			gotErr = err.Error()
+		}
+		if gotErr != tt.wantErr {
+			t.Fatalf("%s: got error %q; want %q", tt.name, gotErr, tt.wantErr)
+		}
+	}
+}
+
+func TestAppLayerBuffers(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			name:  "no buffers",
+			input: `alert tcp any any -> any any (msg:"foo"; content:"foo"; file_data; content:"bar"; sid:1; rev:1;)`,
+		},
+		{
+			name:  "sticky buffers and modifiers",
+			input: `alert tcp any any -> any any (msg:"foo"; content:"foo"; http_uri; tls.sni; content:"bar"; ja3.hash; content:"e7d705a3286e19ea42f587b344ee6865"; dns_query; content:"baz"; http.host; urilen:>10; sid:1; rev:1;)`,
+			want:  []string{"dns", "http", "tls"},
+		},
+	} {
+		r, err := ParseRule(tt.input)
+		if err != nil {
+			t.Fatalf("%s: parse failed: %v", tt.name, err)
+		}
+		if diff := pretty.Compare(r.AppLayerBuffers(), tt.want); diff != "" {
+			t.Fatal(fmt.Sprintf("%s: diff (-got +want):\n%s", tt.name, diff))
+		}
+	}
+}
+
+func TestByteVariables(t *testing.T) {
+	r, err := ParseRule(`alert tcp any any -> any any (msg:"foo"; content:"a"; byte_extract:2,0,len,relative; byte_math:bytes 1,offset 0,oper +,rvalue len,result sum; content:"b"; distance:0; within:sum; sid:1;)`)
+	if err != nil {
+		t.Fatalf("parse rule failed: %v", err)
+	}
+	vars := r.ByteVariables()
+	if len(vars) != 2 || vars["len"] != r.Matchers[1] || vars["sum"] != r.Matchers[2] {
+		t.Fatalf("got %v; expected len and sum", vars)
+	}
+	if err := r.Validate(); err != nil {
+		t.Fatalf("got unexpected error %v", err)
+	}
+}
+
+func TestValidateProtocol(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		protocol     string
+		allowUnknown bool
+		wantErr      bool
+		wantAppLayer bool
+	}{
+		{
+			name:     "transport",
+			protocol: "tcp",
+		},
+		{
+			name:         "app layer",
+			protocol:     "http",
+			wantAppLayer: true,
+		},
+		{
+			name:         "upper case",
+			protocol:     "DNS",
+			wantAppLayer: true,
+		},
+		{
+			name:     "typo",
+			protocol: "tpc",
+			wantErr:  true,
+		},
+		{
+			name:         "allow unknown",
+			protocol:     "foo",
+			allowUnknown: true,
+		},
+		{
+			name:         "empty",
+			allowUnknown: true,
+			wantErr:      true,
+		},
+	} {
+		r := &Rule{Protocol: tt.protocol}
+		err := r.ValidateProtocol(tt.allowUnknown)
+		if tt.wantErr != (err != nil) {
+			t.Fatalf("%s: got err %v; expected err %v", tt.name, err, tt.wantErr)
+		}
+		if got := r.IsAppLayer(); got != tt.wantAppLayer {
+			t.Fatalf("%s: got %v; expected %v", tt.name, got, tt.wantAppLayer)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+/* Copyright 2020 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// yamlWriter writes indented YAML. All scalars are written as double-quoted strings, or plain
+// numbers and booleans.
+type yamlWriter struct {
+	buf bytes.Buffer
+}
+
+// line writes a single indented line.
+func (y *yamlWriter) line(indent int, format string, args ...interface{}) {
+	y.buf.WriteString(strings.Repeat("  ", indent))
+	y.buf.WriteString(fmt.Sprintf(format, args...))
+	y.buf.WriteByte('\n')
+}
+
+// yamlList returns a YAML flow sequence of quoted strings.
+func yamlList(ss []string) string {
+	q := make([]string, len(ss))
+	for i, s := range ss {
+		q[i] = strconv.Quote(s)
+	}
+	return "[" + strings.Join(q, ", ") + "]"
+}
+
+// writeNetwork writes a Network as a mapping.
+func (y *yamlWriter) writeNetwork(indent int, name string, n Network) {
+	y.line(indent, "%s:", name)
+	y.line(indent+1, "nets: %s", yamlList(n.Nets))
+	y.line(indent+1, "ports: %s", yamlList(n.Ports))
+}
+
+// writeRule writes all fields of a rule as a mapping.
+func (y *yamlWriter) writeRule(indent int, r *Rule) {
+	y.line(indent, "action: %q", r.Action)
+	y.line(indent, "protocol: %q", r.Protocol)
+	y.line(indent, "disabled: %v", r.Disabled)
+	y.line(indent, "msg: %q", r.Description)
+	if r.GID != 0 {
+		y.line(indent, "gid: %d", r.GID)
+	}
+	y.line(indent, "revision: %d", r.Revision)
+	if r.Classtype != "" {
+		y.line(indent, "classtype: %q", r.Classtype)
+	}
+	if r.Priority != 0 {
+		y.line(indent, "priority: %d", r.Priority)
+	}
+	if r.Target != "" {
+		y.line(indent, "target: %q", r.Target)
+	}
+	y.writeNetwork(indent, "source", r.Source)
+	y.writeNetwork(indent, "destination", r.Destination)
+	y.line(indent, "bidirectional: %v", r.Bidirectional)
+
+	if v, ok := r.Tags["flow"]; ok {
+		var states []string
+		for _, s := range strings.Split(v, ",") {
+			states = append(states, strings.TrimSpace(s))
+		}
+		y.line(indent, "flow: %s", yamlList(states))
+	}
+	if r.PrefilterTag != "" {
+		y.line(indent, "prefilter: %q", r.PrefilterTag)
+	}
+
+	if cs := r.Contents(); len(cs) > 0 {
+		y.line(indent, "contents:")
+		for _, c := range cs {
+			y.line(indent+1, "- buffer: %q", c.DataPosition)
+			y.line(indent+2, "pattern: %q", c.FormatPattern())
+			y.line(indent+2, "negate: %v", c.Negate)
+			y.line(indent+2, "nocase: %v", c.Nocase)
+			if len(c.Options) > 0 || len(c.positionStrings()) > 0 {
+				var opts []string
+				for _, o := range c.Options {
+					opts = append(opts, strings.TrimSuffix(o.String(), ";"))
+				}
+				for _, p := range c.positionStrings() {
+					opts = append(opts, strings.TrimSuffix(p, ";"))
+				}
+				y.line(indent+2, "options: %s", yamlList(opts))
+			}
+			if c.FastPattern.Enabled {
+				y.line(indent+2, "fast_pattern: %q", strings.TrimSuffix(c.FastPattern.String(), ";"))
+			}
+			if c.Prefilter {
+				y.line(indent+2, "prefilter: true")
+			}
+		}
+	}
+
+	if ps := r.PCREs(); len(ps) > 0 {
+		y.line(indent, "pcres:")
+		for _, p := range ps {
+			y.line(indent+1, "- pattern: %q", p.Pattern)
+			y.line(indent+2, "modifiers: %q", p.Options)
+			y.line(indent+2, "negate: %v", p.Negate)
+		}
+	}
+
+	if bs := r.ByteMatchers(); len(bs) > 0 {
+		y.line(indent, "byte_matches:")
+		for _, b := range bs {
+			y.line(indent+1, "- %q", strings.TrimSuffix(b.String(), ";"))
+		}
+	}
+
+	if ls := r.LenMatchers(); len(ls) > 0 {
+		y.line(indent, "len_matches:")
+		for _, l := range ls {
+			y.line(indent+1, "- kind: %q", l.Kind)
+			y.line(indent+2, "buffer: %q", l.DataPosition)
+			if l.Negate {
+				y.line(indent+2, "negate: %v", l.Negate)
+			}
+			switch {
+			case l.Operator == "<>":
+				y.line(indent+2, "operator: %q", l.Operator)
+				y.line(indent+2, "min: %d", l.Min)
+				y.line(indent+2, "max: %d", l.Max)
+			case l.Operator != "":
+				y.line(indent+2, "operator: %q", l.Operator)
+				y.line(indent+2, "num: %d", l.Num)
+			default:
+				y.line(indent+2, "num: %d", l.Num)
+			}
+			if len(l.Options) > 0 {
+				y.line(indent+2, "options: %s", yamlList(l.Options))
+			}
+			if l.Prefilter {
+				y.line(indent+2, "prefilter: %v", l.Prefilter)
+			}
+		}
+	}
+
+	if ts := r.Transforms(); len(ts) > 0 {
+		y.line(indent, "transforms:")
+		for _, t := range ts {
+			y.line(indent+1, "- buffer: %q", t.DataPosition)
+			y.line(indent+2, "name: %q", t.Name)
+			if t.Value != "" {
+				y.line(indent+2, "value: %q", t.Value)
+			}
+		}
+	}
+
+	if len(r.Flowbits) > 0 {
+		y.line(indent, "flowbits:")
+		for _, fb := range r.Flowbits {
+			y.line(indent+1, "- action: %q", fb.Action)
+			if fb.Value != "" {
+				y.line(indent+2, "value: %q", fb.Value)
+			}
+		}
+	}
+
+	if len(r.Lua) > 0 {
+		y.line(indent, "lua:")
+		for _, l := range r.Lua {
+			y.line(indent+1, "- keyword: %q", l.Keyword)
+			y.line(indent+2, "script: %q", l.Script)
+			y.line(indent+2, "negate: %v", l.Negate)
+		}
+	}
+
+	if len(r.FileMatches) > 0 {
+		y.line(indent, "file_matches:")
+		for _, f := range r.FileMatches {
+			y.line(indent+1, "- keyword: %q", f.Keyword)
+			y.line(indent+2, "value: %q", f.Value)
+			y.line(indent+2, "negate: %v", f.Negate)
+			y.line(indent+2, "nocase: %v", f.Nocase)
+		}
+	}
+
+	if r.Filestore != nil {
+		y.line(indent, "filestore: %q", strings.TrimSuffix(r.Filestore.String(), ";"))
+	}
+
+	// Metadata is grouped by key, preserving the order of first appearance.
+	if len(r.Metas) > 0 {
+		var keys []string
+		values := make(map[string][]string)
+		for _, m := range r.Metas {
+			if _, ok := values[m.Key]; !ok {
+				keys = append(keys, m.Key)
+			}
+			values[m.Key] = append(values[m.Key], m.Value)
+		}
+		y.line(indent, "metadata:")
+		for _, k := range keys {
+			y.line(indent+1, "%q: %s", k, yamlList(values[k]))
+		}
+	}
+
+	if len(r.References) > 0 {
+		y.line(indent, "references:")
+		for _, ref := range r.References {
+			y.line(indent+1, "- type: %q", ref.Type)
+			y.line(indent+2, "value: %q", ref.Value)
+		}
+	}
+
+	var tags []string
+	for k := range r.Tags {
+		if k != "flow" {
+			tags = append(tags, k)
+		}
+	}
+	if len(tags) > 0 {
+		sort.Strings(tags)
+		y.line(indent, "tags:")
+		for _, k := range tags {
+			y.line(indent+1, "%q: %q", k, r.Tags[k])
+		}
+	}
+
+	var multiTags []string
+	for k := range r.MultiTags {
+		multiTags = append(multiTags, k)
+	}
+	if len(multiTags) > 0 {
+		sort.Strings(multiTags)
+		y.line(indent, "multi_tags:")
+		for _, k := range multiTags {
+			y.line(indent+1, "%q: %s", k, yamlList(r.MultiTags[k]))
+		}
+	}
+
+	if r.GeoIP != nil {
+		y.line(indent, "geoip:")
+		y.line(indent+1, "direction: %q", r.GeoIP.Direction)
+		y.line(indent+1, "countries: %s", yamlList(r.GeoIP.Countries))
+	}
+
+	if len(r.IPRep) > 0 {
+		y.line(indent, "iprep:")
+		for _, i := range r.IPRep {
+			y.line(indent+1, "- direction: %q", i.Direction)
+			y.line(indent+2, "category: %q", i.Category)
+			y.line(indent+2, "operator: %q", i.Operator)
+			y.line(indent+2, "value: %d", i.Value)
+		}
+	}
+
+	if r.DCEIface != nil {
+		y.line(indent, "dce_iface: %q", strings.TrimSuffix(strings.TrimPrefix(r.DCEIface.String(), "dce_iface:"), ";"))
+	}
+
+	if len(r.DCEOpnums) > 0 {
+		var os []string
+		for _, o := range r.DCEOpnums {
+			os = append(os, o.String())
+		}
+		y.line(indent, "dce_opnum: %s", yamlList(os))
+	}
+
+	if r.SameIP {
+		y.line(indent, "sameip: %v", r.SameIP)
+	}
+
+	if len(r.IPProto) > 0 {
+		y.line(indent, "ip_proto:")
+		for _, p := range r.IPProto {
+			y.line(indent+1, "- proto: %q", p.Proto)
+			y.line(indent+2, "negate: %v", p.Negate)
+			if p.Operator != "" {
+				y.line(indent+2, "operator: %q", p.Operator)
+			}
+		}
+	}
+
+	if len(r.Statements) > 0 {
+		y.line(indent, "statements: %s", yamlList(r.Statements))
+	}
+
+	if us := r.UnknownOptions(); len(us) > 0 {
+		var opts []string
+		for _, u := range us {
+			opts = append(opts, strings.TrimSuffix(u.String(), ";"))
+		}
+		y.line(indent, "unknown: %s", yamlList(opts))
+	}
+}
+
+// MarshalRulesYAML returns a YAML document describing the rules, keyed by SID. This is intended
+// for review and reporting, and cannot be parsed back into rules.
+func MarshalRulesYAML(rules []*Rule) ([]byte, error) {
+	y := &yamlWriter{}
+	seen := make(map[int]bool)
+	for _, r := range rules {
+		if seen[r.SID] {
+			return nil, fmt.Errorf("duplicate sid %d", r.SID)
+		}
+		seen[r.SID] = true
+		y.line(0, "%q:", strconv.Itoa(r.SID))
+		y.writeRule(1, r)
+	}
+	return y.buf.Bytes(), nil
+}
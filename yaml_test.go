@@ -0,0 +1,135 @@
+/* Copyright 2020 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"testing"
+)
+
+func TestMarshalRulesYAML(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		input   []string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "simple rule",
+			input: []string{
+				`alert tcp $HOME_NET any -> $EXTERNAL_NET [80,443] (msg:"foo bar"; flow:established,to_server; content:"|00|abc"; nocase; fast_pattern; dsize:10<>20; pcre:"/abc/i"; metadata:foo bar, foo baz, created_at 2020_01_01; reference:cve,2020-1; classtype:trojan-activity; sid:1; rev:2;)`,
+			},
+			want: `"1":
+  action: "alert"
+  protocol: "tcp"
+  disabled: false
+  msg: "foo bar"
+  revision: 2
+  classtype: "trojan-activity"
+  source:
+    nets: ["$HOME_NET"]
+    ports: ["any"]
+  destination:
+    nets: ["$EXTERNAL_NET"]
+    ports: ["80", "443"]
+  bidirectional: false
+  flow: ["established", "to_server"]
+  contents:
+    - buffer: "pkt_data"
+      pattern: "|00|abc"
+      negate: false
+      nocase: true
+      fast_pattern: "fast_pattern"
+  pcres:
+    - pattern: "abc"
+      modifiers: "i"
+      negate: false
+  len_matches:
+    - kind: "dsize"
+      buffer: "pkt_data"
+      operator: "<>"
+      min: 10
+      max: 20
+  metadata:
+    "foo": ["bar", "baz"]
+    "created_at": ["2020_01_01"]
+  references:
+    - type: "cve"
+      value: "2020-1"
+`,
+		},
+		{
+			name: "multiple rules",
+			input: []string{
+				`alert tcp any any -> any any (msg:"foo"; sid:1; rev:1;)`,
+				`#alert udp any any -> any any (msg:"bar"; flowbits:isset,foo; sid:2; rev:1;)`,
+			},
+			want: `"1":
+  action: "alert"
+  protocol: "tcp"
+  disabled: false
+  msg: "foo"
+  revision: 1
+  source:
+    nets: ["any"]
+    ports: ["any"]
+  destination:
+    nets: ["any"]
+    ports: ["any"]
+  bidirectional: false
+"2":
+  action: "alert"
+  protocol: "udp"
+  disabled: true
+  msg: "bar"
+  revision: 1
+  source:
+    nets: ["any"]
+    ports: ["any"]
+  destination:
+    nets: ["any"]
+    ports: ["any"]
+  bidirectional: false
+  flowbits:
+    - action: "isset"
+      value: "foo"
+`,
+		},
+		{
+			name: "duplicate sid",
+			input: []string{
+				`alert tcp any any -> any any (msg:"foo"; sid:1; rev:1;)`,
+				`alert tcp any any -> any any (msg:"bar"; sid:1; rev:1;)`,
+			},
+			wantErr: true,
+		},
+	} {
+		var rules []*Rule
+		for _, s := range tt.input {
+			r, err := ParseRule(s)
+			if err != nil {
+				t.Fatalf("%s: parse rule failed: %v", tt.name, err)
+			}
+			rules = append(rules, r)
+		}
+		got, err := MarshalRulesYAML(rules)
+		if tt.wantErr != (err != nil) {
+			t.Fatalf("%s: gotErr=%v; wantErr=%v", tt.name, err, tt.wantErr)
+		}
+		if string(got) != tt.want {
+			t.Fatalf("%s: got:\n%s\nexpected:\n%s", tt.name, got, tt.want)
+		}
+	}
+}